@@ -0,0 +1,256 @@
+package apperrors
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// Code is a stable error code of the API, which clients can rely on instead of error messages
+type Code string
+
+const (
+	// Generic codes, also assigned to errors without a code by the HTTP status of the response
+	Internal            Code = "INTERNAL_ERROR"
+	InvalidRequest      Code = "INVALID_REQUEST"
+	ValidationFailed    Code = "VALIDATION_FAILED"
+	Unauthorized        Code = "UNAUTHORIZED"
+	Forbidden           Code = "FORBIDDEN"
+	NotFound            Code = "NOT_FOUND"
+	Conflict            Code = "CONFLICT"
+	UnprocessableEntity Code = "UNPROCESSABLE_ENTITY"
+	TooManyRequests     Code = "TOO_MANY_REQUESTS"
+
+	// Authentication
+	InvalidCredentials Code = "INVALID_CREDENTIALS"
+	InvalidToken       Code = "INVALID_TOKEN"
+	TokenRevoked       Code = "TOKEN_REVOKED"
+	PermissionDenied   Code = "PERMISSION_DENIED"
+
+	// Two-factor authentication
+	TwoFactorRequired       Code = "TWO_FACTOR_REQUIRED"
+	InvalidTwoFactorCode    Code = "INVALID_TWO_FACTOR_CODE"
+	TwoFactorNotEnrolled    Code = "TWO_FACTOR_NOT_ENROLLED"
+	TwoFactorAlreadyEnabled Code = "TWO_FACTOR_ALREADY_ENABLED"
+	TwoFactorNotEnabled     Code = "TWO_FACTOR_NOT_ENABLED"
+
+	// API keys
+	InvalidAPIKey     Code = "INVALID_API_KEY"
+	APIKeyExpired     Code = "API_KEY_EXPIRED"
+	InsufficientScope Code = "INSUFFICIENT_SCOPE"
+	APIKeyNotFound    Code = "API_KEY_NOT_FOUND"
+	InvalidScope      Code = "INVALID_SCOPE"
+
+	// Users
+	UserNotFound      Code = "USER_NOT_FOUND"
+	UserNameTaken     Code = "USER_NAME_TAKEN"
+	EmailTaken        Code = "EMAIL_TAKEN"
+	AccountHasBalance Code = "ACCOUNT_HAS_BALANCE"
+	InvalidRole       Code = "INVALID_ROLE"
+	LastAdmin         Code = "LAST_ADMIN"
+
+	// Wallet
+	InvalidAmount          Code = "INVALID_AMOUNT"
+	InsufficientBalance    Code = "INSUFFICIENT_BALANCE"
+	SelfTransfer           Code = "SELF_TRANSFER"
+	InvalidIdempotencyKey  Code = "INVALID_IDEMPOTENCY_KEY"
+	IdempotencyKeyConflict Code = "IDEMPOTENCY_KEY_CONFLICT"
+	CurrencyNotFound       Code = "CURRENCY_NOT_FOUND"
+	CurrencyExists         Code = "CURRENCY_EXISTS"
+	AmountBelowFee         Code = "AMOUNT_BELOW_FEE"
+	LimitExceeded          Code = "LIMIT_EXCEEDED"
+
+	// Transactions
+	TransactionNotFound Code = "TRANSACTION_NOT_FOUND"
+	AlreadyReversed     Code = "ALREADY_REVERSED"
+	NotReversible       Code = "NOT_REVERSIBLE"
+	FundsAlreadySpent   Code = "FUNDS_ALREADY_SPENT"
+
+	// Holds
+	HoldNotFound   Code = "HOLD_NOT_FOUND"
+	HoldNotPending Code = "HOLD_NOT_PENDING"
+	HoldExpired    Code = "HOLD_EXPIRED"
+
+	// Currency exchange
+	SameCurrency    Code = "SAME_CURRENCY"
+	QuoteNotFound   Code = "QUOTE_NOT_FOUND"
+	QuoteExpired    Code = "QUOTE_EXPIRED"
+	QuoteExecuted   Code = "QUOTE_EXECUTED"
+	RateUnavailable Code = "RATE_UNAVAILABLE"
+
+	// Webhooks
+	WebhookEndpointNotFound Code = "WEBHOOK_ENDPOINT_NOT_FOUND"
+	InvalidWebhookURL       Code = "INVALID_WEBHOOK_URL"
+)
+
+// codeInfo is the numeric code and the HTTP status of an error code
+type codeInfo struct {
+	number int
+	status int
+}
+
+// codes must never be renumbered, numbers are grouped by the area of the API
+var codes = map[Code]codeInfo{
+	Internal:            {1000, http.StatusInternalServerError},
+	InvalidRequest:      {1001, http.StatusBadRequest},
+	ValidationFailed:    {1002, http.StatusBadRequest},
+	Unauthorized:        {1003, http.StatusUnauthorized},
+	Forbidden:           {1004, http.StatusForbidden},
+	NotFound:            {1005, http.StatusNotFound},
+	Conflict:            {1006, http.StatusConflict},
+	UnprocessableEntity: {1007, http.StatusUnprocessableEntity},
+	TooManyRequests:     {1008, http.StatusTooManyRequests},
+
+	InvalidCredentials: {2001, http.StatusUnauthorized},
+	InvalidToken:       {2002, http.StatusUnauthorized},
+	TokenRevoked:       {2003, http.StatusUnauthorized},
+	PermissionDenied:   {2004, http.StatusForbidden},
+
+	TwoFactorRequired:       {2101, http.StatusForbidden},
+	InvalidTwoFactorCode:    {2102, http.StatusUnauthorized},
+	TwoFactorNotEnrolled:    {2103, http.StatusForbidden},
+	TwoFactorAlreadyEnabled: {2104, http.StatusConflict},
+	TwoFactorNotEnabled:     {2105, http.StatusConflict},
+
+	InvalidAPIKey:     {2201, http.StatusUnauthorized},
+	APIKeyExpired:     {2202, http.StatusUnauthorized},
+	InsufficientScope: {2203, http.StatusForbidden},
+	APIKeyNotFound:    {2204, http.StatusNotFound},
+	InvalidScope:      {2205, http.StatusBadRequest},
+
+	UserNotFound:      {3001, http.StatusNotFound},
+	UserNameTaken:     {3002, http.StatusConflict},
+	EmailTaken:        {3003, http.StatusConflict},
+	AccountHasBalance: {3004, http.StatusConflict},
+	InvalidRole:       {3005, http.StatusBadRequest},
+	LastAdmin:         {3006, http.StatusConflict},
+
+	InvalidAmount:          {4001, http.StatusBadRequest},
+	InsufficientBalance:    {4002, http.StatusUnprocessableEntity},
+	SelfTransfer:           {4003, http.StatusBadRequest},
+	InvalidIdempotencyKey:  {4004, http.StatusBadRequest},
+	IdempotencyKeyConflict: {4005, http.StatusConflict},
+	CurrencyNotFound:       {4006, http.StatusNotFound},
+	CurrencyExists:         {4007, http.StatusConflict},
+	AmountBelowFee:         {4008, http.StatusUnprocessableEntity},
+	LimitExceeded:          {4009, http.StatusUnprocessableEntity},
+
+	TransactionNotFound: {5001, http.StatusNotFound},
+	AlreadyReversed:     {5002, http.StatusConflict},
+	NotReversible:       {5003, http.StatusUnprocessableEntity},
+	FundsAlreadySpent:   {5004, http.StatusConflict},
+
+	HoldNotFound:   {6001, http.StatusNotFound},
+	HoldNotPending: {6002, http.StatusConflict},
+	HoldExpired:    {6003, http.StatusConflict},
+
+	SameCurrency:    {7001, http.StatusBadRequest},
+	QuoteNotFound:   {7002, http.StatusNotFound},
+	QuoteExpired:    {7003, http.StatusConflict},
+	QuoteExecuted:   {7004, http.StatusConflict},
+	RateUnavailable: {7005, http.StatusUnprocessableEntity},
+
+	WebhookEndpointNotFound: {8001, http.StatusNotFound},
+	InvalidWebhookURL:       {8002, http.StatusBadRequest},
+}
+
+// statusCodes are the generic codes of errors without a code by HTTP status
+var statusCodes = map[int]Code{
+	http.StatusBadRequest:          InvalidRequest,
+	http.StatusUnauthorized:        Unauthorized,
+	http.StatusForbidden:           Forbidden,
+	http.StatusNotFound:            NotFound,
+	http.StatusConflict:            Conflict,
+	http.StatusUnprocessableEntity: UnprocessableEntity,
+	http.StatusTooManyRequests:     TooManyRequests,
+}
+
+// Number returns the stable numeric code
+func (c Code) Number() int {
+	if info, ok := codes[c]; ok {
+		return info.number
+	}
+	return codes[Internal].number
+}
+
+// Status returns the HTTP status of the responses of the code
+func (c Code) Status() int {
+	if info, ok := codes[c]; ok {
+		return info.status
+	}
+	return http.StatusInternalServerError
+}
+
+// ForStatus returns the generic code of an error without a code responded with the HTTP status
+func ForStatus(status int) Code {
+	if code, ok := statusCodes[status]; ok {
+		return code
+	}
+	if status >= http.StatusBadRequest && status < http.StatusInternalServerError {
+		return InvalidRequest
+	}
+	return Internal
+}
+
+// Error is an error with a stable code
+type Error struct {
+	Code    Code
+	Message string
+}
+
+func New(code Code, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Detailed is implemented by errors carrying details for the client, e.g. a challenge to answer
+type Detailed interface {
+	error
+	ErrorDetails() interface{}
+}
+
+// CodeOf returns the code of the first error with a code in the chain, or Internal if none
+func CodeOf(err error) Code {
+	var coded *Error
+	if errors.As(err, &coded) {
+		return coded.Code
+	}
+	return Internal
+}
+
+// StatusCode returns the HTTP status of the responses of the error
+func StatusCode(err error) int {
+	return CodeOf(err).Status()
+}
+
+// FieldError is the detail of a request field failing validation
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`            // Failed validation rule, e.g. required
+	Param   string `json:"param,omitempty"` // Parameter of the rule, e.g. the maximum length
+	Message string `json:"message"`
+}
+
+// FieldErrors converts the validation errors into per-field details
+func FieldErrors(errs validator.ValidationErrors) []FieldError {
+	details := make([]FieldError, 0, len(errs))
+	for _, fe := range errs {
+		message := fmt.Sprintf("%s failed on the '%s' rule", fe.Field(), fe.Tag())
+		if fe.Param() != "" {
+			message = fmt.Sprintf("%s failed on the '%s=%s' rule", fe.Field(), fe.Tag(), fe.Param())
+		}
+
+		details = append(details, FieldError{
+			Field:   fe.Field(),
+			Rule:    fe.Tag(),
+			Param:   fe.Param(),
+			Message: message,
+		})
+	}
+	return details
+}
@@ -0,0 +1,60 @@
+package apperrors_test
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/stretchr/testify/assert"
+	"github.com/wanliqun/go-wallet-app/apperrors"
+)
+
+func TestCodes(t *testing.T) {
+	errInsufficientBalance := apperrors.New(apperrors.InsufficientBalance, "insufficient balance")
+
+	tests := []struct {
+		name   string
+		err    error
+		code   apperrors.Code
+		number int
+		status int
+	}{
+		{"coded error", errInsufficientBalance, apperrors.InsufficientBalance, 4002, http.StatusUnprocessableEntity},
+		{"wrapped coded error", fmt.Errorf("withdraw: %w", errInsufficientBalance), apperrors.InsufficientBalance, 4002, http.StatusUnprocessableEntity},
+		{"self transfer", apperrors.New(apperrors.SelfTransfer, "cannot transfer to self"), apperrors.SelfTransfer, 4003, http.StatusBadRequest},
+		{"user not found", apperrors.New(apperrors.UserNotFound, "user not found"), apperrors.UserNotFound, 3001, http.StatusNotFound},
+		{"error without code", fmt.Errorf("connection refused"), apperrors.Internal, 1000, http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code := apperrors.CodeOf(tt.err)
+			assert.Equal(t, tt.code, code)
+			assert.Equal(t, tt.number, code.Number())
+			assert.Equal(t, tt.status, apperrors.StatusCode(tt.err))
+		})
+	}
+}
+
+func TestForStatus(t *testing.T) {
+	assert.Equal(t, apperrors.InvalidRequest, apperrors.ForStatus(http.StatusBadRequest))
+	assert.Equal(t, apperrors.Forbidden, apperrors.ForStatus(http.StatusForbidden))
+	assert.Equal(t, apperrors.InvalidRequest, apperrors.ForStatus(http.StatusRequestEntityTooLarge))
+	assert.Equal(t, apperrors.Internal, apperrors.ForStatus(http.StatusBadGateway))
+}
+
+func TestFieldErrors(t *testing.T) {
+	type request struct {
+		Name string `validate:"required"`
+		Memo string `validate:"max=4"`
+	}
+
+	err := validator.New().Struct(request{Memo: "too long"})
+	details := apperrors.FieldErrors(err.(validator.ValidationErrors))
+
+	assert.Equal(t, []apperrors.FieldError{
+		{Field: "Name", Rule: "required", Message: "Name failed on the 'required' rule"},
+		{Field: "Memo", Rule: "max", Param: "4", Message: "Memo failed on the 'max=4' rule"},
+	}, details)
+}
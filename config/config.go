@@ -1,8 +1,11 @@
 package config
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"log"
 	"strings"
+	"time"
 
 	"github.com/mcuadros/go-defaults"
 	"github.com/spf13/viper"
@@ -14,15 +17,127 @@ type Config struct {
 	Database DatabaseConfig
 
 	Server struct {
-		Port string `default:"8080"`
+		Port           string   `default:"8080"`
+		TrustedProxies []string // Proxies whose X-Forwarded-For header is trusted for the client IP, none if empty
+	}
+
+	Auth AuthConfig
+
+	TwoFactor TwoFactorConfig
+
+	RateLimit RateLimitConfig
+
+	Admin struct {
+		Users []string // Names of the users granted the admin role on startup
+	}
+
+	Holds HoldsConfig
+
+	Exchange ExchangeConfig
+
+	Fees map[string]map[string]FeeConfig // Fee schedules keyed by currency and operation (withdrawal or transfer)
+
+	Limits map[string]LimitConfig // Default transaction limits keyed by currency
+
+	Webhooks WebhooksConfig
+
+	Events EventsConfig
+
+	Reconciliation struct {
+		Interval time.Duration // Interval of scheduled reconciliation runs, zero disables the schedule
 	}
 
 	Concurrencies map[string]ConcurrencyConfig
 }
 
+// ConcurrencyConfig defines a currency seeded into the currency registry on startup
 type ConcurrencyConfig struct {
 	Name      string
-	Precision int
+	Precision int // Number of decimal places of the smallest (minor) unit
+}
+
+// AuthConfig defines how access and refresh tokens are signed and validated
+type AuthConfig struct {
+	Secret          string        // HMAC secret used to sign tokens
+	Issuer          string        `default:"go-wallet-app"`
+	Audience        string        `default:"go-wallet-app"`
+	AccessTokenTTL  time.Duration `default:"15m"`
+	RefreshTokenTTL time.Duration `default:"168h"`
+}
+
+// TwoFactorConfig defines the TOTP enrollment and when withdrawals and transfers require a code
+type TwoFactorConfig struct {
+	Issuer             string            `default:"go-wallet-app"` // Issuer shown by authenticator apps
+	Skew               int               `default:"1"`             // Time steps of 30s a code may be off by
+	RecoveryCodes      int               `default:"10"`            // Number of recovery codes generated
+	Required           bool              // Refuse withdrawals and transfers of users without two-factor authentication
+	TransferThresholds map[string]string // Amounts keyed by currency in human units, transfers above which require a code
+}
+
+// RateLimitConfig defines the token bucket budgets of each client, identified by its IP, user and
+// API key. A bucket holds up to the limit of requests and is refilled from empty over the period.
+type RateLimitConfig struct {
+	IPLimit     int           `default:"300"` // Requests of an IP before authentication, so that invalid credentials are limited too
+	IPPeriod    time.Duration `default:"1m"`
+	AuthLimit   int           `default:"10"` // Logins, token refreshes and sign-ups of an IP
+	AuthPeriod  time.Duration `default:"1m"`
+	ReadLimit   int           `default:"120"` // Requests other than money-moving writes, zero disables the limit
+	ReadPeriod  time.Duration `default:"1m"`
+	WriteLimit  int           `default:"20"` // Deposits, withdrawals, transfers, exchanges and holds, zero disables the limit
+	WritePeriod time.Duration `default:"1m"`
+}
+
+// HoldsConfig defines how long funds can be held before the hold expires
+type HoldsConfig struct {
+	TTL            time.Duration `default:"24h"` // Lifetime of a pending hold
+	ExpiryInterval time.Duration `default:"1m"`  // Interval of sweeping expired holds
+}
+
+// ExchangeConfig defines currency exchange quotes and the static exchange rates
+type ExchangeConfig struct {
+	QuoteTTL time.Duration     `default:"30s"` // Lifetime of an exchange quote
+	Rates    map[string]string // Rates keyed by currency pair, e.g. "BTC/USDT": "65000" for 1 BTC = 65000 USDT
+}
+
+// FeeConfig defines the fee schedule of an operation in a currency, amounts are in human units
+type FeeConfig struct {
+	Type   string // Fee type: flat, percentage or tiered
+	Amount string // Flat fee
+	Rate   string // Percentage fee as a fraction of the amount, e.g. "0.001" for 0.1%
+	Min    string // Minimum fee, empty for no minimum
+	Max    string // Maximum fee, empty for no maximum
+	Tiers  []FeeTierConfig
+}
+
+// FeeTierConfig defines the flat and percentage fee of amounts below the upper bound of a tier
+type FeeTierConfig struct {
+	UpTo   string // Exclusive upper bound of the tier, empty for the last tier
+	Amount string
+	Rate   string
+}
+
+// LimitConfig defines the default limits of the funds a user can send out in a currency, amounts are
+// in human units and an empty limit means no limit
+type LimitConfig struct {
+	PerTransaction string // Maximum amount of a single withdrawal or transfer
+	Daily          string // Maximum amount sent out per calendar day (UTC)
+	Monthly        string // Maximum amount sent out per calendar month (UTC)
+}
+
+// WebhooksConfig defines how events are delivered to the webhook endpoints
+type WebhooksConfig struct {
+	Interval      time.Duration `default:"5s"`  // Interval of dispatching events, zero disables dispatching
+	Timeout       time.Duration `default:"10s"` // Timeout of a delivery attempt
+	MaxAttempts   int           `default:"8"`   // Attempts before a delivery is given up
+	RetryBackoff  time.Duration `default:"30s"` // Delay before the first retry, doubled for every further retry
+	MaxBackoff    time.Duration `default:"1h"`  // Maximum delay between retries
+	AllowInsecure bool          // Allow plain http endpoints and private addresses, for development only
+}
+
+// EventsConfig defines how the committed events are relayed to the event publisher
+type EventsConfig struct {
+	RelayInterval time.Duration `default:"1s"`   // Interval of relaying events, zero disables relaying
+	Capacity      int           `default:"1000"` // Number of the latest events kept by the in-memory publisher
 }
 
 // AppConfig is the global configuration instance
@@ -50,6 +165,7 @@ func LoadConfig() {
 	viper.BindEnv("database.password")
 	viper.BindEnv("database.database")
 	viper.BindEnv("database.sslmode")
+	viper.BindEnv("auth.secret")
 
 	// Set up environment variable bindings (prefix with APP_)
 	viper.SetEnvPrefix("APP")
@@ -60,6 +176,21 @@ func LoadConfig() {
 	if err := viper.Unmarshal(&AppConfig); err != nil {
 		log.Fatalf("unable to decode into struct, %v", err)
 	}
+
+	// Fall back to a random secret, tokens will not survive a restart
+	if AppConfig.Auth.Secret == "" {
+		log.Println("No auth secret configured, generating an ephemeral one")
+		AppConfig.Auth.Secret = mustGenerateSecret()
+	}
+}
+
+// mustGenerateSecret generates a random hex encoded secret or panics on error.
+func mustGenerateSecret() string {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		log.Fatalf("failed to generate secret: %v", err)
+	}
+	return hex.EncodeToString(buf)
 }
 
 // SetupDatabase initializes the database connection using GORM and the config values
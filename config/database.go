@@ -3,6 +3,7 @@ package config
 import (
 	"fmt"
 	"log"
+	"time"
 
 	"github.com/wanliqun/go-wallet-app/models"
 	"gorm.io/driver/postgres"
@@ -14,6 +15,22 @@ var allModels = []interface{}{
 	&models.User{},
 	&models.Vault{},
 	&models.Transaction{},
+	&models.IdempotencyKey{},
+	&models.RevokedToken{},
+	&models.Currency{},
+	&models.LedgerAccount{},
+	&models.JournalEntry{},
+	&models.Posting{},
+	&models.ReconciliationRun{},
+	&models.Hold{},
+	&models.ExchangeQuote{},
+	&models.UserLimit{},
+	&models.OutboxEvent{},
+	&models.WebhookEndpoint{},
+	&models.WebhookDelivery{},
+	&models.RecoveryCode{},
+	&models.APIKey{},
+	&models.SchemaMigration{},
 }
 
 type DatabaseConfig struct {
@@ -23,20 +40,35 @@ type DatabaseConfig struct {
 	Password string `default:"postgres"`
 	Database string `default:"wallet_db"`
 	SSLMode  string `default:"disable"`
+
+	Timeouts TimeoutsConfig
+}
+
+// TimeoutsConfig bounds the time the service operations may spend on the database, so that a
+// slow query or a disconnected client does not hold row locks indefinitely. Zero disables the timeout.
+type TimeoutsConfig struct {
+	Default    time.Duration            `default:"10s"`
+	Operations map[string]time.Duration // Timeouts overriding the default by operation, e.g. "transfer"
+}
+
+// For returns the timeout of the operation
+func (c TimeoutsConfig) For(operation string) time.Duration {
+	if timeout, ok := c.Operations[operation]; ok {
+		return timeout
+	}
+	return c.Default
 }
 
 // MustOpenOrCreate creates an instance of store or panics on any error.
 func (config *DatabaseConfig) MustOpenOrCreate() *gorm.DB {
-	// Create the database if absent and return if it was newly created
-	newCreated := config.mustCreateDatabaseIfAbsent()
+	// Create the database if absent
+	config.mustCreateDatabaseIfAbsent()
 
 	// Connect to the specified database
 	db := config.mustConnect(config.Database)
 
-	// Auto-migrate tables if the database was newly created
-	if newCreated {
-		config.autoMigrateTables(db)
-	}
+	// Auto-migrate tables, so that tables added since the database was created are migrated too
+	config.autoMigrateTables(db)
 
 	log.Println("PostgreSQL database initialized")
 	return db
@@ -0,0 +1,185 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wanliqun/go-wallet-app/apperrors"
+	"github.com/wanliqun/go-wallet-app/models"
+	"github.com/wanliqun/go-wallet-app/services"
+	"github.com/wanliqun/go-wallet-app/utils"
+)
+
+// APIKeyController manages the API keys of the acting user under /users/me/api-keys, and those of
+// the service accounts under /admin/service-accounts/:id/api-keys.
+type APIKeyController struct {
+	APIKeyService services.IAPIKeyService
+	UserService   services.IUserService
+	TwoFactor     services.ITwoFactorService // Step-up authentication of keys granted the transfer scope, none if nil
+}
+
+func NewAPIKeyController(apiKey services.IAPIKeyService, user services.IUserService) *APIKeyController {
+	return &APIKeyController{APIKeyService: apiKey, UserService: user}
+}
+
+// POST /api-keys
+func (ctrl *APIKeyController) CreateAPIKey(c *gin.Context) {
+	ownerID, ok := ctrl.owner(c)
+	if !ok {
+		return
+	}
+
+	var cRequest CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&cRequest); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	stepUp := stepUpCheck(c, ctrl.TwoFactor, services.StepUpAction{Scope: services.ScopeTransfer})
+	apiKey, key, err := ctrl.APIKeyService.CreateAPIKey(
+		c.Request.Context(), ownerID, cRequest.Name, toScopes(cRequest.Scopes), cRequest.ExpiresAt, stepUp)
+	if err != nil {
+		utils.ErrorResponse(c, apperrors.StatusCode(err), err)
+		return
+	}
+
+	// The key is only disclosed once, only its hash is stored
+	resp := newAPIKeyResponse(apiKey)
+	resp.Key = key
+	utils.SuccessResponse(c, resp)
+}
+
+// GET /api-keys
+func (ctrl *APIKeyController) ListAPIKeys(c *gin.Context) {
+	ownerID, ok := ctrl.owner(c)
+	if !ok {
+		return
+	}
+
+	apiKeys, err := ctrl.APIKeyService.ListAPIKeys(c.Request.Context(), ownerID)
+	if err != nil {
+		utils.ErrorResponse(c, apperrors.StatusCode(err), err)
+		return
+	}
+
+	resp := make([]APIKeyResponse, 0, len(apiKeys))
+	for i := range apiKeys {
+		resp = append(resp, newAPIKeyResponse(&apiKeys[i]))
+	}
+	utils.SuccessResponse(c, resp)
+}
+
+// GET /api-keys/:key_id
+func (ctrl *APIKeyController) GetAPIKey(c *gin.Context) {
+	ownerID, ok := ctrl.owner(c)
+	if !ok {
+		return
+	}
+
+	var uri APIKeyURI
+	if err := c.ShouldBindUri(&uri); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	apiKey, err := ctrl.APIKeyService.GetAPIKey(c.Request.Context(), ownerID, uri.KeyID)
+	if err != nil {
+		utils.ErrorResponse(c, apperrors.StatusCode(err), err)
+		return
+	}
+
+	utils.SuccessResponse(c, newAPIKeyResponse(apiKey))
+}
+
+// PATCH /api-keys/:key_id
+func (ctrl *APIKeyController) UpdateAPIKey(c *gin.Context) {
+	ownerID, ok := ctrl.owner(c)
+	if !ok {
+		return
+	}
+
+	var uri APIKeyURI
+	if err := c.ShouldBindUri(&uri); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	var cRequest UpdateAPIKeyRequest
+	if err := c.ShouldBindJSON(&cRequest); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	stepUp := stepUpCheck(c, ctrl.TwoFactor, services.StepUpAction{Scope: services.ScopeTransfer})
+	apiKey, err := ctrl.APIKeyService.UpdateAPIKey(c.Request.Context(), ownerID, uri.KeyID, services.APIKeyUpdate{
+		Name:      cRequest.Name,
+		Scopes:    toScopes(cRequest.Scopes),
+		ExpiresAt: cRequest.ExpiresAt,
+	}, stepUp)
+	if err != nil {
+		utils.ErrorResponse(c, apperrors.StatusCode(err), err)
+		return
+	}
+
+	utils.SuccessResponse(c, newAPIKeyResponse(apiKey))
+}
+
+// DELETE /api-keys/:key_id
+func (ctrl *APIKeyController) DeleteAPIKey(c *gin.Context) {
+	ownerID, ok := ctrl.owner(c)
+	if !ok {
+		return
+	}
+
+	var uri APIKeyURI
+	if err := c.ShouldBindUri(&uri); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := ctrl.APIKeyService.DeleteAPIKey(c.Request.Context(), ownerID, uri.KeyID); err != nil {
+		utils.ErrorResponse(c, apperrors.StatusCode(err), err)
+		return
+	}
+
+	utils.SuccessResponse(c, nil)
+}
+
+// owner returns the ID of the user owning the keys: the service account identified by the URI of the
+// admin routes, or else the acting user. It responds the error and returns false on failure.
+func (ctrl *APIKeyController) owner(c *gin.Context) (uint, bool) {
+	if c.Param("id") == "" {
+		return c.MustGet("user").(*models.User).ID, true
+	}
+
+	var uri UserURI
+	if err := c.ShouldBindUri(&uri); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err)
+		return 0, false
+	}
+
+	account, ok, err := ctrl.UserService.GetUserByID(c.Request.Context(), uri.ID)
+	if err != nil {
+		utils.ErrorResponse(c, apperrors.StatusCode(err), err)
+		return 0, false
+	}
+	if !ok || !account.ServiceAccount {
+		utils.ErrorResponse(c, http.StatusNotFound, services.ErrUserNotFound)
+		return 0, false
+	}
+
+	return account.ID, true
+}
+
+// toScopes converts the validated scopes of a request, keeping nil for omitted scopes
+func toScopes(values []string) []services.Scope {
+	if values == nil {
+		return nil
+	}
+
+	scopes := make([]services.Scope, 0, len(values))
+	for _, value := range values {
+		scopes = append(scopes, services.Scope(value))
+	}
+	return scopes
+}
@@ -0,0 +1,302 @@
+package controllers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/wanliqun/go-wallet-app/controllers"
+	"github.com/wanliqun/go-wallet-app/middlewares"
+	"github.com/wanliqun/go-wallet-app/mocks"
+	"github.com/wanliqun/go-wallet-app/models"
+	"github.com/wanliqun/go-wallet-app/services"
+)
+
+func TestAPIKeyController(t *testing.T) {
+	mockAPIKeyService := new(mocks.MockAPIKeyService)
+	mockUserService := new(mocks.MockUserService)
+	mockAuthService := new(mocks.MockAuthService)
+	mockTwoFactorService := new(mocks.MockTwoFactorService)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+
+	authorizer := services.NewRoleAuthorizer(services.DefaultRolePermissions)
+	apiKeyController := controllers.NewAPIKeyController(mockAPIKeyService, mockUserService)
+	apiKeyController.TwoFactor = mockTwoFactorService
+	userController := controllers.NewUserController(mockUserService)
+
+	apiKeyRouter := router.Group("/users/me/api-keys", middlewares.AuthMiddleware(mockAuthService, nil))
+	{
+		apiKeyRouter.POST("", apiKeyController.CreateAPIKey)
+		apiKeyRouter.GET("", apiKeyController.ListAPIKeys)
+		apiKeyRouter.PATCH("/:key_id", apiKeyController.UpdateAPIKey)
+		apiKeyRouter.DELETE("/:key_id", apiKeyController.DeleteAPIKey)
+	}
+	serviceAccountRouter := router.Group("/admin/service-accounts",
+		middlewares.AuthMiddleware(mockAuthService, nil), middlewares.PermissionMiddleware(authorizer, services.PermManageServices))
+	{
+		serviceAccountRouter.POST("", userController.CreateServiceAccount)
+		serviceAccountRouter.POST("/:id/api-keys", apiKeyController.CreateAPIKey)
+	}
+
+	testUser := userGenerator.Generate()
+	mockAuthService.On("Authenticate", testUser.Name).Return(testUser, nil)
+
+	adminUser := userGenerator.Generate()
+	adminUser.Role = models.RoleAdmin
+	mockAuthService.On("Authenticate", adminUser.Name).Return(adminUser, nil)
+
+	serve := func(method, path, token string, body interface{}) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, http.NoBody)
+		if body != nil {
+			data, _ := json.Marshal(body)
+			req, _ = http.NewRequest(method, path, bytes.NewBuffer(data))
+			req.Header.Set("Content-Type", "application/json")
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	apiKey := &models.APIKey{UserID: testUser.ID, Name: "reporting", Prefix: "gwk_0123456789ab", Scopes: "balances:read"}
+	apiKey.ID = 1
+
+	t.Run("should disclose the key once issued", func(t *testing.T) {
+		mockAPIKeyService.On("CreateAPIKey", mock.Anything, testUser.ID, "reporting",
+			[]services.Scope{services.ScopeReadBalances}, (*time.Time)(nil), mock.Anything).
+			Return(apiKey, "gwk_0123456789ab_secret", nil).Once()
+
+		w := serve("POST", "/users/me/api-keys", testUser.Name, map[string]interface{}{
+			"name": "reporting", "scopes": []string{"balances:read"},
+		})
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var resp struct {
+			Data controllers.APIKeyResponse
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		assert.Equal(t, "gwk_0123456789ab_secret", resp.Data.Key)
+		assert.Equal(t, []string{"balances:read"}, resp.Data.Scopes)
+	})
+
+	t.Run("should list the keys without disclosing them", func(t *testing.T) {
+		mockAPIKeyService.On("ListAPIKeys", mock.Anything, testUser.ID).Return([]models.APIKey{*apiKey}, nil).Once()
+
+		w := serve("GET", "/users/me/api-keys", testUser.Name, nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"prefix":"gwk_0123456789ab"`)
+		assert.NotContains(t, w.Body.String(), `"key"`)
+	})
+
+	t.Run("should not issue transfer keys without a two-factor code", func(t *testing.T) {
+		// The step-up check is run by the service within the transaction issuing the key
+		var stepUp services.StepUpCheck
+		challenge := &services.StepUpRequiredError{
+			Challenge: services.TwoFactorChallenge{Type: "totp", Reasons: []string{services.StepUpTransferKey}},
+		}
+		mockAPIKeyService.On("CreateAPIKey", mock.Anything, testUser.ID, "payouts",
+			[]services.Scope{services.ScopeTransfer}, (*time.Time)(nil), mock.Anything).
+			Run(func(args mock.Arguments) { stepUp = args.Get(5).(services.StepUpCheck) }).
+			Return(nil, "", challenge).Once()
+
+		w := serve("POST", "/users/me/api-keys", testUser.Name, map[string]interface{}{
+			"name": "payouts", "scopes": []string{"transfer:write"},
+		})
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), "TWO_FACTOR_REQUIRED")
+		assert.NotContains(t, w.Body.String(), `"key"`)
+
+		mockTwoFactorService.On("StepUp", mock.Anything, testUser,
+			services.StepUpAction{Scope: services.ScopeTransfer}, "").Return(challenge).Once()
+		if assert.NotNil(t, stepUp) {
+			assert.Equal(t, challenge, stepUp(nil))
+		}
+		mockTwoFactorService.AssertExpectations(t)
+	})
+
+	t.Run("should reject unknown scopes", func(t *testing.T) {
+		w := serve("POST", "/users/me/api-keys", testUser.Name, map[string]interface{}{
+			"name": "admin", "scopes": []string{"users:roles"},
+		})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "VALIDATION_FAILED")
+	})
+
+	t.Run("should revoke the key", func(t *testing.T) {
+		mockAPIKeyService.On("DeleteAPIKey", mock.Anything, testUser.ID, uint(2)).Return(services.ErrAPIKeyNotFound).Once()
+
+		w := serve("DELETE", "/users/me/api-keys/2", testUser.Name, nil)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Contains(t, w.Body.String(), "API_KEY_NOT_FOUND")
+	})
+
+	t.Run("should issue keys for service accounts only", func(t *testing.T) {
+		account := userGenerator.Generate()
+		account.ServiceAccount = true
+		account.Role = models.RoleSupport
+		mockUserService.On("CreateServiceAccount", mock.Anything, account.Name, models.RoleSupport).Return(account, nil).Once()
+
+		w := serve("POST", "/admin/service-accounts", adminUser.Name, map[string]string{"name": account.Name, "role": "support"})
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"service_account":true`)
+
+		mockUserService.On("GetUserByID", mock.Anything, account.ID).Return(account, true, nil).Once()
+		mockAPIKeyService.On("CreateAPIKey", mock.Anything, account.ID, "reporting",
+			[]services.Scope{services.ScopeReadBalances}, (*time.Time)(nil), mock.Anything).
+			Return(&models.APIKey{UserID: account.ID, Name: "reporting", Scopes: "balances:read"}, "gwk_key", nil).Once()
+
+		body := map[string]interface{}{"name": "reporting", "scopes": []string{"balances:read"}}
+		w = serve("POST", fmt.Sprintf("/admin/service-accounts/%d/api-keys", account.ID), adminUser.Name, body)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		mockUserService.On("GetUserByID", mock.Anything, testUser.ID).Return(testUser, true, nil).Once()
+		w = serve("POST", fmt.Sprintf("/admin/service-accounts/%d/api-keys", testUser.ID), adminUser.Name, body)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("should forbid users without the permission", func(t *testing.T) {
+		w := serve("POST", "/admin/service-accounts", testUser.Name, map[string]string{"name": "job", "role": "support"})
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+}
+
+func TestAPIKeyAuthentication(t *testing.T) {
+	mockAPIKeyService := new(mocks.MockAPIKeyService)
+	mockWalletService := new(mocks.MockWalletService)
+	mockUserService := new(mocks.MockUserService)
+	mockAuthService := new(mocks.MockAuthService)
+	mockTwoFactorService := new(mocks.MockTwoFactorService)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+
+	authMiddleware := middlewares.AuthMiddleware(mockAuthService, mockAPIKeyService)
+	authorizer := services.NewRoleAuthorizer(services.DefaultRolePermissions)
+	walletController := controllers.NewWalletController(mockWalletService, mockUserService)
+	walletController.TwoFactor = mockTwoFactorService
+	router.GET("/wallet/balances", authMiddleware,
+		middlewares.ScopeMiddleware(services.ScopeReadBalances), walletController.GetBalances)
+	router.POST("/wallet/transfer", authMiddleware,
+		middlewares.ScopeMiddleware(services.ScopeTransfer), walletController.Transfer)
+	router.GET("/admin/users/:id/balances", authMiddleware,
+		middlewares.PermissionMiddleware(authorizer, services.PermViewBalances), walletController.GetUserBalances)
+	router.GET("/admin/users/:id/transactions", authMiddleware,
+		middlewares.PermissionMiddleware(authorizer, services.PermViewTransactions), walletController.GetUserTransactionHistory)
+
+	testUser := userGenerator.Generate()
+	mockAuthService.On("Authenticate", testUser.Name).Return(testUser, nil)
+
+	account := userGenerator.Generate()
+	account.ServiceAccount = true
+	account.Role = models.RoleSupport
+
+	customer := userGenerator.Generate()
+	mockUserService.On("GetUserByID", mock.Anything, customer.ID).Return(customer, true, nil)
+
+	const (
+		balancesKey = "gwk_000000000001_balances"
+		historyKey  = "gwk_000000000002_history"
+		accountKey  = "gwk_000000000003_account"
+		expiredKey  = "gwk_000000000004_expired"
+		transferKey = "gwk_000000000005_transfer"
+	)
+	mockAPIKeyService.On("Authenticate", mock.Anything, balancesKey).
+		Return(&models.APIKey{UserID: testUser.ID, Scopes: "balances:read"}, testUser, nil)
+	mockAPIKeyService.On("Authenticate", mock.Anything, historyKey).
+		Return(&models.APIKey{UserID: testUser.ID, Scopes: "transactions:read"}, testUser, nil)
+	mockAPIKeyService.On("Authenticate", mock.Anything, accountKey).
+		Return(&models.APIKey{UserID: account.ID, Scopes: "balances:read"}, account, nil)
+	mockAPIKeyService.On("Authenticate", mock.Anything, expiredKey).Return(nil, nil, services.ErrAPIKeyExpired)
+	mockAPIKeyService.On("Authenticate", mock.Anything, transferKey).
+		Return(&models.APIKey{UserID: account.ID, Scopes: "transfer:write"}, account, nil)
+
+	serve := func(path, token string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	balances := []models.Vault{{UserID: testUser.ID, Currency: "USDT", Amount: decimal.NewFromInt(100)}}
+
+	t.Run("should accept user tokens and keys granted the scope", func(t *testing.T) {
+		mockWalletService.On("GetBalances", mock.Anything, testUser.ID, []string{"USDT"}).Return(balances, nil).Twice()
+
+		w := serve("/wallet/balances?currency=USDT", testUser.Name)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		w = serve("/wallet/balances?currency=USDT", balancesKey)
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("should refuse keys lacking the scope", func(t *testing.T) {
+		w := serve("/wallet/balances?currency=USDT", historyKey)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), "INSUFFICIENT_SCOPE")
+	})
+
+	t.Run("should refuse expired keys", func(t *testing.T) {
+		w := serve("/wallet/balances?currency=USDT", expiredKey)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Contains(t, w.Body.String(), "API_KEY_EXPIRED")
+	})
+
+	t.Run("should let service accounts exercise the permissions within the scopes", func(t *testing.T) {
+		mockWalletService.On("GetBalances", mock.Anything, customer.ID, []string{"USDT"}).Return(balances, nil).Once()
+
+		w := serve(fmt.Sprintf("/admin/users/%d/balances?currency=USDT", customer.ID), accountKey)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		// The role of the account grants the permission, but the key lacks the scope
+		w = serve(fmt.Sprintf("/admin/users/%d/transactions", customer.ID), accountKey)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), "INSUFFICIENT_SCOPE")
+
+		// The key has the scope, but the role of its user lacks the permission
+		w = serve(fmt.Sprintf("/admin/users/%d/balances?currency=USDT", customer.ID), balancesKey)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), "PERMISSION_DENIED")
+	})
+
+	t.Run("should exempt keys from the step-up of transfers", func(t *testing.T) {
+		transfer := func(token string) *httptest.ResponseRecorder {
+			data, _ := json.Marshal(map[string]string{"recipient": customer.Name, "currency": "USDT", "amount": "1"})
+			req, _ := http.NewRequest("POST", "/wallet/transfer", bytes.NewBuffer(data))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", "Bearer "+token)
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			return w
+		}
+		mockUserService.On("GetUserByName", mock.Anything, customer.Name).Return(customer, true, nil).Twice()
+
+		mockWalletService.On("Transfer", mock.Anything, account.ID, customer.ID, "USDT", mock.Anything, "", "",
+			mock.MatchedBy(func(stepUp services.StepUpCheck) bool { return stepUp == nil })).
+			Return(&models.Transaction{}, nil).Once()
+		w := transfer(transferKey)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		// Users are still checked
+		mockWalletService.On("Transfer", mock.Anything, testUser.ID, customer.ID, "USDT", mock.Anything, "", "",
+			mock.MatchedBy(func(stepUp services.StepUpCheck) bool { return stepUp != nil })).
+			Return(&models.Transaction{}, nil).Once()
+		w = transfer(testUser.Name)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		mockWalletService.AssertExpectations(t)
+	})
+}
@@ -0,0 +1,85 @@
+package controllers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wanliqun/go-wallet-app/apperrors"
+	"github.com/wanliqun/go-wallet-app/services"
+	"github.com/wanliqun/go-wallet-app/utils"
+)
+
+type AuthController struct {
+	AuthService services.IAuthService
+}
+
+func NewAuthController(auth services.IAuthService) *AuthController {
+	return &AuthController{AuthService: auth}
+}
+
+// POST /login
+func (ctrl *AuthController) Login(c *gin.Context) {
+	var cRequest LoginRequest
+	if err := c.ShouldBindJSON(&cRequest); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	tokens, err := ctrl.AuthService.Login(cRequest.Name, cRequest.Password)
+	if err != nil {
+		utils.ErrorResponse(c, apperrors.StatusCode(err), err)
+		return
+	}
+
+	utils.SuccessResponse(c, tokens)
+}
+
+// POST /refresh
+func (ctrl *AuthController) Refresh(c *gin.Context) {
+	var cRequest RefreshRequest
+	if err := c.ShouldBindJSON(&cRequest); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	tokens, err := ctrl.AuthService.Refresh(cRequest.RefreshToken)
+	if err != nil {
+		utils.ErrorResponse(c, apperrors.StatusCode(err), err)
+		return
+	}
+
+	utils.SuccessResponse(c, tokens)
+}
+
+// POST /logout
+func (ctrl *AuthController) Logout(c *gin.Context) {
+	// The request body is optional
+	var cRequest LogoutRequest
+	if err := c.ShouldBindJSON(&cRequest); err != nil && !errors.Is(err, io.EOF) {
+		utils.ErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	// Revoke the access token used for this request
+	token, err := utils.ExtractBearerToken(c)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusUnauthorized, err)
+		return
+	}
+	if err := ctrl.AuthService.Revoke(token); err != nil {
+		utils.ErrorResponse(c, apperrors.StatusCode(err), err)
+		return
+	}
+
+	// Revoke the refresh token as well if provided
+	if cRequest.RefreshToken != "" {
+		if err := ctrl.AuthService.Revoke(cRequest.RefreshToken); err != nil {
+			utils.ErrorResponse(c, apperrors.StatusCode(err), err)
+			return
+		}
+	}
+
+	utils.SuccessResponse(c, nil)
+}
@@ -0,0 +1,128 @@
+package controllers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/wanliqun/go-wallet-app/controllers"
+	"github.com/wanliqun/go-wallet-app/middlewares"
+	"github.com/wanliqun/go-wallet-app/mocks"
+	"github.com/wanliqun/go-wallet-app/services"
+)
+
+func setupAuthTestRouter(authService *mocks.MockAuthService) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+
+	authController := controllers.NewAuthController(authService)
+	authRouter := router.Group("/auth")
+	{
+		authRouter.POST("/login", authController.Login)
+		authRouter.POST("/refresh", authController.Refresh)
+		authRouter.POST("/logout", middlewares.AuthMiddleware(authService, nil), authController.Logout)
+	}
+
+	return router
+}
+
+func TestAuthController_Login(t *testing.T) {
+	mockAuthService := new(mocks.MockAuthService)
+	router := setupAuthTestRouter(mockAuthService)
+
+	testUser := userGenerator.Generate()
+
+	t.Run("should login successfully", func(t *testing.T) {
+		tokens := &services.TokenPair{AccessToken: "access", RefreshToken: "refresh", TokenType: "Bearer", ExpiresIn: 900}
+		mockAuthService.On("Login", testUser.Name, "password").Return(tokens, nil)
+
+		body, _ := json.Marshal(map[string]interface{}{"name": testUser.Name, "password": "password"})
+		req, _ := http.NewRequest("POST", "/auth/login", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var resp struct {
+			Code int
+			Data services.TokenPair
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		assert.Equal(t, *tokens, resp.Data)
+	})
+
+	t.Run("should return unauthorized for invalid credentials", func(t *testing.T) {
+		mockAuthService.On("Login", testUser.Name, "wrong").Return(nil, services.ErrInvalidCredentials)
+
+		body, _ := json.Marshal(map[string]interface{}{"name": testUser.Name, "password": "wrong"})
+		req, _ := http.NewRequest("POST", "/auth/login", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+}
+
+func TestAuthController_Refresh(t *testing.T) {
+	mockAuthService := new(mocks.MockAuthService)
+	router := setupAuthTestRouter(mockAuthService)
+
+	t.Run("should return unauthorized for a revoked refresh token", func(t *testing.T) {
+		mockAuthService.On("Refresh", "used-refresh-token").Return(nil, services.ErrTokenRevoked)
+
+		body, _ := json.Marshal(map[string]interface{}{"refresh_token": "used-refresh-token"})
+		req, _ := http.NewRequest("POST", "/auth/refresh", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+}
+
+func TestAuthController_Logout(t *testing.T) {
+	mockAuthService := new(mocks.MockAuthService)
+	router := setupAuthTestRouter(mockAuthService)
+
+	testUser := userGenerator.Generate()
+
+	t.Run("should revoke both tokens", func(t *testing.T) {
+		mockAuthService.On("Authenticate", "access-token").Return(testUser, nil)
+		mockAuthService.On("Revoke", "access-token").Return(nil)
+		mockAuthService.On("Revoke", "refresh-token").Return(nil)
+
+		body, _ := json.Marshal(map[string]interface{}{"refresh_token": "refresh-token"})
+		req, _ := http.NewRequest("POST", "/auth/logout", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer access-token")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		mockAuthService.AssertCalled(t, "Revoke", "access-token")
+		mockAuthService.AssertCalled(t, "Revoke", "refresh-token")
+	})
+
+	t.Run("should reject an invalid access token", func(t *testing.T) {
+		mockAuthService.On("Authenticate", "bad-token").Return(nil, services.ErrInvalidToken)
+
+		req, _ := http.NewRequest("POST", "/auth/logout", nil)
+		req.Header.Set("Authorization", "Bearer bad-token")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		mockAuthService.AssertNotCalled(t, "Revoke", "bad-token")
+	})
+}
@@ -0,0 +1,216 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/shopspring/decimal"
+	"github.com/wanliqun/go-wallet-app/apperrors"
+	"github.com/wanliqun/go-wallet-app/models"
+	"github.com/wanliqun/go-wallet-app/services"
+	"github.com/wanliqun/go-wallet-app/utils"
+)
+
+// currencyRegistry is consulted by the request validators, all currencies
+// are accepted with zero precision until one is set.
+var currencyRegistry services.ICurrencyRegistry
+
+// SetCurrencyRegistry sets the currency registry consulted by the request validators
+func SetCurrencyRegistry(registry services.ICurrencyRegistry) {
+	currencyRegistry = registry
+}
+
+// currencyPrecision returns the number of decimal places of the currency's minor unit
+func currencyPrecision(currency string) int32 {
+	if currencyRegistry == nil {
+		return 0
+	}
+
+	conf, _ := currencyRegistry.LookupCurrency(currency)
+	return conf.Precision
+}
+
+// toMinorUnits converts a validated amount in human units to integer minor units of the currency
+func toMinorUnits(currency string, amount decimal.Decimal) decimal.Decimal {
+	return amount.Shift(currencyPrecision(currency)).Truncate(0)
+}
+
+// validateAmount rejects amounts with more decimal places than the currency precision,
+// or outside of the per transaction limits of the currency.
+func validateAmount(sl validator.StructLevel) {
+	var currency string
+	var amount decimal.Decimal
+	switch req := sl.Current().Interface().(type) {
+	case DepositRequest:
+		currency, amount = req.Currency, req.Amount
+	case WithdrawRequest:
+		currency, amount = req.Currency, req.Amount
+	case TransferRequest:
+		currency, amount = req.Currency, req.Amount
+	case HoldRequest:
+		currency, amount = req.Currency, req.Amount
+	case FeeQuery:
+		currency, amount = req.Currency, req.Amount
+	case ExchangeQuoteRequest:
+		currency, amount = req.From, req.Amount
+	case ExchangeRequest:
+		if req.QuoteID != 0 {
+			return
+		}
+		if !req.Amount.IsPositive() {
+			sl.ReportError(req.Amount, "amount", "Amount", "positive_decimal", "")
+			return
+		}
+		currency, amount = req.From, req.Amount
+	default:
+		return
+	}
+
+	minorAmount, err := utils.ToMinorUnits(amount, currencyPrecision(currency))
+	if err != nil {
+		sl.ReportError(amount, "amount", "Amount", "precision", "")
+		return
+	}
+
+	if currencyRegistry == nil {
+		return
+	}
+
+	conf, ok := currencyRegistry.LookupCurrency(currency)
+	if !ok {
+		return
+	}
+	if conf.MinAmount.IsPositive() && minorAmount.LessThan(conf.MinAmount) {
+		sl.ReportError(amount, "amount", "Amount", "min_amount", utils.FormatMinorUnits(conf.MinAmount, conf.Precision))
+	}
+	if conf.MaxAmount.IsPositive() && minorAmount.GreaterThan(conf.MaxAmount) {
+		sl.ReportError(amount, "amount", "Amount", "max_amount", utils.FormatMinorUnits(conf.MaxAmount, conf.Precision))
+	}
+}
+
+type CurrencyController struct {
+	CurrencyService services.ICurrencyService
+}
+
+func NewCurrencyController(currency services.ICurrencyService) *CurrencyController {
+	return &CurrencyController{CurrencyService: currency}
+}
+
+// GET /currencies
+func (ctrl *CurrencyController) ListCurrencies(c *gin.Context) {
+	ctrl.listCurrencies(c, false)
+}
+
+// GET /admin/currencies
+func (ctrl *CurrencyController) ListAllCurrencies(c *gin.Context) {
+	ctrl.listCurrencies(c, true)
+}
+
+func (ctrl *CurrencyController) listCurrencies(c *gin.Context, includeDisabled bool) {
+	currencies, err := ctrl.CurrencyService.ListCurrencies(includeDisabled)
+	if err != nil {
+		utils.ErrorResponse(c, apperrors.StatusCode(err), err)
+		return
+	}
+
+	response := make([]CurrencyResponse, 0, len(currencies))
+	for i := range currencies {
+		response = append(response, newCurrencyResponse(&currencies[i]))
+	}
+
+	utils.SuccessResponse(c, response)
+}
+
+// POST /admin/currencies
+func (ctrl *CurrencyController) CreateCurrency(c *gin.Context) {
+	var cRequest CreateCurrencyRequest
+	if err := c.ShouldBindJSON(&cRequest); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	currency := models.Currency{
+		Code:      cRequest.Code,
+		Name:      cRequest.Name,
+		Precision: *cRequest.Precision,
+		Enabled:   cRequest.Enabled == nil || *cRequest.Enabled,
+	}
+
+	var err error
+	if currency.MinAmount, err = optionalMinorUnits(cRequest.MinAmount, currency.Precision); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+	if currency.MaxAmount, err = optionalMinorUnits(cRequest.MaxAmount, currency.Precision); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := ctrl.CurrencyService.CreateCurrency(&currency); err != nil {
+		utils.ErrorResponse(c, apperrors.StatusCode(err), err)
+		return
+	}
+
+	utils.SuccessResponse(c, newCurrencyResponse(&currency))
+}
+
+// PATCH /admin/currencies/:code
+func (ctrl *CurrencyController) UpdateCurrency(c *gin.Context) {
+	var cRequest UpdateCurrencyRequest
+	if err := c.ShouldBindJSON(&cRequest); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	code := c.Param("code")
+	currency, ok, err := ctrl.CurrencyService.GetCurrency(code)
+	if err != nil {
+		utils.ErrorResponse(c, apperrors.StatusCode(err), err)
+		return
+	}
+	if !ok {
+		utils.ErrorResponse(c, http.StatusNotFound, services.ErrCurrencyNotFound)
+		return
+	}
+
+	update := services.CurrencyUpdate{
+		Name:    cRequest.Name,
+		Enabled: cRequest.Enabled,
+	}
+	if cRequest.MinAmount != nil {
+		minAmount, err := optionalMinorUnits(cRequest.MinAmount, currency.Precision)
+		if err != nil {
+			utils.ErrorResponse(c, http.StatusBadRequest, err)
+			return
+		}
+		update.MinAmount = &minAmount
+	}
+	if cRequest.MaxAmount != nil {
+		maxAmount, err := optionalMinorUnits(cRequest.MaxAmount, currency.Precision)
+		if err != nil {
+			utils.ErrorResponse(c, http.StatusBadRequest, err)
+			return
+		}
+		update.MaxAmount = &maxAmount
+	}
+
+	currency, err = ctrl.CurrencyService.UpdateCurrency(code, update)
+	if err != nil {
+		utils.ErrorResponse(c, apperrors.StatusCode(err), err)
+		return
+	}
+
+	utils.SuccessResponse(c, newCurrencyResponse(currency))
+}
+
+// optionalMinorUnits converts an optional non-negative amount limit in human units to minor units
+func optionalMinorUnits(amount *decimal.Decimal, precision int32) (decimal.Decimal, error) {
+	if amount == nil {
+		return decimal.Zero, nil
+	}
+	if amount.IsNegative() {
+		return decimal.Zero, services.ErrInvalidAmount
+	}
+	return utils.ToMinorUnits(*amount, precision)
+}
@@ -0,0 +1,147 @@
+package controllers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/wanliqun/go-wallet-app/controllers"
+	"github.com/wanliqun/go-wallet-app/middlewares"
+	"github.com/wanliqun/go-wallet-app/mocks"
+	"github.com/wanliqun/go-wallet-app/models"
+	"github.com/wanliqun/go-wallet-app/services"
+)
+
+func setupCurrencyTestRouter(
+	currencyService *mocks.MockCurrencyService, authService *mocks.MockAuthService) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+
+	currencyController := controllers.NewCurrencyController(currencyService)
+	router.GET("/currencies", currencyController.ListCurrencies)
+
+	adminRouter := router.Group("/admin", middlewares.AuthMiddleware(authService, nil), middlewares.PermissionMiddleware(
+		services.NewRoleAuthorizer(services.DefaultRolePermissions), services.PermManageCurrencies))
+	{
+		adminRouter.GET("/currencies", currencyController.ListAllCurrencies)
+		adminRouter.POST("/currencies", currencyController.CreateCurrency)
+		adminRouter.PATCH("/currencies/:code", currencyController.UpdateCurrency)
+	}
+
+	return router
+}
+
+func TestCurrencyController_ListCurrencies(t *testing.T) {
+	mockCurrencyService := new(mocks.MockCurrencyService)
+	mockAuthService := new(mocks.MockAuthService)
+	router := setupCurrencyTestRouter(mockCurrencyService, mockAuthService)
+
+	t.Run("should list enabled currencies", func(t *testing.T) {
+		mockCurrencyService.On("ListCurrencies", false).Return([]models.Currency{
+			{Code: "BTC", Name: "Bitcoin", Precision: 8, Enabled: true, MinAmount: decimal.NewFromInt(1000)},
+		}, nil)
+
+		req, _ := http.NewRequest("GET", "/currencies", nil)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var resp struct {
+			Data []controllers.CurrencyResponse
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		assert.Len(t, resp.Data, 1)
+		assert.Equal(t, "0.00001000", resp.Data[0].MinAmount)
+	})
+}
+
+func TestCurrencyController_Admin(t *testing.T) {
+	mockCurrencyService := new(mocks.MockCurrencyService)
+	mockAuthService := new(mocks.MockAuthService)
+
+	adminUser := userGenerator.Generate()
+	adminUser.Role = models.RoleAdmin
+	normalUser := userGenerator.Generate()
+	router := setupCurrencyTestRouter(mockCurrencyService, mockAuthService)
+
+	mockAuthService.On("Authenticate", adminUser.Name).Return(adminUser, nil)
+	mockAuthService.On("Authenticate", normalUser.Name).Return(normalUser, nil)
+
+	t.Run("should forbid non-admin users", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/admin/currencies", nil)
+		req.Header.Set("Authorization", "Bearer "+normalUser.Name)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		mockCurrencyService.AssertNotCalled(t, "ListCurrencies", true)
+	})
+
+	t.Run("should create a currency with limits in minor units", func(t *testing.T) {
+		mockCurrencyService.On("CreateCurrency", mock.MatchedBy(func(c *models.Currency) bool {
+			return c.Code == "ETH" && c.Precision == 18 && c.Enabled &&
+				c.MinAmount.Equal(decimal.RequireFromString("1000000000000000"))
+		})).Return(nil)
+
+		body, _ := json.Marshal(map[string]interface{}{
+			"code": "ETH", "name": "Ethereum", "precision": 18, "min_amount": "0.001",
+		})
+		req, _ := http.NewRequest("POST", "/admin/currencies", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+adminUser.Name)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("should return conflict for an existing currency", func(t *testing.T) {
+		mockCurrencyService.On("CreateCurrency", mock.MatchedBy(func(c *models.Currency) bool {
+			return c.Code == "BTC"
+		})).Return(services.ErrCurrencyExists)
+
+		body, _ := json.Marshal(map[string]interface{}{"code": "BTC", "name": "Bitcoin", "precision": 8})
+		req, _ := http.NewRequest("POST", "/admin/currencies", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+adminUser.Name)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+	})
+
+	t.Run("should disable a currency", func(t *testing.T) {
+		disabled := false
+		currency := &models.Currency{Code: "BTC", Name: "Bitcoin", Precision: 8, Enabled: true}
+		mockCurrencyService.On("GetCurrency", "BTC").Return(currency, true, nil)
+		mockCurrencyService.On("UpdateCurrency", "BTC", services.CurrencyUpdate{Enabled: &disabled}).
+			Return(&models.Currency{Code: "BTC", Name: "Bitcoin", Precision: 8, Enabled: false}, nil)
+
+		body, _ := json.Marshal(map[string]interface{}{"enabled": false})
+		req, _ := http.NewRequest("PATCH", "/admin/currencies/BTC", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+adminUser.Name)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var resp struct {
+			Data controllers.CurrencyResponse
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		assert.False(t, resp.Data.Enabled)
+	})
+}
@@ -1,27 +1,55 @@
 package controllers
 
 import (
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
 	"github.com/gin-gonic/gin/binding"
 	"github.com/go-playground/validator/v10"
 	"github.com/shopspring/decimal"
-	"github.com/wanliqun/go-wallet-app/config"
 	"github.com/wanliqun/go-wallet-app/models"
+	"github.com/wanliqun/go-wallet-app/services"
+	"github.com/wanliqun/go-wallet-app/utils"
 )
 
+// userNamePattern restricts user names to letters, digits and underscores
+var userNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_]{3,16}$`)
+
 func init() {
 	// set up custom validator
 	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		// Report the JSON, query or URI names of the fields failing validation
+		v.RegisterTagNameFunc(func(field reflect.StructField) string {
+			for _, key := range []string{"json", "form", "uri"} {
+				if name, _, _ := strings.Cut(field.Tag.Get(key), ","); name != "" && name != "-" {
+					return name
+				}
+			}
+			return field.Name
+		})
+
 		// Register currency validation
 		v.RegisterValidation("currency", func(fl validator.FieldLevel) bool {
 			currency := fl.Field().String()
 
-			if config.AppConfig.Concurrencies != nil {
-				_, ok := config.AppConfig.Concurrencies[currency]
-				return ok
+			if currencyRegistry != nil {
+				conf, ok := currencyRegistry.LookupCurrency(currency)
+				return ok && conf.Enabled
 			}
 			return true
 		})
 
+		// Register amount precision and limit validation
+		v.RegisterStructValidation(validateAmount, DepositRequest{}, WithdrawRequest{}, TransferRequest{}, HoldRequest{},
+			ExchangeQuoteRequest{}, ExchangeRequest{}, FeeQuery{})
+
+		// Register user name validation
+		v.RegisterValidation("username", func(fl validator.FieldLevel) bool {
+			return userNamePattern.MatchString(fl.Field().String())
+		})
+
 		// Register amount validation
 		v.RegisterValidation("positive_decimal", func(fl validator.FieldLevel) bool {
 			amount, ok := fl.Field().Interface().(decimal.Decimal)
@@ -41,10 +69,45 @@ func init() {
 	}
 }
 
+// LoginRequest represents the incoming request body for login
+type LoginRequest struct {
+	Name     string `json:"name" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// RefreshRequest represents the incoming request body for refreshing tokens
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// LogoutRequest represents the incoming request body for logout
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token,omitempty"` // Optional refresh token to revoke along with the access token
+}
+
+// RegisterRequest represents the incoming request body for user sign-up
+type RegisterRequest struct {
+	Name     string `json:"name" binding:"required,username"`
+	Email    string `json:"email" binding:"required,email,max=64"`
+	Password string `json:"password" binding:"required,min=8,max=72"`
+}
+
+// UpdateUserRequest represents the incoming request body for profile updates, omitted fields are left unchanged
+type UpdateUserRequest struct {
+	Name     *string `json:"name,omitempty" binding:"omitempty,username"`
+	Email    *string `json:"email,omitempty" binding:"omitempty,email,max=64"`
+	Password *string `json:"password,omitempty" binding:"omitempty,min=8,max=72"`
+}
+
+// TwoFactorCodeRequest represents the incoming request body carrying a TOTP or recovery code
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" binding:"required,max=32"`
+}
+
 // DepositRequest represents the incoming request body for deposit operations
 type DepositRequest struct {
 	Currency string          `json:"currency" binding:"required,currency"`
-	Amount   decimal.Decimal `json:"amount" binding:"required,positive_decimal"`
+	Amount   decimal.Decimal `json:"amount" binding:"required,positive_decimal"` // Amount in human units, e.g. 1.5
 }
 
 // WithdrawRequest represents the incoming request body for withdrawal operations
@@ -58,21 +121,453 @@ type TransferRequest struct {
 	Memo      string          `json:"memo,omitempty"`
 }
 
+// HoldRequest represents the incoming request body for placing a hold
+type HoldRequest TransferRequest
+
+// HoldURI represents the URI parameters identifying a hold
+type HoldURI struct {
+	ID uint `uri:"id" binding:"required"` // Hold ID
+}
+
+// ExchangeQuoteRequest represents the incoming request body for quoting a currency exchange
+type ExchangeQuoteRequest struct {
+	From   string          `json:"from" binding:"required,currency"`
+	To     string          `json:"to" binding:"required,currency,nefield=From"`
+	Amount decimal.Decimal `json:"amount" binding:"required,positive_decimal"` // Amount of the source currency in human units
+}
+
+// ExchangeRequest represents the incoming request body for exchanging currencies, either by
+// executing a quote or at the current rate
+type ExchangeRequest struct {
+	QuoteID uint            `json:"quote_id,omitempty"`                                                              // Quote to execute
+	From    string          `json:"from,omitempty" binding:"required_without=QuoteID,omitempty,currency"`            // Source currency, if no quote is given
+	To      string          `json:"to,omitempty" binding:"required_without=QuoteID,omitempty,currency,nefield=From"` // Target currency, if no quote is given
+	Amount  decimal.Decimal `json:"amount,omitempty"`                                                                // Amount of the source currency in human units, if no quote is given
+}
+
+// FeeQuery represents the query for estimating the fee of an operation without committing it
+type FeeQuery struct {
+	Operation string          `form:"operation" binding:"required,oneof=withdrawal transfer"`
+	Currency  string          `form:"currency" binding:"required,currency"`
+	Amount    decimal.Decimal `form:"amount" binding:"required,positive_decimal"` // Amount in human units
+}
+
+// LimitQuery represents the query for retrieving the effective limits of a currency
+type LimitQuery struct {
+	Currency string `form:"currency" binding:"required,currency"`
+}
+
+// UserURI represents the URI parameters identifying a user
+type UserURI struct {
+	ID uint `uri:"id" binding:"required"` // User ID
+}
+
+// SetUserRoleRequest represents the incoming request body for changing the role of a user
+type SetUserRoleRequest struct {
+	Role string `json:"role" binding:"required,oneof=user support finance admin"`
+}
+
+// UserLimitURI represents the URI parameters identifying the limits of a user in a currency
+type UserLimitURI struct {
+	ID       uint   `uri:"id" binding:"required"` // User ID
+	Currency string `uri:"currency" binding:"required,currency"`
+}
+
+// UserLimitRequest represents the incoming request body for overriding the limits of a user,
+// omitted limits fall back to the configured ones and zero limits mean no limit
+type UserLimitRequest struct {
+	PerTransaction *decimal.Decimal `json:"per_transaction,omitempty"` // Maximum amount of a single withdrawal or transfer in human units
+	Daily          *decimal.Decimal `json:"daily,omitempty"`           // Maximum amount sent out per day in human units
+	Monthly        *decimal.Decimal `json:"monthly,omitempty"`         // Maximum amount sent out per month in human units
+}
+
+// CreateWebhookRequest represents the incoming request body for registering a webhook endpoint
+type CreateWebhookRequest struct {
+	URL    string   `json:"url" binding:"required,http_url,max=2048"`
+	Events []string `json:"events,omitempty" binding:"omitempty,dive,oneof=transaction.created balance.changed"` // Subscribed event types, all types if omitted
+}
+
+// WebhookURI represents the URI parameters identifying a webhook endpoint
+type WebhookURI struct {
+	ID uint `uri:"id" binding:"required"` // Webhook endpoint ID
+}
+
+// ListWebhookDeliveriesQuery represents the query for listing the latest deliveries to a webhook endpoint
+type ListWebhookDeliveriesQuery struct {
+	Limit int `form:"limit,omitempty" binding:"min=0,max=100"` // Number of deliveries to fetch
+}
+
+// CreateAPIKeyRequest represents the incoming request body for issuing an API key
+type CreateAPIKeyRequest struct {
+	Name      string     `json:"name" binding:"required,max=64"`
+	Scopes    []string   `json:"scopes" binding:"required,min=1,dive,oneof=balances:read transactions:read transfer:write"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty" binding:"omitempty,gt"` // Never expires if omitted
+}
+
+// UpdateAPIKeyRequest represents the incoming request body for updating an API key, omitted fields are left unchanged
+type UpdateAPIKeyRequest struct {
+	Name      *string    `json:"name,omitempty" binding:"omitempty,min=1,max=64"`
+	Scopes    []string   `json:"scopes,omitempty" binding:"omitempty,min=1,dive,oneof=balances:read transactions:read transfer:write"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty" binding:"omitempty,gt"`
+}
+
+// APIKeyURI represents the URI parameters identifying an API key
+type APIKeyURI struct {
+	KeyID uint `uri:"key_id" binding:"required"` // API key ID
+}
+
+// CreateServiceAccountRequest represents the incoming request body for creating a service account
+type CreateServiceAccountRequest struct {
+	Name string `json:"name" binding:"required,username"`
+	Role string `json:"role" binding:"required,oneof=user support finance admin"`
+}
+
+// TransactionURI represents the URI parameters identifying a transaction
+type TransactionURI struct {
+	ID uint `uri:"id" binding:"required"` // Transaction ID
+}
+
+// ReverseRequest represents the request for reversing a transaction
+type ReverseRequest struct {
+	Memo  string `json:"memo" binding:"max=256"` // Optional reason of the reversal
+	Force bool   `json:"force"`                  // Reverse even if it forces the credited vault into negative
+}
+
 // GetBalancesQuery represents the incoming request body for balance retrieval
 type GetBalancesQuery struct {
 	Currencies []string `form:"currency" binding:"required,currency_limit"` // List of currencies to filter by
 }
 
-// GetTransactionHistoryRequest represents the request for retrieving paginated transaction history with filters
+// StreamQuery represents the query for streaming live balance and transaction updates
+type StreamQuery struct {
+	Currencies  []string `form:"currency" binding:"omitempty,currency_limit"` // Currencies to stream, all currencies if omitted
+	LastEventID string   `form:"last_event_id,omitempty"`                     // Cursor to resume from, for clients unable to send the Last-Event-ID header
+}
+
+// CreateCurrencyRequest represents the incoming request body for registering a currency
+type CreateCurrencyRequest struct {
+	Code      string           `json:"code" binding:"required,alphanum,uppercase,max=32"`
+	Name      string           `json:"name" binding:"required,max=64"`
+	Precision *int32           `json:"precision" binding:"required,min=0,max=36"`
+	Enabled   *bool            `json:"enabled,omitempty"`    // Defaults to true
+	MinAmount *decimal.Decimal `json:"min_amount,omitempty"` // Minimum amount per transaction in human units
+	MaxAmount *decimal.Decimal `json:"max_amount,omitempty"` // Maximum amount per transaction in human units
+}
+
+// UpdateCurrencyRequest represents the incoming request body for updating a currency, omitted fields are left unchanged
+type UpdateCurrencyRequest struct {
+	Name      *string          `json:"name,omitempty" binding:"omitempty,max=64"`
+	Enabled   *bool            `json:"enabled,omitempty"`
+	MinAmount *decimal.Decimal `json:"min_amount,omitempty"` // Minimum amount per transaction in human units
+	MaxAmount *decimal.Decimal `json:"max_amount,omitempty"` // Maximum amount per transaction in human units
+}
+
+// ListReconciliationRunsQuery represents the query for listing the most recent reconciliation runs
+type ListReconciliationRunsQuery struct {
+	Limit int `form:"limit,omitempty" binding:"min=0,max=100"` // Number of runs to fetch
+}
+
+// TransactionFilterQuery represents the filters of the transaction history
+type TransactionFilterQuery struct {
+	Types        []string         `form:"type,omitempty" binding:"omitempty,dive,oneof=deposit withdrawal transfer_out transfer_in reversal_in reversal_out hold hold_capture hold_release hold_expire exchange_out exchange_in fee"` // Filter by any of the transaction types (e.g., "deposit", "withdrawal"), repeatable
+	From         time.Time        `form:"from,omitempty" time_format:"2006-01-02T15:04:05Z07:00"`                                                                                                                                     // Inclusive lower bound of the timestamp (RFC 3339)
+	To           time.Time        `form:"to,omitempty" time_format:"2006-01-02T15:04:05Z07:00" binding:"omitempty,gtfield=From"`                                                                                                      // Exclusive upper bound of the timestamp (RFC 3339)
+	Currency     string           `form:"currency,omitempty" binding:"required_with=MinAmount MaxAmount,omitempty,currency"`                                                                                                          // Filter by currency, required to filter by amount
+	Counterparty string           `form:"counterparty,omitempty" binding:"omitempty,username"`                                                                                                                                        // Filter by the name of the counterparty user
+	MinAmount    *decimal.Decimal `form:"min_amount,omitempty"`                                                                                                                                                                       // Inclusive lower bound of the amount in human units
+	MaxAmount    *decimal.Decimal `form:"max_amount,omitempty"`                                                                                                                                                                       // Inclusive upper bound of the amount in human units
+	Memo         string           `form:"memo,omitempty" binding:"omitempty,max=256"`                                                                                                                                                 // Filter by text the memo contains, case-insensitively
+}
+
+// GetTransactionHistoryQuery represents the request for retrieving paginated transaction history with filters
 type GetTransactionHistoryQuery struct {
-	Type   string `form:"type,omitempty" binding:"omitempty,oneof=deposit withdrawal transfer_out transfer_in"` // Filter by transaction type (e.g., "deposit", "withdrawal")
-	Cursor string `form:"cursor,omitempty"`                                                                     // Encoded cursor for keyset pagination
-	Limit  int    `form:"limit,omitempty" binding:"min=0,max=50"`                                               // Number of records to fetch
-	Order  string `form:"order,omitempty" binding:"omitempty,oneof=asc desc"`                                   // Sort order (e.g., "asc", "desc")
+	TransactionFilterQuery
+	Cursor string `form:"cursor,omitempty"`                                   // Encoded cursor for keyset pagination
+	Limit  int    `form:"limit,omitempty" binding:"min=0,max=50"`             // Number of records to fetch
+	Order  string `form:"order,omitempty" binding:"omitempty,oneof=asc desc"` // Sort order (e.g., "asc", "desc")
+}
+
+// ExportTransactionsQuery represents the request for exporting the filtered transaction history
+type ExportTransactionsQuery struct {
+	TransactionFilterQuery
+	Format string `form:"format" binding:"required,oneof=csv ndjson ofx"`
+	Order  string `form:"order,omitempty" binding:"omitempty,oneof=asc desc"` // Sort order, oldest first by default
+}
+
+// GetStatementQuery represents the request for the account statement of a currency, over either a
+// calendar month or the period from and to
+type GetStatementQuery struct {
+	Currency string    `form:"currency" binding:"required,currency"`
+	Month    string    `form:"month,omitempty" binding:"omitempty,datetime=2006-01"` // Calendar month in UTC, e.g. "2024-01"
+	From     time.Time `form:"from,omitempty" time_format:"2006-01-02T15:04:05Z07:00"`
+	To       time.Time `form:"to,omitempty" time_format:"2006-01-02T15:04:05Z07:00" binding:"omitempty,gtfield=From"`
+	Format   string    `form:"format,omitempty" binding:"omitempty,oneof=json html pdf"`
+}
+
+// RecoveryCodesResponse represents the recovery codes of two-factor authentication, only disclosed once
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
 }
 
 // GetTransactionHistoryResponse represents the response for paginated transaction history
 type GetTransactionHistoryResponse struct {
-	Transactions []models.Transaction `json:"transactions"` // Array of transaction objects
-	NextCursor   string               `json:"next_cursor"`  // Encoded cursor for next page
+	Transactions []TransactionResponse `json:"transactions"` // Array of transaction objects
+	NextCursor   string                `json:"next_cursor"`  // Encoded cursor for next page
+}
+
+// BalanceResponse represents a vault balance in API responses
+type BalanceResponse struct {
+	Currency    string          `json:"currency"`
+	Amount      string          `json:"amount"`       // Available balance in human units scaled by the currency precision
+	AmountMinor decimal.Decimal `json:"amount_minor"` // Raw available balance in integer minor units
+	Held        string          `json:"held"`         // Balance reserved by pending holds in human units
+	HeldMinor   decimal.Decimal `json:"held_minor"`   // Raw balance reserved by pending holds in integer minor units
+}
+
+func newBalanceResponse(vault models.Vault) BalanceResponse {
+	return BalanceResponse{
+		Currency:    vault.Currency,
+		Amount:      utils.FormatMinorUnits(vault.Amount, currencyPrecision(vault.Currency)),
+		AmountMinor: vault.Amount,
+		Held:        utils.FormatMinorUnits(vault.Held, currencyPrecision(vault.Currency)),
+		HeldMinor:   vault.Held,
+	}
+}
+
+// ExchangeQuoteResponse represents an exchange quote in API responses
+type ExchangeQuoteResponse struct {
+	ID              uint            `json:"id"`
+	FromCurrency    string          `json:"from_currency"`
+	ToCurrency      string          `json:"to_currency"`
+	FromAmount      string          `json:"from_amount"`       // Amount of the source currency in human units
+	FromAmountMinor decimal.Decimal `json:"from_amount_minor"` // Raw amount of the source currency in integer minor units
+	ToAmount        string          `json:"to_amount"`         // Amount of the target currency in human units
+	ToAmountMinor   decimal.Decimal `json:"to_amount_minor"`   // Raw amount of the target currency in integer minor units
+	Rate            decimal.Decimal `json:"rate"`              // Amount of the target currency one unit of the source currency is worth
+	ExpiresAt       time.Time       `json:"expires_at"`
+}
+
+func newExchangeQuoteResponse(quote *models.ExchangeQuote) ExchangeQuoteResponse {
+	return ExchangeQuoteResponse{
+		ID:              quote.ID,
+		FromCurrency:    quote.FromCurrency,
+		ToCurrency:      quote.ToCurrency,
+		FromAmount:      utils.FormatMinorUnits(quote.FromAmount, currencyPrecision(quote.FromCurrency)),
+		FromAmountMinor: quote.FromAmount,
+		ToAmount:        utils.FormatMinorUnits(quote.ToAmount, currencyPrecision(quote.ToCurrency)),
+		ToAmountMinor:   quote.ToAmount,
+		Rate:            quote.Rate,
+		ExpiresAt:       quote.ExpiresAt,
+	}
+}
+
+// ExchangeResponse represents both legs of an executed exchange in API responses
+type ExchangeResponse struct {
+	Out TransactionResponse `json:"out"` // exchange_out transaction debiting the source vault
+	In  TransactionResponse `json:"in"`  // exchange_in transaction crediting the target vault
+}
+
+// FeeResponse represents the estimated fee of an operation in API responses
+type FeeResponse struct {
+	Operation   string          `json:"operation"`
+	Currency    string          `json:"currency"`
+	Amount      string          `json:"amount"`       // Requested amount in human units
+	AmountMinor decimal.Decimal `json:"amount_minor"` // Raw requested amount in integer minor units
+	Fee         string          `json:"fee"`          // Fee deducted from the amount in human units
+	FeeMinor    decimal.Decimal `json:"fee_minor"`    // Raw fee in integer minor units
+	Net         string          `json:"net"`          // Amount left once the fee is deducted in human units
+	NetMinor    decimal.Decimal `json:"net_minor"`    // Raw net amount in integer minor units
+}
+
+// LimitResponse represents the effective limits of a user in a currency in API responses, amounts
+// are in human units and a zero limit means no limit
+type LimitResponse struct {
+	Currency       string `json:"currency"`
+	PerTransaction string `json:"per_transaction"`
+	Daily          string `json:"daily"`
+	DailyUsed      string `json:"daily_used"` // Amount sent out since the start of the day (UTC)
+	Monthly        string `json:"monthly"`
+	MonthlyUsed    string `json:"monthly_used"` // Amount sent out since the start of the month (UTC)
+}
+
+func newLimitResponse(usage *services.LimitUsage) LimitResponse {
+	return LimitResponse{
+		Currency:       usage.Currency,
+		PerTransaction: utils.FormatMinorUnits(usage.PerTransaction, usage.Precision),
+		Daily:          utils.FormatMinorUnits(usage.Daily, usage.Precision),
+		DailyUsed:      utils.FormatMinorUnits(usage.DailyUsed, usage.Precision),
+		Monthly:        utils.FormatMinorUnits(usage.Monthly, usage.Precision),
+		MonthlyUsed:    utils.FormatMinorUnits(usage.MonthlyUsed, usage.Precision),
+	}
+}
+
+// HoldResponse represents a hold in API responses
+type HoldResponse struct {
+	ID            uint              `json:"id"`
+	UserID        uint              `json:"user_id"`
+	RecipientID   uint              `json:"recipient_id"`
+	Amount        string            `json:"amount"`       // Amount in human units scaled by the currency precision
+	AmountMinor   decimal.Decimal   `json:"amount_minor"` // Raw amount in integer minor units
+	Currency      string            `json:"currency"`
+	Memo          string            `json:"memo,omitempty"`
+	Status        models.HoldStatus `json:"status"`
+	ExpiresAt     time.Time         `json:"expires_at"`
+	TransactionID uint              `json:"transaction_id"` // Transaction placing the hold
+	CreatedAt     time.Time         `json:"created_at"`
+}
+
+func newHoldResponse(hold *models.Hold) HoldResponse {
+	return HoldResponse{
+		ID:            hold.ID,
+		UserID:        hold.UserID,
+		RecipientID:   hold.RecipientID,
+		Amount:        utils.FormatMinorUnits(hold.Amount, currencyPrecision(hold.Currency)),
+		AmountMinor:   hold.Amount,
+		Currency:      hold.Currency,
+		Memo:          hold.Memo,
+		Status:        hold.Status,
+		ExpiresAt:     hold.ExpiresAt,
+		TransactionID: hold.TransactionID,
+		CreatedAt:     hold.CreatedAt,
+	}
+}
+
+// CurrencyResponse represents a registered currency in API responses
+type CurrencyResponse struct {
+	Code      string `json:"code"`
+	Name      string `json:"name"`
+	Precision int32  `json:"precision"`
+	Enabled   bool   `json:"enabled"`
+	MinAmount string `json:"min_amount"` // Minimum amount per transaction in human units, zero means no minimum
+	MaxAmount string `json:"max_amount"` // Maximum amount per transaction in human units, zero means no maximum
+}
+
+func newCurrencyResponse(currency *models.Currency) CurrencyResponse {
+	return CurrencyResponse{
+		Code:      currency.Code,
+		Name:      currency.Name,
+		Precision: currency.Precision,
+		Enabled:   currency.Enabled,
+		MinAmount: utils.FormatMinorUnits(currency.MinAmount, currency.Precision),
+		MaxAmount: utils.FormatMinorUnits(currency.MaxAmount, currency.Precision),
+	}
+}
+
+// TransactionResponse represents a transaction in API responses
+type TransactionResponse struct {
+	ID             uint                   `json:"id"`
+	UserID         uint                   `json:"user_id"`
+	CounterpartyID *uint                  `json:"counterparty_id"`
+	Type           models.TransactionType `json:"type"`
+	Amount         string                 `json:"amount"`       // Amount in human units scaled by the currency precision
+	AmountMinor    decimal.Decimal        `json:"amount_minor"` // Raw amount in integer minor units
+	Currency       string                 `json:"currency"`
+	Memo           string                 `json:"memo,omitempty"`
+	Reference      string                 `json:"reference,omitempty"` // Reference shared by both legs of a transfer
+	Timestamp      time.Time              `json:"timestamp"`
+
+	OriginalTransactionID *uint               `json:"original_transaction_id,omitempty"` // Transaction compensated by a reversal
+	ParentTransactionID   *uint               `json:"parent_transaction_id,omitempty"`   // Transaction this one is linked to
+	Rate                  decimal.NullDecimal `json:"rate,omitempty"`                    // Applied exchange rate of exchange legs
+}
+
+func newTransactionResponse(txn *models.Transaction) TransactionResponse {
+	return TransactionResponse{
+		ID:             txn.ID,
+		UserID:         txn.UserID,
+		CounterpartyID: txn.CounterpartyID,
+		Type:           txn.Type,
+		Amount:         utils.FormatMinorUnits(txn.Amount, currencyPrecision(txn.Currency)),
+		AmountMinor:    txn.Amount,
+		Currency:       txn.Currency,
+		Memo:           txn.Memo,
+		Reference:      txn.Reference,
+		Timestamp:      txn.Timestamp,
+
+		OriginalTransactionID: txn.OriginalTransactionID,
+		ParentTransactionID:   txn.ParentTransactionID,
+		Rate:                  txn.Rate,
+	}
+}
+
+// TransactionDetailResponse represents a transaction along with the other leg of a transfer
+type TransactionDetailResponse struct {
+	TransactionResponse
+	Counterparty string               `json:"counterparty,omitempty"` // Name of the counterparty, empty once deleted
+	Counterpart  *TransactionResponse `json:"counterpart,omitempty"`  // Leg of the transfer recorded for the counterparty
+}
+
+// WebhookResponse represents a webhook endpoint in API responses
+type WebhookResponse struct {
+	ID        uint      `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`           // Subscribed event types, empty for all types
+	Secret    string    `json:"secret,omitempty"` // Secret signing the deliveries, only returned once registered
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// APIKeyResponse represents an API key in API responses
+type APIKeyResponse struct {
+	ID         uint       `json:"id"`
+	UserID     uint       `json:"user_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"` // Public part of the key, telling the keys apart
+	Scopes     []string   `json:"scopes"`
+	Key        string     `json:"key,omitempty"` // Whole key, only returned once issued
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+func newAPIKeyResponse(apiKey *models.APIKey) APIKeyResponse {
+	scopes := apiKey.ScopeList()
+	if scopes == nil {
+		scopes = []string{}
+	}
+
+	return APIKeyResponse{
+		ID:         apiKey.ID,
+		UserID:     apiKey.UserID,
+		Name:       apiKey.Name,
+		Prefix:     apiKey.Prefix,
+		Scopes:     scopes,
+		ExpiresAt:  apiKey.ExpiresAt,
+		LastUsedAt: apiKey.LastUsedAt,
+		CreatedAt:  apiKey.CreatedAt,
+	}
+}
+
+func newWebhookResponse(endpoint *models.WebhookEndpoint) WebhookResponse {
+	events := endpoint.EventTypes()
+	if events == nil {
+		events = []string{}
+	}
+
+	return WebhookResponse{
+		ID:        endpoint.ID,
+		URL:       endpoint.URL,
+		Events:    events,
+		CreatedAt: endpoint.CreatedAt,
+	}
+}
+
+// StatementResponse represents an account statement, balances including the held amount
+type StatementResponse struct {
+	User           string                  `json:"user"`
+	Currency       string                  `json:"currency"`
+	From           time.Time               `json:"from"` // Inclusive start of the period
+	To             time.Time               `json:"to"`   // Exclusive end of the period
+	OpeningBalance string                  `json:"opening_balance"`
+	ClosingBalance string                  `json:"closing_balance"`
+	TotalCredits   string                  `json:"total_credits"`
+	TotalDebits    string                  `json:"total_debits"`
+	Discrepancy    string                  `json:"discrepancy,omitempty"` // Vault balance not accounted for by the transactions
+	Lines          []StatementLineResponse `json:"lines"`
+	GeneratedAt    time.Time               `json:"generated_at"`
+}
+
+// StatementLineResponse represents a transaction of a statement with the running balance following it
+type StatementLineResponse struct {
+	ExportRecord
+	Balance string `json:"balance"`
 }
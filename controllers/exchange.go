@@ -0,0 +1,72 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wanliqun/go-wallet-app/apperrors"
+	"github.com/wanliqun/go-wallet-app/models"
+	"github.com/wanliqun/go-wallet-app/services"
+	"github.com/wanliqun/go-wallet-app/utils"
+)
+
+type ExchangeController struct {
+	ExchangeService services.IExchangeService
+}
+
+func NewExchangeController(exchange services.IExchangeService) *ExchangeController {
+	return &ExchangeController{ExchangeService: exchange}
+}
+
+// POST /exchange/quotes
+func (ctrl *ExchangeController) Quote(c *gin.Context) {
+	var cRequest ExchangeQuoteRequest
+	if err := c.ShouldBindJSON(&cRequest); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	user := c.MustGet("user").(*models.User)
+	amount := toMinorUnits(cRequest.From, cRequest.Amount)
+	quote, err := ctrl.ExchangeService.Quote(user.ID, cRequest.From, cRequest.To, amount)
+	if err != nil {
+		utils.ErrorResponse(c, apperrors.StatusCode(err), err)
+		return
+	}
+
+	utils.SuccessResponse(c, newExchangeQuoteResponse(quote))
+}
+
+// POST /exchange
+func (ctrl *ExchangeController) Exchange(c *gin.Context) {
+	var cRequest ExchangeRequest
+	if err := c.ShouldBindJSON(&cRequest); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	idempotencyKey, err := getIdempotencyKey(c)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	user := c.MustGet("user").(*models.User)
+
+	var out, in *models.Transaction
+	if cRequest.QuoteID != 0 {
+		out, in, err = ctrl.ExchangeService.ExecuteQuote(user.ID, cRequest.QuoteID, idempotencyKey)
+	} else {
+		amount := toMinorUnits(cRequest.From, cRequest.Amount)
+		out, in, err = ctrl.ExchangeService.Exchange(user.ID, cRequest.From, cRequest.To, amount, idempotencyKey)
+	}
+	if err != nil {
+		utils.ErrorResponse(c, apperrors.StatusCode(err), err)
+		return
+	}
+
+	utils.SuccessResponse(c, ExchangeResponse{
+		Out: newTransactionResponse(out),
+		In:  newTransactionResponse(in),
+	})
+}
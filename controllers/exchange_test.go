@@ -0,0 +1,116 @@
+package controllers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/wanliqun/go-wallet-app/controllers"
+	"github.com/wanliqun/go-wallet-app/middlewares"
+	"github.com/wanliqun/go-wallet-app/mocks"
+	"github.com/wanliqun/go-wallet-app/models"
+	"github.com/wanliqun/go-wallet-app/services"
+)
+
+func setupExchangeTestRouter(exchangeService *mocks.MockExchangeService, authService *mocks.MockAuthService) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+
+	router.Use(middlewares.AuthMiddleware(authService, nil))
+
+	exchangeController := controllers.NewExchangeController(exchangeService)
+	router.POST("/exchange/quotes", exchangeController.Quote)
+	router.POST("/exchange", exchangeController.Exchange)
+
+	return router
+}
+
+func TestExchangeController(t *testing.T) {
+	mockExchangeService := new(mocks.MockExchangeService)
+	mockAuthService := new(mocks.MockAuthService)
+	router := setupExchangeTestRouter(mockExchangeService, mockAuthService)
+
+	testUser := userGenerator.Generate()
+	mockAuthService.On("Authenticate", testUser.Name).Return(testUser, nil)
+
+	post := func(path string, body interface{}) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(body)
+		req, _ := http.NewRequest("POST", path, bytes.NewBuffer(data))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+testUser.Name)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("should quote successfully", func(t *testing.T) {
+		mockExchangeService.On("Quote", testUser.ID, "BTC", "USDT", mock.MatchedBy(func(a decimal.Decimal) bool {
+			return a.Equal(decimal.NewFromInt(1))
+		})).Return(&models.ExchangeQuote{
+			FromCurrency: "BTC",
+			ToCurrency:   "USDT",
+			FromAmount:   decimal.NewFromInt(1),
+			ToAmount:     decimal.NewFromInt(50000),
+			Rate:         decimal.NewFromInt(50000),
+		}, nil)
+
+		w := post("/exchange/quotes", map[string]interface{}{"from": "BTC", "to": "USDT", "amount": "1"})
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var resp struct {
+			Data controllers.ExchangeQuoteResponse
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		assert.True(t, decimal.NewFromInt(50000).Equal(resp.Data.Rate))
+	})
+
+	t.Run("should reject exchanging a currency to itself", func(t *testing.T) {
+		w := post("/exchange/quotes", map[string]interface{}{"from": "BTC", "to": "BTC", "amount": "1"})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("should execute quote successfully", func(t *testing.T) {
+		parentID := uint(10)
+		mockExchangeService.On("ExecuteQuote", testUser.ID, uint(1), "").Return(
+			&models.Transaction{ID: parentID, Type: models.ExchangeOut, Currency: "BTC", Amount: decimal.NewFromInt(1)},
+			&models.Transaction{Type: models.ExchangeIn, Currency: "USDT", Amount: decimal.NewFromInt(50000), ParentTransactionID: &parentID},
+			nil)
+
+		w := post("/exchange", map[string]interface{}{"quote_id": 1})
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var resp struct {
+			Data controllers.ExchangeResponse
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		assert.Equal(t, models.ExchangeOut, resp.Data.Out.Type)
+		assert.Equal(t, models.ExchangeIn, resp.Data.In.Type)
+	})
+
+	t.Run("should return error for expired quote", func(t *testing.T) {
+		mockExchangeService.On("ExecuteQuote", testUser.ID, uint(2), "").Return(nil, nil, services.ErrQuoteExpired)
+
+		w := post("/exchange", map[string]interface{}{"quote_id": 2})
+		assert.Equal(t, http.StatusConflict, w.Code)
+	})
+
+	t.Run("should exchange at the current rate", func(t *testing.T) {
+		mockExchangeService.On("Exchange", testUser.ID, "USDT", "BTC", mock.Anything, "").Return(
+			&models.Transaction{Type: models.ExchangeOut}, &models.Transaction{Type: models.ExchangeIn}, nil)
+
+		w := post("/exchange", map[string]interface{}{"from": "USDT", "to": "BTC", "amount": "100"})
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("should reject missing amount", func(t *testing.T) {
+		w := post("/exchange", map[string]interface{}{"from": "USDT", "to": "BTC"})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
@@ -0,0 +1,369 @@
+package controllers
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wanliqun/go-wallet-app/apperrors"
+	"github.com/wanliqun/go-wallet-app/models"
+	"github.com/wanliqun/go-wallet-app/services"
+	"github.com/wanliqun/go-wallet-app/utils"
+)
+
+// exportPageSize is the number of transactions fetched per query while exporting
+const exportPageSize = 500
+
+// Maximum lengths of the OFX elements
+const (
+	ofxNameLength = 32
+	ofxMemoLength = 255
+)
+
+// Directions of the exported transactions, empty for holds which leave the total balance unchanged
+const (
+	exportCredit = "credit"
+	exportDebit  = "debit"
+)
+
+// ExportRecord is an exported transaction, with the name of the counterparty resolved
+type ExportRecord struct {
+	TransactionResponse
+	Direction    string `json:"direction,omitempty"`
+	Counterparty string `json:"counterparty,omitempty"` // Name of the counterparty, empty once deleted
+}
+
+// exportWriter encodes the exported transactions in one of the export formats
+type exportWriter interface {
+	ContentType() string
+	Extension() string
+	Begin() error
+	Write(record *ExportRecord) error
+	Flush() error // Flush writes out the buffered records
+	End() error
+}
+
+// GET /transactions/export
+func (ctrl *WalletController) ExportTransactions(c *gin.Context) {
+	var cRequest ExportTransactionsQuery
+	if err := c.ShouldBindQuery(&cRequest); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	// An OFX statement is of a single currency
+	if cRequest.Format == "ofx" && cRequest.Currency == "" {
+		utils.ErrorResponse(c, http.StatusBadRequest, apperrors.New(apperrors.InvalidRequest, "currency is required by the ofx format"))
+		return
+	}
+
+	user := c.MustGet("user").(*models.User)
+
+	filter, ok := ctrl.transactionFilter(c, &cRequest.TransactionFilterQuery)
+	if !ok {
+		return
+	}
+
+	sortOrder := services.SortOrderAsc
+	if cRequest.Order == "desc" {
+		sortOrder = services.SortOrderDesc
+	}
+
+	// Fetch the first page before responding, so that failures are still reported with a status
+	page, cursor, err := ctrl.WalletService.GetTransactionHistory(c.Request.Context(), user.ID, filter, "", sortOrder, exportPageSize)
+	if err != nil {
+		utils.ErrorResponse(c, apperrors.StatusCode(err), err)
+		return
+	}
+
+	var writer exportWriter
+	switch cRequest.Format {
+	case "csv":
+		writer = &csvExportWriter{w: csv.NewWriter(c.Writer)}
+	case "ndjson":
+		writer = &ndjsonExportWriter{enc: json.NewEncoder(c.Writer)}
+	case "ofx":
+		writer = &ofxExportWriter{w: c.Writer, userID: user.ID, currency: cRequest.Currency, from: filter.From, to: filter.To}
+	}
+
+	filename := fmt.Sprintf("transactions-%s.%s", time.Now().UTC().Format("20060102"), writer.Extension())
+	c.Header("Content-Type", writer.ContentType())
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Header("X-Accel-Buffering", "no") // Disable response buffering of nginx
+	c.Status(http.StatusOK)
+
+	// The status is sent already, a failure can only cut the export short
+	if err := ctrl.exportTransactions(c, writer, user.ID, filter, sortOrder, page, cursor); err != nil {
+		log.Printf("failed to export transactions of user %d: %v", user.ID, err)
+		c.Abort()
+	}
+}
+
+// exportTransactions writes the history page by page, so that memory stays flat however long it is
+func (ctrl *WalletController) exportTransactions(
+	c *gin.Context, writer exportWriter, userID uint, filter services.TransactionFilter,
+	sortOrder services.SortOrder, page []models.Transaction, cursor string) error {
+	if err := writer.Begin(); err != nil {
+		return err
+	}
+
+	counterparties := newCounterpartyNames(c.Request.Context(), ctrl.UserService)
+	for {
+		for i := range page {
+			record, err := newExportRecord(&page[i], counterparties)
+			if err != nil {
+				return err
+			}
+			if err := writer.Write(record); err != nil {
+				return err
+			}
+		}
+		if err := writer.Flush(); err != nil {
+			return err
+		}
+		c.Writer.Flush()
+
+		if len(page) < exportPageSize {
+			break
+		}
+
+		var err error
+		if page, cursor, err = ctrl.WalletService.GetTransactionHistory(c.Request.Context(), userID, filter, cursor, sortOrder, exportPageSize); err != nil {
+			return err
+		}
+	}
+
+	if err := writer.End(); err != nil {
+		return err
+	}
+	c.Writer.Flush()
+	return nil
+}
+
+// newExportRecord converts the transaction, resolving the name of the counterparty
+func newExportRecord(txn *models.Transaction, counterparties *counterpartyNames) (*ExportRecord, error) {
+	name, err := counterparties.lookup(txn.CounterpartyID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ExportRecord{
+		TransactionResponse: newTransactionResponse(txn),
+		Direction:           transactionDirection(txn.Type),
+		Counterparty:        name,
+	}, nil
+}
+
+// counterpartyNames resolves the names of the counterparties, each user being looked up once
+type counterpartyNames struct {
+	ctx   context.Context
+	users services.IUserService
+	names map[uint]string
+}
+
+func newCounterpartyNames(ctx context.Context, users services.IUserService) *counterpartyNames {
+	return &counterpartyNames{ctx: ctx, users: users, names: make(map[uint]string)}
+}
+
+// lookup returns the name of the counterparty, empty if none or deleted
+func (n *counterpartyNames) lookup(id *uint) (string, error) {
+	if id == nil {
+		return "", nil
+	}
+	if name, ok := n.names[*id]; ok {
+		return name, nil
+	}
+
+	user, found, err := n.users.GetUserByID(n.ctx, *id)
+	if err != nil {
+		return "", err
+	}
+
+	var name string
+	if found {
+		name = user.Name
+	}
+	n.names[*id] = name
+	return name, nil
+}
+
+// transactionDirection returns whether the transaction credits or debits the total balance
+func transactionDirection(txnType models.TransactionType) string {
+	switch txnType.Sign() {
+	case 1:
+		return exportCredit
+	case -1:
+		return exportDebit
+	}
+	return ""
+}
+
+// csvExportWriter writes a header line followed by a line per transaction
+type csvExportWriter struct {
+	w *csv.Writer
+}
+
+var csvExportHeader = []string{
+	"id", "timestamp", "type", "direction", "currency", "amount", "amount_minor",
+	"counterparty", "memo", "original_transaction_id", "parent_transaction_id", "rate", "reference",
+}
+
+func (e *csvExportWriter) ContentType() string { return "text/csv; charset=utf-8" }
+func (e *csvExportWriter) Extension() string   { return "csv" }
+
+func (e *csvExportWriter) Begin() error {
+	return e.w.Write(csvExportHeader)
+}
+
+func (e *csvExportWriter) Write(record *ExportRecord) error {
+	var rate string
+	if record.Rate.Valid {
+		rate = record.Rate.Decimal.String()
+	}
+
+	err := e.w.Write([]string{
+		strconv.FormatUint(uint64(record.ID), 10),
+		record.Timestamp.UTC().Format(time.RFC3339Nano),
+		string(record.Type),
+		record.Direction,
+		record.Currency,
+		record.Amount,
+		record.AmountMinor.String(),
+		csvText(record.Counterparty),
+		csvText(record.Memo),
+		optionalID(record.OriginalTransactionID),
+		optionalID(record.ParentTransactionID),
+		rate,
+		csvText(record.Reference),
+	})
+	return err
+}
+
+// csvText neutralizes the text chosen by users, such as the memo set by the sender of a transfer,
+// which spreadsheets would evaluate as a formula: cells starting with =, +, -, @, tab or carriage
+// return are prefixed with a quote.
+func csvText(text string) string {
+	if text != "" && strings.ContainsRune("=+-@\t\r", rune(text[0])) {
+		return "'" + text
+	}
+	return text
+}
+
+func (e *csvExportWriter) Flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func (e *csvExportWriter) End() error {
+	return e.Flush()
+}
+
+// ndjsonExportWriter writes a JSON object per line
+type ndjsonExportWriter struct {
+	enc *json.Encoder
+}
+
+func (e *ndjsonExportWriter) ContentType() string { return "application/x-ndjson" }
+func (e *ndjsonExportWriter) Extension() string   { return "ndjson" }
+func (e *ndjsonExportWriter) Begin() error        { return nil }
+func (e *ndjsonExportWriter) Flush() error        { return nil }
+func (e *ndjsonExportWriter) End() error          { return nil }
+
+func (e *ndjsonExportWriter) Write(record *ExportRecord) error {
+	return e.enc.Encode(record)
+}
+
+// ofxExportWriter writes an OFX 2 bank statement of a single currency, as imported by personal
+// finance software. Holds are left out since they do not change the ledger balance until captured.
+type ofxExportWriter struct {
+	w        io.Writer
+	userID   uint
+	currency string
+	from, to time.Time // Period of the statement, unbounded if zero
+}
+
+func (e *ofxExportWriter) ContentType() string { return "application/x-ofx" }
+func (e *ofxExportWriter) Extension() string   { return "ofx" }
+func (e *ofxExportWriter) Flush() error        { return nil }
+
+func (e *ofxExportWriter) Begin() error {
+	now := time.Now()
+	start, end := e.from, e.to
+	if start.IsZero() {
+		start = time.Unix(0, 0)
+	}
+	if end.IsZero() {
+		end = now
+	}
+
+	_, err := fmt.Fprintf(e.w, `<?xml version="1.0" encoding="UTF-8"?>
+<?OFX OFXHEADER="200" VERSION="220" SECURITY="NONE" OLDFILEUID="NONE" NEWFILEUID="NONE"?>
+<OFX>
+<SIGNONMSGSRSV1><SONRS><STATUS><CODE>0</CODE><SEVERITY>INFO</SEVERITY></STATUS><DTSERVER>%s</DTSERVER><LANGUAGE>ENG</LANGUAGE></SONRS></SIGNONMSGSRSV1>
+<BANKMSGSRSV1><STMTTRNRS><TRNUID>0</TRNUID><STATUS><CODE>0</CODE><SEVERITY>INFO</SEVERITY></STATUS>
+<STMTRS><CURDEF>%s</CURDEF>
+<BANKACCTFROM><BANKID>go-wallet-app</BANKID><ACCTID>%d</ACCTID><ACCTTYPE>CHECKING</ACCTTYPE></BANKACCTFROM>
+<BANKTRANLIST><DTSTART>%s</DTSTART><DTEND>%s</DTEND>
+`, ofxTime(now), e.currency, e.userID, ofxTime(start), ofxTime(end))
+	return err
+}
+
+func (e *ofxExportWriter) Write(record *ExportRecord) error {
+	var trnType, sign string
+	switch record.Direction {
+	case exportCredit:
+		trnType = "CREDIT"
+	case exportDebit:
+		trnType, sign = "DEBIT", "-"
+	default:
+		return nil
+	}
+
+	name := record.Counterparty
+	if name == "" {
+		name = string(record.Type)
+	}
+
+	_, err := fmt.Fprintf(e.w,
+		"<STMTTRN><TRNTYPE>%s</TRNTYPE><DTPOSTED>%s</DTPOSTED><TRNAMT>%s%s</TRNAMT><FITID>%d</FITID><NAME>%s</NAME><MEMO>%s</MEMO></STMTTRN>\n",
+		trnType, ofxTime(record.Timestamp), sign, record.Amount, record.ID, ofxEscape(name, ofxNameLength), ofxEscape(record.Memo, ofxMemoLength))
+	return err
+}
+
+func (e *ofxExportWriter) End() error {
+	_, err := io.WriteString(e.w, "</BANKTRANLIST>\n</STMTRS></STMTTRNRS></BANKMSGSRSV1>\n</OFX>\n")
+	return err
+}
+
+// ofxTime formats the time as an OFX datetime in UTC
+func ofxTime(t time.Time) string {
+	return t.UTC().Format("20060102150405.000") + "[0:GMT]"
+}
+
+// ofxEscape escapes the text for an OFX element, truncated to the maximum length of the element
+func ofxEscape(text string, maxLength int) string {
+	if runes := []rune(text); len(runes) > maxLength {
+		text = string(runes[:maxLength])
+	}
+
+	var buf strings.Builder
+	xml.EscapeText(&buf, []byte(text))
+	return buf.String()
+}
+
+// optionalID formats the optional ID, empty if nil
+func optionalID(id *uint) string {
+	if id == nil {
+		return ""
+	}
+	return strconv.FormatUint(uint64(*id), 10)
+}
@@ -0,0 +1,184 @@
+package controllers_test
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/wanliqun/go-wallet-app/controllers"
+	"github.com/wanliqun/go-wallet-app/mocks"
+	"github.com/wanliqun/go-wallet-app/models"
+	"github.com/wanliqun/go-wallet-app/services"
+)
+
+func TestWalletController_ExportTransactions(t *testing.T) {
+	mockWalletService := new(mocks.MockWalletService)
+	mockUserService := new(mocks.MockUserService)
+	mockAuthService := new(mocks.MockAuthService)
+	router := setupTestRouter(mockWalletService, mockUserService, mockAuthService)
+
+	mockCurrencyService := new(mocks.MockCurrencyService)
+	mockCurrencyService.On("LookupCurrency", "USDT").Return(models.Currency{
+		Code: "USDT", Name: "Tether", Precision: 6, Enabled: true,
+	}, true)
+	mockCurrencyService.On("LookupCurrency", "").Return(models.Currency{}, false)
+
+	controllers.SetCurrencyRegistry(mockCurrencyService)
+	defer controllers.SetCurrencyRegistry(nil)
+
+	testUser := userGenerator.Generate()
+	mockAuthService.On("Authenticate", testUser.Name).Return(testUser, nil)
+
+	counterparty := userGenerator.Generate()
+	counterparty.ID = testUser.ID + 1
+
+	timestamp := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	newTransaction := func(id uint, txnType models.TransactionType, counterpartyID *uint) models.Transaction {
+		txn := models.Transaction{
+			UserID:         testUser.ID,
+			CounterpartyID: counterpartyID,
+			Type:           txnType,
+			Amount:         decimal.NewFromInt(1500000),
+			Currency:       "USDT",
+			Memo:           "rent, <march>",
+			Timestamp:      timestamp.Add(time.Duration(id) * time.Second),
+		}
+		txn.ID = id
+		return txn
+	}
+
+	serve := func(query string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/transactions/export?"+query, http.NoBody)
+		req.Header.Set("Authorization", "Bearer "+testUser.Name)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("should walk all pages to CSV", func(t *testing.T) {
+		firstPage := make([]models.Transaction, 0, 500)
+		for id := uint(1); id <= 500; id++ {
+			firstPage = append(firstPage, newTransaction(id, models.TransferOut, &counterparty.ID))
+		}
+		lastPage := []models.Transaction{newTransaction(501, models.Deposit, nil)}
+
+		mockWalletService.On("GetTransactionHistory", mock.Anything, testUser.ID, mock.Anything, "", services.SortOrderAsc, 500).
+			Return(firstPage, "cursor-1", nil).Once()
+		mockWalletService.On("GetTransactionHistory", mock.Anything, testUser.ID, mock.Anything, "cursor-1", services.SortOrderAsc, 500).
+			Return(lastPage, "cursor-2", nil).Once()
+		mockUserService.On("GetUserByID", mock.Anything, counterparty.ID).Return(counterparty, true, nil).Once()
+
+		w := serve("format=csv")
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Equal(t, "text/csv; charset=utf-8", w.Header().Get("Content-Type"))
+		assert.Contains(t, w.Header().Get("Content-Disposition"), `attachment; filename="transactions-`)
+
+		records, err := csv.NewReader(w.Body).ReadAll()
+		assert.NoError(t, err)
+		assert.Len(t, records, 502)
+		assert.Equal(t, "id", records[0][0])
+		assert.Equal(t, []string{
+			"1", "2024-01-01T00:00:01Z", "transfer_out", "debit", "USDT", "1.500000", "1500000",
+			counterparty.Name, "rent, <march>", "", "", "", "",
+		}, records[1])
+		assert.Equal(t, "credit", records[501][3])
+		assert.Equal(t, "", records[501][7])
+
+		mockUserService.AssertExpectations(t)
+	})
+
+	t.Run("should neutralize formulas in CSV cells", func(t *testing.T) {
+		formulas := []string{"=HYPERLINK(\"http://evil.example\",\"refund\")", "+cmd|' /C calc'!A0", "-2+3", "@SUM(A1:A2)", "\tx", "\rx"}
+		transactions := make([]models.Transaction, 0, len(formulas))
+		for i, memo := range formulas {
+			txn := newTransaction(uint(i+1), models.Deposit, nil)
+			txn.Memo = memo
+			transactions = append(transactions, txn)
+		}
+
+		mockWalletService.On("GetTransactionHistory", mock.Anything, testUser.ID, mock.Anything, "", services.SortOrderAsc, 500).
+			Return(transactions, "", nil).Once()
+
+		w := serve("format=csv")
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		records, err := csv.NewReader(w.Body).ReadAll()
+		assert.NoError(t, err)
+		if assert.Len(t, records, len(formulas)+1) {
+			for i, memo := range formulas {
+				assert.Equal(t, "'"+memo, records[i+1][8])
+			}
+		}
+	})
+
+	t.Run("should export NDJSON in the requested order", func(t *testing.T) {
+		mockWalletService.On("GetTransactionHistory", mock.Anything, testUser.ID, mock.Anything, "", services.SortOrderDesc, 500).
+			Return([]models.Transaction{newTransaction(2, models.Deposit, nil), newTransaction(1, models.Withdrawal, nil)}, "", nil).Once()
+
+		w := serve("format=ndjson&order=desc")
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+
+		var records []controllers.ExportRecord
+		scanner := bufio.NewScanner(w.Body)
+		for scanner.Scan() {
+			var record controllers.ExportRecord
+			assert.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+			records = append(records, record)
+		}
+		if assert.Len(t, records, 2) {
+			assert.Equal(t, uint(2), records[0].ID)
+			assert.Equal(t, "credit", records[0].Direction)
+			assert.Equal(t, "1.500000", records[0].Amount)
+			assert.Equal(t, "debit", records[1].Direction)
+		}
+	})
+
+	t.Run("should export an OFX statement without holds", func(t *testing.T) {
+		mockWalletService.On("GetTransactionHistory", mock.Anything, testUser.ID, mock.MatchedBy(func(f services.TransactionFilter) bool {
+			return f.Currency == "USDT"
+		}), "", services.SortOrderAsc, 500).
+			Return([]models.Transaction{newTransaction(1, models.Withdrawal, nil), newTransaction(2, models.HoldPlaced, nil)}, "", nil).Once()
+
+		w := serve("format=ofx&currency=USDT")
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		body := w.Body.String()
+		assert.Contains(t, body, "<CURDEF>USDT</CURDEF>")
+		assert.Contains(t, body, "<TRNTYPE>DEBIT</TRNTYPE><DTPOSTED>20240101000001.000[0:GMT]</DTPOSTED><TRNAMT>-1.500000</TRNAMT><FITID>1</FITID>")
+		assert.Contains(t, body, "<MEMO>rent, &lt;march&gt;</MEMO>")
+		assert.Equal(t, 1, strings.Count(body, "<STMTTRN>"))
+		assert.True(t, strings.HasSuffix(body, "</OFX>\n"))
+	})
+
+	t.Run("should require the currency of OFX statements", func(t *testing.T) {
+		w := serve("format=ofx")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("should reject unknown formats", func(t *testing.T) {
+		for _, query := range []string{"", "format=xlsx", "format=csv&order=random"} {
+			w := serve(query)
+			assert.Equal(t, http.StatusBadRequest, w.Code, query)
+		}
+	})
+
+	t.Run("should report failures before streaming", func(t *testing.T) {
+		mockWalletService.On("GetTransactionHistory", mock.Anything, testUser.ID, mock.Anything, "", services.SortOrderAsc, 500).
+			Return([]models.Transaction(nil), "", errors.New("database unavailable")).Once()
+
+		w := serve("format=ndjson")
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		assert.Contains(t, w.Header().Get("Content-Type"), "application/json")
+	})
+}
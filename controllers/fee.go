@@ -0,0 +1,46 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wanliqun/go-wallet-app/apperrors"
+	"github.com/wanliqun/go-wallet-app/services"
+	"github.com/wanliqun/go-wallet-app/utils"
+)
+
+type FeeController struct {
+	FeeService services.IFeeService
+}
+
+func NewFeeController(fee services.IFeeService) *FeeController {
+	return &FeeController{FeeService: fee}
+}
+
+// GET /fees
+func (ctrl *FeeController) EstimateFee(c *gin.Context) {
+	var cRequest FeeQuery
+	if err := c.ShouldBindQuery(&cRequest); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	amount := toMinorUnits(cRequest.Currency, cRequest.Amount)
+	fee, net, err := ctrl.FeeService.CalculateFee(cRequest.Currency, services.FeeOperations[cRequest.Operation], amount)
+	if err != nil {
+		utils.ErrorResponse(c, apperrors.StatusCode(err), err)
+		return
+	}
+
+	precision := currencyPrecision(cRequest.Currency)
+	utils.SuccessResponse(c, FeeResponse{
+		Operation:   cRequest.Operation,
+		Currency:    cRequest.Currency,
+		Amount:      utils.FormatMinorUnits(amount, precision),
+		AmountMinor: amount,
+		Fee:         utils.FormatMinorUnits(fee, precision),
+		FeeMinor:    fee,
+		Net:         utils.FormatMinorUnits(net, precision),
+		NetMinor:    net,
+	})
+}
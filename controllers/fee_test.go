@@ -0,0 +1,78 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/wanliqun/go-wallet-app/controllers"
+	"github.com/wanliqun/go-wallet-app/middlewares"
+	"github.com/wanliqun/go-wallet-app/mocks"
+	"github.com/wanliqun/go-wallet-app/models"
+	"github.com/wanliqun/go-wallet-app/services"
+)
+
+func setupFeeTestRouter(feeService *mocks.MockFeeService, authService *mocks.MockAuthService) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+
+	router.Use(middlewares.AuthMiddleware(authService, nil))
+
+	feeController := controllers.NewFeeController(feeService)
+	router.GET("/fees", feeController.EstimateFee)
+
+	return router
+}
+
+func TestFeeController_EstimateFee(t *testing.T) {
+	mockFeeService := new(mocks.MockFeeService)
+	mockAuthService := new(mocks.MockAuthService)
+	router := setupFeeTestRouter(mockFeeService, mockAuthService)
+
+	testUser := userGenerator.Generate()
+	mockAuthService.On("Authenticate", testUser.Name).Return(testUser, nil)
+
+	get := func(query string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/fees?"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+testUser.Name)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("should estimate fee successfully", func(t *testing.T) {
+		mockFeeService.On("CalculateFee", "USDT", models.Withdrawal, mock.MatchedBy(func(a decimal.Decimal) bool {
+			return a.Equal(decimal.NewFromInt(100))
+		})).Return(decimal.NewFromInt(2), decimal.NewFromInt(98), nil).Once()
+
+		w := get("operation=withdrawal&currency=USDT&amount=100")
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var resp struct {
+			Data controllers.FeeResponse
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		assert.Equal(t, "withdrawal", resp.Data.Operation)
+		assert.True(t, decimal.NewFromInt(2).Equal(resp.Data.FeeMinor))
+		assert.True(t, decimal.NewFromInt(98).Equal(resp.Data.NetMinor))
+	})
+
+	t.Run("should return 422 if the amount does not cover the fee", func(t *testing.T) {
+		mockFeeService.On("CalculateFee", "USDT", models.TransferOut, mock.Anything).
+			Return(decimal.NewFromInt(2), decimal.NewFromInt(-1), services.ErrAmountBelowFee).Once()
+
+		w := get("operation=transfer&currency=USDT&amount=1")
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	})
+
+	t.Run("should reject unknown operations", func(t *testing.T) {
+		w := get("operation=deposit&currency=USDT&amount=100")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
@@ -0,0 +1,126 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wanliqun/go-wallet-app/apperrors"
+	"github.com/wanliqun/go-wallet-app/models"
+	"github.com/wanliqun/go-wallet-app/services"
+	"github.com/wanliqun/go-wallet-app/utils"
+)
+
+type HoldController struct {
+	HoldService services.IHoldService
+	UserService services.IUserService
+	TwoFactor   services.ITwoFactorService // Step-up authentication of holds as transfers, none if nil
+}
+
+func NewHoldController(hold services.IHoldService, user services.IUserService) *HoldController {
+	return &HoldController{HoldService: hold, UserService: user}
+}
+
+// POST /holds
+func (ctrl *HoldController) PlaceHold(c *gin.Context) {
+	var cRequest HoldRequest
+	if err := c.ShouldBindJSON(&cRequest); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	idempotencyKey, err := getIdempotencyKey(c)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	user := c.MustGet("user").(*models.User)
+
+	recipient, ok, err := ctrl.UserService.GetUserByName(c.Request.Context(), cRequest.Recipient)
+	if err != nil {
+		utils.ErrorResponse(c, apperrors.StatusCode(err), err)
+		return
+	}
+	if !ok {
+		utils.ErrorResponse(c, http.StatusBadRequest, services.ErrUserNotFound)
+		return
+	}
+
+	amount := toMinorUnits(cRequest.Currency, cRequest.Amount)
+
+	// Holds are transfers settled later, the step-up is due when the funds are reserved
+	action := services.StepUpAction{
+		Operation: models.TransferOut, Currency: cRequest.Currency, Amount: amount, RecipientID: recipient.ID,
+	}
+	stepUp := stepUpCheck(c, ctrl.TwoFactor, action)
+
+	hold, err := ctrl.HoldService.PlaceHold(
+		user.ID, recipient.ID, cRequest.Currency, amount, cRequest.Memo, idempotencyKey, stepUp)
+	if err != nil {
+		utils.ErrorResponse(c, apperrors.StatusCode(err), err)
+		return
+	}
+
+	utils.SuccessResponse(c, newHoldResponse(hold))
+}
+
+// GET /holds/:id
+func (ctrl *HoldController) GetHold(c *gin.Context) {
+	var uri HoldURI
+	if err := c.ShouldBindUri(&uri); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	user := c.MustGet("user").(*models.User)
+
+	hold, ok, err := ctrl.HoldService.GetHold(user.ID, uri.ID)
+	if err != nil {
+		utils.ErrorResponse(c, apperrors.StatusCode(err), err)
+		return
+	}
+	if !ok {
+		utils.ErrorResponse(c, http.StatusNotFound, services.ErrHoldNotFound)
+		return
+	}
+
+	utils.SuccessResponse(c, newHoldResponse(hold))
+}
+
+// POST /holds/:id/capture
+func (ctrl *HoldController) CaptureHold(c *gin.Context) {
+	var uri HoldURI
+	if err := c.ShouldBindUri(&uri); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	user := c.MustGet("user").(*models.User)
+
+	hold, err := ctrl.HoldService.CaptureHold(user.ID, uri.ID)
+	if err != nil {
+		utils.ErrorResponse(c, apperrors.StatusCode(err), err)
+		return
+	}
+
+	utils.SuccessResponse(c, newHoldResponse(hold))
+}
+
+// POST /holds/:id/release
+func (ctrl *HoldController) ReleaseHold(c *gin.Context) {
+	var uri HoldURI
+	if err := c.ShouldBindUri(&uri); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	user := c.MustGet("user").(*models.User)
+
+	hold, err := ctrl.HoldService.ReleaseHold(user.ID, uri.ID)
+	if err != nil {
+		utils.ErrorResponse(c, apperrors.StatusCode(err), err)
+		return
+	}
+
+	utils.SuccessResponse(c, newHoldResponse(hold))
+}
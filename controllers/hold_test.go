@@ -0,0 +1,124 @@
+package controllers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/wanliqun/go-wallet-app/controllers"
+	"github.com/wanliqun/go-wallet-app/middlewares"
+	"github.com/wanliqun/go-wallet-app/mocks"
+	"github.com/wanliqun/go-wallet-app/models"
+	"github.com/wanliqun/go-wallet-app/services"
+)
+
+func setupHoldTestRouter(
+	holdService *mocks.MockHoldService, userService *mocks.MockUserService, authService *mocks.MockAuthService) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+
+	router.Use(middlewares.AuthMiddleware(authService, nil))
+
+	holdController := controllers.NewHoldController(holdService, userService)
+	holdRouter := router.Group("/holds")
+	{
+		holdRouter.POST("", holdController.PlaceHold)
+		holdRouter.GET("/:id", holdController.GetHold)
+		holdRouter.POST("/:id/capture", holdController.CaptureHold)
+		holdRouter.POST("/:id/release", holdController.ReleaseHold)
+	}
+
+	return router
+}
+
+func TestHoldController(t *testing.T) {
+	mockHoldService := new(mocks.MockHoldService)
+	mockUserService := new(mocks.MockUserService)
+	mockAuthService := new(mocks.MockAuthService)
+	router := setupHoldTestRouter(mockHoldService, mockUserService, mockAuthService)
+
+	senderUser := userGenerator.Generate()
+	recipientUser := userGenerator.Generate()
+	currency := "USDT"
+
+	mockAuthService.On("Authenticate", senderUser.Name).Return(senderUser, nil)
+
+	t.Run("should place hold successfully", func(t *testing.T) {
+		amount := decimal.NewFromFloat(100.0)
+
+		mockUserService.On("GetUserByName", mock.Anything, recipientUser.Name).Return(recipientUser, true, nil)
+		mockHoldService.On("PlaceHold", senderUser.ID, recipientUser.ID, currency, mock.MatchedBy(func(a decimal.Decimal) bool {
+			return a.Equal(amount)
+		}), "order", "", mock.Anything).Return(&models.Hold{
+			UserID:      senderUser.ID,
+			RecipientID: recipientUser.ID,
+			Amount:      amount,
+			Currency:    currency,
+			Status:      models.HoldStatusPending,
+		}, nil)
+
+		body, _ := json.Marshal(map[string]interface{}{
+			"recipient": recipientUser.Name,
+			"currency":  currency,
+			"amount":    amount.String(),
+			"memo":      "order",
+		})
+		req, _ := http.NewRequest("POST", "/holds", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+senderUser.Name)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var resp struct {
+			Data controllers.HoldResponse
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		assert.Equal(t, models.HoldStatusPending, resp.Data.Status)
+		assert.Equal(t, "100", resp.Data.Amount)
+	})
+
+	t.Run("should capture hold successfully", func(t *testing.T) {
+		mockHoldService.On("CaptureHold", senderUser.ID, uint(1)).Return(&models.Hold{Status: models.HoldStatusCaptured}, nil)
+
+		req, _ := http.NewRequest("POST", "/holds/1/capture", nil)
+		req.Header.Set("Authorization", "Bearer "+senderUser.Name)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("should return error for settled hold", func(t *testing.T) {
+		mockHoldService.On("ReleaseHold", senderUser.ID, uint(2)).Return(nil, services.ErrHoldNotPending)
+
+		req, _ := http.NewRequest("POST", "/holds/2/release", nil)
+		req.Header.Set("Authorization", "Bearer "+senderUser.Name)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+	})
+
+	t.Run("should return error for unknown hold", func(t *testing.T) {
+		mockHoldService.On("GetHold", senderUser.ID, uint(3)).Return(nil, false, nil)
+
+		req, _ := http.NewRequest("GET", "/holds/3", nil)
+		req.Header.Set("Authorization", "Bearer "+senderUser.Name)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
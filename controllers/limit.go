@@ -0,0 +1,159 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"github.com/wanliqun/go-wallet-app/apperrors"
+	"github.com/wanliqun/go-wallet-app/models"
+	"github.com/wanliqun/go-wallet-app/services"
+	"github.com/wanliqun/go-wallet-app/utils"
+)
+
+type LimitController struct {
+	LimitService services.ILimitService
+	UserService  services.IUserService
+}
+
+func NewLimitController(limit services.ILimitService, user services.IUserService) *LimitController {
+	return &LimitController{LimitService: limit, UserService: user}
+}
+
+// GET /limits
+func (ctrl *LimitController) GetLimits(c *gin.Context) {
+	var cRequest LimitQuery
+	if err := c.ShouldBindQuery(&cRequest); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	user := c.MustGet("user").(*models.User)
+	usage, err := ctrl.LimitService.GetLimits(user.ID, cRequest.Currency)
+	if err != nil {
+		utils.ErrorResponse(c, apperrors.StatusCode(err), err)
+		return
+	}
+
+	utils.SuccessResponse(c, newLimitResponse(usage))
+}
+
+// GET /admin/users/:id/limits
+func (ctrl *LimitController) GetUserLimits(c *gin.Context) {
+	var uri UserURI
+	if err := c.ShouldBindUri(&uri); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	var cRequest LimitQuery
+	if err := c.ShouldBindQuery(&cRequest); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if !ctrl.userExists(c, uri.ID) {
+		return
+	}
+
+	usage, err := ctrl.LimitService.GetLimits(uri.ID, cRequest.Currency)
+	if err != nil {
+		utils.ErrorResponse(c, apperrors.StatusCode(err), err)
+		return
+	}
+
+	utils.SuccessResponse(c, newLimitResponse(usage))
+}
+
+// PUT /admin/users/:id/limits/:currency
+func (ctrl *LimitController) SetUserLimit(c *gin.Context) {
+	var uri UserLimitURI
+	if err := c.ShouldBindUri(&uri); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	var cRequest UserLimitRequest
+	if err := c.ShouldBindJSON(&cRequest); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	limit := models.UserLimit{UserID: uri.ID, Currency: uri.Currency}
+	precision := currencyPrecision(uri.Currency)
+
+	var err error
+	if limit.PerTransaction, err = nullableMinorUnits(cRequest.PerTransaction, precision); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+	if limit.Daily, err = nullableMinorUnits(cRequest.Daily, precision); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+	if limit.Monthly, err = nullableMinorUnits(cRequest.Monthly, precision); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if !ctrl.userExists(c, uri.ID) {
+		return
+	}
+
+	if err := ctrl.LimitService.SetUserLimit(&limit); err != nil {
+		utils.ErrorResponse(c, apperrors.StatusCode(err), err)
+		return
+	}
+
+	usage, err := ctrl.LimitService.GetLimits(uri.ID, uri.Currency)
+	if err != nil {
+		utils.ErrorResponse(c, apperrors.StatusCode(err), err)
+		return
+	}
+
+	utils.SuccessResponse(c, newLimitResponse(usage))
+}
+
+// DELETE /admin/users/:id/limits/:currency
+func (ctrl *LimitController) DeleteUserLimit(c *gin.Context) {
+	var uri UserLimitURI
+	if err := c.ShouldBindUri(&uri); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := ctrl.LimitService.DeleteUserLimit(uri.ID, uri.Currency); err != nil {
+		utils.ErrorResponse(c, apperrors.StatusCode(err), err)
+		return
+	}
+
+	utils.SuccessResponse(c, nil)
+}
+
+// userExists responds with 404 Not Found unless the user exists
+func (ctrl *LimitController) userExists(c *gin.Context, userID uint) bool {
+	_, ok, err := ctrl.UserService.GetUserByID(c.Request.Context(), userID)
+	if err != nil {
+		utils.ErrorResponse(c, apperrors.StatusCode(err), err)
+		return false
+	}
+	if !ok {
+		utils.ErrorResponse(c, http.StatusNotFound, services.ErrUserNotFound)
+		return false
+	}
+	return true
+}
+
+// nullableMinorUnits converts an optional non-negative limit in human units to minor units, an
+// omitted limit is converted to null
+func nullableMinorUnits(amount *decimal.Decimal, precision int32) (decimal.NullDecimal, error) {
+	if amount == nil {
+		return decimal.NullDecimal{}, nil
+	}
+
+	minorAmount, err := optionalMinorUnits(amount, precision)
+	if err != nil {
+		return decimal.NullDecimal{}, err
+	}
+	return decimal.NewNullDecimal(minorAmount), nil
+}
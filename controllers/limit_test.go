@@ -0,0 +1,119 @@
+package controllers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/wanliqun/go-wallet-app/controllers"
+	"github.com/wanliqun/go-wallet-app/middlewares"
+	"github.com/wanliqun/go-wallet-app/mocks"
+	"github.com/wanliqun/go-wallet-app/models"
+	"github.com/wanliqun/go-wallet-app/services"
+)
+
+func setupLimitTestRouter(
+	limitService *mocks.MockLimitService, userService *mocks.MockUserService, authService *mocks.MockAuthService) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+
+	router.Use(middlewares.AuthMiddleware(authService, nil))
+
+	limitController := controllers.NewLimitController(limitService, userService)
+	router.GET("/limits", limitController.GetLimits)
+	router.GET("/users/:id/limits", limitController.GetUserLimits)
+	router.PUT("/users/:id/limits/:currency", limitController.SetUserLimit)
+	router.DELETE("/users/:id/limits/:currency", limitController.DeleteUserLimit)
+
+	return router
+}
+
+func TestLimitController(t *testing.T) {
+	mockLimitService := new(mocks.MockLimitService)
+	mockUserService := new(mocks.MockUserService)
+	mockAuthService := new(mocks.MockAuthService)
+	router := setupLimitTestRouter(mockLimitService, mockUserService, mockAuthService)
+
+	testUser := userGenerator.Generate()
+	mockAuthService.On("Authenticate", testUser.Name).Return(testUser, nil)
+
+	otherUser := userGenerator.Generate()
+	otherUser.ID = testUser.ID + 1
+
+	serve := func(method, path string, body interface{}) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, http.NoBody)
+		if body != nil {
+			data, _ := json.Marshal(body)
+			req, _ = http.NewRequest(method, path, bytes.NewBuffer(data))
+			req.Header.Set("Content-Type", "application/json")
+		}
+		req.Header.Set("Authorization", "Bearer "+testUser.Name)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	usage := &services.LimitUsage{
+		Limits:      services.Limits{Daily: decimal.NewFromInt(1000)},
+		Currency:    "USDT",
+		DailyUsed:   decimal.NewFromInt(250),
+		MonthlyUsed: decimal.NewFromInt(250),
+	}
+
+	t.Run("should get limits successfully", func(t *testing.T) {
+		mockLimitService.On("GetLimits", testUser.ID, "USDT").Return(usage, nil).Once()
+
+		w := serve("GET", "/limits?currency=USDT", nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var resp struct {
+			Data controllers.LimitResponse
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		assert.Equal(t, "1000", resp.Data.Daily)
+		assert.Equal(t, "250", resp.Data.DailyUsed)
+		assert.Equal(t, "0", resp.Data.Monthly)
+	})
+
+	t.Run("should override user limits successfully", func(t *testing.T) {
+		mockUserService.On("GetUserByID", mock.Anything, otherUser.ID).Return(otherUser, true, nil).Once()
+		mockLimitService.On("SetUserLimit", mock.MatchedBy(func(l *models.UserLimit) bool {
+			return l.UserID == otherUser.ID && l.Currency == "USDT" &&
+				l.Daily.Valid && l.Daily.Decimal.Equal(decimal.NewFromInt(500)) &&
+				l.Monthly.Valid && l.Monthly.Decimal.IsZero() && !l.PerTransaction.Valid
+		})).Return(nil).Once()
+		mockLimitService.On("GetLimits", otherUser.ID, "USDT").Return(usage, nil).Once()
+
+		path := fmt.Sprintf("/users/%d/limits/USDT", otherUser.ID)
+		w := serve("PUT", path, map[string]interface{}{"daily": "500", "monthly": "0"})
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("should reject negative limits", func(t *testing.T) {
+		path := fmt.Sprintf("/users/%d/limits/USDT", otherUser.ID)
+		w := serve("PUT", path, map[string]interface{}{"daily": "-1"})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("should return 404 for unknown users", func(t *testing.T) {
+		mockUserService.On("GetUserByID", mock.Anything, uint(999)).Return(nil, false, nil).Once()
+
+		w := serve("GET", "/users/999/limits?currency=USDT", nil)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("should delete user limits successfully", func(t *testing.T) {
+		mockLimitService.On("DeleteUserLimit", otherUser.ID, "USDT").Return(nil).Once()
+
+		w := serve("DELETE", fmt.Sprintf("/users/%d/limits/USDT", otherUser.ID), nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+}
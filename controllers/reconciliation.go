@@ -0,0 +1,46 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wanliqun/go-wallet-app/apperrors"
+	"github.com/wanliqun/go-wallet-app/services"
+	"github.com/wanliqun/go-wallet-app/utils"
+)
+
+type ReconciliationController struct {
+	ReconciliationService services.IReconciliationService
+}
+
+func NewReconciliationController(reconciliation services.IReconciliationService) *ReconciliationController {
+	return &ReconciliationController{ReconciliationService: reconciliation}
+}
+
+// POST /admin/reconciliation/runs
+func (ctrl *ReconciliationController) Reconcile(c *gin.Context) {
+	run, err := ctrl.ReconciliationService.Reconcile()
+	if err != nil {
+		utils.ErrorResponse(c, apperrors.StatusCode(err), err)
+		return
+	}
+
+	utils.SuccessResponse(c, run)
+}
+
+// GET /admin/reconciliation/runs
+func (ctrl *ReconciliationController) ListRuns(c *gin.Context) {
+	var cRequest ListReconciliationRunsQuery
+	if err := c.ShouldBindQuery(&cRequest); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	runs, err := ctrl.ReconciliationService.ListRuns(cRequest.Limit)
+	if err != nil {
+		utils.ErrorResponse(c, apperrors.StatusCode(err), err)
+		return
+	}
+
+	utils.SuccessResponse(c, runs)
+}
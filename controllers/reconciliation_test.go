@@ -0,0 +1,98 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/wanliqun/go-wallet-app/controllers"
+	"github.com/wanliqun/go-wallet-app/middlewares"
+	"github.com/wanliqun/go-wallet-app/mocks"
+	"github.com/wanliqun/go-wallet-app/models"
+	"github.com/wanliqun/go-wallet-app/services"
+)
+
+func setupReconciliationTestRouter(
+	reconciliationService *mocks.MockReconciliationService, authService *mocks.MockAuthService) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+
+	reconciliationController := controllers.NewReconciliationController(reconciliationService)
+
+	adminRouter := router.Group("/admin", middlewares.AuthMiddleware(authService, nil), middlewares.PermissionMiddleware(
+		services.NewRoleAuthorizer(services.DefaultRolePermissions), services.PermReconcile))
+	{
+		adminRouter.POST("/reconciliation/runs", reconciliationController.Reconcile)
+		adminRouter.GET("/reconciliation/runs", reconciliationController.ListRuns)
+	}
+
+	return router
+}
+
+func TestReconciliationController(t *testing.T) {
+	mockReconciliationService := new(mocks.MockReconciliationService)
+	mockAuthService := new(mocks.MockAuthService)
+
+	adminUser := userGenerator.Generate()
+	adminUser.Role = models.RoleFinance
+	router := setupReconciliationTestRouter(mockReconciliationService, mockAuthService)
+
+	mockAuthService.On("Authenticate", adminUser.Name).Return(adminUser, nil)
+
+	t.Run("should run reconciliation", func(t *testing.T) {
+		run := &models.ReconciliationRun{
+			VaultsScanned: 2,
+			MismatchCount: 1,
+			Mismatches: models.VaultMismatches{{
+				UserID:     1,
+				Currency:   "USDT",
+				Expected:   decimal.NewFromInt(20),
+				Actual:     decimal.NewFromInt(25),
+				Difference: decimal.NewFromInt(5),
+			}},
+		}
+		mockReconciliationService.On("Reconcile").Return(run, nil)
+
+		req, _ := http.NewRequest("POST", "/admin/reconciliation/runs", nil)
+		req.Header.Set("Authorization", "Bearer "+adminUser.Name)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var resp struct {
+			Data models.ReconciliationRun
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		assert.Equal(t, 1, resp.Data.MismatchCount)
+		assert.Len(t, resp.Data.Mismatches, 1)
+	})
+
+	t.Run("should list reconciliation runs", func(t *testing.T) {
+		mockReconciliationService.On("ListRuns", 5).Return([]models.ReconciliationRun{{VaultsScanned: 2}}, nil)
+
+		req, _ := http.NewRequest("GET", "/admin/reconciliation/runs?limit=5", nil)
+		req.Header.Set("Authorization", "Bearer "+adminUser.Name)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		mockReconciliationService.AssertCalled(t, "ListRuns", 5)
+	})
+
+	t.Run("should reject invalid limits", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/admin/reconciliation/runs?limit=1000", nil)
+		req.Header.Set("Authorization", "Bearer "+adminUser.Name)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
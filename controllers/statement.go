@@ -0,0 +1,218 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wanliqun/go-wallet-app/apperrors"
+	"github.com/wanliqun/go-wallet-app/models"
+	"github.com/wanliqun/go-wallet-app/services"
+	"github.com/wanliqun/go-wallet-app/utils"
+)
+
+// maxStatementPeriod is the longest period a statement can cover
+const maxStatementPeriod = 366 * 24 * time.Hour
+
+// statementTemplate renders statements as a standalone HTML page, e.g. to be emailed
+var statementTemplate = template.Must(template.New("statement").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Statement {{.Currency}} {{.From.Format "2006-01-02"}}</title>
+<style>
+body { font-family: sans-serif; font-size: 13px; }
+table { border-collapse: collapse; width: 100%; }
+th, td { border-bottom: 1px solid #ddd; padding: 4px 8px; text-align: left; }
+.amount { text-align: right; font-family: monospace; }
+</style>
+</head>
+<body>
+<h1>Account statement</h1>
+<p>
+User: {{.User}}<br>
+Currency: {{.Currency}}<br>
+Period: {{.From.Format "2006-01-02 15:04:05 MST"}} to {{.To.Format "2006-01-02 15:04:05 MST"}}<br>
+Generated at: {{.GeneratedAt.Format "2006-01-02 15:04:05 MST"}}
+</p>
+<table>
+<thead>
+<tr><th>Date</th><th>Type</th><th>Counterparty</th><th>Memo</th><th class="amount">Amount</th><th class="amount">Balance</th></tr>
+</thead>
+<tbody>
+<tr><td colspan="5">Opening balance</td><td class="amount">{{.OpeningBalance}}</td></tr>
+{{- range .Lines}}
+<tr><td>{{.Timestamp.Format "2006-01-02 15:04:05"}}</td><td>{{.Type}}</td><td>{{.Counterparty}}</td><td>{{.Memo}}</td><td class="amount">{{if eq .Direction "debit"}}-{{end}}{{.Amount}}</td><td class="amount">{{.Balance}}</td></tr>
+{{- end}}
+<tr><td colspan="5">Closing balance</td><td class="amount">{{.ClosingBalance}}</td></tr>
+</tbody>
+</table>
+<p>Total credits: {{.TotalCredits}}<br>Total debits: {{.TotalDebits}}</p>
+{{- with .Discrepancy}}
+<p>Balance not accounted for by the transactions: {{.}}</p>
+{{- end}}
+</body>
+</html>
+`))
+
+type StatementController struct {
+	StatementService services.IStatementService
+	UserService      services.IUserService
+}
+
+func NewStatementController(statement services.IStatementService, user services.IUserService) *StatementController {
+	return &StatementController{StatementService: statement, UserService: user}
+}
+
+// GET /statements
+func (ctrl *StatementController) GetStatement(c *gin.Context) {
+	var cRequest GetStatementQuery
+	if err := c.ShouldBindQuery(&cRequest); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	from, to, err := statementPeriod(&cRequest)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	user := c.MustGet("user").(*models.User)
+
+	statement, err := ctrl.StatementService.GetStatement(user.ID, cRequest.Currency, from, to)
+	if err != nil {
+		utils.ErrorResponse(c, apperrors.StatusCode(err), err)
+		return
+	}
+
+	response, err := ctrl.newStatementResponse(c.Request.Context(), user, statement)
+	if err != nil {
+		utils.ErrorResponse(c, apperrors.StatusCode(err), err)
+		return
+	}
+
+	filename := fmt.Sprintf("statement-%s-%s", statement.Currency, statement.From.UTC().Format("20060102"))
+	switch cRequest.Format {
+	case "html":
+		var buf bytes.Buffer
+		if err := statementTemplate.Execute(&buf, response); err != nil {
+			utils.ErrorResponse(c, http.StatusInternalServerError, err)
+			return
+		}
+		c.Header("Content-Disposition", fmt.Sprintf("inline; filename=%q", filename+".html"))
+		c.Data(http.StatusOK, "text/html; charset=utf-8", buf.Bytes())
+	case "pdf":
+		var buf bytes.Buffer
+		if err := utils.WriteTextPDF(&buf, "Account statement", statementTextLines(response)); err != nil {
+			utils.ErrorResponse(c, http.StatusInternalServerError, err)
+			return
+		}
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename+".pdf"))
+		c.Data(http.StatusOK, "application/pdf", buf.Bytes())
+	default:
+		utils.SuccessResponse(c, response)
+	}
+}
+
+// statementPeriod returns the period of the statement, either the calendar month or from and
+// to, which defaults to now
+func statementPeriod(cRequest *GetStatementQuery) (time.Time, time.Time, error) {
+	if cRequest.Month != "" {
+		if !cRequest.From.IsZero() || !cRequest.To.IsZero() {
+			return time.Time{}, time.Time{}, apperrors.New(apperrors.InvalidRequest, "month cannot be combined with from and to")
+		}
+
+		from, _ := time.Parse("2006-01", cRequest.Month)
+		return from, from.AddDate(0, 1, 0), nil
+	}
+
+	if cRequest.From.IsZero() {
+		return time.Time{}, time.Time{}, apperrors.New(apperrors.InvalidRequest, "either month or from is required")
+	}
+
+	to := cRequest.To
+	if to.IsZero() {
+		to = time.Now()
+	}
+	if !to.After(cRequest.From) {
+		return time.Time{}, time.Time{}, apperrors.New(apperrors.InvalidRequest, "from must precede to")
+	}
+	if to.Sub(cRequest.From) > maxStatementPeriod {
+		return time.Time{}, time.Time{}, apperrors.New(apperrors.InvalidRequest, "statement period exceeds one year")
+	}
+	return cRequest.From, to, nil
+}
+
+func (ctrl *StatementController) newStatementResponse(ctx context.Context, user *models.User, statement *services.Statement) (*StatementResponse, error) {
+	precision := currencyPrecision(statement.Currency)
+	response := StatementResponse{
+		User:           user.Name,
+		Currency:       statement.Currency,
+		From:           statement.From,
+		To:             statement.To,
+		OpeningBalance: utils.FormatMinorUnits(statement.OpeningBalance, precision),
+		ClosingBalance: utils.FormatMinorUnits(statement.ClosingBalance, precision),
+		TotalCredits:   utils.FormatMinorUnits(statement.TotalCredits, precision),
+		TotalDebits:    utils.FormatMinorUnits(statement.TotalDebits, precision),
+		Lines:          make([]StatementLineResponse, 0, len(statement.Lines)),
+		GeneratedAt:    statement.GeneratedAt,
+	}
+	if !statement.Discrepancy.IsZero() {
+		response.Discrepancy = utils.FormatMinorUnits(statement.Discrepancy, precision)
+	}
+
+	counterparties := newCounterpartyNames(ctx, ctrl.UserService)
+	for i := range statement.Lines {
+		record, err := newExportRecord(&statement.Lines[i].Transaction, counterparties)
+		if err != nil {
+			return nil, err
+		}
+
+		response.Lines = append(response.Lines, StatementLineResponse{
+			ExportRecord: *record,
+			Balance:      utils.FormatMinorUnits(statement.Lines[i].Balance, precision),
+		})
+	}
+	return &response, nil
+}
+
+// statementTextLines lays the statement out in fixed width columns, for the monospaced PDF
+func statementTextLines(response *StatementResponse) []string {
+	const row = "%-19s  %-12s  %-16.16s  %-20.20s  %15s  %15s"
+
+	lines := []string{
+		"ACCOUNT STATEMENT",
+		"",
+		"User:      " + response.User,
+		"Currency:  " + response.Currency,
+		"Period:    " + response.From.UTC().Format("2006-01-02 15:04:05") + " to " + response.To.UTC().Format("2006-01-02 15:04:05") + " UTC",
+		"Generated: " + response.GeneratedAt.UTC().Format("2006-01-02 15:04:05") + " UTC",
+		"",
+		fmt.Sprintf(row, "Date (UTC)", "Type", "Counterparty", "Memo", "Amount", "Balance"),
+		fmt.Sprintf(row, "Opening balance", "", "", "", "", response.OpeningBalance),
+	}
+	for _, line := range response.Lines {
+		amount := line.Amount
+		if line.Direction == exportDebit {
+			amount = "-" + amount
+		}
+		lines = append(lines, fmt.Sprintf(row,
+			line.Timestamp.UTC().Format("2006-01-02 15:04:05"), line.Type, line.Counterparty, line.Memo, amount, line.Balance))
+	}
+
+	lines = append(lines,
+		fmt.Sprintf(row, "Closing balance", "", "", "", "", response.ClosingBalance),
+		"",
+		"Total credits: "+response.TotalCredits,
+		"Total debits:  "+response.TotalDebits,
+	)
+	if response.Discrepancy != "" {
+		lines = append(lines, "Balance not accounted for by the transactions: "+response.Discrepancy)
+	}
+	return lines
+}
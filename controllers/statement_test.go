@@ -0,0 +1,171 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/wanliqun/go-wallet-app/controllers"
+	"github.com/wanliqun/go-wallet-app/middlewares"
+	"github.com/wanliqun/go-wallet-app/mocks"
+	"github.com/wanliqun/go-wallet-app/models"
+	"github.com/wanliqun/go-wallet-app/services"
+)
+
+func setupStatementTestRouter(
+	statementService *mocks.MockStatementService, userService *mocks.MockUserService, authService *mocks.MockAuthService) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+
+	router.Use(middlewares.AuthMiddleware(authService, nil))
+
+	statementController := controllers.NewStatementController(statementService, userService)
+	router.GET("/statements", statementController.GetStatement)
+
+	return router
+}
+
+func TestStatementController_GetStatement(t *testing.T) {
+	mockStatementService := new(mocks.MockStatementService)
+	mockUserService := new(mocks.MockUserService)
+	mockAuthService := new(mocks.MockAuthService)
+	router := setupStatementTestRouter(mockStatementService, mockUserService, mockAuthService)
+
+	mockCurrencyService := new(mocks.MockCurrencyService)
+	mockCurrencyService.On("LookupCurrency", "USDT").Return(models.Currency{
+		Code: "USDT", Name: "Tether", Precision: 6, Enabled: true,
+	}, true)
+
+	controllers.SetCurrencyRegistry(mockCurrencyService)
+	defer controllers.SetCurrencyRegistry(nil)
+
+	testUser := userGenerator.Generate()
+	mockAuthService.On("Authenticate", testUser.Name).Return(testUser, nil)
+
+	counterparty := userGenerator.Generate()
+	counterparty.ID = testUser.ID + 1
+	mockUserService.On("GetUserByID", mock.Anything, counterparty.ID).Return(counterparty, true, nil)
+
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 1, 0)
+
+	withdrawal := models.Transaction{Type: models.Withdrawal, Amount: decimal.NewFromInt(30_000000), Currency: "USDT", Timestamp: from}
+	withdrawal.ID = 2
+	transferIn := models.Transaction{
+		Type: models.TransferIn, Amount: decimal.NewFromInt(20_000000), Currency: "USDT", Memo: "refund",
+		CounterpartyID: &counterparty.ID, Timestamp: from.AddDate(0, 0, 20),
+	}
+	transferIn.ID = 3
+
+	statement := &services.Statement{
+		UserID:         testUser.ID,
+		Currency:       "USDT",
+		From:           from,
+		To:             to,
+		OpeningBalance: decimal.NewFromInt(100_000000),
+		ClosingBalance: decimal.NewFromInt(90_000000),
+		TotalCredits:   decimal.NewFromInt(20_000000),
+		TotalDebits:    decimal.NewFromInt(30_000000),
+		Lines: []services.StatementLine{
+			{Transaction: withdrawal, Balance: decimal.NewFromInt(70_000000)},
+			{Transaction: transferIn, Balance: decimal.NewFromInt(90_000000)},
+		},
+		GeneratedAt: time.Now(),
+	}
+
+	serve := func(query string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/statements?"+query, http.NoBody)
+		req.Header.Set("Authorization", "Bearer "+testUser.Name)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("should return the monthly statement as JSON", func(t *testing.T) {
+		mockStatementService.On("GetStatement", testUser.ID, "USDT", from, to).Return(statement, nil).Once()
+
+		w := serve("currency=USDT&month=2024-01")
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		var resp struct {
+			Data controllers.StatementResponse
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		assert.Equal(t, testUser.Name, resp.Data.User)
+		assert.Equal(t, "100.000000", resp.Data.OpeningBalance)
+		assert.Equal(t, "90.000000", resp.Data.ClosingBalance)
+		assert.Empty(t, resp.Data.Discrepancy)
+		if assert.Len(t, resp.Data.Lines, 2) {
+			assert.Equal(t, "debit", resp.Data.Lines[0].Direction)
+			assert.Equal(t, "70.000000", resp.Data.Lines[0].Balance)
+			assert.Equal(t, counterparty.Name, resp.Data.Lines[1].Counterparty)
+			assert.Equal(t, "20.000000", resp.Data.Lines[1].Amount)
+		}
+	})
+
+	t.Run("should render the statement as HTML", func(t *testing.T) {
+		mockStatementService.On("GetStatement", testUser.ID, "USDT", from, to).Return(statement, nil).Once()
+
+		w := serve("currency=USDT&from=2024-01-01T00:00:00Z&to=2024-02-01T00:00:00Z&format=html")
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+
+		body := w.Body.String()
+		assert.Contains(t, body, `<td class="amount">-30.000000</td><td class="amount">70.000000</td>`)
+		assert.Contains(t, body, "<td>"+counterparty.Name+"</td>")
+		assert.Contains(t, body, `Closing balance</td><td class="amount">90.000000</td>`)
+	})
+
+	t.Run("should render the statement as PDF", func(t *testing.T) {
+		mockStatementService.On("GetStatement", testUser.ID, "USDT", from, to).Return(statement, nil).Once()
+
+		w := serve("currency=USDT&month=2024-01&format=pdf")
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Equal(t, "application/pdf", w.Header().Get("Content-Type"))
+		assert.Equal(t, `attachment; filename="statement-USDT-20240101.pdf"`, w.Header().Get("Content-Disposition"))
+		assert.True(t, strings.HasPrefix(w.Body.String(), "%PDF-"))
+		assert.Contains(t, w.Body.String(), "-30.000000")
+	})
+
+	t.Run("should reject invalid periods", func(t *testing.T) {
+		for _, query := range []string{
+			"month=2024-01",
+			"currency=USDT",
+			"currency=USDT&month=2024-13",
+			"currency=USDT&month=2024-01&from=2024-01-01T00:00:00Z",
+			"currency=USDT&from=2024-02-01T00:00:00Z&to=2024-01-01T00:00:00Z",
+			"currency=USDT&from=2022-01-01T00:00:00Z&to=2024-01-01T00:00:00Z",
+			"currency=USDT&month=2024-01&format=xml",
+		} {
+			w := serve(query)
+			assert.Equal(t, http.StatusBadRequest, w.Code, query)
+		}
+	})
+
+	t.Run("should report the discrepancy of statements not reconciling with the vault", func(t *testing.T) {
+		unreconciled := *statement
+		unreconciled.Lines = nil
+		unreconciled.Discrepancy = decimal.NewFromInt(-5_000000)
+		mockStatementService.On("GetStatement", testUser.ID, "USDT", from, to).Return(&unreconciled, nil).Once()
+
+		w := serve("currency=USDT&month=2024-01")
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		var resp struct {
+			Data controllers.StatementResponse
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		assert.Equal(t, "-5.000000", resp.Data.Discrepancy)
+	})
+
+	mockStatementService.AssertExpectations(t)
+	mockUserService.AssertNumberOfCalls(t, "GetUserByID", 3)
+}
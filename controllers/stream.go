@@ -0,0 +1,190 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+	"github.com/wanliqun/go-wallet-app/apperrors"
+	"github.com/wanliqun/go-wallet-app/models"
+	"github.com/wanliqun/go-wallet-app/services"
+	"github.com/wanliqun/go-wallet-app/utils"
+)
+
+const (
+	// streamBuffer is the number of events a stream can fall behind before it is closed
+	streamBuffer = 64
+	// streamReplayPageSize is the number of transactions replayed per query on resume
+	streamReplayPageSize = 50
+	// streamHeartbeatInterval is the interval of the comments keeping idle streams open
+	streamHeartbeatInterval = 15 * time.Second
+)
+
+// Names of the events pushed to the streams
+const (
+	streamTransactionEvent = "transaction"
+	streamBalanceEvent     = "balance"
+)
+
+type StreamController struct {
+	WalletService services.IWalletService
+	Events        services.ISubscribable
+	Heartbeat     time.Duration // Interval of the heartbeat comments
+}
+
+func NewStreamController(wallet services.IWalletService, events services.ISubscribable) *StreamController {
+	return &StreamController{WalletService: wallet, Events: events, Heartbeat: streamHeartbeatInterval}
+}
+
+// GET /stream
+//
+// The stream is fed by the in-memory publisher of this instance, which only receives the events
+// relayed by this instance. Until a BrokerPublisher feeds the streams, the API must run as a
+// single instance, or streams miss the events relayed by the other instances.
+func (ctrl *StreamController) Stream(c *gin.Context) {
+	var cRequest StreamQuery
+	if err := c.ShouldBindQuery(&cRequest); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	// The Last-Event-ID header is sent by browsers reconnecting on their own
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = cRequest.LastEventID
+	}
+	if lastEventID != "" {
+		if _, _, err := utils.DecodeCursor(lastEventID); err != nil {
+			utils.ErrorResponse(c, http.StatusBadRequest, apperrors.New(apperrors.InvalidRequest, "invalid last event ID"))
+			return
+		}
+	}
+
+	user := c.MustGet("user").(*models.User)
+	streamed := func(currency string) bool {
+		if len(cRequest.Currencies) == 0 {
+			return true
+		}
+		for _, code := range cRequest.Currencies {
+			if code == currency {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Subscribe before replaying, so that no transaction committed meanwhile is missed
+	events, cancel := ctrl.Events.Subscribe(user.ID, streamBuffer)
+	defer cancel()
+
+	// Replay the transactions following the last event received, and the current balances
+	var transactions []models.Transaction
+	for cursor := lastEventID; cursor != ""; {
+		page, nextCursor, err := ctrl.WalletService.GetTransactionHistory(
+			c.Request.Context(), user.ID, services.TransactionFilter{}, cursor, services.SortOrderAsc, streamReplayPageSize)
+		if err != nil {
+			utils.ErrorResponse(c, apperrors.StatusCode(err), err)
+			return
+		}
+
+		transactions = append(transactions, page...)
+		if len(page) < streamReplayPageSize {
+			break
+		}
+		cursor = nextCursor
+	}
+
+	var vaults []models.Vault
+	if len(cRequest.Currencies) > 0 {
+		var err error
+		if vaults, err = ctrl.WalletService.GetBalances(c.Request.Context(), user.ID, cRequest.Currencies); err != nil {
+			utils.ErrorResponse(c, apperrors.StatusCode(err), err)
+			return
+		}
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no") // Disable response buffering of nginx
+	c.Status(http.StatusOK)
+
+	// Live events up to the last transaction replayed, or resumed from, were already received by
+	// the client, either replayed or before reconnecting
+	var watermark uint
+	if lastEventID != "" {
+		_, watermark, _ = utils.DecodeCursor(lastEventID)
+	}
+	for i := range transactions {
+		if transactions[i].ID > watermark {
+			watermark = transactions[i].ID
+		}
+		if streamed(transactions[i].Currency) {
+			writeTransactionEvent(c, &transactions[i])
+		}
+	}
+	for _, vault := range vaults {
+		writeBalanceEvent(c, vault)
+	}
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(ctrl.Heartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-heartbeat.C:
+			c.Writer.WriteString(": heartbeat\n\n")
+		case event, ok := <-events:
+			if !ok {
+				// Fell behind, the client reconnects and resumes from the last event
+				return
+			}
+
+			switch event.Type {
+			case models.EventTransactionCreated:
+				var txn models.Transaction
+				if err := json.Unmarshal(event.Payload, &txn); err != nil || txn.ID <= watermark || !streamed(txn.Currency) {
+					continue
+				}
+				writeTransactionEvent(c, &txn)
+			case models.EventBalanceChanged:
+				var change models.BalanceChange
+				if err := json.Unmarshal(event.Payload, &change); err != nil || !streamed(change.Currency) {
+					continue
+				}
+				writeBalanceEvent(c, models.Vault{
+					UserID:   change.UserID,
+					Currency: change.Currency,
+					Amount:   change.Amount,
+					Held:     change.Held,
+				})
+			default:
+				continue
+			}
+		}
+		c.Writer.Flush()
+	}
+}
+
+// writeTransactionEvent pushes the transaction identified by its history cursor, so that the
+// stream resumes from it on reconnect
+func writeTransactionEvent(c *gin.Context, txn *models.Transaction) {
+	c.Render(-1, sse.Event{
+		Id:    utils.EncodeCursor(txn.Timestamp, txn.ID),
+		Event: streamTransactionEvent,
+		Data:  newTransactionResponse(txn),
+	})
+}
+
+// writeBalanceEvent pushes the balance without an ID, leaving the last event ID unchanged
+func writeBalanceEvent(c *gin.Context, vault models.Vault) {
+	c.Render(-1, sse.Event{
+		Event: streamBalanceEvent,
+		Data:  newBalanceResponse(vault),
+	})
+}
@@ -0,0 +1,152 @@
+package controllers_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/wanliqun/go-wallet-app/controllers"
+	"github.com/wanliqun/go-wallet-app/middlewares"
+	"github.com/wanliqun/go-wallet-app/mocks"
+	"github.com/wanliqun/go-wallet-app/models"
+	"github.com/wanliqun/go-wallet-app/services"
+	"github.com/wanliqun/go-wallet-app/utils"
+)
+
+func setupStreamTestRouter(
+	walletService *mocks.MockWalletService, events services.ISubscribable, authService *mocks.MockAuthService) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+
+	router.Use(middlewares.AuthMiddleware(authService, nil))
+
+	streamController := controllers.NewStreamController(walletService, events)
+	router.GET("/stream", streamController.Stream)
+
+	return router
+}
+
+// streamEvent is an event read from a stream
+type streamEvent struct {
+	ID    string
+	Event string
+	Data  string
+}
+
+// readStreamEvents reads the next count events from the stream, skipping comments
+func readStreamEvents(scanner *bufio.Scanner, count int) []streamEvent {
+	var events []streamEvent
+	var event streamEvent
+	for len(events) < count && scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if event.Event != "" {
+				events = append(events, event)
+			}
+			event = streamEvent{}
+		case strings.HasPrefix(line, "id:"):
+			event.ID = strings.TrimPrefix(line, "id:")
+		case strings.HasPrefix(line, "event:"):
+			event.Event = strings.TrimPrefix(line, "event:")
+		case strings.HasPrefix(line, "data:"):
+			event.Data = strings.TrimPrefix(line, "data:")
+		}
+	}
+	return events
+}
+
+func TestStreamController(t *testing.T) {
+	mockWalletService := new(mocks.MockWalletService)
+	mockAuthService := new(mocks.MockAuthService)
+	publisher := services.NewMemoryPublisher(0)
+	server := httptest.NewServer(setupStreamTestRouter(mockWalletService, publisher, mockAuthService))
+	defer server.Close()
+
+	testUser := userGenerator.Generate()
+	mockAuthService.On("Authenticate", testUser.Name).Return(testUser, nil)
+
+	t.Run("should reject invalid last event ID", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", server.URL+"/stream", http.NoBody)
+		req.Header.Set("Authorization", "Bearer "+testUser.Name)
+		req.Header.Set("Last-Event-ID", "invalid")
+
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("should resume and push live updates", func(t *testing.T) {
+		now := time.Now()
+		lastEventID := utils.EncodeCursor(now, 1)
+		resumed := models.Transaction{ID: 1, UserID: testUser.ID, Type: models.Deposit, Amount: decimal.NewFromInt(10), Currency: "USDT", Timestamp: now}
+		missed := models.Transaction{ID: 2, UserID: testUser.ID, Type: models.Deposit, Amount: decimal.NewFromInt(100), Currency: "USDT", Timestamp: now}
+		live := models.Transaction{ID: 3, UserID: testUser.ID, Type: models.Withdrawal, Amount: decimal.NewFromInt(40), Currency: "USDT", Timestamp: now}
+
+		mockWalletService.On("GetTransactionHistory", mock.Anything, testUser.ID, services.TransactionFilter{}, lastEventID, services.SortOrderAsc, 50).
+			Return([]models.Transaction{missed}, utils.EncodeCursor(missed.Timestamp, missed.ID), nil).Once()
+
+		// Balances are fetched once subscribed to the live events
+		subscribed := make(chan struct{})
+		mockWalletService.On("GetBalances", mock.Anything, testUser.ID, []string{"USDT"}).
+			Return([]models.Vault{{UserID: testUser.ID, Currency: "USDT", Amount: decimal.NewFromInt(100)}}, nil).
+			Run(func(mock.Arguments) { close(subscribed) }).Once()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		req, _ := http.NewRequestWithContext(ctx, "GET", server.URL+"/stream?currency=USDT", http.NoBody)
+		req.Header.Set("Authorization", "Bearer "+testUser.Name)
+		req.Header.Set("Last-Event-ID", lastEventID)
+
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+		<-subscribed
+		publish := func(eventType string, payload interface{}) {
+			data, _ := json.Marshal(payload)
+			publisher.Publish(context.Background(), &models.OutboxEvent{UserID: testUser.ID, Type: eventType, Payload: data})
+		}
+		publish(models.EventTransactionCreated, resumed) // Received before reconnecting
+		publish(models.EventTransactionCreated, missed)  // Already replayed
+		publish(models.EventTransactionCreated, live)
+		publish(models.EventBalanceChanged, models.BalanceChange{
+			UserID: testUser.ID, Currency: "USDT", Amount: decimal.NewFromInt(60), TransactionID: live.ID,
+		})
+
+		events := readStreamEvents(bufio.NewScanner(resp.Body), 4)
+		if !assert.Len(t, events, 4) {
+			return
+		}
+
+		assert.Equal(t, "transaction", events[0].Event)
+		assert.Equal(t, utils.EncodeCursor(missed.Timestamp, missed.ID), events[0].ID)
+		assert.Equal(t, "balance", events[1].Event)
+		assert.Contains(t, events[1].Data, `"amount_minor":"100"`)
+
+		assert.Equal(t, "transaction", events[2].Event)
+		assert.Equal(t, utils.EncodeCursor(live.Timestamp, live.ID), events[2].ID)
+		var txn controllers.TransactionResponse
+		assert.NoError(t, json.Unmarshal([]byte(events[2].Data), &txn))
+		assert.Equal(t, live.ID, txn.ID)
+
+		assert.Equal(t, "balance", events[3].Event)
+		assert.Empty(t, events[3].ID)
+		assert.Contains(t, events[3].Data, `"amount_minor":"60"`)
+	})
+
+	mockWalletService.AssertExpectations(t)
+}
@@ -0,0 +1,102 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wanliqun/go-wallet-app/apperrors"
+	"github.com/wanliqun/go-wallet-app/middlewares"
+	"github.com/wanliqun/go-wallet-app/models"
+	"github.com/wanliqun/go-wallet-app/services"
+	"github.com/wanliqun/go-wallet-app/utils"
+)
+
+// TwoFactorCodeHeader is the request header carrying the TOTP or recovery code of step-up authentication
+const TwoFactorCodeHeader = "X-Two-Factor-Code"
+
+type TwoFactorController struct {
+	TwoFactorService services.ITwoFactorService
+}
+
+func NewTwoFactorController(twoFactor services.ITwoFactorService) *TwoFactorController {
+	return &TwoFactorController{TwoFactorService: twoFactor}
+}
+
+// POST /users/me/2fa
+func (ctrl *TwoFactorController) Enroll(c *gin.Context) {
+	user := c.MustGet("user").(*models.User)
+	enrollment, err := ctrl.TwoFactorService.Enroll(c.Request.Context(), user)
+	if err != nil {
+		utils.ErrorResponse(c, apperrors.StatusCode(err), err)
+		return
+	}
+
+	utils.SuccessResponse(c, enrollment)
+}
+
+// POST /users/me/2fa/confirm
+func (ctrl *TwoFactorController) Confirm(c *gin.Context) {
+	var cRequest TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&cRequest); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	user := c.MustGet("user").(*models.User)
+	codes, err := ctrl.TwoFactorService.Confirm(c.Request.Context(), user.ID, cRequest.Code)
+	if err != nil {
+		utils.ErrorResponse(c, apperrors.StatusCode(err), err)
+		return
+	}
+
+	utils.SuccessResponse(c, RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// POST /users/me/2fa/recovery-codes
+func (ctrl *TwoFactorController) RegenerateRecoveryCodes(c *gin.Context) {
+	var cRequest TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&cRequest); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	user := c.MustGet("user").(*models.User)
+	codes, err := ctrl.TwoFactorService.RegenerateRecoveryCodes(c.Request.Context(), user.ID, cRequest.Code)
+	if err != nil {
+		utils.ErrorResponse(c, apperrors.StatusCode(err), err)
+		return
+	}
+
+	utils.SuccessResponse(c, RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// DELETE /users/me/2fa
+func (ctrl *TwoFactorController) Disable(c *gin.Context) {
+	var cRequest TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&cRequest); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	user := c.MustGet("user").(*models.User)
+	if err := ctrl.TwoFactorService.Disable(c.Request.Context(), user.ID, cRequest.Code); err != nil {
+		utils.ErrorResponse(c, apperrors.StatusCode(err), err)
+		return
+	}
+
+	utils.SuccessResponse(c, nil)
+}
+
+// stepUpCheck returns the step-up check of the action with the code supplied in the request header,
+// which the services run within the transaction of the action. Requests authenticated by an API key
+// are exempt, as the backends holding keys can not answer challenges: the scope of the key authorizes
+// its transfers, which stay bounded by the transaction limits of its user. Granting the transfer scope
+// to a key requires step-up authentication instead, so that a stolen access token can not issue one.
+func stepUpCheck(c *gin.Context, twoFactor services.ITwoFactorService, action services.StepUpAction) services.StepUpCheck {
+	if middlewares.APIKey(c) != nil {
+		return nil
+	}
+
+	user := c.MustGet("user").(*models.User)
+	return services.NewStepUpCheck(twoFactor, user, action, c.GetHeader(TwoFactorCodeHeader))
+}
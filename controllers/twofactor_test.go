@@ -0,0 +1,171 @@
+package controllers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/wanliqun/go-wallet-app/controllers"
+	"github.com/wanliqun/go-wallet-app/middlewares"
+	"github.com/wanliqun/go-wallet-app/mocks"
+	"github.com/wanliqun/go-wallet-app/models"
+	"github.com/wanliqun/go-wallet-app/services"
+)
+
+func setupTwoFactorTestRouter(
+	twoFactorService *mocks.MockTwoFactorService, walletService *mocks.MockWalletService,
+	userService *mocks.MockUserService, authService *mocks.MockAuthService) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+
+	router.Use(middlewares.AuthMiddleware(authService, nil))
+
+	twoFactorController := controllers.NewTwoFactorController(twoFactorService)
+	router.POST("/users/me/2fa", twoFactorController.Enroll)
+	router.POST("/users/me/2fa/confirm", twoFactorController.Confirm)
+	router.POST("/users/me/2fa/recovery-codes", twoFactorController.RegenerateRecoveryCodes)
+	router.DELETE("/users/me/2fa", twoFactorController.Disable)
+
+	walletController := controllers.NewWalletController(walletService, userService)
+	walletController.TwoFactor = twoFactorService
+	router.POST("/withdraw", walletController.Withdraw)
+	router.POST("/transfer", walletController.Transfer)
+
+	return router
+}
+
+func TestTwoFactorController(t *testing.T) {
+	mockTwoFactorService := new(mocks.MockTwoFactorService)
+	mockWalletService := new(mocks.MockWalletService)
+	mockUserService := new(mocks.MockUserService)
+	mockAuthService := new(mocks.MockAuthService)
+	router := setupTwoFactorTestRouter(mockTwoFactorService, mockWalletService, mockUserService, mockAuthService)
+
+	testUser := userGenerator.Generate()
+	mockAuthService.On("Authenticate", testUser.Name).Return(testUser, nil)
+
+	serve := func(method, path string, body interface{}, code string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, http.NoBody)
+		if body != nil {
+			data, _ := json.Marshal(body)
+			req, _ = http.NewRequest(method, path, bytes.NewBuffer(data))
+			req.Header.Set("Content-Type", "application/json")
+		}
+		req.Header.Set("Authorization", "Bearer "+testUser.Name)
+		if code != "" {
+			req.Header.Set(controllers.TwoFactorCodeHeader, code)
+		}
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("should enroll with a provisioning URI", func(t *testing.T) {
+		mockTwoFactorService.On("Enroll", mock.Anything, testUser).Return(&services.TOTPEnrollment{
+			Secret:          "JBSWY3DPEHPK3PXP",
+			ProvisioningURI: "otpauth://totp/go-wallet-app:" + testUser.Name + "?secret=JBSWY3DPEHPK3PXP",
+		}, nil).Once()
+
+		w := serve("POST", "/users/me/2fa", nil, "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"provisioning_uri":"otpauth://totp/`)
+	})
+
+	t.Run("should confirm the enrollment with the recovery codes", func(t *testing.T) {
+		mockTwoFactorService.On("Confirm", mock.Anything, testUser.ID, "123456").
+			Return([]string{"abcd-efgh-ijkl-mnop"}, nil).Once()
+
+		w := serve("POST", "/users/me/2fa/confirm", map[string]string{"code": "123456"}, "")
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var resp struct {
+			Data controllers.RecoveryCodesResponse
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		assert.Equal(t, []string{"abcd-efgh-ijkl-mnop"}, resp.Data.RecoveryCodes)
+	})
+
+	t.Run("should refuse to disable with an invalid code", func(t *testing.T) {
+		mockTwoFactorService.On("Disable", mock.Anything, testUser.ID, "000000").Return(services.ErrInvalidTwoFactorCode).Once()
+
+		w := serve("DELETE", "/users/me/2fa", map[string]string{"code": "000000"}, "")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Contains(t, w.Body.String(), "INVALID_TWO_FACTOR_CODE")
+	})
+
+	withdrawal := services.StepUpAction{Operation: models.Withdrawal, Currency: "USDT", Amount: decimal.NewFromInt(100)}
+	matchesAmount := mock.MatchedBy(func(a decimal.Decimal) bool { return a.Equal(withdrawal.Amount) })
+
+	// The step-up check is run by the services within the transaction of the operation
+	var stepUp services.StepUpCheck
+	captureStepUp := func(args mock.Arguments) {
+		stepUp = args.Get(len(args) - 1).(services.StepUpCheck)
+	}
+
+	t.Run("should challenge a withdrawal without a code", func(t *testing.T) {
+		challenge := &services.StepUpRequiredError{
+			Challenge: services.TwoFactorChallenge{Type: "totp", Reasons: []string{services.StepUpWithdrawal}},
+		}
+		mockWalletService.On("Withdraw", mock.Anything, testUser.ID, "USDT", matchesAmount, "", mock.Anything).
+			Run(captureStepUp).Return(nil, challenge).Once()
+
+		w := serve("POST", "/withdraw", map[string]string{"currency": "USDT", "amount": "100"}, "")
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		var resp map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		assert.Equal(t, "TWO_FACTOR_REQUIRED", resp["error"])
+		assert.Equal(t, map[string]interface{}{"type": "totp", "reasons": []interface{}{"withdrawal"}}, resp["details"])
+
+		mockTwoFactorService.On("StepUp", mock.Anything, testUser, mock.MatchedBy(func(a services.StepUpAction) bool {
+			return a.Operation == withdrawal.Operation && a.Amount.Equal(withdrawal.Amount)
+		}), "").Return(challenge).Once()
+		if assert.NotNil(t, stepUp) {
+			assert.Equal(t, challenge, stepUp(nil))
+		}
+	})
+
+	t.Run("should withdraw with the code of the request", func(t *testing.T) {
+		mockWalletService.On("Withdraw", mock.Anything, testUser.ID, "USDT", matchesAmount, "", mock.Anything).
+			Run(captureStepUp).Return(&models.Transaction{}, nil).Once()
+
+		w := serve("POST", "/withdraw", map[string]string{"currency": "USDT", "amount": "100"}, "654321")
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		mockTwoFactorService.On("StepUp", mock.Anything, testUser, mock.Anything, "654321").Return(nil).Once()
+		if assert.NotNil(t, stepUp) {
+			assert.NoError(t, stepUp(nil))
+		}
+	})
+
+	t.Run("should check transfers against the recipient", func(t *testing.T) {
+		recipient := userGenerator.Generate()
+		challenge := &services.StepUpRequiredError{
+			Challenge: services.TwoFactorChallenge{Type: "totp", Reasons: []string{services.StepUpNewRecipient}},
+		}
+		mockUserService.On("GetUserByName", mock.Anything, recipient.Name).Return(recipient, true, nil).Once()
+		mockWalletService.On("Transfer", mock.Anything, testUser.ID, recipient.ID, "USDT", mock.Anything, "", "", mock.Anything).
+			Run(captureStepUp).Return(nil, challenge).Once()
+
+		w := serve("POST", "/transfer", map[string]string{"recipient": recipient.Name, "currency": "USDT", "amount": "1"}, "")
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), "new_recipient")
+
+		mockTwoFactorService.On("StepUp", mock.Anything, testUser, mock.MatchedBy(func(a services.StepUpAction) bool {
+			return a.Operation == models.TransferOut && a.RecipientID == recipient.ID
+		}), "").Return(challenge).Once()
+		if assert.NotNil(t, stepUp) {
+			assert.Equal(t, challenge, stepUp(nil))
+		}
+	})
+
+	mockTwoFactorService.AssertExpectations(t)
+	mockWalletService.AssertExpectations(t)
+}
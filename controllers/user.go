@@ -0,0 +1,136 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wanliqun/go-wallet-app/apperrors"
+	"github.com/wanliqun/go-wallet-app/models"
+	"github.com/wanliqun/go-wallet-app/services"
+	"github.com/wanliqun/go-wallet-app/utils"
+)
+
+type UserController struct {
+	UserService services.IUserService
+}
+
+func NewUserController(user services.IUserService) *UserController {
+	return &UserController{UserService: user}
+}
+
+// POST /users
+func (ctrl *UserController) Register(c *gin.Context) {
+	var cRequest RegisterRequest
+	if err := c.ShouldBindJSON(&cRequest); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	user, err := ctrl.UserService.CreateUser(c.Request.Context(), cRequest.Name, cRequest.Email, cRequest.Password)
+	if err != nil {
+		utils.ErrorResponse(c, apperrors.StatusCode(err), err)
+		return
+	}
+
+	utils.SuccessResponse(c, user)
+}
+
+// GET /users/me
+func (ctrl *UserController) GetMe(c *gin.Context) {
+	user := c.MustGet("user").(*models.User)
+	utils.SuccessResponse(c, user)
+}
+
+// PATCH /users/me
+func (ctrl *UserController) UpdateMe(c *gin.Context) {
+	var cRequest UpdateUserRequest
+	if err := c.ShouldBindJSON(&cRequest); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	currentUser := c.MustGet("user").(*models.User)
+	user, err := ctrl.UserService.UpdateUser(c.Request.Context(), currentUser.ID, services.UserUpdate{
+		Name:     cRequest.Name,
+		Email:    cRequest.Email,
+		Password: cRequest.Password,
+	})
+	if err != nil {
+		utils.ErrorResponse(c, apperrors.StatusCode(err), err)
+		return
+	}
+
+	utils.SuccessResponse(c, user)
+}
+
+// DELETE /users/me
+func (ctrl *UserController) DeleteMe(c *gin.Context) {
+	user := c.MustGet("user").(*models.User)
+	if err := ctrl.UserService.DeleteUser(c.Request.Context(), user.ID); err != nil {
+		utils.ErrorResponse(c, apperrors.StatusCode(err), err)
+		return
+	}
+
+	utils.SuccessResponse(c, nil)
+}
+
+// GET /admin/users/:id
+func (ctrl *UserController) GetUser(c *gin.Context) {
+	var uri UserURI
+	if err := c.ShouldBindUri(&uri); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	user, ok, err := ctrl.UserService.GetUserByID(c.Request.Context(), uri.ID)
+	if err != nil {
+		utils.ErrorResponse(c, apperrors.StatusCode(err), err)
+		return
+	}
+	if !ok {
+		utils.ErrorResponse(c, http.StatusNotFound, services.ErrUserNotFound)
+		return
+	}
+
+	utils.SuccessResponse(c, user)
+}
+
+// PUT /admin/users/:id/role
+func (ctrl *UserController) SetUserRole(c *gin.Context) {
+	var uri UserURI
+	if err := c.ShouldBindUri(&uri); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	var cRequest SetUserRoleRequest
+	if err := c.ShouldBindJSON(&cRequest); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	user, err := ctrl.UserService.SetUserRole(c.Request.Context(), uri.ID, models.Role(cRequest.Role))
+	if err != nil {
+		utils.ErrorResponse(c, apperrors.StatusCode(err), err)
+		return
+	}
+
+	utils.SuccessResponse(c, user)
+}
+
+// POST /admin/service-accounts
+func (ctrl *UserController) CreateServiceAccount(c *gin.Context) {
+	var cRequest CreateServiceAccountRequest
+	if err := c.ShouldBindJSON(&cRequest); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	user, err := ctrl.UserService.CreateServiceAccount(c.Request.Context(), cRequest.Name, models.Role(cRequest.Role))
+	if err != nil {
+		utils.ErrorResponse(c, apperrors.StatusCode(err), err)
+		return
+	}
+
+	utils.SuccessResponse(c, user)
+}
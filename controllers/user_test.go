@@ -0,0 +1,213 @@
+package controllers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/wanliqun/go-wallet-app/controllers"
+	"github.com/wanliqun/go-wallet-app/middlewares"
+	"github.com/wanliqun/go-wallet-app/mocks"
+	"github.com/wanliqun/go-wallet-app/models"
+	"github.com/wanliqun/go-wallet-app/services"
+)
+
+func setupUserTestRouter(userService *mocks.MockUserService, authService *mocks.MockAuthService) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+
+	userController := controllers.NewUserController(userService)
+	router.POST("/users", userController.Register)
+	userRouter := router.Group("/users/me", middlewares.AuthMiddleware(authService, nil))
+	{
+		userRouter.GET("", userController.GetMe)
+		userRouter.PATCH("", userController.UpdateMe)
+		userRouter.DELETE("", userController.DeleteMe)
+	}
+
+	return router
+}
+
+func TestUserController_Register(t *testing.T) {
+	mockUserService := new(mocks.MockUserService)
+	mockAuthService := new(mocks.MockAuthService)
+	router := setupUserTestRouter(mockUserService, mockAuthService)
+
+	testUser := userGenerator.Generate()
+
+	t.Run("should register successfully", func(t *testing.T) {
+		mockUserService.On("CreateUser", mock.Anything, testUser.Name, testUser.Email, "password123").Return(testUser, nil)
+
+		body, _ := json.Marshal(map[string]interface{}{
+			"name": testUser.Name, "email": testUser.Email, "password": "password123",
+		})
+		req, _ := http.NewRequest("POST", "/users", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NotContains(t, w.Body.String(), "password")
+	})
+
+	t.Run("should return conflict for a taken name", func(t *testing.T) {
+		mockUserService.On("CreateUser", mock.Anything, "taken_name", "taken@example.com", "password123").
+			Return(nil, services.ErrUserNameTaken)
+
+		body, _ := json.Marshal(map[string]interface{}{
+			"name": "taken_name", "email": "taken@example.com", "password": "password123",
+		})
+		req, _ := http.NewRequest("POST", "/users", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+	})
+
+	t.Run("should return error for invalid input", func(t *testing.T) {
+		body, _ := json.Marshal(map[string]interface{}{
+			"name": "bad name!", "email": "not-an-email", "password": "short",
+		})
+		req, _ := http.NewRequest("POST", "/users", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		mockUserService.AssertNotCalled(t, "CreateUser", mock.Anything, "bad name!", mock.Anything, mock.Anything)
+	})
+}
+
+func TestUserController_Me(t *testing.T) {
+	mockUserService := new(mocks.MockUserService)
+	mockAuthService := new(mocks.MockAuthService)
+	router := setupUserTestRouter(mockUserService, mockAuthService)
+
+	testUser := userGenerator.Generate()
+	mockAuthService.On("Authenticate", testUser.Name).Return(testUser, nil)
+
+	t.Run("should get the profile", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/users/me", nil)
+		req.Header.Set("Authorization", "Bearer "+testUser.Name)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var resp struct {
+			Data models.User
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		assert.Equal(t, testUser.Name, resp.Data.Name)
+	})
+
+	t.Run("should update the email", func(t *testing.T) {
+		newEmail := "new_email@example.com"
+		updated := *testUser
+		updated.Email = newEmail
+		mockUserService.On("UpdateUser", mock.Anything, testUser.ID, services.UserUpdate{Email: &newEmail}).Return(&updated, nil)
+
+		body, _ := json.Marshal(map[string]interface{}{"email": newEmail})
+		req, _ := http.NewRequest("PATCH", "/users/me", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+testUser.Name)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), newEmail)
+	})
+
+	t.Run("should refuse to delete an account with balances", func(t *testing.T) {
+		mockUserService.On("DeleteUser", mock.Anything, testUser.ID).Return(services.ErrAccountHasBalance)
+
+		req, _ := http.NewRequest("DELETE", "/users/me", nil)
+		req.Header.Set("Authorization", "Bearer "+testUser.Name)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+	})
+}
+
+func TestUserController_Admin(t *testing.T) {
+	mockUserService := new(mocks.MockUserService)
+	mockAuthService := new(mocks.MockAuthService)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+
+	authorizer := services.NewRoleAuthorizer(services.DefaultRolePermissions)
+	userController := controllers.NewUserController(mockUserService)
+	adminRouter := router.Group("/admin", middlewares.AuthMiddleware(mockAuthService, nil))
+	{
+		adminRouter.GET("/users/:id", middlewares.PermissionMiddleware(authorizer, services.PermViewUsers), userController.GetUser)
+		adminRouter.PUT("/users/:id/role", middlewares.PermissionMiddleware(authorizer, services.PermManageRoles), userController.SetUserRole)
+	}
+
+	adminUser := userGenerator.Generate()
+	adminUser.Role = models.RoleAdmin
+	mockAuthService.On("Authenticate", adminUser.Name).Return(adminUser, nil)
+
+	supportUser := userGenerator.Generate()
+	supportUser.Role = models.RoleSupport
+	mockAuthService.On("Authenticate", supportUser.Name).Return(supportUser, nil)
+
+	customer := userGenerator.Generate()
+
+	serve := func(method, path, token string, body interface{}) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, http.NoBody)
+		if body != nil {
+			data, _ := json.Marshal(body)
+			req, _ = http.NewRequest(method, path, bytes.NewBuffer(data))
+			req.Header.Set("Content-Type", "application/json")
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("should let support staff view any user", func(t *testing.T) {
+		mockUserService.On("GetUserByID", mock.Anything, customer.ID).Return(customer, true, nil).Once()
+
+		w := serve("GET", fmt.Sprintf("/admin/users/%d", customer.ID), supportUser.Name, nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"role":"user"`)
+	})
+
+	t.Run("should forbid support staff to change roles", func(t *testing.T) {
+		w := serve("PUT", fmt.Sprintf("/admin/users/%d/role", customer.ID), supportUser.Name, map[string]string{"role": "admin"})
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		mockUserService.AssertNotCalled(t, "SetUserRole", mock.Anything, customer.ID, models.RoleAdmin)
+	})
+
+	t.Run("should let admins change roles", func(t *testing.T) {
+		promoted := *customer
+		promoted.Role = models.RoleFinance
+		mockUserService.On("SetUserRole", mock.Anything, customer.ID, models.RoleFinance).Return(&promoted, nil).Once()
+
+		w := serve("PUT", fmt.Sprintf("/admin/users/%d/role", customer.ID), adminUser.Name, map[string]string{"role": "finance"})
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"role":"finance"`)
+	})
+
+	t.Run("should reject an unknown role", func(t *testing.T) {
+		w := serve("PUT", fmt.Sprintf("/admin/users/%d/role", customer.ID), adminUser.Name, map[string]string{"role": "root"})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
@@ -1,17 +1,29 @@
 package controllers
 
 import (
+	"errors"
+	"io"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/wanliqun/go-wallet-app/apperrors"
 	"github.com/wanliqun/go-wallet-app/models"
 	"github.com/wanliqun/go-wallet-app/services"
 	"github.com/wanliqun/go-wallet-app/utils"
 )
 
+// IdempotencyKeyHeader is the request header carrying the client supplied idempotency key
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// maxIdempotencyKeyLength is the maximum length of a client supplied idempotency key
+const maxIdempotencyKeyLength = 64
+
+var ErrInvalidIdempotencyKey = apperrors.New(apperrors.InvalidIdempotencyKey, "invalid idempotency key")
+
 type WalletController struct {
 	WalletService services.IWalletService
 	UserService   services.IUserService
+	TwoFactor     services.ITwoFactorService // Step-up authentication of withdrawals and transfers, none if nil
 }
 
 func NewWalletController(wallet services.IWalletService, user services.IUserService) *WalletController {
@@ -26,14 +38,21 @@ func (ctrl *WalletController) Deposit(c *gin.Context) {
 		return
 	}
 
+	idempotencyKey, err := getIdempotencyKey(c)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
 	user := c.MustGet("user").(*models.User)
-	err := ctrl.WalletService.Deposit(user.ID, cRequest.Currency, cRequest.Amount)
+	amount := toMinorUnits(cRequest.Currency, cRequest.Amount)
+	transaction, err := ctrl.WalletService.Deposit(c.Request.Context(), user.ID, cRequest.Currency, amount, idempotencyKey)
 	if err != nil {
-		utils.ErrorResponse(c, http.StatusInternalServerError, err)
+		utils.ErrorResponse(c, apperrors.StatusCode(err), err)
 		return
 	}
 
-	utils.SuccessResponse(c, nil)
+	utils.SuccessResponse(c, newTransactionResponse(transaction))
 }
 
 // POST /withdraw
@@ -44,14 +63,25 @@ func (ctrl *WalletController) Withdraw(c *gin.Context) {
 		return
 	}
 
+	idempotencyKey, err := getIdempotencyKey(c)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
 	user := c.MustGet("user").(*models.User)
-	err := ctrl.WalletService.Withdraw(user.ID, cRequest.Currency, cRequest.Amount)
+	amount := toMinorUnits(cRequest.Currency, cRequest.Amount)
+
+	action := services.StepUpAction{Operation: models.Withdrawal, Currency: cRequest.Currency, Amount: amount}
+	stepUp := stepUpCheck(c, ctrl.TwoFactor, action)
+
+	transaction, err := ctrl.WalletService.Withdraw(c.Request.Context(), user.ID, cRequest.Currency, amount, idempotencyKey, stepUp)
 	if err != nil {
-		utils.ErrorResponse(c, http.StatusInternalServerError, err)
+		utils.ErrorResponse(c, apperrors.StatusCode(err), err)
 		return
 	}
 
-	utils.SuccessResponse(c, nil)
+	utils.SuccessResponse(c, newTransactionResponse(transaction))
 }
 
 // POST /transfer
@@ -62,11 +92,17 @@ func (ctrl *WalletController) Transfer(c *gin.Context) {
 		return
 	}
 
+	idempotencyKey, err := getIdempotencyKey(c)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
 	user := c.MustGet("user").(*models.User)
 
-	recipient, ok, err := ctrl.UserService.GetUserByName(cRequest.Recipient)
+	recipient, ok, err := ctrl.UserService.GetUserByName(c.Request.Context(), cRequest.Recipient)
 	if err != nil {
-		utils.ErrorResponse(c, http.StatusInternalServerError, err)
+		utils.ErrorResponse(c, apperrors.StatusCode(err), err)
 		return
 	}
 	if !ok {
@@ -74,12 +110,44 @@ func (ctrl *WalletController) Transfer(c *gin.Context) {
 		return
 	}
 
-	if err := ctrl.WalletService.Transfer(user.ID, recipient.ID, cRequest.Currency, cRequest.Amount, cRequest.Memo); err != nil {
-		utils.ErrorResponse(c, http.StatusInternalServerError, err)
+	amount := toMinorUnits(cRequest.Currency, cRequest.Amount)
+
+	action := services.StepUpAction{
+		Operation: models.TransferOut, Currency: cRequest.Currency, Amount: amount, RecipientID: recipient.ID,
+	}
+	stepUp := stepUpCheck(c, ctrl.TwoFactor, action)
+
+	transaction, err := ctrl.WalletService.Transfer(
+		c.Request.Context(), user.ID, recipient.ID, cRequest.Currency, amount, cRequest.Memo, idempotencyKey, stepUp)
+	if err != nil {
+		utils.ErrorResponse(c, apperrors.StatusCode(err), err)
 		return
 	}
 
-	utils.SuccessResponse(c, nil)
+	utils.SuccessResponse(c, newTransactionResponse(transaction))
+}
+
+// POST /admin/transactions/:id/reverse
+func (ctrl *WalletController) Reverse(c *gin.Context) {
+	var uri TransactionURI
+	if err := c.ShouldBindUri(&uri); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	var cRequest ReverseRequest
+	if err := c.ShouldBindJSON(&cRequest); err != nil && !errors.Is(err, io.EOF) {
+		utils.ErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	reversal, err := ctrl.WalletService.Reverse(c.Request.Context(), uri.ID, cRequest.Memo, cRequest.Force)
+	if err != nil {
+		utils.ErrorResponse(c, apperrors.StatusCode(err), err)
+		return
+	}
+
+	utils.SuccessResponse(c, newTransactionResponse(reversal))
 }
 
 // GET /balances
@@ -91,14 +159,44 @@ func (ctrl *WalletController) GetBalances(c *gin.Context) {
 	}
 
 	user := c.MustGet("user").(*models.User)
+	ctrl.respondBalances(c, user.ID, cRequest.Currencies)
+}
+
+// GET /admin/users/:id/balances
+func (ctrl *WalletController) GetUserBalances(c *gin.Context) {
+	var uri UserURI
+	if err := c.ShouldBindUri(&uri); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
 
-	vaults, err := ctrl.WalletService.GetBalances(user.ID, cRequest.Currencies)
+	var cRequest GetBalancesQuery
+	if err := c.ShouldBindQuery(&cRequest); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if !ctrl.userExists(c, uri.ID) {
+		return
+	}
+
+	ctrl.respondBalances(c, uri.ID, cRequest.Currencies)
+}
+
+// respondBalances responds with the balances of the user in the currencies
+func (ctrl *WalletController) respondBalances(c *gin.Context, userID uint, currencies []string) {
+	vaults, err := ctrl.WalletService.GetBalances(c.Request.Context(), userID, currencies)
 	if err != nil {
-		utils.ErrorResponse(c, http.StatusInternalServerError, err)
+		utils.ErrorResponse(c, apperrors.StatusCode(err), err)
 		return
 	}
 
-	utils.SuccessResponse(c, vaults)
+	balances := make([]BalanceResponse, 0, len(vaults))
+	for _, vault := range vaults {
+		balances = append(balances, newBalanceResponse(vault))
+	}
+
+	utils.SuccessResponse(c, balances)
 }
 
 // GET /transactions
@@ -110,22 +208,165 @@ func (ctrl *WalletController) GetTransactionHistory(c *gin.Context) {
 	}
 
 	user := c.MustGet("user").(*models.User)
+	ctrl.respondTransactionHistory(c, user.ID, &cRequest)
+}
+
+// GET /admin/users/:id/transactions
+func (ctrl *WalletController) GetUserTransactionHistory(c *gin.Context) {
+	var uri UserURI
+	if err := c.ShouldBindUri(&uri); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	var cRequest GetTransactionHistoryQuery
+	if err := c.ShouldBindQuery(&cRequest); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if !ctrl.userExists(c, uri.ID) {
+		return
+	}
+
+	ctrl.respondTransactionHistory(c, uri.ID, &cRequest)
+}
+
+// respondTransactionHistory responds with the page of the filtered transaction history of the user
+func (ctrl *WalletController) respondTransactionHistory(c *gin.Context, userID uint, cRequest *GetTransactionHistoryQuery) {
+	filter, ok := ctrl.transactionFilter(c, &cRequest.TransactionFilterQuery)
+	if !ok {
+		return
+	}
 
-	txnType := models.TransactionType(cRequest.Type)
 	sortOrder := services.SortOrderDesc
 	if cRequest.Order == "asc" {
 		sortOrder = services.SortOrderAsc
 	}
 
-	transactions, nextCursor, err := ctrl.WalletService.GetTransactionHistory(user.ID, txnType, cRequest.Cursor, sortOrder, cRequest.Limit)
+	transactions, nextCursor, err := ctrl.WalletService.GetTransactionHistory(c.Request.Context(), userID, filter, cRequest.Cursor, sortOrder, cRequest.Limit)
 	if err != nil {
-		utils.ErrorResponse(c, http.StatusInternalServerError, err)
+		utils.ErrorResponse(c, apperrors.StatusCode(err), err)
 		return
 	}
 
 	// Return transactions with the next cursor for pagination
-	utils.SuccessResponse(c, GetTransactionHistoryResponse{
-		Transactions: transactions,
+	response := GetTransactionHistoryResponse{
+		Transactions: make([]TransactionResponse, 0, len(transactions)),
 		NextCursor:   nextCursor,
-	})
+	}
+	for i := range transactions {
+		response.Transactions = append(response.Transactions, newTransactionResponse(&transactions[i]))
+	}
+
+	utils.SuccessResponse(c, response)
+}
+
+// GET /transactions/:id
+func (ctrl *WalletController) GetTransaction(c *gin.Context) {
+	var uri TransactionURI
+	if err := c.ShouldBindUri(&uri); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	user := c.MustGet("user").(*models.User)
+
+	transaction, ok, err := ctrl.WalletService.GetTransaction(c.Request.Context(), user.ID, uri.ID)
+	if err != nil {
+		utils.ErrorResponse(c, apperrors.StatusCode(err), err)
+		return
+	}
+	if !ok {
+		utils.ErrorResponse(c, http.StatusNotFound, services.ErrTransactionNotFound)
+		return
+	}
+
+	response := TransactionDetailResponse{TransactionResponse: newTransactionResponse(transaction)}
+
+	counterpart, ok, err := ctrl.WalletService.GetTransferCounterpart(c.Request.Context(), transaction)
+	if err != nil {
+		utils.ErrorResponse(c, apperrors.StatusCode(err), err)
+		return
+	}
+	if ok {
+		counterpartResponse := newTransactionResponse(counterpart)
+		response.Counterpart = &counterpartResponse
+	}
+
+	if response.Counterparty, err = newCounterpartyNames(c.Request.Context(), ctrl.UserService).lookup(transaction.CounterpartyID); err != nil {
+		utils.ErrorResponse(c, apperrors.StatusCode(err), err)
+		return
+	}
+
+	utils.SuccessResponse(c, response)
+}
+
+// transactionFilter converts the filters of the query, resolving the counterparty by name and the
+// amounts to minor units of the currency. It responds with the error if any filter is invalid.
+func (ctrl *WalletController) transactionFilter(
+	c *gin.Context, cRequest *TransactionFilterQuery) (services.TransactionFilter, bool) {
+	filter := services.TransactionFilter{
+		From:     cRequest.From,
+		To:       cRequest.To,
+		Currency: cRequest.Currency,
+		Memo:     cRequest.Memo,
+	}
+	for _, txnType := range cRequest.Types {
+		filter.Types = append(filter.Types, models.TransactionType(txnType))
+	}
+
+	precision := currencyPrecision(cRequest.Currency)
+
+	var err error
+	if filter.MinAmount, err = nullableMinorUnits(cRequest.MinAmount, precision); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err)
+		return filter, false
+	}
+	if filter.MaxAmount, err = nullableMinorUnits(cRequest.MaxAmount, precision); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err)
+		return filter, false
+	}
+	if filter.MinAmount.Valid && filter.MaxAmount.Valid && filter.MinAmount.Decimal.GreaterThan(filter.MaxAmount.Decimal) {
+		utils.ErrorResponse(c, http.StatusBadRequest, apperrors.New(apperrors.InvalidRequest, "min_amount exceeds max_amount"))
+		return filter, false
+	}
+
+	if cRequest.Counterparty != "" {
+		counterparty, ok, err := ctrl.UserService.GetUserByName(c.Request.Context(), cRequest.Counterparty)
+		if err != nil {
+			utils.ErrorResponse(c, apperrors.StatusCode(err), err)
+			return filter, false
+		}
+		if !ok {
+			utils.ErrorResponse(c, http.StatusBadRequest, services.ErrUserNotFound)
+			return filter, false
+		}
+		filter.CounterpartyID = counterparty.ID
+	}
+
+	return filter, true
+}
+
+// userExists responds with 404 Not Found unless the user exists
+func (ctrl *WalletController) userExists(c *gin.Context, userID uint) bool {
+	_, ok, err := ctrl.UserService.GetUserByID(c.Request.Context(), userID)
+	if err != nil {
+		utils.ErrorResponse(c, apperrors.StatusCode(err), err)
+		return false
+	}
+	if !ok {
+		utils.ErrorResponse(c, http.StatusNotFound, services.ErrUserNotFound)
+		return false
+	}
+	return true
+}
+
+// getIdempotencyKey returns the optional idempotency key supplied in the request header
+func getIdempotencyKey(c *gin.Context) (string, error) {
+	key := c.GetHeader(IdempotencyKeyHeader)
+	if len(key) > maxIdempotencyKeyLength {
+		return "", ErrInvalidIdempotencyKey
+	}
+	return key, nil
 }
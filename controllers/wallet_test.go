@@ -3,9 +3,12 @@ package controllers_test
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/shopspring/decimal"
@@ -16,18 +19,20 @@ import (
 	"github.com/wanliqun/go-wallet-app/mocks"
 	"github.com/wanliqun/go-wallet-app/models"
 	"github.com/wanliqun/go-wallet-app/services"
+	"gorm.io/gorm"
 )
 
 var (
 	userGenerator models.FakeUserGenerator
 )
 
-func setupTestRouter(walletService *mocks.MockWalletService, userService *mocks.MockUserService) *gin.Engine {
+func setupTestRouter(
+	walletService *mocks.MockWalletService, userService *mocks.MockUserService, authService *mocks.MockAuthService) *gin.Engine {
 	gin.SetMode(gin.TestMode)
 	router := gin.Default()
 
-	router.Use(middlewares.AuthMiddleware(userService))
 	router.Use(middlewares.CorsMiddleware())
+	router.Use(middlewares.AuthMiddleware(authService, nil))
 
 	walletController := controllers.NewWalletController(walletService, userService)
 	walletRouter := router.Group("/")
@@ -37,6 +42,9 @@ func setupTestRouter(walletService *mocks.MockWalletService, userService *mocks.
 		walletRouter.POST("/transfer", walletController.Transfer)
 		walletRouter.GET("/balances", walletController.GetBalances)
 		walletRouter.GET("/transactions", walletController.GetTransactionHistory)
+		walletRouter.GET("/transactions/export", walletController.ExportTransactions)
+		walletRouter.GET("/transactions/:id", walletController.GetTransaction)
+		walletRouter.POST("/transactions/:id/reverse", walletController.Reverse)
 	}
 
 	return router
@@ -45,8 +53,9 @@ func setupTestRouter(walletService *mocks.MockWalletService, userService *mocks.
 func TestWalletController_Deposit(t *testing.T) {
 	mockWalletService := new(mocks.MockWalletService)
 	mockUserService := new(mocks.MockUserService)
+	mockAuthService := new(mocks.MockAuthService)
 
-	router := setupTestRouter(mockWalletService, mockUserService)
+	router := setupTestRouter(mockWalletService, mockUserService, mockAuthService)
 
 	testUser := userGenerator.Generate()
 	currency := "USDT"
@@ -54,10 +63,10 @@ func TestWalletController_Deposit(t *testing.T) {
 	t.Run("should deposit successfully", func(t *testing.T) {
 		amount := decimal.NewFromFloat(100.0)
 
-		mockUserService.On("GetUserByName", testUser.Name).Return(testUser, true, nil)
-		mockWalletService.On("Deposit", testUser.ID, currency, mock.MatchedBy(func(a decimal.Decimal) bool {
+		mockAuthService.On("Authenticate", testUser.Name).Return(testUser, nil)
+		mockWalletService.On("Deposit", mock.Anything, testUser.ID, currency, mock.MatchedBy(func(a decimal.Decimal) bool {
 			return a.Equal(amount)
-		})).Return(nil)
+		}), "").Return(&models.Transaction{}, nil)
 
 		body, _ := json.Marshal(map[string]interface{}{
 			"currency": currency,
@@ -77,10 +86,10 @@ func TestWalletController_Deposit(t *testing.T) {
 	t.Run("should return error for invalid amount", func(t *testing.T) {
 		amount := decimal.NewFromFloat(-100.0)
 
-		mockUserService.On("GetUserByName", testUser.Name).Return(testUser, true, nil)
-		mockWalletService.On("Deposit", testUser.ID, currency, mock.MatchedBy(func(a decimal.Decimal) bool {
+		mockAuthService.On("Authenticate", testUser.Name).Return(testUser, nil)
+		mockWalletService.On("Deposit", mock.Anything, testUser.ID, currency, mock.MatchedBy(func(a decimal.Decimal) bool {
 			return a.Equal(amount)
-		})).Return(services.ErrInvalidAmount)
+		}), "").Return(nil, services.ErrInvalidAmount)
 
 		body, _ := json.Marshal(map[string]interface{}{
 			"currency": currency,
@@ -98,14 +107,161 @@ func TestWalletController_Deposit(t *testing.T) {
 
 		var resp map[string]interface{}
 		json.Unmarshal(w.Body.Bytes(), &resp)
-		assert.Contains(t, resp["message"], "Field validation for 'Amount' failed on the 'positive_decimal' tag")
+		assert.Equal(t, "VALIDATION_FAILED", resp["error"])
+		assert.Equal(t, "amount failed on the 'positive_decimal' rule", resp["message"])
+		assert.Equal(t, []interface{}{map[string]interface{}{
+			"field":   "amount",
+			"rule":    "positive_decimal",
+			"message": "amount failed on the 'positive_decimal' rule",
+		}}, resp["details"])
+	})
+}
+
+func TestWalletController_DepositIdempotency(t *testing.T) {
+	mockWalletService := new(mocks.MockWalletService)
+	mockUserService := new(mocks.MockUserService)
+	mockAuthService := new(mocks.MockAuthService)
+
+	router := setupTestRouter(mockWalletService, mockUserService, mockAuthService)
+
+	testUser := userGenerator.Generate()
+	currency := "USDT"
+	amount := decimal.NewFromFloat(100.0)
+
+	mockAuthService.On("Authenticate", testUser.Name).Return(testUser, nil)
+
+	newRequest := func(key string) *http.Request {
+		body, _ := json.Marshal(map[string]interface{}{
+			"currency": currency,
+			"amount":   amount.String(),
+		})
+		req, _ := http.NewRequest("POST", "/deposit", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+testUser.Name)
+		req.Header.Set(controllers.IdempotencyKeyHeader, key)
+		return req
+	}
+
+	t.Run("should pass the idempotency key to the service", func(t *testing.T) {
+		mockWalletService.On("Deposit", mock.Anything, testUser.ID, currency, mock.Anything, "key-1").
+			Return(&models.Transaction{ID: 1}, nil)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, newRequest("key-1"))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		mockWalletService.AssertCalled(t, "Deposit", mock.Anything, testUser.ID, currency, mock.Anything, "key-1")
+	})
+
+	t.Run("should return conflict for a reused key", func(t *testing.T) {
+		mockWalletService.On("Deposit", mock.Anything, testUser.ID, currency, mock.Anything, "key-2").
+			Return(nil, services.ErrIdempotencyKeyConflict)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, newRequest("key-2"))
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+	})
+
+	t.Run("should reject an oversized key", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, newRequest(strings.Repeat("k", 65)))
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+func TestWalletController_DepositCurrencyRegistry(t *testing.T) {
+	mockWalletService := new(mocks.MockWalletService)
+	mockUserService := new(mocks.MockUserService)
+	mockAuthService := new(mocks.MockAuthService)
+	router := setupTestRouter(mockWalletService, mockUserService, mockAuthService)
+
+	mockCurrencyService := new(mocks.MockCurrencyService)
+	mockCurrencyService.On("LookupCurrency", "USDT").Return(models.Currency{
+		Code: "USDT", Name: "Tether", Precision: 6, Enabled: true, MaxAmount: decimal.NewFromInt(1000_000000),
+	}, true)
+	mockCurrencyService.On("LookupCurrency", "DOGE").Return(models.Currency{
+		Code: "DOGE", Name: "Dogecoin", Precision: 8, Enabled: false,
+	}, true)
+
+	controllers.SetCurrencyRegistry(mockCurrencyService)
+	defer controllers.SetCurrencyRegistry(nil)
+
+	testUser := userGenerator.Generate()
+	currency := "USDT"
+
+	mockAuthService.On("Authenticate", testUser.Name).Return(testUser, nil)
+
+	newRequest := func(currency, amount string) *http.Request {
+		body, _ := json.Marshal(map[string]interface{}{
+			"currency": currency,
+			"amount":   amount,
+		})
+		req, _ := http.NewRequest("POST", "/deposit", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+testUser.Name)
+		return req
+	}
+
+	t.Run("should convert the amount to minor units", func(t *testing.T) {
+		minorAmount := decimal.NewFromInt(1500000)
+		mockWalletService.On("Deposit", mock.Anything, testUser.ID, currency, mock.MatchedBy(func(a decimal.Decimal) bool {
+			return a.Equal(minorAmount)
+		}), "").Return(&models.Transaction{Type: models.Deposit, Currency: currency, Amount: minorAmount}, nil)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, newRequest(currency, "1.5"))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var resp struct {
+			Data controllers.TransactionResponse
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		assert.Equal(t, "1.500000", resp.Data.Amount)
+		assert.True(t, minorAmount.Equal(resp.Data.AmountMinor))
+	})
+
+	t.Run("should reject amounts more precise than the currency", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, newRequest(currency, "1.0000001"))
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		var resp map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		assert.Contains(t, resp["message"], "amount failed on the 'precision' rule")
+	})
+
+	t.Run("should reject amounts above the currency maximum", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, newRequest(currency, "1000.000001"))
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		var resp map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		assert.Contains(t, resp["message"], "amount failed on the 'max_amount=1000.000000' rule")
+	})
+
+	t.Run("should reject disabled currencies", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, newRequest("DOGE", "1"))
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		var resp map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		assert.Contains(t, resp["message"], "currency failed on the 'currency' rule")
 	})
 }
 
 func TestWalletController_Withdraw(t *testing.T) {
 	mockWalletService := new(mocks.MockWalletService)
 	mockUserService := new(mocks.MockUserService)
-	router := setupTestRouter(mockWalletService, mockUserService)
+	mockAuthService := new(mocks.MockAuthService)
+	router := setupTestRouter(mockWalletService, mockUserService, mockAuthService)
 
 	testUser := userGenerator.Generate()
 	currency := "USDT"
@@ -113,10 +269,10 @@ func TestWalletController_Withdraw(t *testing.T) {
 	t.Run("should withdraw successfully", func(t *testing.T) {
 		amount := decimal.NewFromFloat(100.0)
 
-		mockUserService.On("GetUserByName", testUser.Name).Return(testUser, true, nil)
-		mockWalletService.On("Withdraw", testUser.ID, currency, mock.MatchedBy(func(a decimal.Decimal) bool {
+		mockAuthService.On("Authenticate", testUser.Name).Return(testUser, nil)
+		mockWalletService.On("Withdraw", mock.Anything, testUser.ID, currency, mock.MatchedBy(func(a decimal.Decimal) bool {
 			return a.Equal(amount)
-		})).Return(nil)
+		}), "", mock.Anything).Return(&models.Transaction{}, nil)
 
 		body, _ := json.Marshal(map[string]interface{}{
 			"currency": currency,
@@ -136,10 +292,10 @@ func TestWalletController_Withdraw(t *testing.T) {
 	t.Run("should return error for insufficient balance", func(t *testing.T) {
 		amount := decimal.NewFromFloat(200.0)
 
-		mockUserService.On("GetUserByName", testUser.Name).Return(testUser, true, nil)
-		mockWalletService.On("Withdraw", testUser.ID, currency, mock.MatchedBy(func(a decimal.Decimal) bool {
+		mockAuthService.On("Authenticate", testUser.Name).Return(testUser, nil)
+		mockWalletService.On("Withdraw", mock.Anything, testUser.ID, currency, mock.MatchedBy(func(a decimal.Decimal) bool {
 			return a.Equal(amount)
-		})).Return(services.ErrInsufficientBalance)
+		}), "", mock.Anything).Return(nil, services.ErrInsufficientBalance)
 
 		body, _ := json.Marshal(map[string]interface{}{
 			"currency": currency,
@@ -152,19 +308,52 @@ func TestWalletController_Withdraw(t *testing.T) {
 		w := httptest.NewRecorder()
 		router.ServeHTTP(w, req)
 
-		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
 		t.Logf("Response Body: %s", w.Body.String())
 
 		var resp map[string]interface{}
 		json.Unmarshal(w.Body.Bytes(), &resp)
+		assert.Equal(t, "INSUFFICIENT_BALANCE", resp["error"])
 		assert.Contains(t, resp["message"], services.ErrInsufficientBalance.Error())
 	})
+
+	t.Run("should return 422 if a limit is exceeded", func(t *testing.T) {
+		amount := decimal.NewFromFloat(300.0)
+
+		mockAuthService.On("Authenticate", testUser.Name).Return(testUser, nil)
+		mockWalletService.On("Withdraw", mock.Anything, testUser.ID, currency, mock.MatchedBy(func(a decimal.Decimal) bool {
+			return a.Equal(amount)
+		}), "", mock.Anything).Return(nil, &services.LimitExceededError{
+			Period:    services.DailyLimit,
+			Currency:  currency,
+			Limit:     decimal.NewFromInt(1000),
+			Remaining: decimal.NewFromInt(250),
+		})
+
+		body, _ := json.Marshal(map[string]interface{}{
+			"currency": currency,
+			"amount":   amount.String(),
+		})
+		req, _ := http.NewRequest("POST", "/withdraw", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+testUser.Name)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+		var resp map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		assert.Equal(t, "daily limit of 1000 USDT exceeded, remaining allowance is 250 USDT", resp["message"])
+	})
 }
 
 func TestWalletController_Transfer(t *testing.T) {
 	mockWalletService := new(mocks.MockWalletService)
 	mockUserService := new(mocks.MockUserService)
-	router := setupTestRouter(mockWalletService, mockUserService)
+	mockAuthService := new(mocks.MockAuthService)
+	router := setupTestRouter(mockWalletService, mockUserService, mockAuthService)
 
 	sender := userGenerator.Generate()
 	recipient := userGenerator.Generate()
@@ -174,11 +363,11 @@ func TestWalletController_Transfer(t *testing.T) {
 	t.Run("should transfer successfully", func(t *testing.T) {
 		amount := decimal.NewFromFloat(30.0)
 
-		mockUserService.On("GetUserByName", sender.Name).Return(sender, true, nil)
-		mockUserService.On("GetUserByName", recipient.Name).Return(recipient, true, nil)
-		mockWalletService.On("Transfer", sender.ID, recipient.ID, currency, mock.MatchedBy(func(a decimal.Decimal) bool {
+		mockAuthService.On("Authenticate", sender.Name).Return(sender, nil)
+		mockUserService.On("GetUserByName", mock.Anything, recipient.Name).Return(recipient, true, nil)
+		mockWalletService.On("Transfer", mock.Anything, sender.ID, recipient.ID, currency, mock.MatchedBy(func(a decimal.Decimal) bool {
 			return a.Equal(amount)
-		}), memo).Return(nil)
+		}), memo, "", mock.Anything).Return(&models.Transaction{}, nil)
 
 		reqBody, _ := json.Marshal(map[string]interface{}{
 			"recipient": recipient.Name, "currency": currency, "amount": amount.String(), "memo": memo,
@@ -197,11 +386,11 @@ func TestWalletController_Transfer(t *testing.T) {
 	t.Run("should return error for insufficient balance", func(t *testing.T) {
 		amount := decimal.NewFromFloat(100.0)
 
-		mockUserService.On("GetUserByName", sender.Name).Return(sender, true, nil)
-		mockUserService.On("GetUserByName", recipient.Name).Return(recipient, true, nil)
-		mockWalletService.On("Transfer", sender.ID, recipient.ID, currency, mock.MatchedBy(func(a decimal.Decimal) bool {
+		mockAuthService.On("Authenticate", sender.Name).Return(sender, nil)
+		mockUserService.On("GetUserByName", mock.Anything, recipient.Name).Return(recipient, true, nil)
+		mockWalletService.On("Transfer", mock.Anything, sender.ID, recipient.ID, currency, mock.MatchedBy(func(a decimal.Decimal) bool {
 			return a.Equal(amount)
-		}), memo).Return(services.ErrInsufficientBalance)
+		}), memo, "", mock.Anything).Return(nil, services.ErrInsufficientBalance)
 
 		reqBody, _ := json.Marshal(map[string]interface{}{
 			"recipient": recipient.Name, "currency": currency, "amount": amount.String(), "memo": memo,
@@ -213,11 +402,12 @@ func TestWalletController_Transfer(t *testing.T) {
 		w := httptest.NewRecorder()
 		router.ServeHTTP(w, req)
 
-		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
 		t.Logf("Response Body: %s", w.Body.String())
 
 		var resp map[string]interface{}
 		json.Unmarshal(w.Body.Bytes(), &resp)
+		assert.Equal(t, "INSUFFICIENT_BALANCE", resp["error"])
 		assert.Contains(t, resp["message"], services.ErrInsufficientBalance.Error())
 	})
 }
@@ -225,7 +415,8 @@ func TestWalletController_Transfer(t *testing.T) {
 func TestWalletController_Transfer_GetBalances(t *testing.T) {
 	mockWalletService := new(mocks.MockWalletService)
 	mockUserService := new(mocks.MockUserService)
-	router := setupTestRouter(mockWalletService, mockUserService)
+	mockAuthService := new(mocks.MockAuthService)
+	router := setupTestRouter(mockWalletService, mockUserService, mockAuthService)
 
 	testUser := userGenerator.Generate()
 	currencies := []string{"USDT", "BTC"}
@@ -236,8 +427,8 @@ func TestWalletController_Transfer_GetBalances(t *testing.T) {
 			{UserID: testUser.ID, Currency: "BTC", Amount: decimal.NewFromFloat(1)},
 		}
 
-		mockUserService.On("GetUserByName", testUser.Name).Return(testUser, true, nil)
-		mockWalletService.On("GetBalances", testUser.ID, currencies).Return(expectedVaults, nil)
+		mockAuthService.On("Authenticate", testUser.Name).Return(testUser, nil)
+		mockWalletService.On("GetBalances", mock.Anything, testUser.ID, currencies).Return(expectedVaults, nil)
 
 		req, _ := http.NewRequest("GET", "/balances?currency=USDT&currency=BTC", nil)
 		req.Header.Set("Authorization", "Bearer "+testUser.Name)
@@ -248,21 +439,25 @@ func TestWalletController_Transfer_GetBalances(t *testing.T) {
 		assert.Equal(t, http.StatusOK, w.Code)
 		t.Logf("Response Body: %s", w.Body.String())
 
-		var resp map[string]interface{}
+		var resp struct {
+			Data []controllers.BalanceResponse
+		}
 		json.Unmarshal(w.Body.Bytes(), &resp)
-		assert.Equal(t, len(expectedVaults), len(resp["data"].([]interface{})))
+		assert.Equal(t, len(expectedVaults), len(resp.Data))
+		assert.Equal(t, "100", resp.Data[0].Amount)
 	})
 }
 
 func TestWalletController_GetTransactions(t *testing.T) {
 	mockWalletService := new(mocks.MockWalletService)
 	mockUserService := new(mocks.MockUserService)
-	router := setupTestRouter(mockWalletService, mockUserService)
+	mockAuthService := new(mocks.MockAuthService)
+	router := setupTestRouter(mockWalletService, mockUserService, mockAuthService)
 
 	testUser := userGenerator.Generate()
 
 	t.Run("should get transactions successfully", func(t *testing.T) {
-		txnType := models.Deposit
+		filter := services.TransactionFilter{Types: []models.TransactionType{models.Deposit}}
 		cursor := "cursor"
 		expectedCursor := "next_cursor"
 		expectedTransactions := []models.Transaction{
@@ -270,8 +465,8 @@ func TestWalletController_GetTransactions(t *testing.T) {
 			{UserID: testUser.ID, Type: "withdraw", Currency: "BTC", Amount: decimal.NewFromFloat(1)},
 		}
 
-		mockUserService.On("GetUserByName", testUser.Name).Return(testUser, true, nil)
-		mockWalletService.On("GetTransactionHistory", testUser.ID, txnType, cursor, services.SortOrderDesc, 10).
+		mockAuthService.On("Authenticate", testUser.Name).Return(testUser, nil)
+		mockWalletService.On("GetTransactionHistory", mock.Anything, testUser.ID, filter, cursor, services.SortOrderDesc, 10).
 			Return(expectedTransactions, expectedCursor, nil)
 
 		req, _ := http.NewRequest("GET", "/transactions?type=deposit&cursor=cursor&order=desc&limit=10", nil)
@@ -292,4 +487,458 @@ func TestWalletController_GetTransactions(t *testing.T) {
 		assert.Equal(t, expectedCursor, resp.Data.NextCursor)
 		assert.Equal(t, len(expectedTransactions), len(resp.Data.Transactions))
 	})
+	t.Run("should refuse malformed cursors with 400", func(t *testing.T) {
+		mockWalletService.On("GetTransactionHistory", mock.Anything, testUser.ID, services.TransactionFilter{}, "malformed", services.SortOrderDesc, 10).
+			Return([]models.Transaction(nil), "", services.ErrInvalidCursor)
+
+		req, _ := http.NewRequest("GET", "/transactions?cursor=malformed&order=desc&limit=10", nil)
+		req.Header.Set("Authorization", "Bearer "+testUser.Name)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "INVALID_REQUEST")
+	})
+}
+
+func TestWalletController_Reverse(t *testing.T) {
+	mockWalletService := new(mocks.MockWalletService)
+	mockUserService := new(mocks.MockUserService)
+	mockAuthService := new(mocks.MockAuthService)
+	router := setupTestRouter(mockWalletService, mockUserService, mockAuthService)
+
+	testUser := userGenerator.Generate()
+	mockAuthService.On("Authenticate", testUser.Name).Return(testUser, nil)
+
+	t.Run("should reverse successfully", func(t *testing.T) {
+		originalID := uint(1)
+		mockWalletService.On("Reverse", mock.Anything, originalID, "refund", false).Return(&models.Transaction{
+			UserID:                testUser.ID,
+			Type:                  models.ReversalIn,
+			Currency:              "USDT",
+			Amount:                decimal.NewFromInt(100),
+			OriginalTransactionID: &originalID,
+		}, nil)
+
+		body, _ := json.Marshal(map[string]interface{}{"memo": "refund"})
+		req, _ := http.NewRequest("POST", "/transactions/1/reverse", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+testUser.Name)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var resp struct {
+			Data controllers.TransactionResponse
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		assert.Equal(t, models.ReversalIn, resp.Data.Type)
+		if assert.NotNil(t, resp.Data.OriginalTransactionID) {
+			assert.Equal(t, originalID, *resp.Data.OriginalTransactionID)
+		}
+	})
+
+	t.Run("should return error for reversed transaction", func(t *testing.T) {
+		mockWalletService.On("Reverse", mock.Anything, uint(2), "", true).Return(nil, services.ErrAlreadyReversed)
+
+		body, _ := json.Marshal(map[string]interface{}{"force": true})
+		req, _ := http.NewRequest("POST", "/transactions/2/reverse", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+testUser.Name)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+	})
+
+	t.Run("should return error for unknown transaction", func(t *testing.T) {
+		mockWalletService.On("Reverse", mock.Anything, uint(3), "", false).Return(nil, services.ErrTransactionNotFound)
+
+		req, _ := http.NewRequest("POST", "/transactions/3/reverse", http.NoBody)
+		req.Header.Set("Authorization", "Bearer "+testUser.Name)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
+
+func TestWalletController_GetTransactionsFilters(t *testing.T) {
+	mockWalletService := new(mocks.MockWalletService)
+	mockUserService := new(mocks.MockUserService)
+	mockAuthService := new(mocks.MockAuthService)
+	router := setupTestRouter(mockWalletService, mockUserService, mockAuthService)
+
+	testUser := userGenerator.Generate()
+	mockAuthService.On("Authenticate", testUser.Name).Return(testUser, nil)
+
+	counterparty := userGenerator.Generate()
+	counterparty.ID = testUser.ID + 1
+	mockUserService.On("GetUserByName", mock.Anything, counterparty.Name).Return(counterparty, true, nil)
+
+	serve := func(query string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/transactions?"+query, http.NoBody)
+		req.Header.Set("Authorization", "Bearer "+testUser.Name)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("should compose all filters", func(t *testing.T) {
+		from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		to := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+		filter := services.TransactionFilter{
+			Types:          []models.TransactionType{models.TransferOut, models.TransferIn},
+			From:           from,
+			To:             to,
+			Currency:       "USDT",
+			CounterpartyID: counterparty.ID,
+			MinAmount:      decimal.NewNullDecimal(decimal.NewFromInt(10)),
+			MaxAmount:      decimal.NewNullDecimal(decimal.NewFromInt(100)),
+			Memo:           "rent",
+		}
+		mockWalletService.On("GetTransactionHistory", mock.Anything, testUser.ID, mock.MatchedBy(func(f services.TransactionFilter) bool {
+			return f.From.Equal(from) && f.To.Equal(to) && f.MinAmount.Decimal.Equal(filter.MinAmount.Decimal) &&
+				f.MaxAmount.Decimal.Equal(filter.MaxAmount.Decimal) && f.Currency == filter.Currency &&
+				f.CounterpartyID == filter.CounterpartyID && f.Memo == filter.Memo && assert.ObjectsAreEqual(filter.Types, f.Types)
+		}), "", services.SortOrderDesc, 0).Return([]models.Transaction{}, "", nil).Once()
+
+		w := serve("type=transfer_out&type=transfer_in&from=2024-01-01T00:00:00Z&to=2024-02-01T00:00:00Z" +
+			"&currency=USDT&counterparty=" + counterparty.Name + "&min_amount=10&max_amount=100&memo=rent")
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	})
+
+	t.Run("should reject invalid filters", func(t *testing.T) {
+		for _, query := range []string{
+			"type=unknown",
+			"from=2024-02-01T00:00:00Z&to=2024-01-01T00:00:00Z",
+			"from=yesterday",
+			"min_amount=10",
+			"currency=USDT&min_amount=100&max_amount=10",
+			"currency=USDT&min_amount=-1",
+		} {
+			w := serve(query)
+			assert.Equal(t, http.StatusBadRequest, w.Code, query)
+		}
+	})
+
+	t.Run("should reject unknown counterparty", func(t *testing.T) {
+		mockUserService.On("GetUserByName", mock.Anything, "nobody").Return((*models.User)(nil), false, nil).Once()
+
+		w := serve("counterparty=nobody")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+func TestWalletController_GetTransaction(t *testing.T) {
+	mockWalletService := new(mocks.MockWalletService)
+	mockUserService := new(mocks.MockUserService)
+	mockAuthService := new(mocks.MockAuthService)
+	router := setupTestRouter(mockWalletService, mockUserService, mockAuthService)
+
+	testUser := userGenerator.Generate()
+	mockAuthService.On("Authenticate", testUser.Name).Return(testUser, nil)
+
+	counterparty := userGenerator.Generate()
+	counterparty.ID = testUser.ID + 1
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, http.NoBody)
+		req.Header.Set("Authorization", "Bearer "+testUser.Name)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("should return a transfer with its counterpart", func(t *testing.T) {
+		transferOut := &models.Transaction{
+			UserID: testUser.ID, CounterpartyID: &counterparty.ID, Type: models.TransferOut,
+			Amount: decimal.NewFromInt(10), Currency: "USDT", Reference: "ref",
+		}
+		transferOut.ID = 1
+		transferIn := &models.Transaction{
+			UserID: counterparty.ID, CounterpartyID: &testUser.ID, Type: models.TransferIn,
+			Amount: decimal.NewFromInt(10), Currency: "USDT", Reference: "ref",
+		}
+		transferIn.ID = 2
+
+		mockWalletService.On("GetTransaction", mock.Anything, testUser.ID, uint(1)).Return(transferOut, true, nil).Once()
+		mockWalletService.On("GetTransferCounterpart", mock.Anything, transferOut).Return(transferIn, true, nil).Once()
+		mockUserService.On("GetUserByID", mock.Anything, counterparty.ID).Return(counterparty, true, nil).Once()
+
+		w := serve("/transactions/1")
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		var resp struct {
+			Data controllers.TransactionDetailResponse
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		assert.Equal(t, uint(1), resp.Data.ID)
+		assert.Equal(t, "ref", resp.Data.Reference)
+		assert.Equal(t, counterparty.Name, resp.Data.Counterparty)
+		if assert.NotNil(t, resp.Data.Counterpart) {
+			assert.Equal(t, uint(2), resp.Data.Counterpart.ID)
+			assert.Equal(t, models.TransferIn, resp.Data.Counterpart.Type)
+		}
+	})
+
+	t.Run("should return a deposit without counterpart", func(t *testing.T) {
+		deposit := &models.Transaction{UserID: testUser.ID, Type: models.Deposit, Amount: decimal.NewFromInt(10), Currency: "USDT"}
+		deposit.ID = 3
+
+		mockWalletService.On("GetTransaction", mock.Anything, testUser.ID, uint(3)).Return(deposit, true, nil).Once()
+		mockWalletService.On("GetTransferCounterpart", mock.Anything, deposit).Return(nil, false, nil).Once()
+
+		w := serve("/transactions/3")
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.NotContains(t, w.Body.String(), `"counterpart":`)
+	})
+
+	t.Run("should not find transactions of other users", func(t *testing.T) {
+		mockWalletService.On("GetTransaction", mock.Anything, testUser.ID, uint(4)).Return(nil, false, nil).Once()
+
+		w := serve("/transactions/4")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("should reject invalid IDs", func(t *testing.T) {
+		w := serve("/transactions/abc")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+func TestWalletController_AdminViews(t *testing.T) {
+	mockWalletService := new(mocks.MockWalletService)
+	mockUserService := new(mocks.MockUserService)
+	mockAuthService := new(mocks.MockAuthService)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+
+	authorizer := services.NewRoleAuthorizer(services.DefaultRolePermissions)
+	walletController := controllers.NewWalletController(mockWalletService, mockUserService)
+	adminRouter := router.Group("/admin", middlewares.AuthMiddleware(mockAuthService, nil))
+	{
+		adminRouter.GET("/users/:id/balances",
+			middlewares.PermissionMiddleware(authorizer, services.PermViewBalances), walletController.GetUserBalances)
+		adminRouter.GET("/users/:id/transactions",
+			middlewares.PermissionMiddleware(authorizer, services.PermViewTransactions), walletController.GetUserTransactionHistory)
+	}
+
+	supportUser := userGenerator.Generate()
+	supportUser.Role = models.RoleSupport
+	mockAuthService.On("Authenticate", supportUser.Name).Return(supportUser, nil)
+
+	normalUser := userGenerator.Generate()
+	mockAuthService.On("Authenticate", normalUser.Name).Return(normalUser, nil)
+
+	customer := userGenerator.Generate()
+	mockUserService.On("GetUserByID", mock.Anything, customer.ID).Return(customer, true, nil)
+
+	serve := func(path, token string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("should let support staff view the balances of any user", func(t *testing.T) {
+		mockWalletService.On("GetBalances", mock.Anything, customer.ID, []string{"USDT"}).Return([]models.Vault{
+			{UserID: customer.ID, Currency: "USDT", Amount: decimal.NewFromInt(100)},
+		}, nil).Once()
+
+		w := serve(fmt.Sprintf("/admin/users/%d/balances?currency=USDT", customer.ID), supportUser.Name)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var resp struct {
+			Data []controllers.BalanceResponse
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		assert.Len(t, resp.Data, 1)
+	})
+
+	t.Run("should let support staff view the history of any user", func(t *testing.T) {
+		mockWalletService.On("GetTransactionHistory", mock.Anything, customer.ID, services.TransactionFilter{}, "", services.SortOrderDesc, 0).
+			Return([]models.Transaction{{UserID: customer.ID, Type: models.Deposit, Currency: "USDT"}}, "", nil).Once()
+
+		w := serve(fmt.Sprintf("/admin/users/%d/transactions", customer.ID), supportUser.Name)
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("should return not found for an unknown user", func(t *testing.T) {
+		mockUserService.On("GetUserByID", mock.Anything, uint(999999)).Return(nil, false, nil).Once()
+
+		w := serve("/admin/users/999999/balances?currency=USDT", supportUser.Name)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("should forbid users without the permission", func(t *testing.T) {
+		w := serve(fmt.Sprintf("/admin/users/%d/balances?currency=USDT", customer.ID), normalUser.Name)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		var resp map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		assert.Equal(t, "PERMISSION_DENIED", resp["error"])
+	})
+}
+
+func TestWalletController_RateLimit(t *testing.T) {
+	mockWalletService := new(mocks.MockWalletService)
+	mockUserService := new(mocks.MockUserService)
+	mockAuthService := new(mocks.MockAuthService)
+	mockAPIKeyService := new(mocks.MockAPIKeyService)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+
+	store := services.NewMemoryRateLimitStore()
+	router.Use(middlewares.RateLimitMiddleware(store, "ips", services.RateBudget{Limit: 10, Period: time.Minute}))
+	limitCredentials := middlewares.RateLimitMiddleware(store, "credentials", services.RateBudget{Limit: 2, Period: time.Minute})
+	limitReads := middlewares.RateLimitMiddleware(store, "reads", services.RateBudget{Limit: 5, Period: time.Minute})
+	limitWrites := middlewares.RateLimitMiddleware(store, "writes", services.RateBudget{Limit: 1, Period: time.Minute})
+
+	walletController := controllers.NewWalletController(mockWalletService, mockUserService)
+	router.POST("/users", limitCredentials, func(c *gin.Context) { c.Status(http.StatusOK) })
+	walletRouter := router.Group("/", middlewares.AuthMiddleware(mockAuthService, mockAPIKeyService))
+	{
+		walletRouter.GET("/balances", limitReads, walletController.GetBalances)
+		walletRouter.POST("/withdraw", limitWrites, walletController.Withdraw)
+	}
+
+	testUser := userGenerator.Generate()
+	mockAuthService.On("Authenticate", testUser.Name).Return(testUser, nil)
+	otherUser := userGenerator.Generate()
+	mockAuthService.On("Authenticate", otherUser.Name).Return(otherUser, nil)
+	neighbourUser := userGenerator.Generate()
+	mockAuthService.On("Authenticate", neighbourUser.Name).Return(neighbourUser, nil)
+	mockAuthService.On("Authenticate", "invalid").Return(nil, services.ErrInvalidToken)
+
+	keyUser := userGenerator.Generate()
+	const (
+		firstKey  = "gwk_000000000001_first"
+		secondKey = "gwk_000000000002_second"
+	)
+	mockAPIKeyService.On("Authenticate", mock.Anything, firstKey).
+		Return(&models.APIKey{Model: gorm.Model{ID: 1}, UserID: keyUser.ID}, keyUser, nil)
+	mockAPIKeyService.On("Authenticate", mock.Anything, secondKey).
+		Return(&models.APIKey{Model: gorm.Model{ID: 2}, UserID: keyUser.ID}, keyUser, nil)
+
+	serve := func(method, path, ip, token string, body interface{}) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(body)
+		req, _ := http.NewRequest(method, path, bytes.NewBuffer(data))
+		req.RemoteAddr = ip + ":40000"
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	withdrawal := map[string]string{"currency": "USDT", "amount": "1"}
+
+	t.Run("should refuse money-moving writes beyond their budget", func(t *testing.T) {
+		mockWalletService.On("Withdraw", mock.Anything, testUser.ID, "USDT", mock.Anything, "", mock.Anything).
+			Return(&models.Transaction{}, nil).Once()
+
+		w := serve("POST", "/withdraw", "10.0.0.1", testUser.Name, withdrawal)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "1", w.Header().Get(middlewares.RateLimitLimitHeader))
+		assert.Equal(t, "0", w.Header().Get(middlewares.RateLimitRemainingHeader))
+		assert.Equal(t, "60", w.Header().Get(middlewares.RateLimitResetHeader))
+		assert.Equal(t, "1;w=60", w.Header().Get(middlewares.RateLimitPolicyHeader))
+
+		w = serve("POST", "/withdraw", "10.0.0.1", testUser.Name, withdrawal)
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Contains(t, w.Body.String(), "TOO_MANY_REQUESTS")
+		assert.Equal(t, "60", w.Header().Get(middlewares.RetryAfterHeader))
+		mockWalletService.AssertNumberOfCalls(t, "Withdraw", 1)
+	})
+
+	t.Run("should keep separate budgets per client and for reads", func(t *testing.T) {
+		mockWalletService.On("GetBalances", mock.Anything, testUser.ID, []string{"USDT"}).Return([]models.Vault{}, nil).Once()
+
+		w := serve("GET", "/balances?currency=USDT", "10.0.0.1", testUser.Name, nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "4", w.Header().Get(middlewares.RateLimitRemainingHeader))
+
+		mockWalletService.On("Withdraw", mock.Anything, otherUser.ID, "USDT", mock.Anything, "", mock.Anything).
+			Return(&models.Transaction{}, nil).Once()
+
+		w = serve("POST", "/withdraw", "10.0.0.2", otherUser.Name, withdrawal)
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("should share the budget of an IP between its users", func(t *testing.T) {
+		w := serve("POST", "/withdraw", "10.0.0.1", neighbourUser.Name, withdrawal)
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		mockWalletService.AssertNotCalled(t, "Withdraw", mock.Anything, neighbourUser.ID, "USDT", mock.Anything, "", mock.Anything)
+	})
+
+	t.Run("should not drain the budget of an IP with the requests of a throttled user", func(t *testing.T) {
+		throttledUser := userGenerator.Generate()
+		mockAuthService.On("Authenticate", throttledUser.Name).Return(throttledUser, nil)
+		mockWalletService.On("GetBalances", mock.Anything, throttledUser.ID, []string{"USDT"}).Return([]models.Vault{}, nil).Times(5)
+
+		for i := 0; i < 5; i++ {
+			w := serve("GET", "/balances?currency=USDT", "10.0.0.7", throttledUser.Name, nil)
+			assert.Equal(t, http.StatusOK, w.Code)
+		}
+
+		// Refused by the bucket of the user, the requests give back the tokens of the IP bucket
+		for i := 0; i < 5; i++ {
+			w := serve("GET", "/balances?currency=USDT", "10.0.0.8", throttledUser.Name, nil)
+			assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		}
+
+		mockWalletService.On("GetBalances", mock.Anything, neighbourUser.ID, []string{"USDT"}).Return([]models.Vault{}, nil).Once()
+		w := serve("GET", "/balances?currency=USDT", "10.0.0.8", neighbourUser.Name, nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "4", w.Header().Get(middlewares.RateLimitRemainingHeader))
+	})
+
+	t.Run("should share the budget of a user between its API keys", func(t *testing.T) {
+		mockWalletService.On("Withdraw", mock.Anything, keyUser.ID, "USDT", mock.Anything, "", mock.Anything).
+			Return(&models.Transaction{}, nil).Once()
+
+		w := serve("POST", "/withdraw", "10.0.0.3", firstKey, withdrawal)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		w = serve("POST", "/withdraw", "10.0.0.4", secondKey, withdrawal)
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		mockWalletService.AssertNumberOfCalls(t, "Withdraw", 3)
+	})
+
+	t.Run("should limit invalid tokens by IP before authentication", func(t *testing.T) {
+		authenticated := len(mockAuthService.Calls)
+		for i := 0; i < 10; i++ {
+			w := serve("GET", "/balances?currency=USDT", "10.0.0.5", "invalid", nil)
+			assert.Equal(t, http.StatusUnauthorized, w.Code)
+		}
+
+		w := serve("GET", "/balances?currency=USDT", "10.0.0.5", "invalid", nil)
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Len(t, mockAuthService.Calls, authenticated+10)
+	})
+
+	t.Run("should limit credential requests by IP with a budget of their own", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			w := serve("POST", "/users", "10.0.0.6", "", nil)
+			assert.Equal(t, http.StatusOK, w.Code)
+		}
+
+		w := serve("POST", "/users", "10.0.0.6", "", nil)
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.NotEmpty(t, w.Header().Get(middlewares.RetryAfterHeader))
+	})
 }
@@ -0,0 +1,97 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wanliqun/go-wallet-app/apperrors"
+	"github.com/wanliqun/go-wallet-app/models"
+	"github.com/wanliqun/go-wallet-app/services"
+	"github.com/wanliqun/go-wallet-app/utils"
+)
+
+type WebhookController struct {
+	WebhookService services.IWebhookService
+}
+
+func NewWebhookController(webhook services.IWebhookService) *WebhookController {
+	return &WebhookController{WebhookService: webhook}
+}
+
+// POST /webhooks
+func (ctrl *WebhookController) CreateWebhook(c *gin.Context) {
+	var cRequest CreateWebhookRequest
+	if err := c.ShouldBindJSON(&cRequest); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	user := c.MustGet("user").(*models.User)
+	endpoint, err := ctrl.WebhookService.CreateEndpoint(user.ID, cRequest.URL, cRequest.Events)
+	if err != nil {
+		utils.ErrorResponse(c, apperrors.StatusCode(err), err)
+		return
+	}
+
+	// The secret is only disclosed once, receivers need it to verify the signatures
+	resp := newWebhookResponse(endpoint)
+	resp.Secret = endpoint.Secret
+	utils.SuccessResponse(c, resp)
+}
+
+// GET /webhooks
+func (ctrl *WebhookController) ListWebhooks(c *gin.Context) {
+	user := c.MustGet("user").(*models.User)
+	endpoints, err := ctrl.WebhookService.ListEndpoints(user.ID)
+	if err != nil {
+		utils.ErrorResponse(c, apperrors.StatusCode(err), err)
+		return
+	}
+
+	resp := make([]WebhookResponse, 0, len(endpoints))
+	for i := range endpoints {
+		resp = append(resp, newWebhookResponse(&endpoints[i]))
+	}
+	utils.SuccessResponse(c, resp)
+}
+
+// DELETE /webhooks/:id
+func (ctrl *WebhookController) DeleteWebhook(c *gin.Context) {
+	var uri WebhookURI
+	if err := c.ShouldBindUri(&uri); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	user := c.MustGet("user").(*models.User)
+	if err := ctrl.WebhookService.DeleteEndpoint(user.ID, uri.ID); err != nil {
+		utils.ErrorResponse(c, apperrors.StatusCode(err), err)
+		return
+	}
+
+	utils.SuccessResponse(c, nil)
+}
+
+// GET /webhooks/:id/deliveries
+func (ctrl *WebhookController) ListDeliveries(c *gin.Context) {
+	var uri WebhookURI
+	if err := c.ShouldBindUri(&uri); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	var cRequest ListWebhookDeliveriesQuery
+	if err := c.ShouldBindQuery(&cRequest); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	user := c.MustGet("user").(*models.User)
+	deliveries, err := ctrl.WebhookService.ListDeliveries(user.ID, uri.ID, cRequest.Limit)
+	if err != nil {
+		utils.ErrorResponse(c, apperrors.StatusCode(err), err)
+		return
+	}
+
+	utils.SuccessResponse(c, deliveries)
+}
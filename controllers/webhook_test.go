@@ -0,0 +1,112 @@
+package controllers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/wanliqun/go-wallet-app/controllers"
+	"github.com/wanliqun/go-wallet-app/middlewares"
+	"github.com/wanliqun/go-wallet-app/mocks"
+	"github.com/wanliqun/go-wallet-app/models"
+	"github.com/wanliqun/go-wallet-app/services"
+)
+
+func setupWebhookTestRouter(webhookService *mocks.MockWebhookService, authService *mocks.MockAuthService) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+
+	router.Use(middlewares.AuthMiddleware(authService, nil))
+
+	webhookController := controllers.NewWebhookController(webhookService)
+	router.POST("/webhooks", webhookController.CreateWebhook)
+	router.GET("/webhooks", webhookController.ListWebhooks)
+	router.DELETE("/webhooks/:id", webhookController.DeleteWebhook)
+	router.GET("/webhooks/:id/deliveries", webhookController.ListDeliveries)
+
+	return router
+}
+
+func TestWebhookController(t *testing.T) {
+	mockWebhookService := new(mocks.MockWebhookService)
+	mockAuthService := new(mocks.MockAuthService)
+	router := setupWebhookTestRouter(mockWebhookService, mockAuthService)
+
+	testUser := userGenerator.Generate()
+	mockAuthService.On("Authenticate", testUser.Name).Return(testUser, nil)
+
+	serve := func(method, path string, body interface{}) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, http.NoBody)
+		if body != nil {
+			data, _ := json.Marshal(body)
+			req, _ = http.NewRequest(method, path, bytes.NewBuffer(data))
+			req.Header.Set("Content-Type", "application/json")
+		}
+		req.Header.Set("Authorization", "Bearer "+testUser.Name)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	endpoint := &models.WebhookEndpoint{
+		UserID: testUser.ID,
+		URL:    "https://example.com/hooks",
+		Secret: "secret",
+		Events: models.EventTransactionCreated,
+	}
+	endpoint.ID = 7
+
+	t.Run("should create webhook and disclose the secret", func(t *testing.T) {
+		events := []string{models.EventTransactionCreated}
+		mockWebhookService.On("CreateEndpoint", testUser.ID, endpoint.URL, events).Return(endpoint, nil).Once()
+
+		w := serve("POST", "/webhooks", controllers.CreateWebhookRequest{URL: endpoint.URL, Events: events})
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var resp struct {
+			Data controllers.WebhookResponse
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		assert.Equal(t, endpoint.ID, resp.Data.ID)
+		assert.Equal(t, "secret", resp.Data.Secret)
+		assert.Equal(t, events, resp.Data.Events)
+	})
+
+	t.Run("should reject invalid webhook", func(t *testing.T) {
+		w := serve("POST", "/webhooks", controllers.CreateWebhookRequest{URL: "not a url"})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		w = serve("POST", "/webhooks", controllers.CreateWebhookRequest{URL: endpoint.URL, Events: []string{"unknown"}})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("should list webhooks without secrets", func(t *testing.T) {
+		mockWebhookService.On("ListEndpoints", testUser.ID).Return([]models.WebhookEndpoint{*endpoint}, nil).Once()
+
+		w := serve("GET", "/webhooks", nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NotContains(t, w.Body.String(), "secret")
+	})
+
+	t.Run("should list deliveries", func(t *testing.T) {
+		deliveries := []models.WebhookDelivery{{EndpointID: endpoint.ID, Status: models.WebhookDeliverySucceeded}}
+		mockWebhookService.On("ListDeliveries", testUser.ID, endpoint.ID, 5).Return(deliveries, nil).Once()
+
+		w := serve("GET", "/webhooks/7/deliveries?limit=5", nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("should fail to delete unknown webhook", func(t *testing.T) {
+		mockWebhookService.On("DeleteEndpoint", testUser.ID, uint(8)).Return(services.ErrWebhookEndpointNotFound).Once()
+
+		w := serve("DELETE", "/webhooks/8", nil)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	mockWebhookService.AssertExpectations(t)
+}
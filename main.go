@@ -1,11 +1,17 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"flag"
 	"log"
+	"os"
 
 	"github.com/gin-gonic/gin"
 	"github.com/wanliqun/go-wallet-app/config"
 	"github.com/wanliqun/go-wallet-app/routes"
+	"github.com/wanliqun/go-wallet-app/services"
+	"gorm.io/gorm"
 )
 
 func main() {
@@ -17,13 +23,109 @@ func main() {
 	sqlDB, _ := db.DB()
 	defer sqlDB.Close()
 
+	// Run the subcommand if any
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "reconcile":
+			os.Exit(reconcile(db))
+		case "migrate-minor-units":
+			os.Exit(migrateMinorUnits(db, os.Args[2:]))
+		default:
+			log.Fatalf("unknown subcommand %q", os.Args[1])
+		}
+	}
+
+	// Refuse to serve amounts which may still be in whole units
+	if err := services.CheckMinorUnits(db); err != nil {
+		log.Fatalf("failed to check the units of the amounts: %v", err)
+	}
+
+	// Schedule the expiry of pending holds
+	if interval := config.AppConfig.Holds.ExpiryInterval; interval > 0 {
+		go services.NewHoldService(db, config.AppConfig.Holds).Schedule(context.Background(), interval)
+	}
+
+	// Schedule balance reconciliation runs
+	if interval := config.AppConfig.Reconciliation.Interval; interval > 0 {
+		go services.NewReconciliationService(db).Schedule(context.Background(), interval)
+	}
+
+	// Schedule the delivery of events to the webhook endpoints
+	if interval := config.AppConfig.Webhooks.Interval; interval > 0 {
+		go services.NewWebhookService(db, config.AppConfig.Webhooks).Schedule(context.Background(), interval)
+	}
+
+	// Relay the committed events to the event publisher, which feeds the wallet streams
+	publisher := services.NewMemoryPublisher(config.AppConfig.Events.Capacity)
+	if interval := config.AppConfig.Events.RelayInterval; interval > 0 {
+		go services.NewOutboxRelay(db, publisher).Schedule(context.Background(), interval)
+	}
+
 	// Initialize router
 	router := gin.Default()
+	if err := router.SetTrustedProxies(config.AppConfig.Server.TrustedProxies); err != nil {
+		log.Fatalf("invalid trusted proxies: %v", err)
+	}
 
 	// Setup routes
-	routes.SetupRouter(router, db)
+	routes.SetupRouter(router, db, publisher)
 
 	// Run server
 	log.Printf("Starting server on port %s", config.AppConfig.Server.Port)
 	router.Run(":" + config.AppConfig.Server.Port)
 }
+
+// reconcile runs the balance reconciliation once and prints the report, it returns
+// a non-zero exit code if any mismatch was found.
+func reconcile(db *gorm.DB) int {
+	run, err := services.NewReconciliationService(db).Reconcile()
+	if err != nil {
+		log.Printf("failed to reconcile balances: %v", err)
+		return 2
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(run)
+
+	if run.MismatchCount > 0 {
+		return 1
+	}
+	return 0
+}
+
+// migrateMinorUnits scales the amounts stored in whole units by the precision of their currency,
+// or with -mark-applied only records that the amounts are already in minor units.
+func migrateMinorUnits(db *gorm.DB, args []string) int {
+	flags := flag.NewFlagSet("migrate-minor-units", flag.ExitOnError)
+	markApplied := flags.Bool("mark-applied", false, "record the amounts as already in minor units without scaling them")
+	flags.Parse(args)
+
+	if *markApplied {
+		if _, err := services.MarkMigrationApplied(db, services.MinorUnitsMigration); err != nil {
+			log.Printf("failed to record the migration: %v", err)
+			return 2
+		}
+		log.Printf("amounts recorded as in minor units")
+		return 0
+	}
+
+	currencyService := services.NewCurrencyService(db)
+	if err := currencyService.SeedFromConfig(config.AppConfig.Concurrencies); err != nil {
+		log.Printf("failed to seed currencies: %v", err)
+		return 2
+	}
+
+	applied, err := services.MigrateToMinorUnits(db, currencyService)
+	if err != nil {
+		log.Printf("failed to migrate amounts to minor units: %v", err)
+		return 2
+	}
+	if !applied {
+		log.Printf("amounts already migrated to minor units")
+		return 1
+	}
+
+	log.Printf("amounts migrated to minor units")
+	return 0
+}
@@ -1,14 +1,22 @@
 package middlewares
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/wanliqun/go-wallet-app/models"
 	"github.com/wanliqun/go-wallet-app/services"
 	"github.com/wanliqun/go-wallet-app/utils"
 )
 
-func AuthMiddleware(userService services.IUserService) gin.HandlerFunc {
+// apiKeyContextKey is the context key of the API key authenticating the request, if any
+const apiKeyContextKey = "api_key"
+
+// AuthMiddleware authenticates the user of the request with a signed access token, or with an API key
+// if the API key service is given. Routes accepting API keys must restrict them to a scope with the
+// ScopeMiddleware or the PermissionMiddleware.
+func AuthMiddleware(authService services.IAuthService, apiKeyService services.IAPIKeyService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		token, err := utils.ExtractBearerToken(c)
 		if err != nil {
@@ -16,13 +24,26 @@ func AuthMiddleware(userService services.IUserService) gin.HandlerFunc {
 			return
 		}
 
-		user, ok, err := userService.GetUserByName(token)
-		if err != nil {
-			utils.ErrorResponse(c, http.StatusInternalServerError, err)
-			return
+		var user *models.User
+		if apiKeyService != nil && services.IsAPIKey(token) {
+			var apiKey *models.APIKey
+			apiKey, user, err = apiKeyService.Authenticate(c.Request.Context(), token)
+			if err == nil {
+				c.Set(apiKeyContextKey, apiKey)
+			}
+		} else {
+			user, err = authService.Authenticate(token)
 		}
-		if !ok {
-			utils.ErrorResponse(c, http.StatusUnauthorized, services.ErrUserNotFound)
+		if err != nil {
+			if errors.Is(err, services.ErrInvalidToken) ||
+				errors.Is(err, services.ErrTokenRevoked) ||
+				errors.Is(err, services.ErrInvalidAPIKey) ||
+				errors.Is(err, services.ErrAPIKeyExpired) ||
+				errors.Is(err, services.ErrUserNotFound) {
+				utils.ErrorResponse(c, http.StatusUnauthorized, err)
+			} else {
+				utils.ErrorResponse(c, http.StatusInternalServerError, err)
+			}
 			return
 		}
 
@@ -30,3 +51,10 @@ func AuthMiddleware(userService services.IUserService) gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// APIKey returns the API key authenticating the request, or nil if authenticated with a user token
+func APIKey(c *gin.Context) *models.APIKey {
+	apiKey, _ := c.Get(apiKeyContextKey)
+	key, _ := apiKey.(*models.APIKey)
+	return key
+}
@@ -3,6 +3,7 @@ package middlewares
 import (
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"github.com/wanliqun/go-wallet-app/utils"
 )
 
 func CorsMiddleware() gin.HandlerFunc {
@@ -10,6 +11,7 @@ func CorsMiddleware() gin.HandlerFunc {
 	conf.AllowMethods = append(conf.AllowMethods, "OPTIONS")
 	conf.AllowHeaders = append(conf.AllowHeaders, "*")
 	conf.AllowAllOrigins = true
+	conf.ExposeHeaders = append(conf.ExposeHeaders, utils.RequestIDHeader)
 
 	return cors.New(conf)
 }
@@ -0,0 +1,29 @@
+package middlewares
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/wanliqun/go-wallet-app/apperrors"
+	"github.com/wanliqun/go-wallet-app/models"
+	"github.com/wanliqun/go-wallet-app/services"
+	"github.com/wanliqun/go-wallet-app/utils"
+)
+
+// PermissionMiddleware only lets through authenticated users granted the permission by the authorizer,
+// it must be installed after the AuthMiddleware. Requests authenticated with an API key also need the
+// key to be granted the scope named after the permission.
+func PermissionMiddleware(authorizer services.IAuthorizer, permission services.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("user").(*models.User)
+		if err := authorizer.Authorize(user, permission); err != nil {
+			utils.ErrorResponse(c, apperrors.StatusCode(err), err)
+			return
+		}
+		if apiKey := APIKey(c); apiKey != nil && !apiKey.HasScope(string(permission)) {
+			err := services.ErrInsufficientScope
+			utils.ErrorResponse(c, apperrors.StatusCode(err), err)
+			return
+		}
+
+		c.Next()
+	}
+}
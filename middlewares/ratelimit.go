@@ -0,0 +1,107 @@
+package middlewares
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wanliqun/go-wallet-app/apperrors"
+	"github.com/wanliqun/go-wallet-app/models"
+	"github.com/wanliqun/go-wallet-app/services"
+	"github.com/wanliqun/go-wallet-app/utils"
+)
+
+// Headers of the rate limited responses, following the IETF draft of the RateLimit header fields
+const (
+	RateLimitLimitHeader     = "RateLimit-Limit"     // Requests allowed by the bucket when full
+	RateLimitRemainingHeader = "RateLimit-Remaining" // Requests left in the bucket
+	RateLimitResetHeader     = "RateLimit-Reset"     // Seconds until the bucket is full again
+	RateLimitPolicyHeader    = "RateLimit-Policy"    // Limit and period of the bucket, e.g. "120;w=60"
+	RetryAfterHeader         = "Retry-After"         // Seconds until the next request is allowed, once refused
+)
+
+// RateLimitMiddleware limits the requests of each client with token buckets of the named budget.
+// A request takes a token from the bucket of its IP, and from those of the user and the API key
+// authenticating it, so that neither many keys of a user nor many users of an IP multiply the
+// budget. The tokens taken are given back if another bucket refuses the request, so that a client
+// over its budget does not drain the buckets it shares with others. Installed before the
+// AuthMiddleware, it limits by IP only. A zero limit disables the middleware, and the buckets of a
+// failing store are skipped.
+func RateLimitMiddleware(store services.IRateLimitStore, name string, budget services.RateBudget) gin.HandlerFunc {
+	if budget.Limit <= 0 || budget.Period <= 0 {
+		return func(c *gin.Context) { c.Next() }
+	}
+
+	policy := fmt.Sprintf("%d;w=%d", budget.Limit, int64(budget.Period.Seconds()))
+	return func(c *gin.Context) {
+		// Report the bucket refusing the request, or else the one with the fewest requests left
+		var limiting *services.RateLimitResult
+		var taken []string
+		now := time.Now()
+		for _, client := range rateLimitClients(c) {
+			result, err := store.Take(c.Request.Context(), name+":"+client, budget, now)
+			if err != nil {
+				log.Printf("request %s not rate limited by %s: %v", utils.RequestID(c), client, err)
+				continue
+			}
+
+			if limiting == nil || result.Remaining < limiting.Remaining {
+				limiting = &result
+			}
+			if !result.Allowed {
+				limiting = &result
+				refundRateLimit(c, store, name, budget, taken, now)
+				break
+			}
+			taken = append(taken, client)
+		}
+		if limiting == nil {
+			c.Next()
+			return
+		}
+
+		c.Header(RateLimitLimitHeader, strconv.Itoa(limiting.Limit))
+		c.Header(RateLimitRemainingHeader, strconv.Itoa(limiting.Remaining))
+		c.Header(RateLimitResetHeader, ceilSeconds(limiting.Reset))
+		c.Header(RateLimitPolicyHeader, policy)
+
+		if !limiting.Allowed {
+			c.Header(RetryAfterHeader, ceilSeconds(limiting.RetryAfter))
+			err := services.ErrRateLimited
+			utils.ErrorResponse(c, apperrors.StatusCode(err), err)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// refundRateLimit gives back the tokens taken from the buckets of the clients by a refused request
+func refundRateLimit(
+	c *gin.Context, store services.IRateLimitStore, name string, budget services.RateBudget, clients []string, now time.Time) {
+	for _, client := range clients {
+		if err := store.Refund(c.Request.Context(), name+":"+client, budget, now); err != nil {
+			log.Printf("request %s not refunded to %s: %v", utils.RequestID(c), client, err)
+		}
+	}
+}
+
+// rateLimitClients identifies the IP of the request, and the user and the API key authenticating it
+func rateLimitClients(c *gin.Context) []string {
+	clients := []string{"ip:" + c.ClientIP()}
+	if user, ok := c.Get("user"); ok {
+		clients = append(clients, "user:"+strconv.FormatUint(uint64(user.(*models.User).ID), 10))
+	}
+	if apiKey := APIKey(c); apiKey != nil {
+		clients = append(clients, "key:"+strconv.FormatUint(uint64(apiKey.ID), 10))
+	}
+	return clients
+}
+
+// ceilSeconds formats the duration in whole seconds, rounded up
+func ceilSeconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
@@ -0,0 +1,24 @@
+package middlewares
+
+import (
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wanliqun/go-wallet-app/utils"
+)
+
+// requestIDPattern restricts client supplied request IDs to safe characters
+var requestIDPattern = regexp.MustCompile(`^[a-zA-Z0-9._-]{1,64}$`)
+
+// RequestIDMiddleware assigns an ID to every request, keeping a valid one supplied by the client
+func RequestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(utils.RequestIDHeader)
+		if !requestIDPattern.MatchString(requestID) {
+			requestID = utils.NewRequestID()
+		}
+
+		utils.SetRequestID(c, requestID)
+		c.Next()
+	}
+}
@@ -0,0 +1,22 @@
+package middlewares
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/wanliqun/go-wallet-app/apperrors"
+	"github.com/wanliqun/go-wallet-app/services"
+	"github.com/wanliqun/go-wallet-app/utils"
+)
+
+// ScopeMiddleware only lets through requests authenticated with an API key granted the scope, along
+// with those authenticated with user tokens. It must be installed after the AuthMiddleware.
+func ScopeMiddleware(scope services.Scope) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if apiKey := APIKey(c); apiKey != nil && !apiKey.HasScope(string(scope)) {
+			err := services.ErrInsufficientScope
+			utils.ErrorResponse(c, apperrors.StatusCode(err), err)
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,56 @@
+package mocks
+
+import (
+	"context"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/wanliqun/go-wallet-app/models"
+	"github.com/wanliqun/go-wallet-app/services"
+)
+
+var (
+	_ services.IAPIKeyService = &MockAPIKeyService{}
+)
+
+type MockAPIKeyService struct {
+	mock.Mock
+}
+
+func (m *MockAPIKeyService) CreateAPIKey(ctx context.Context, userID uint, name string, scopes []services.Scope,
+	expiresAt *time.Time, stepUp services.StepUpCheck) (*models.APIKey, string, error) {
+	args := m.Called(ctx, userID, name, scopes, expiresAt, stepUp)
+	apiKey, _ := args.Get(0).(*models.APIKey)
+	return apiKey, args.String(1), args.Error(2)
+}
+
+func (m *MockAPIKeyService) ListAPIKeys(ctx context.Context, userID uint) ([]models.APIKey, error) {
+	args := m.Called(ctx, userID)
+	apiKeys, _ := args.Get(0).([]models.APIKey)
+	return apiKeys, args.Error(1)
+}
+
+func (m *MockAPIKeyService) GetAPIKey(ctx context.Context, userID, keyID uint) (*models.APIKey, error) {
+	args := m.Called(ctx, userID, keyID)
+	apiKey, _ := args.Get(0).(*models.APIKey)
+	return apiKey, args.Error(1)
+}
+
+func (m *MockAPIKeyService) UpdateAPIKey(ctx context.Context, userID, keyID uint, update services.APIKeyUpdate,
+	stepUp services.StepUpCheck) (*models.APIKey, error) {
+	args := m.Called(ctx, userID, keyID, update, stepUp)
+	apiKey, _ := args.Get(0).(*models.APIKey)
+	return apiKey, args.Error(1)
+}
+
+func (m *MockAPIKeyService) DeleteAPIKey(ctx context.Context, userID, keyID uint) error {
+	args := m.Called(ctx, userID, keyID)
+	return args.Error(0)
+}
+
+func (m *MockAPIKeyService) Authenticate(ctx context.Context, key string) (*models.APIKey, *models.User, error) {
+	args := m.Called(ctx, key)
+	apiKey, _ := args.Get(0).(*models.APIKey)
+	user, _ := args.Get(1).(*models.User)
+	return apiKey, user, args.Error(2)
+}
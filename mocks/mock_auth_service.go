@@ -0,0 +1,38 @@
+package mocks
+
+import (
+	"github.com/stretchr/testify/mock"
+	"github.com/wanliqun/go-wallet-app/models"
+	"github.com/wanliqun/go-wallet-app/services"
+)
+
+var (
+	_ services.IAuthService = &MockAuthService{}
+)
+
+type MockAuthService struct {
+	mock.Mock
+}
+
+func (m *MockAuthService) Login(name, password string) (*services.TokenPair, error) {
+	args := m.Called(name, password)
+	tokens, _ := args.Get(0).(*services.TokenPair)
+	return tokens, args.Error(1)
+}
+
+func (m *MockAuthService) Refresh(refreshToken string) (*services.TokenPair, error) {
+	args := m.Called(refreshToken)
+	tokens, _ := args.Get(0).(*services.TokenPair)
+	return tokens, args.Error(1)
+}
+
+func (m *MockAuthService) Revoke(token string) error {
+	args := m.Called(token)
+	return args.Error(0)
+}
+
+func (m *MockAuthService) Authenticate(accessToken string) (*models.User, error) {
+	args := m.Called(accessToken)
+	user, _ := args.Get(0).(*models.User)
+	return user, args.Error(1)
+}
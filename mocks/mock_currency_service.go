@@ -0,0 +1,42 @@
+package mocks
+
+import (
+	"github.com/stretchr/testify/mock"
+	"github.com/wanliqun/go-wallet-app/models"
+	"github.com/wanliqun/go-wallet-app/services"
+)
+
+var (
+	_ services.ICurrencyService = &MockCurrencyService{}
+)
+
+type MockCurrencyService struct {
+	mock.Mock
+}
+
+func (m *MockCurrencyService) LookupCurrency(code string) (models.Currency, bool) {
+	args := m.Called(code)
+	return args.Get(0).(models.Currency), args.Bool(1)
+}
+
+func (m *MockCurrencyService) ListCurrencies(includeDisabled bool) ([]models.Currency, error) {
+	args := m.Called(includeDisabled)
+	return args.Get(0).([]models.Currency), args.Error(1)
+}
+
+func (m *MockCurrencyService) GetCurrency(code string) (*models.Currency, bool, error) {
+	args := m.Called(code)
+	currency, _ := args.Get(0).(*models.Currency)
+	return currency, args.Bool(1), args.Error(2)
+}
+
+func (m *MockCurrencyService) CreateCurrency(currency *models.Currency) error {
+	args := m.Called(currency)
+	return args.Error(0)
+}
+
+func (m *MockCurrencyService) UpdateCurrency(code string, update services.CurrencyUpdate) (*models.Currency, error) {
+	args := m.Called(code, update)
+	currency, _ := args.Get(0).(*models.Currency)
+	return currency, args.Error(1)
+}
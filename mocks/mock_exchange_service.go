@@ -0,0 +1,36 @@
+package mocks
+
+import (
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/mock"
+	"github.com/wanliqun/go-wallet-app/models"
+	"github.com/wanliqun/go-wallet-app/services"
+)
+
+var (
+	_ services.IExchangeService = &MockExchangeService{}
+)
+
+type MockExchangeService struct {
+	mock.Mock
+}
+
+func (m *MockExchangeService) Quote(userID uint, from, to string, amount decimal.Decimal) (*models.ExchangeQuote, error) {
+	args := m.Called(userID, from, to, amount)
+	quote, _ := args.Get(0).(*models.ExchangeQuote)
+	return quote, args.Error(1)
+}
+
+func (m *MockExchangeService) ExecuteQuote(userID, quoteID uint, idempotencyKey string) (*models.Transaction, *models.Transaction, error) {
+	args := m.Called(userID, quoteID, idempotencyKey)
+	out, _ := args.Get(0).(*models.Transaction)
+	in, _ := args.Get(1).(*models.Transaction)
+	return out, in, args.Error(2)
+}
+
+func (m *MockExchangeService) Exchange(userID uint, from, to string, amount decimal.Decimal, idempotencyKey string) (*models.Transaction, *models.Transaction, error) {
+	args := m.Called(userID, from, to, amount, idempotencyKey)
+	out, _ := args.Get(0).(*models.Transaction)
+	in, _ := args.Get(1).(*models.Transaction)
+	return out, in, args.Error(2)
+}
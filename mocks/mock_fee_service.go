@@ -0,0 +1,21 @@
+package mocks
+
+import (
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/mock"
+	"github.com/wanliqun/go-wallet-app/models"
+	"github.com/wanliqun/go-wallet-app/services"
+)
+
+var (
+	_ services.IFeeService = &MockFeeService{}
+)
+
+type MockFeeService struct {
+	mock.Mock
+}
+
+func (m *MockFeeService) CalculateFee(currency string, operation models.TransactionType, amount decimal.Decimal) (decimal.Decimal, decimal.Decimal, error) {
+	args := m.Called(currency, operation, amount)
+	return args.Get(0).(decimal.Decimal), args.Get(1).(decimal.Decimal), args.Error(2)
+}
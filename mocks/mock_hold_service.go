@@ -0,0 +1,45 @@
+package mocks
+
+import (
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/mock"
+	"github.com/wanliqun/go-wallet-app/models"
+	"github.com/wanliqun/go-wallet-app/services"
+)
+
+var (
+	_ services.IHoldService = &MockHoldService{}
+)
+
+type MockHoldService struct {
+	mock.Mock
+}
+
+func (m *MockHoldService) PlaceHold(senderID, recipientID uint, currency string, amount decimal.Decimal, memo, idempotencyKey string, stepUp services.StepUpCheck) (*models.Hold, error) {
+	args := m.Called(senderID, recipientID, currency, amount, memo, idempotencyKey, stepUp)
+	hold, _ := args.Get(0).(*models.Hold)
+	return hold, args.Error(1)
+}
+
+func (m *MockHoldService) CaptureHold(userID, holdID uint) (*models.Hold, error) {
+	args := m.Called(userID, holdID)
+	hold, _ := args.Get(0).(*models.Hold)
+	return hold, args.Error(1)
+}
+
+func (m *MockHoldService) ReleaseHold(userID, holdID uint) (*models.Hold, error) {
+	args := m.Called(userID, holdID)
+	hold, _ := args.Get(0).(*models.Hold)
+	return hold, args.Error(1)
+}
+
+func (m *MockHoldService) GetHold(userID, holdID uint) (*models.Hold, bool, error) {
+	args := m.Called(userID, holdID)
+	hold, _ := args.Get(0).(*models.Hold)
+	return hold, args.Bool(1), args.Error(2)
+}
+
+func (m *MockHoldService) ExpireHolds() (int, error) {
+	args := m.Called()
+	return args.Int(0), args.Error(1)
+}
@@ -0,0 +1,38 @@
+package mocks
+
+import (
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/mock"
+	"github.com/wanliqun/go-wallet-app/models"
+	"github.com/wanliqun/go-wallet-app/services"
+	"gorm.io/gorm"
+)
+
+var (
+	_ services.ILimitService = &MockLimitService{}
+)
+
+type MockLimitService struct {
+	mock.Mock
+}
+
+func (m *MockLimitService) GetLimits(userID uint, currency string) (*services.LimitUsage, error) {
+	args := m.Called(userID, currency)
+	usage, _ := args.Get(0).(*services.LimitUsage)
+	return usage, args.Error(1)
+}
+
+func (m *MockLimitService) SetUserLimit(limit *models.UserLimit) error {
+	args := m.Called(limit)
+	return args.Error(0)
+}
+
+func (m *MockLimitService) DeleteUserLimit(userID uint, currency string) error {
+	args := m.Called(userID, currency)
+	return args.Error(0)
+}
+
+func (m *MockLimitService) CheckLimits(tx *gorm.DB, userID uint, currency string, amount decimal.Decimal) error {
+	args := m.Called(tx, userID, currency, amount)
+	return args.Error(0)
+}
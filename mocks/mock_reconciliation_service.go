@@ -0,0 +1,26 @@
+package mocks
+
+import (
+	"github.com/stretchr/testify/mock"
+	"github.com/wanliqun/go-wallet-app/models"
+	"github.com/wanliqun/go-wallet-app/services"
+)
+
+var (
+	_ services.IReconciliationService = &MockReconciliationService{}
+)
+
+type MockReconciliationService struct {
+	mock.Mock
+}
+
+func (m *MockReconciliationService) Reconcile() (*models.ReconciliationRun, error) {
+	args := m.Called()
+	run, _ := args.Get(0).(*models.ReconciliationRun)
+	return run, args.Error(1)
+}
+
+func (m *MockReconciliationService) ListRuns(limit int) ([]models.ReconciliationRun, error) {
+	args := m.Called(limit)
+	return args.Get(0).([]models.ReconciliationRun), args.Error(1)
+}
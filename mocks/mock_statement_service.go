@@ -0,0 +1,22 @@
+package mocks
+
+import (
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/wanliqun/go-wallet-app/services"
+)
+
+var (
+	_ services.IStatementService = &MockStatementService{}
+)
+
+type MockStatementService struct {
+	mock.Mock
+}
+
+func (m *MockStatementService) GetStatement(userID uint, currency string, from, to time.Time) (*services.Statement, error) {
+	args := m.Called(userID, currency, from, to)
+	statement, _ := args.Get(0).(*services.Statement)
+	return statement, args.Error(1)
+}
@@ -0,0 +1,47 @@
+package mocks
+
+import (
+	"context"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/wanliqun/go-wallet-app/models"
+	"github.com/wanliqun/go-wallet-app/services"
+	"gorm.io/gorm"
+)
+
+var (
+	_ services.ITwoFactorService = &MockTwoFactorService{}
+)
+
+type MockTwoFactorService struct {
+	mock.Mock
+}
+
+func (m *MockTwoFactorService) Enroll(ctx context.Context, user *models.User) (*services.TOTPEnrollment, error) {
+	args := m.Called(ctx, user)
+	enrollment, _ := args.Get(0).(*services.TOTPEnrollment)
+	return enrollment, args.Error(1)
+}
+
+func (m *MockTwoFactorService) Confirm(ctx context.Context, userID uint, code string) ([]string, error) {
+	args := m.Called(ctx, userID, code)
+	codes, _ := args.Get(0).([]string)
+	return codes, args.Error(1)
+}
+
+func (m *MockTwoFactorService) Disable(ctx context.Context, userID uint, code string) error {
+	args := m.Called(ctx, userID, code)
+	return args.Error(0)
+}
+
+func (m *MockTwoFactorService) RegenerateRecoveryCodes(ctx context.Context, userID uint, code string) ([]string, error) {
+	args := m.Called(ctx, userID, code)
+	codes, _ := args.Get(0).([]string)
+	return codes, args.Error(1)
+}
+
+func (m *MockTwoFactorService) StepUp(
+	tx *gorm.DB, user *models.User, action services.StepUpAction, code string) error {
+	args := m.Called(tx, user, action, code)
+	return args.Error(0)
+}
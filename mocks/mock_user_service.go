@@ -1,6 +1,8 @@
 package mocks
 
 import (
+	"context"
+
 	"github.com/stretchr/testify/mock"
 	"github.com/wanliqun/go-wallet-app/models"
 	"github.com/wanliqun/go-wallet-app/services"
@@ -14,7 +16,42 @@ type MockUserService struct {
 	mock.Mock
 }
 
-func (m *MockUserService) GetUserByName(name string) (*models.User, bool, error) {
-	args := m.Called(name)
+func (m *MockUserService) GetUserByName(ctx context.Context, name string) (*models.User, bool, error) {
+	args := m.Called(ctx, name)
 	return args.Get(0).(*models.User), args.Bool(1), args.Error(2)
 }
+
+func (m *MockUserService) GetUserByID(ctx context.Context, id uint) (*models.User, bool, error) {
+	args := m.Called(ctx, id)
+	user, _ := args.Get(0).(*models.User)
+	return user, args.Bool(1), args.Error(2)
+}
+
+func (m *MockUserService) CreateUser(ctx context.Context, name, email, password string) (*models.User, error) {
+	args := m.Called(ctx, name, email, password)
+	user, _ := args.Get(0).(*models.User)
+	return user, args.Error(1)
+}
+
+func (m *MockUserService) CreateServiceAccount(ctx context.Context, name string, role models.Role) (*models.User, error) {
+	args := m.Called(ctx, name, role)
+	user, _ := args.Get(0).(*models.User)
+	return user, args.Error(1)
+}
+
+func (m *MockUserService) UpdateUser(ctx context.Context, id uint, update services.UserUpdate) (*models.User, error) {
+	args := m.Called(ctx, id, update)
+	user, _ := args.Get(0).(*models.User)
+	return user, args.Error(1)
+}
+
+func (m *MockUserService) DeleteUser(ctx context.Context, id uint) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (m *MockUserService) SetUserRole(ctx context.Context, id uint, role models.Role) (*models.User, error) {
+	args := m.Called(ctx, id, role)
+	user, _ := args.Get(0).(*models.User)
+	return user, args.Error(1)
+}
@@ -1,6 +1,8 @@
 package mocks
 
 import (
+	"context"
+
 	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/mock"
 	"github.com/wanliqun/go-wallet-app/models"
@@ -15,27 +17,48 @@ type MockWalletService struct {
 	mock.Mock
 }
 
-func (m *MockWalletService) Deposit(userID uint, currency string, amount decimal.Decimal) error {
-	args := m.Called(userID, currency, amount)
-	return args.Error(0)
+func (m *MockWalletService) Deposit(ctx context.Context, userID uint, currency string, amount decimal.Decimal, idempotencyKey string) (*models.Transaction, error) {
+	args := m.Called(ctx, userID, currency, amount, idempotencyKey)
+	transaction, _ := args.Get(0).(*models.Transaction)
+	return transaction, args.Error(1)
+}
+
+func (m *MockWalletService) Withdraw(ctx context.Context, userID uint, currency string, amount decimal.Decimal, idempotencyKey string, stepUp services.StepUpCheck) (*models.Transaction, error) {
+	args := m.Called(ctx, userID, currency, amount, idempotencyKey, stepUp)
+	transaction, _ := args.Get(0).(*models.Transaction)
+	return transaction, args.Error(1)
 }
 
-func (m *MockWalletService) Withdraw(userID uint, currency string, amount decimal.Decimal) error {
-	args := m.Called(userID, currency, amount)
-	return args.Error(0)
+func (m *MockWalletService) Transfer(ctx context.Context, senderID, recipientID uint, currency string, amount decimal.Decimal, memo, idempotencyKey string, stepUp services.StepUpCheck) (*models.Transaction, error) {
+	args := m.Called(ctx, senderID, recipientID, currency, amount, memo, idempotencyKey, stepUp)
+	transaction, _ := args.Get(0).(*models.Transaction)
+	return transaction, args.Error(1)
 }
 
-func (m *MockWalletService) Transfer(senderID, recipientID uint, currency string, amount decimal.Decimal, memo string) error {
-	args := m.Called(senderID, recipientID, currency, amount, memo)
-	return args.Error(0)
+func (m *MockWalletService) Reverse(ctx context.Context, transactionID uint, memo string, force bool) (*models.Transaction, error) {
+	args := m.Called(ctx, transactionID, memo, force)
+	transaction, _ := args.Get(0).(*models.Transaction)
+	return transaction, args.Error(1)
 }
 
-func (m *MockWalletService) GetBalances(userID uint, currencies []string) ([]models.Vault, error) {
-	args := m.Called(userID, currencies)
+func (m *MockWalletService) GetBalances(ctx context.Context, userID uint, currencies []string) ([]models.Vault, error) {
+	args := m.Called(ctx, userID, currencies)
 	return args.Get(0).([]models.Vault), args.Error(1)
 }
 
-func (m *MockWalletService) GetTransactionHistory(userID uint, txnType models.TransactionType, cursor string, order services.SortOrder, limit int) ([]models.Transaction, string, error) {
-	args := m.Called(userID, txnType, cursor, order, limit)
+func (m *MockWalletService) GetTransactionHistory(ctx context.Context, userID uint, filter services.TransactionFilter, cursor string, order services.SortOrder, limit int) ([]models.Transaction, string, error) {
+	args := m.Called(ctx, userID, filter, cursor, order, limit)
 	return args.Get(0).([]models.Transaction), args.String(1), args.Error(2)
 }
+
+func (m *MockWalletService) GetTransaction(ctx context.Context, userID, transactionID uint) (*models.Transaction, bool, error) {
+	args := m.Called(ctx, userID, transactionID)
+	transaction, _ := args.Get(0).(*models.Transaction)
+	return transaction, args.Bool(1), args.Error(2)
+}
+
+func (m *MockWalletService) GetTransferCounterpart(ctx context.Context, txn *models.Transaction) (*models.Transaction, bool, error) {
+	args := m.Called(ctx, txn)
+	counterpart, _ := args.Get(0).(*models.Transaction)
+	return counterpart, args.Bool(1), args.Error(2)
+}
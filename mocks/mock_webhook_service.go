@@ -0,0 +1,43 @@
+package mocks
+
+import (
+	"github.com/stretchr/testify/mock"
+	"github.com/wanliqun/go-wallet-app/models"
+	"github.com/wanliqun/go-wallet-app/services"
+)
+
+var (
+	_ services.IWebhookService = &MockWebhookService{}
+)
+
+type MockWebhookService struct {
+	mock.Mock
+}
+
+func (m *MockWebhookService) CreateEndpoint(userID uint, url string, events []string) (*models.WebhookEndpoint, error) {
+	args := m.Called(userID, url, events)
+	endpoint, _ := args.Get(0).(*models.WebhookEndpoint)
+	return endpoint, args.Error(1)
+}
+
+func (m *MockWebhookService) ListEndpoints(userID uint) ([]models.WebhookEndpoint, error) {
+	args := m.Called(userID)
+	endpoints, _ := args.Get(0).([]models.WebhookEndpoint)
+	return endpoints, args.Error(1)
+}
+
+func (m *MockWebhookService) DeleteEndpoint(userID, endpointID uint) error {
+	args := m.Called(userID, endpointID)
+	return args.Error(0)
+}
+
+func (m *MockWebhookService) ListDeliveries(userID, endpointID uint, limit int) ([]models.WebhookDelivery, error) {
+	args := m.Called(userID, endpointID, limit)
+	deliveries, _ := args.Get(0).([]models.WebhookDelivery)
+	return deliveries, args.Error(1)
+}
+
+func (m *MockWebhookService) Dispatch() (int, error) {
+	args := m.Called()
+	return args.Int(0), args.Error(1)
+}
@@ -0,0 +1,44 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// APIKey grants services access to the wallet of its user within its scopes, without the tokens
+// of the user. Only the hash of the key is stored, the key itself is disclosed once on creation.
+type APIKey struct {
+	gorm.Model
+	UserID     uint       `gorm:"not null;index" json:"user_id"`
+	Name       string     `gorm:"size:64;not null" json:"name"`
+	Prefix     string     `gorm:"size:16;not null;uniqueIndex" json:"prefix"` // Public part of the key looking it up
+	KeyHash    string     `gorm:"size:64;not null" json:"-"`                  // SHA-256 of the key
+	Scopes     string     `gorm:"size:256;not null" json:"-"`                 // Comma separated scopes
+	ExpiresAt  *time.Time `json:"expires_at"`                                 // Never expires if nil
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+// ScopeList returns the scopes granted to the key
+func (k *APIKey) ScopeList() []string {
+	if k.Scopes == "" {
+		return nil
+	}
+	return strings.Split(k.Scopes, ",")
+}
+
+// HasScope returns whether the key is granted the scope
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.ScopeList() {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Expired returns whether the key has expired at the instant
+func (k *APIKey) Expired(now time.Time) bool {
+	return k.ExpiresAt != nil && !now.Before(*k.ExpiresAt)
+}
@@ -0,0 +1,18 @@
+package models
+
+import (
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// Currency is an entry of the currency registry. Amount limits are stored in integer minor units
+// of the currency, and a zero limit means no limit.
+type Currency struct {
+	gorm.Model
+	Code      string          `gorm:"size:32;uniqueIndex;not null" json:"code"`
+	Name      string          `gorm:"size:64;not null" json:"name"`
+	Precision int32           `gorm:"not null" json:"precision"` // Number of decimal places of the minor unit
+	Enabled   bool            `gorm:"not null" json:"enabled"`
+	MinAmount decimal.Decimal `gorm:"type:numeric(64,0);default:0" json:"min_amount"` // Minimum amount per transaction
+	MaxAmount decimal.Decimal `gorm:"type:numeric(64,0);default:0" json:"max_amount"` // Maximum amount per transaction
+}
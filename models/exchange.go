@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// ExchangeQuote fixes the rate of a currency exchange for the user until it expires. A quote
+// can be executed only once.
+type ExchangeQuote struct {
+	gorm.Model
+	UserID        uint            `gorm:"not null;index" json:"user_id"`
+	FromCurrency  string          `gorm:"size:32;not null" json:"from_currency"`
+	ToCurrency    string          `gorm:"size:32;not null" json:"to_currency"`
+	FromAmount    decimal.Decimal `gorm:"type:numeric(64,0);not null" json:"from_amount"`
+	ToAmount      decimal.Decimal `gorm:"type:numeric(64,0);not null" json:"to_amount"`
+	Rate          decimal.Decimal `gorm:"type:numeric;not null" json:"rate"` // Amount of the target currency one unit of the source currency is worth, in human units
+	ExpiresAt     time.Time       `gorm:"not null" json:"expires_at"`
+	TransactionID *uint           `gorm:"uniqueIndex" json:"transaction_id"` // exchange_out transaction executing the quote
+}
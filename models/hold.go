@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+type HoldStatus string
+
+const (
+	HoldStatusPending  HoldStatus = "pending"
+	HoldStatusCaptured HoldStatus = "captured"
+	HoldStatusReleased HoldStatus = "released"
+	HoldStatusExpired  HoldStatus = "expired"
+)
+
+// Hold reserves funds of the sender for a pending transfer to the recipient. The funds are moved
+// from the available to the held balance of the sender's vault until the hold is captured,
+// released or expired.
+type Hold struct {
+	gorm.Model
+	UserID        uint            `gorm:"not null;index" json:"user_id"` // Sender whose funds are held
+	RecipientID   uint            `gorm:"not null" json:"recipient_id"`
+	Amount        decimal.Decimal `gorm:"type:numeric(64,0);not null" json:"amount"`
+	Currency      string          `gorm:"size:32;not null" json:"currency"`
+	Memo          string          `gorm:"size:256" json:"memo,omitempty"`
+	Status        HoldStatus      `gorm:"size:16;not null;index:idx_status_expires_at,priority:1" json:"status"`
+	ExpiresAt     time.Time       `gorm:"not null;index:idx_status_expires_at,priority:2" json:"expires_at"`
+	TransactionID uint            `gorm:"not null;uniqueIndex" json:"transaction_id"` // Transaction placing the hold
+}
@@ -0,0 +1,16 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+// IdempotencyKey records a client supplied idempotency key for a money-moving request.
+// Keys are scoped per user, and the fingerprint of the original request is kept so that
+// a reused key with a different payload can be detected.
+type IdempotencyKey struct {
+	gorm.Model
+	UserID        uint   `gorm:"not null;uniqueIndex:idx_user_idempotency_key,priority:1" json:"user_id"`
+	Key           string `gorm:"size:64;not null;uniqueIndex:idx_user_idempotency_key,priority:2" json:"key"`
+	Fingerprint   string `gorm:"size:64;not null" json:"fingerprint"`
+	TransactionID *uint  `json:"transaction_id"` // Transaction produced by the original request
+}
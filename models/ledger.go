@@ -0,0 +1,68 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+type LedgerAccountType string
+
+const (
+	UserLedgerAccount   LedgerAccountType = "user"
+	SystemLedgerAccount LedgerAccountType = "system"
+)
+
+// Codes of the system ledger accounts
+const (
+	ExternalDepositsAccount   = "external_deposits"   // Funds received from outside the platform
+	WithdrawalsPayableAccount = "withdrawals_payable" // Funds owed to destinations outside the platform
+	OpeningBalancesAccount    = "opening_balances"    // Balances held before the ledger was introduced
+	ExchangeAccount           = "exchange"            // Position of the platform in currency exchanges
+	PlatformFeesAccount       = "platform_fees"       // Fees charged by the platform
+)
+
+type PostingSide string
+
+const (
+	Debit  PostingSide = "debit"
+	Credit PostingSide = "credit"
+)
+
+// LedgerAccount is an account of the double-entry ledger in a single currency. Every user
+// owns one account per currency, while system accounts are shared by the platform.
+type LedgerAccount struct {
+	gorm.Model
+	Code     string            `gorm:"size:64;not null;uniqueIndex:idx_ledger_code_currency,priority:1" json:"code"`
+	Currency string            `gorm:"size:32;not null;uniqueIndex:idx_ledger_code_currency,priority:2" json:"currency"`
+	Type     LedgerAccountType `gorm:"size:16;not null" json:"type"`
+	UserID   *uint             `gorm:"index" json:"user_id"`
+}
+
+// UserLedgerAccountCode returns the code of the user's ledger account
+func UserLedgerAccountCode(userID uint) string {
+	return fmt.Sprintf("user:%d", userID)
+}
+
+// JournalEntry groups the postings of a single balance-changing operation. The debits and
+// credits of an entry always sum up to the same amount.
+type JournalEntry struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	TransactionID *uint     `gorm:"index" json:"transaction_id"` // Transaction row recording the operation
+	Currency      string    `gorm:"size:32;not null" json:"currency"`
+	Description   string    `gorm:"size:256" json:"description"`
+	CreatedAt     time.Time `json:"created_at"`
+	Postings      []Posting `json:"postings"`
+}
+
+// Posting debits or credits a ledger account by a positive amount in minor units
+type Posting struct {
+	ID             uint            `gorm:"primaryKey" json:"id"`
+	JournalEntryID uint            `gorm:"not null;index" json:"journal_entry_id"`
+	AccountID      uint            `gorm:"not null;index" json:"account_id"`
+	Side           PostingSide     `gorm:"size:8;not null" json:"side"`
+	Amount         decimal.Decimal `gorm:"type:numeric(64,0);not null" json:"amount"`
+	CreatedAt      time.Time       `json:"created_at"`
+}
@@ -0,0 +1,18 @@
+package models
+
+import (
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// UserLimit overrides the configured transaction limits of a currency for a user. Limits are stored
+// in integer minor units of the currency, a null limit falls back to the configured one and a zero
+// limit means no limit.
+type UserLimit struct {
+	gorm.Model
+	UserID         uint                `gorm:"not null;uniqueIndex:idx_user_limit_currency,priority:1" json:"user_id"`
+	Currency       string              `gorm:"size:32;not null;uniqueIndex:idx_user_limit_currency,priority:2" json:"currency"`
+	PerTransaction decimal.NullDecimal `gorm:"type:numeric(64,0)" json:"per_transaction"`
+	Daily          decimal.NullDecimal `gorm:"type:numeric(64,0)" json:"daily"`
+	Monthly        decimal.NullDecimal `gorm:"type:numeric(64,0)" json:"monthly"`
+}
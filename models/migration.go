@@ -0,0 +1,9 @@
+package models
+
+import "time"
+
+// SchemaMigration records a one-off data migration, so that it is only ever applied once
+type SchemaMigration struct {
+	Name      string    `gorm:"primaryKey;size:64" json:"name"`
+	AppliedAt time.Time `gorm:"not null" json:"applied_at"`
+}
@@ -0,0 +1,78 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Types of the events recorded in the outbox
+const (
+	EventTransactionCreated = "transaction.created" // Payload is the created transaction
+	EventBalanceChanged     = "balance.changed"     // Payload is a BalanceChange
+)
+
+// EventTypes are all types of the events recorded in the outbox
+var EventTypes = []string{EventTransactionCreated, EventBalanceChanged}
+
+// BalanceChange is the payload of balance.changed events, carrying the vault balances once changed
+type BalanceChange struct {
+	UserID        uint            `json:"user_id"`
+	Currency      string          `json:"currency"`
+	Amount        decimal.Decimal `json:"amount"`         // Available balance in minor units
+	Held          decimal.Decimal `json:"held"`           // Balance reserved by pending holds in minor units
+	TransactionID uint            `json:"transaction_id"` // Transaction changing the balance
+}
+
+// EventPayload is stored as a JSON document and rendered as is
+type EventPayload json.RawMessage
+
+func (p EventPayload) Value() (driver.Value, error) {
+	if len(p) == 0 {
+		return "null", nil
+	}
+	return string(p), nil
+}
+
+func (p *EventPayload) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case []byte:
+		*p = append((*p)[:0], v...)
+		return nil
+	case string:
+		*p = EventPayload(v)
+		return nil
+	case nil:
+		*p = nil
+		return nil
+	default:
+		return errors.New("unsupported type for event payload")
+	}
+}
+
+func (p EventPayload) MarshalJSON() ([]byte, error) {
+	if len(p) == 0 {
+		return []byte("null"), nil
+	}
+	return p, nil
+}
+
+func (p *EventPayload) UnmarshalJSON(data []byte) error {
+	*p = append((*p)[:0], data...)
+	return nil
+}
+
+// OutboxEvent is an event recorded in the same database transaction as the change it describes,
+// so that it is dispatched if and only if the change is committed.
+type OutboxEvent struct {
+	ID           uint         `gorm:"primaryKey" json:"id"`
+	UserID       uint         `gorm:"not null;index" json:"user_id"` // User the event is about
+	Type         string       `gorm:"size:64;not null" json:"type"`
+	Payload      EventPayload `gorm:"type:jsonb;not null" json:"payload"`
+	CreatedAt    time.Time    `gorm:"not null" json:"created_at"`
+	DispatchedAt *time.Time   `gorm:"index" json:"dispatched_at,omitempty"` // When fanned out to the webhook endpoints
+	PublishedAt  *time.Time   `gorm:"index" json:"published_at,omitempty"`  // When relayed to the event publisher
+}
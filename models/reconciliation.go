@@ -0,0 +1,63 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// VaultMismatch reports a vault whose amount differs from the signed sum of its transactions
+type VaultMismatch struct {
+	UserID     uint            `json:"user_id"`
+	Currency   string          `json:"currency"`
+	Expected   decimal.Decimal `json:"expected"` // Signed sum of the transactions
+	Actual     decimal.Decimal `json:"actual"`   // Amount of the vault
+	Difference decimal.Decimal `json:"difference"`
+
+	// Range of the transactions since the last clean run, which should contain the offending ones.
+	// An empty range means the vault was changed without recording any transaction.
+	FirstTransactionID *uint      `json:"first_transaction_id"`
+	LastTransactionID  *uint      `json:"last_transaction_id"`
+	FirstTimestamp     *time.Time `json:"first_timestamp"`
+	LastTimestamp      *time.Time `json:"last_timestamp"`
+	TransactionCount   int64      `json:"transaction_count"`
+}
+
+// VaultMismatches is stored as a JSON document
+type VaultMismatches []VaultMismatch
+
+func (m VaultMismatches) Value() (driver.Value, error) {
+	if m == nil {
+		m = VaultMismatches{}
+	}
+	data, err := json.Marshal(m)
+	return string(data), err
+}
+
+func (m *VaultMismatches) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case []byte:
+		return json.Unmarshal(v, m)
+	case string:
+		return json.Unmarshal([]byte(v), m)
+	case nil:
+		*m = nil
+		return nil
+	default:
+		return errors.New("unsupported type for vault mismatches")
+	}
+}
+
+// ReconciliationRun records the result of a balance reconciliation run
+type ReconciliationRun struct {
+	gorm.Model
+	StartedAt     time.Time       `gorm:"not null;index" json:"started_at"`
+	FinishedAt    time.Time       `gorm:"not null" json:"finished_at"`
+	VaultsScanned int64           `gorm:"not null" json:"vaults_scanned"`
+	MismatchCount int             `gorm:"not null" json:"mismatch_count"`
+	Mismatches    VaultMismatches `gorm:"type:jsonb" json:"mismatches"`
+}
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// RevokedToken records the ID of a signed token which must no longer be accepted.
+// Rows can be purged once the token itself has expired.
+type RevokedToken struct {
+	ID        string    `gorm:"primaryKey;size:36" json:"id"` // JWT ID (jti) of the revoked token
+	UserID    uint      `gorm:"not null;index" json:"user_id"`
+	ExpiresAt time.Time `gorm:"not null;index" json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
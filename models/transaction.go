@@ -14,16 +14,56 @@ const (
 	Withdrawal  TransactionType = "withdrawal"
 	TransferOut TransactionType = "transfer_out"
 	TransferIn  TransactionType = "transfer_in"
+	ReversalIn  TransactionType = "reversal_in"  // Funds returned by the reversal of a debit
+	ReversalOut TransactionType = "reversal_out" // Funds taken back by the reversal of a credit
+
+	// Steps of a hold, which only change the total balance once captured
+	HoldPlaced   TransactionType = "hold"
+	HoldCaptured TransactionType = "hold_capture"
+	HoldReleased TransactionType = "hold_release"
+	HoldExpired  TransactionType = "hold_expire"
+
+	// Legs of a currency exchange
+	ExchangeOut TransactionType = "exchange_out"
+	ExchangeIn  TransactionType = "exchange_in"
+
+	Fee TransactionType = "fee" // Fee charged for the parent transaction
 )
 
+// Transaction types crediting and debiting the vault balance of the user
+var (
+	CreditTransactionTypes = []TransactionType{Deposit, TransferIn, ReversalIn, ExchangeIn}
+	DebitTransactionTypes  = []TransactionType{Withdrawal, TransferOut, ReversalOut, HoldCaptured, ExchangeOut, Fee}
+)
+
+// Sign returns 1 for the types crediting the vault balance, -1 for the types debiting it,
+// and 0 for the hold steps leaving it unchanged
+func (t TransactionType) Sign() int {
+	for _, credit := range CreditTransactionTypes {
+		if t == credit {
+			return 1
+		}
+	}
+	for _, debit := range DebitTransactionTypes {
+		if t == debit {
+			return -1
+		}
+	}
+	return 0
+}
+
 type Transaction struct {
 	gorm.Model
-	UserID         uint            `gorm:"not null;index:idx_user_type_timestamp_id,priority:1;index:idx_user_timestamp_id,priority:1" json:"user_id"`
-	CounterpartyID *uint           `json:"counterparty_id"` // Pointer allows nulls
-	Type           TransactionType `gorm:"size:16;index:idx_user_type_timestamp_id,priority:2" json:"type"`
-	Amount         decimal.Decimal `gorm:"type:numeric(64,0);not null" json:"amount"`
-	Currency       string          `gorm:"size:32;not null" json:"currency"`
-	Memo           string          `gorm:"size:256" json:"memo,omitempty"`
-	Timestamp      time.Time       `gorm:"autoCreateTime:milli;index:idx_user_type_timestamp_id,priority:3;index:idx_user_timestamp_id,priority:2" json:"timestamp"`
-	ID             uint            `gorm:"primaryKey;index:idx_user_type_timestamp_id,priority:4;index:idx_user_timestamp_id,priority:3"`
+	UserID                uint                `gorm:"not null;index:idx_user_type_timestamp_id,priority:1;index:idx_user_timestamp_id,priority:1;index:idx_user_currency_timestamp_id,priority:1;index:idx_user_counterparty_timestamp_id,priority:1" json:"user_id"`
+	CounterpartyID        *uint               `gorm:"index:idx_user_counterparty_timestamp_id,priority:2" json:"counterparty_id"` // Pointer allows nulls
+	Type                  TransactionType     `gorm:"size:16;index:idx_user_type_timestamp_id,priority:2" json:"type"`
+	Amount                decimal.Decimal     `gorm:"type:numeric(64,0);not null" json:"amount"`
+	Currency              string              `gorm:"size:32;not null;index:idx_user_currency_timestamp_id,priority:2" json:"currency"`
+	Memo                  string              `gorm:"size:256" json:"memo,omitempty"`
+	Reference             string              `gorm:"size:36;index" json:"reference,omitempty"`             // Reference shared by both legs of a transfer
+	OriginalTransactionID *uint               `gorm:"uniqueIndex" json:"original_transaction_id,omitempty"` // Transaction compensated by a reversal, which can be reversed only once
+	ParentTransactionID   *uint               `gorm:"index" json:"parent_transaction_id,omitempty"`         // Transaction this one is linked to, e.g. the exchange_out leg of an exchange_in
+	Rate                  decimal.NullDecimal `gorm:"type:numeric" json:"rate,omitempty"`                   // Applied exchange rate of exchange legs
+	Timestamp             time.Time           `gorm:"autoCreateTime:milli;index:idx_user_type_timestamp_id,priority:3;index:idx_user_timestamp_id,priority:2;index:idx_user_currency_timestamp_id,priority:3;index:idx_user_counterparty_timestamp_id,priority:3" json:"timestamp"`
+	ID                    uint                `gorm:"primaryKey;index:idx_user_type_timestamp_id,priority:4;index:idx_user_timestamp_id,priority:3;index:idx_user_currency_timestamp_id,priority:4;index:idx_user_counterparty_timestamp_id,priority:4"`
 }
@@ -7,10 +7,34 @@ import (
 	"gorm.io/gorm"
 )
 
+// Role determines the permissions of a user
+type Role string
+
+const (
+	RoleUser    Role = "user"    // Customer acting on their own wallet only
+	RoleSupport Role = "support" // Staff viewing the accounts of any user
+	RoleFinance Role = "finance" // Staff also moving funds on behalf of the platform, e.g. reversals
+	RoleAdmin   Role = "admin"   // Staff with every permission, including the management of roles
+)
+
+// Roles are all the roles, from the least to the most privileged
+var Roles = []Role{RoleUser, RoleSupport, RoleFinance, RoleAdmin}
+
 type User struct {
 	gorm.Model
 	Name  string `gorm:"unique;not null" json:"name"`
 	Email string `gorm:"unique;not null" json:"email"`
+	Role  Role   `gorm:"size:16;not null;default:user" json:"role"`
+
+	PasswordHash string `gorm:"size:72" json:"-"` // bcrypt hash of the login password
+
+	// Service accounts have no password and only authenticate with API keys
+	ServiceAccount bool `gorm:"not null;default:false" json:"service_account"`
+
+	// Two-factor authentication with time-based one-time passwords (TOTP)
+	TOTPSecret   string `gorm:"size:64" json:"-"`                           // Base32 secret, pending until enabled
+	TOTPEnabled  bool   `gorm:"not null;default:false" json:"totp_enabled"` // Whether the secret was confirmed
+	TOTPLastStep int64  `gorm:"not null;default:0" json:"-"`                // Time step of the last accepted code, which can not be reused
 }
 
 type FakeUserGenerator struct {
@@ -24,5 +48,6 @@ func (generator *FakeUserGenerator) Generate() *User {
 		Model: gorm.Model{ID: uint(id)},
 		Name:  fmt.Sprintf("testuser_%d", id),
 		Email: fmt.Sprintf("testuser_%d@example.com", id),
+		Role:  RoleUser,
 	}
 }
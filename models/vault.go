@@ -5,10 +5,14 @@ import (
 	"gorm.io/gorm"
 )
 
+// Vault holds the balance of a user in a single currency. The available amount plus the held
+// amount is a cached balance of the user's ledger account, which is updated along with every
+// journal entry posted to the account.
 type Vault struct {
 	gorm.Model
 	UserID   uint            `gorm:"index;uniqueIndex:idx_user_currency;not null" json:"user_id"`
 	Currency string          `gorm:"size:32;uniqueIndex:idx_user_currency;not null" json:"currency"`
-	Amount   decimal.Decimal `gorm:"type:numeric(64,0);default:0" json:"amount"`
+	Amount   decimal.Decimal `gorm:"type:numeric(64,0);default:0" json:"amount"`        // Available balance
+	Held     decimal.Decimal `gorm:"type:numeric(64,0);not null;default:0" json:"held"` // Balance reserved by pending holds
 	User     User            `gorm:"foreignKey:UserID"`
 }
@@ -0,0 +1,62 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type WebhookDeliveryStatus string
+
+const (
+	WebhookDeliveryPending   WebhookDeliveryStatus = "pending"
+	WebhookDeliverySucceeded WebhookDeliveryStatus = "succeeded"
+	WebhookDeliveryFailed    WebhookDeliveryStatus = "failed"
+)
+
+// WebhookEndpoint is a URL registered by a user to be notified of the events about the user
+type WebhookEndpoint struct {
+	gorm.Model
+	UserID uint   `gorm:"not null;index" json:"user_id"`
+	URL    string `gorm:"size:2048;not null" json:"url"`
+	Secret string `gorm:"size:64;not null" json:"-"` // Secret signing the deliveries
+	Events string `gorm:"size:256" json:"-"`         // Comma separated event types, empty for all types
+}
+
+// EventTypes returns the subscribed event types, or nil if subscribed to all types
+func (e *WebhookEndpoint) EventTypes() []string {
+	if e.Events == "" {
+		return nil
+	}
+	return strings.Split(e.Events, ",")
+}
+
+// Subscribes returns whether the endpoint is notified of events of the type
+func (e *WebhookEndpoint) Subscribes(eventType string) bool {
+	if e.Events == "" {
+		return true
+	}
+	for _, t := range e.EventTypes() {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDelivery is the delivery of an outbox event to a webhook endpoint, retried until
+// the endpoint acknowledges it or the attempts are exhausted.
+type WebhookDelivery struct {
+	gorm.Model
+	EndpointID     uint                  `gorm:"not null;uniqueIndex:idx_webhook_endpoint_event,priority:1" json:"endpoint_id"`
+	EventID        uint                  `gorm:"not null;uniqueIndex:idx_webhook_endpoint_event,priority:2" json:"event_id"`
+	EventType      string                `gorm:"size:64;not null" json:"event_type"`
+	Status         WebhookDeliveryStatus `gorm:"size:16;not null;index:idx_webhook_status_next_attempt,priority:1" json:"status"`
+	Attempts       int                   `gorm:"not null;default:0" json:"attempts"`
+	NextAttemptAt  time.Time             `gorm:"not null;index:idx_webhook_status_next_attempt,priority:2" json:"next_attempt_at"`
+	ResponseStatus int                   `json:"response_status,omitempty"` // HTTP status of the last attempt
+	LastError      string                `gorm:"size:512" json:"last_error,omitempty"`
+	DeliveredAt    *time.Time            `json:"delivered_at,omitempty"`
+	Event          OutboxEvent           `gorm:"foreignKey:EventID" json:"-"`
+}
@@ -1,27 +1,218 @@
 package routes
 
 import (
+	"log"
+
 	"github.com/gin-gonic/gin"
+	"github.com/wanliqun/go-wallet-app/config"
 	"github.com/wanliqun/go-wallet-app/controllers"
 	"github.com/wanliqun/go-wallet-app/middlewares"
 	"github.com/wanliqun/go-wallet-app/services"
 	"gorm.io/gorm"
 )
 
-func SetupRouter(router *gin.Engine, db *gorm.DB) {
+func SetupRouter(router *gin.Engine, db *gorm.DB, events services.ISubscribable) {
 	walletService := services.NewWalletService(db)
 	userService := services.NewUserService(db)
+	authService := services.NewAuthService(db, config.AppConfig.Auth)
+	apiKeyService := services.NewAPIKeyService(db)
+	currencyService := services.NewCurrencyService(db)
+
+	// Bound the time the wallet and user operations may spend on the database
+	walletService.Timeouts = config.AppConfig.Database.Timeouts
+	userService.Timeouts = config.AppConfig.Database.Timeouts
+
+	// Seed the currency registry and let the request validators consult it
+	if err := currencyService.SeedFromConfig(config.AppConfig.Concurrencies); err != nil {
+		log.Fatalf("failed to seed currencies: %v", err)
+	}
+	controllers.SetCurrencyRegistry(currencyService)
 
-	router.Use(middlewares.AuthMiddleware(userService))
+	// Charge withdrawals and transfers according to the fee schedules
+	feeService, err := services.NewFeeServiceFromConfig(currencyService, config.AppConfig.Fees)
+	if err != nil {
+		log.Fatalf("failed to load fee schedules: %v", err)
+	}
+	walletService.Fees = feeService
+
+	// Enforce the transaction limits on withdrawals, transfers and holds
+	limitService, err := services.NewLimitServiceFromConfig(db, currencyService, config.AppConfig.Limits)
+	if err != nil {
+		log.Fatalf("failed to load transaction limits: %v", err)
+	}
+	walletService.Limits = limitService
+
+	// Grant the admin role to the configured administrators
+	if err := userService.SeedAdmins(config.AppConfig.Admin.Users); err != nil {
+		log.Fatalf("failed to seed admin users: %v", err)
+	}
+
+	// Post the vault balances which predate the ledger as opening balances
+	if _, err := services.NewLedgerService(db).BackfillOpeningBalances(); err != nil {
+		log.Fatalf("failed to backfill ledger opening balances: %v", err)
+	}
+
+	authMiddleware := middlewares.AuthMiddleware(authService, nil)
+	// Also accept API keys on the routes restricting them to a scope or a permission
+	apiKeyAuthMiddleware := middlewares.AuthMiddleware(authService, apiKeyService)
+	authorizer := services.NewRoleAuthorizer(services.DefaultRolePermissions)
+	requirePermission := func(permission services.Permission) gin.HandlerFunc {
+		return middlewares.PermissionMiddleware(authorizer, permission)
+	}
+	requireScope := func(scope services.Scope) gin.HandlerFunc {
+		return middlewares.ScopeMiddleware(scope)
+	}
+
+	// Throttle each client, credentials and money-moving writes drawing on budgets of their own
+	rateLimitStore := services.NewMemoryRateLimitStore()
+	rateLimitConf := config.AppConfig.RateLimit
+	limitCredentials := middlewares.RateLimitMiddleware(rateLimitStore, "credentials", services.RateBudget{
+		Limit: rateLimitConf.AuthLimit, Period: rateLimitConf.AuthPeriod,
+	})
+	limitReads := middlewares.RateLimitMiddleware(rateLimitStore, "reads", services.RateBudget{
+		Limit: rateLimitConf.ReadLimit, Period: rateLimitConf.ReadPeriod,
+	})
+	limitWrites := middlewares.RateLimitMiddleware(rateLimitStore, "writes", services.RateBudget{
+		Limit: rateLimitConf.WriteLimit, Period: rateLimitConf.WritePeriod,
+	})
+
+	router.Use(middlewares.RequestIDMiddleware())
 	router.Use(middlewares.CorsMiddleware())
+	// Limit each IP before authenticating its requests, so that invalid tokens and keys are limited too
+	router.Use(middlewares.RateLimitMiddleware(rateLimitStore, "ips", services.RateBudget{
+		Limit: rateLimitConf.IPLimit, Period: rateLimitConf.IPPeriod,
+	}))
+
+	authController := controllers.NewAuthController(authService)
+	authRouter := router.Group("/auth")
+	{
+		authRouter.POST("/login", limitCredentials, authController.Login)
+		authRouter.POST("/refresh", limitCredentials, authController.Refresh)
+		authRouter.POST("/logout", authMiddleware, limitReads, authController.Logout)
+	}
+
+	userController := controllers.NewUserController(userService)
+	router.POST("/users", limitCredentials, userController.Register)
+	userRouter := router.Group("/users/me", authMiddleware, limitReads)
+	{
+		userRouter.GET("", userController.GetMe)
+		userRouter.PATCH("", userController.UpdateMe)
+		userRouter.DELETE("", userController.DeleteMe)
+	}
+
+	apiKeyController := controllers.NewAPIKeyController(apiKeyService, userService)
+	apiKeyRouter := userRouter.Group("/api-keys")
+	{
+		apiKeyRouter.POST("", apiKeyController.CreateAPIKey)
+		apiKeyRouter.GET("", apiKeyController.ListAPIKeys)
+		apiKeyRouter.GET("/:key_id", apiKeyController.GetAPIKey)
+		apiKeyRouter.PATCH("/:key_id", apiKeyController.UpdateAPIKey)
+		apiKeyRouter.DELETE("/:key_id", apiKeyController.DeleteAPIKey)
+	}
+
+	// Require a two-factor code for withdrawals, risky transfers and keys granted the transfer scope
+	twoFactorService, err := services.NewTwoFactorServiceFromConfig(db, currencyService, config.AppConfig.TwoFactor)
+	if err != nil {
+		log.Fatalf("failed to load two-factor settings: %v", err)
+	}
+	apiKeyController.TwoFactor = twoFactorService
+	twoFactorController := controllers.NewTwoFactorController(twoFactorService)
+	twoFactorRouter := userRouter.Group("/2fa")
+	{
+		twoFactorRouter.POST("", twoFactorController.Enroll)
+		twoFactorRouter.POST("/confirm", twoFactorController.Confirm)
+		twoFactorRouter.POST("/recovery-codes", twoFactorController.RegenerateRecoveryCodes)
+		twoFactorRouter.DELETE("", twoFactorController.Disable)
+	}
 
 	walletController := controllers.NewWalletController(walletService, userService)
-	walletRouter := router.Group("/wallet")
+	walletController.TwoFactor = twoFactorService
+	holdService := services.NewHoldService(db, config.AppConfig.Holds)
+	holdService.Fees = feeService
+	holdService.Limits = limitService
+	holdController := controllers.NewHoldController(holdService, userService)
+	holdController.TwoFactor = twoFactorService
+
+	rateProvider, err := services.NewStaticRateProviderFromConfig(config.AppConfig.Exchange.Rates)
+	if err != nil {
+		log.Fatalf("failed to load exchange rates: %v", err)
+	}
+	exchangeService := services.NewExchangeService(db, currencyService, rateProvider, config.AppConfig.Exchange)
+	exchangeController := controllers.NewExchangeController(exchangeService)
+	feeController := controllers.NewFeeController(feeService)
+	limitController := controllers.NewLimitController(limitService, userService)
+	streamController := controllers.NewStreamController(walletService, events)
+	statementController := controllers.NewStatementController(services.NewStatementService(db), userService)
+	webhookController := controllers.NewWebhookController(services.NewWebhookService(db, config.AppConfig.Webhooks))
+
+	walletRouter := router.Group("/wallet", authMiddleware, limitReads)
 	{
-		walletRouter.POST("/deposit", walletController.Deposit)
-		walletRouter.POST("/withdraw", walletController.Withdraw)
-		walletRouter.POST("/transfer", walletController.Transfer)
-		walletRouter.GET("/balances", walletController.GetBalances)
-		walletRouter.GET("/transactions", walletController.GetTransactionHistory)
+		walletRouter.GET("/statements", statementController.GetStatement)
+		walletRouter.GET("/stream", streamController.Stream)
+		walletRouter.GET("/fees", feeController.EstimateFee)
+		walletRouter.GET("/limits", limitController.GetLimits)
+
+		walletRouter.POST("/exchange/quotes", exchangeController.Quote)
+		walletRouter.GET("/holds/:id", holdController.GetHold)
+
+		walletRouter.POST("/webhooks", webhookController.CreateWebhook)
+		walletRouter.GET("/webhooks", webhookController.ListWebhooks)
+		walletRouter.DELETE("/webhooks/:id", webhookController.DeleteWebhook)
+		walletRouter.GET("/webhooks/:id/deliveries", webhookController.ListDeliveries)
+	}
+
+	walletWriteRouter := router.Group("/wallet", authMiddleware, limitWrites)
+	{
+		walletWriteRouter.POST("/deposit", walletController.Deposit)
+		walletWriteRouter.POST("/withdraw", walletController.Withdraw)
+		walletWriteRouter.POST("/exchange", exchangeController.Exchange)
+		walletWriteRouter.POST("/holds", holdController.PlaceHold)
+		walletWriteRouter.POST("/holds/:id/capture", holdController.CaptureHold)
+		walletWriteRouter.POST("/holds/:id/release", holdController.ReleaseHold)
+	}
+
+	apiKeyWalletRouter := router.Group("/wallet", apiKeyAuthMiddleware)
+	{
+		apiKeyWalletRouter.POST("/transfer", requireScope(services.ScopeTransfer), limitWrites, walletController.Transfer)
+		apiKeyWalletRouter.GET("/balances", requireScope(services.ScopeReadBalances), limitReads, walletController.GetBalances)
+		apiKeyWalletRouter.GET("/transactions", requireScope(services.ScopeReadTransactions), limitReads, walletController.GetTransactionHistory)
+		apiKeyWalletRouter.GET("/transactions/export", requireScope(services.ScopeReadTransactions), limitReads, walletController.ExportTransactions)
+		apiKeyWalletRouter.GET("/transactions/:id", requireScope(services.ScopeReadTransactions), limitReads, walletController.GetTransaction)
+	}
+
+	currencyController := controllers.NewCurrencyController(currencyService)
+	router.GET("/currencies", limitReads, currencyController.ListCurrencies)
+
+	reconciliationController := controllers.NewReconciliationController(services.NewReconciliationService(db))
+
+	adminRouter := router.Group("/admin", apiKeyAuthMiddleware, limitReads)
+	{
+		currencyRouter := adminRouter.Group("/currencies", requirePermission(services.PermManageCurrencies))
+		currencyRouter.GET("", currencyController.ListAllCurrencies)
+		currencyRouter.POST("", currencyController.CreateCurrency)
+		currencyRouter.PATCH("/:code", currencyController.UpdateCurrency)
+
+		adminRouter.POST("/transactions/:id/reverse", requirePermission(services.PermReverse), walletController.Reverse)
+
+		adminRouter.GET("/users/:id", requirePermission(services.PermViewUsers), userController.GetUser)
+		adminRouter.PUT("/users/:id/role", requirePermission(services.PermManageRoles), userController.SetUserRole)
+		adminRouter.GET("/users/:id/balances", requirePermission(services.PermViewBalances), walletController.GetUserBalances)
+		adminRouter.GET("/users/:id/transactions", requirePermission(services.PermViewTransactions), walletController.GetUserTransactionHistory)
+
+		adminRouter.GET("/users/:id/limits", requirePermission(services.PermViewLimits), limitController.GetUserLimits)
+		adminRouter.PUT("/users/:id/limits/:currency", requirePermission(services.PermManageLimits), limitController.SetUserLimit)
+		adminRouter.DELETE("/users/:id/limits/:currency", requirePermission(services.PermManageLimits), limitController.DeleteUserLimit)
+
+		serviceAccountRouter := adminRouter.Group("/service-accounts", requirePermission(services.PermManageServices))
+		serviceAccountRouter.POST("", userController.CreateServiceAccount)
+		serviceAccountRouter.POST("/:id/api-keys", apiKeyController.CreateAPIKey)
+		serviceAccountRouter.GET("/:id/api-keys", apiKeyController.ListAPIKeys)
+		serviceAccountRouter.GET("/:id/api-keys/:key_id", apiKeyController.GetAPIKey)
+		serviceAccountRouter.PATCH("/:id/api-keys/:key_id", apiKeyController.UpdateAPIKey)
+		serviceAccountRouter.DELETE("/:id/api-keys/:key_id", apiKeyController.DeleteAPIKey)
+
+		reconciliationRouter := adminRouter.Group("/reconciliation", requirePermission(services.PermReconcile))
+		reconciliationRouter.POST("/runs", reconciliationController.Reconcile)
+		reconciliationRouter.GET("/runs", reconciliationController.ListRuns)
 	}
 }
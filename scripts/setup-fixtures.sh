@@ -29,17 +29,29 @@ echo "Populating database with test data..."
 # Build the SQL commands
 SQL_COMMANDS=""
 
+# Amounts are stored in minor units, so whole amounts are scaled by the
+# precision of their currency (BTC has 8 decimal places, ETH has 18)
+declare -A PRECISIONS=([BTC]=8 [ETH]=18)
+
+minor_units() {
+  local amount=$1 currency=$2
+  printf '%s%0*d' "$amount" "${PRECISIONS[$currency]}" 0
+}
+
+# bcrypt hash of the fixture password "password"
+PASSWORD_HASH='$2a$10$SWbAknOpwNUlM44aEPQkI.X5voLFD9Qm8vHdB1GUNjwemr2OE9DE2'
+
 # Insert user data (10 users)
 SQL_COMMANDS+="
 -- Insert user data
-INSERT INTO users (name, email, created_at, updated_at) VALUES
+INSERT INTO users (name, email, password_hash, created_at, updated_at) VALUES
 "
 
 for i in {1..10}; do
   if [ $i -lt 10 ]; then
-    SQL_COMMANDS+="('testuser_$i', 'testuser_$i@example.com', NOW(), NOW()),"
+    SQL_COMMANDS+="('testuser_$i', 'testuser_$i@example.com', '$PASSWORD_HASH', NOW(), NOW()),"
   else
-    SQL_COMMANDS+="('testuser_$i', 'testuser_$i@example.com', NOW(), NOW());"
+    SQL_COMMANDS+="('testuser_$i', 'testuser_$i@example.com', '$PASSWORD_HASH', NOW(), NOW());"
   fi
 done
 
@@ -50,8 +62,8 @@ INSERT INTO vaults (user_id, currency, amount, created_at, updated_at) VALUES
 "
 
 for i in {1..10}; do
-  BTC_AMOUNT=$((1000 + $i * 100))
-  ETH_AMOUNT=$((500 + $i * 100))
+  BTC_AMOUNT=$(minor_units $((1000 + $i * 100)) BTC)
+  ETH_AMOUNT=$(minor_units $((500 + $i * 100)) ETH)
   if [ $i -lt 10 ]; then
     SQL_COMMANDS+="
     ($i, 'BTC', $BTC_AMOUNT, NOW(), NOW()),
@@ -98,6 +110,8 @@ for i in {1..10}; do
       CURRENCY="BTC"
     fi
 
+    AMOUNT=$(minor_units $AMOUNT $CURRENCY)
+
     # Add transaction values
     if [ "$TYPE" = "transfer_out" ]; then
       # Transfer out transaction for sender
@@ -117,6 +131,12 @@ TRANSACTION_VALUES+=";"
 
 SQL_COMMANDS+="$TRANSACTION_VALUES"
 
+# The fixtures are already in minor units, so record the migration as applied
+SQL_COMMANDS+="
+-- Record the minor units migration
+INSERT INTO schema_migrations (name, applied_at) VALUES ('minor_units', NOW()) ON CONFLICT DO NOTHING;
+"
+
 # Execute the SQL commands
 echo "Executing batch insert..."
 echo "$SQL_COMMANDS" | docker exec -i postgres13 psql -U "$DB_USER" -d "$DB_NAME"
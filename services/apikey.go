@@ -0,0 +1,302 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/wanliqun/go-wallet-app/apperrors"
+	"github.com/wanliqun/go-wallet-app/models"
+	"gorm.io/gorm"
+)
+
+// APIKeyPrefix starts every API key, telling them apart from signed user tokens
+const APIKeyPrefix = "gwk_"
+
+const (
+	apiKeyIDSize     = 6  // Random bytes of the public part looking the key up
+	apiKeySecretSize = 32 // Random bytes of the secret part
+
+	// apiKeyUsageInterval is how often the last use of a key is recorded, so that busy keys
+	// do not write on every request
+	apiKeyUsageInterval = time.Minute
+)
+
+// Scope is an operation which an API key is allowed on the wallet of its user. Scopes named after
+// a permission also allow the key to exercise the permission, if the role of its user grants it.
+type Scope string
+
+const (
+	ScopeReadBalances     Scope = "balances:read"     // View the balances, and those of any user with the permission
+	ScopeReadTransactions Scope = "transactions:read" // View the transactions, and those of any user with the permission
+	ScopeTransfer         Scope = "transfer:write"    // Transfer funds to other users
+)
+
+// Scopes are all the scopes which can be granted to API keys
+var Scopes = []Scope{ScopeReadBalances, ScopeReadTransactions, ScopeTransfer}
+
+var (
+	ErrInvalidAPIKey     = apperrors.New(apperrors.InvalidAPIKey, "invalid API key")
+	ErrAPIKeyExpired     = apperrors.New(apperrors.APIKeyExpired, "API key expired")
+	ErrInsufficientScope = apperrors.New(apperrors.InsufficientScope, "API key lacks the required scope")
+	ErrAPIKeyNotFound    = apperrors.New(apperrors.APIKeyNotFound, "API key not found")
+	ErrInvalidScope      = apperrors.New(apperrors.InvalidScope, "invalid scope")
+
+	_ IAPIKeyService = &APIKeyService{}
+)
+
+// APIKeyUpdate holds the optional fields of a key to update, nil fields are left unchanged
+type APIKeyUpdate struct {
+	Name      *string
+	Scopes    []Scope
+	ExpiresAt *time.Time
+}
+
+type IAPIKeyService interface {
+	// CreateAPIKey issues a key for the user, returning the key which is only disclosed once. The
+	// step-up check is run if the key is granted the transfer scope.
+	CreateAPIKey(ctx context.Context, userID uint, name string, scopes []Scope, expiresAt *time.Time,
+		stepUp StepUpCheck) (*models.APIKey, string, error)
+	ListAPIKeys(ctx context.Context, userID uint) ([]models.APIKey, error)
+	GetAPIKey(ctx context.Context, userID, keyID uint) (*models.APIKey, error)
+	// UpdateAPIKey updates the key, running the step-up check if it is granted the transfer scope
+	UpdateAPIKey(ctx context.Context, userID, keyID uint, update APIKeyUpdate, stepUp StepUpCheck) (*models.APIKey, error)
+	DeleteAPIKey(ctx context.Context, userID, keyID uint) error
+	// Authenticate returns the key and its user, recording the use of the key
+	Authenticate(ctx context.Context, key string) (*models.APIKey, *models.User, error)
+}
+
+// APIKeyService represents the service issuing and authenticating the API keys of users and service accounts
+type APIKeyService struct {
+	DB *gorm.DB
+}
+
+func NewAPIKeyService(db *gorm.DB) *APIKeyService {
+	return &APIKeyService{DB: db}
+}
+
+// IsAPIKey reports whether the bearer token is an API key rather than a signed user token
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, APIKeyPrefix)
+}
+
+// IsValidScope reports whether the scope is one of the known scopes
+func IsValidScope(scope Scope) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateAPIKey issues the key, verifying the step-up authentication of its creator within the same
+// transaction if it is granted the transfer scope: its transfers are exempt from step-up, so that a
+// stolen access token must not be enough to issue one.
+func (s *APIKeyService) CreateAPIKey(ctx context.Context, userID uint, name string, scopes []Scope,
+	expiresAt *time.Time, stepUp StepUpCheck) (*models.APIKey, string, error) {
+	joined, err := joinScopes(scopes)
+	if err != nil {
+		return nil, "", err
+	}
+
+	key, prefix, err := generateAPIKey()
+	if err != nil {
+		return nil, "", err
+	}
+
+	apiKey := models.APIKey{
+		UserID:    userID,
+		Name:      name,
+		Prefix:    prefix,
+		KeyHash:   hashAPIKey(key),
+		Scopes:    joined,
+		ExpiresAt: expiresAt,
+	}
+	err = s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if grantsScope(scopes, ScopeTransfer) {
+			if err := checkStepUp(stepUp, tx); err != nil {
+				return err
+			}
+		}
+		return tx.Create(&apiKey).Error
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return &apiKey, key, nil
+}
+
+// ListAPIKeys returns the keys of the user, including the expired ones
+func (s *APIKeyService) ListAPIKeys(ctx context.Context, userID uint) ([]models.APIKey, error) {
+	var apiKeys []models.APIKey
+	err := s.DB.WithContext(ctx).Where("user_id = ?", userID).Order("id").Find(&apiKeys).Error
+	return apiKeys, err
+}
+
+func (s *APIKeyService) GetAPIKey(ctx context.Context, userID, keyID uint) (*models.APIKey, error) {
+	var apiKey models.APIKey
+	err := s.DB.WithContext(ctx).Where("id = ? AND user_id = ?", keyID, userID).First(&apiKey).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAPIKeyNotFound
+		}
+		return nil, err
+	}
+	return &apiKey, nil
+}
+
+// UpdateAPIKey renames the key, changes its scopes or extends its expiry, taking effect on its next use.
+// Granting the transfer scope to a key lacking it requires step-up authentication, as on creation.
+func (s *APIKeyService) UpdateAPIKey(
+	ctx context.Context, userID, keyID uint, update APIKeyUpdate, stepUp StepUpCheck) (*models.APIKey, error) {
+	apiKey, err := s.GetAPIKey(ctx, userID, keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	updates := map[string]interface{}{}
+	if update.Name != nil {
+		updates["name"] = *update.Name
+	}
+	if update.Scopes != nil {
+		joined, err := joinScopes(update.Scopes)
+		if err != nil {
+			return nil, err
+		}
+		updates["scopes"] = joined
+	}
+	if update.ExpiresAt != nil {
+		updates["expires_at"] = *update.ExpiresAt
+	}
+	if len(updates) == 0 {
+		return apiKey, nil
+	}
+
+	err = s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if grantsScope(update.Scopes, ScopeTransfer) && !apiKey.HasScope(string(ScopeTransfer)) {
+			if err := checkStepUp(stepUp, tx); err != nil {
+				return err
+			}
+		}
+		return tx.Model(apiKey).Updates(updates).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.GetAPIKey(ctx, userID, keyID)
+}
+
+// DeleteAPIKey revokes the key, which is refused from then on
+func (s *APIKeyService) DeleteAPIKey(ctx context.Context, userID, keyID uint) error {
+	result := s.DB.WithContext(ctx).Where("id = ? AND user_id = ?", keyID, userID).Delete(&models.APIKey{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// Authenticate looks the key up by its public prefix and compares the hash of the whole key in
+// constant time. Revoked and unknown keys are refused alike, expired keys are told apart.
+func (s *APIKeyService) Authenticate(ctx context.Context, key string) (*models.APIKey, *models.User, error) {
+	prefix, ok := apiKeyPrefix(key)
+	if !ok {
+		return nil, nil, ErrInvalidAPIKey
+	}
+
+	db := s.DB.WithContext(ctx)
+
+	var apiKey models.APIKey
+	if err := db.Where("prefix = ?", prefix).First(&apiKey).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrInvalidAPIKey
+		}
+		return nil, nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(hashAPIKey(key)), []byte(apiKey.KeyHash)) != 1 {
+		return nil, nil, ErrInvalidAPIKey
+	}
+
+	now := time.Now()
+	if apiKey.Expired(now) {
+		return nil, nil, ErrAPIKeyExpired
+	}
+
+	var user models.User
+	if err := db.First(&user, apiKey.UserID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrUserNotFound
+		}
+		return nil, nil, err
+	}
+
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) >= apiKeyUsageInterval {
+		if err := db.Model(&apiKey).UpdateColumn("last_used_at", now).Error; err != nil {
+			return nil, nil, err
+		}
+		apiKey.LastUsedAt = &now
+	}
+
+	return &apiKey, &user, nil
+}
+
+// joinScopes validates the scopes and joins them without duplicates
+func joinScopes(scopes []Scope) (string, error) {
+	joined := make([]string, 0, len(scopes))
+	seen := make(map[Scope]bool, len(scopes))
+	for _, scope := range scopes {
+		if !IsValidScope(scope) {
+			return "", ErrInvalidScope
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			joined = append(joined, string(scope))
+		}
+	}
+	return strings.Join(joined, ","), nil
+}
+
+// grantsScope reports whether the scope is among the scopes
+func grantsScope(scopes []Scope, scope Scope) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// generateAPIKey generates a key of the form gwk_<id>_<secret>, returning the key and its prefix gwk_<id>
+func generateAPIKey() (string, string, error) {
+	buf := make([]byte, apiKeyIDSize+apiKeySecretSize)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+
+	prefix := APIKeyPrefix + hex.EncodeToString(buf[:apiKeyIDSize])
+	return prefix + "_" + hex.EncodeToString(buf[apiKeyIDSize:]), prefix, nil
+}
+
+// apiKeyPrefix returns the prefix of a well-formed key
+func apiKeyPrefix(key string) (string, bool) {
+	prefixLen := len(APIKeyPrefix) + 2*apiKeyIDSize
+	if !IsAPIKey(key) || len(key) != prefixLen+1+2*apiKeySecretSize || key[prefixLen] != '_' {
+		return "", false
+	}
+	return key[:prefixLen], true
+}
+
+// hashAPIKey returns the hex encoded SHA-256 of the key. Unlike passwords, keys carry enough
+// entropy not to need a slow hash, which would cost every request.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
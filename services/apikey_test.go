@@ -0,0 +1,144 @@
+package services_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wanliqun/go-wallet-app/models"
+	"github.com/wanliqun/go-wallet-app/services"
+	"gorm.io/gorm"
+)
+
+func TestAPIKeys(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+
+	user := userGenerator.Generate()
+	otherUser := userGenerator.Generate()
+	tx.CreateInBatches([]*models.User{user, otherUser}, 2)
+
+	apiKeyService := services.NewAPIKeyService(tx)
+
+	t.Run("should issue hashed keys and authenticate them", func(t *testing.T) {
+		apiKey, key, err := apiKeyService.CreateAPIKey(ctx, user.ID, "reporting",
+			[]services.Scope{services.ScopeReadBalances, services.ScopeReadTransactions, services.ScopeReadBalances}, nil, nil)
+		assert.NoError(t, err)
+		assert.True(t, strings.HasPrefix(key, apiKey.Prefix+"_"))
+		assert.NotContains(t, apiKey.KeyHash, key)
+		assert.Equal(t, []string{"balances:read", "transactions:read"}, apiKey.ScopeList())
+		assert.Nil(t, apiKey.LastUsedAt)
+
+		authenticated, authUser, err := apiKeyService.Authenticate(ctx, key)
+		assert.NoError(t, err)
+		assert.Equal(t, apiKey.ID, authenticated.ID)
+		assert.Equal(t, user.ID, authUser.ID)
+		assert.True(t, authenticated.HasScope(string(services.ScopeReadBalances)))
+		assert.False(t, authenticated.HasScope(string(services.ScopeTransfer)))
+
+		// The use is recorded once per interval
+		stored, err := apiKeyService.GetAPIKey(ctx, user.ID, apiKey.ID)
+		assert.NoError(t, err)
+		assert.NotNil(t, stored.LastUsedAt)
+
+		again, _, err := apiKeyService.Authenticate(ctx, key)
+		assert.NoError(t, err)
+		assert.True(t, stored.LastUsedAt.Equal(*again.LastUsedAt))
+
+		// A key differing in its secret part is refused
+		forged := key[:len(key)-1] + "0"
+		if forged == key {
+			forged = key[:len(key)-1] + "1"
+		}
+		_, _, err = apiKeyService.Authenticate(ctx, forged)
+		assert.ErrorIs(t, err, services.ErrInvalidAPIKey)
+
+		_, _, err = apiKeyService.Authenticate(ctx, "gwk_malformed")
+		assert.ErrorIs(t, err, services.ErrInvalidAPIKey)
+	})
+
+	t.Run("should refuse invalid scopes", func(t *testing.T) {
+		_, _, err := apiKeyService.CreateAPIKey(ctx, user.ID, "admin", []services.Scope{"users:roles"}, nil, nil)
+		assert.ErrorIs(t, err, services.ErrInvalidScope)
+	})
+
+	t.Run("should refuse expired keys", func(t *testing.T) {
+		expiresAt := time.Now().Add(time.Hour)
+		apiKey, key, err := apiKeyService.CreateAPIKey(ctx, user.ID, "batch", []services.Scope{services.ScopeTransfer}, &expiresAt, nil)
+		assert.NoError(t, err)
+
+		_, _, err = apiKeyService.Authenticate(ctx, key)
+		assert.NoError(t, err)
+
+		tx.Model(apiKey).Update("expires_at", time.Now().Add(-time.Minute))
+		_, _, err = apiKeyService.Authenticate(ctx, key)
+		assert.ErrorIs(t, err, services.ErrAPIKeyExpired)
+
+		// Extending the expiry restores the key
+		expiresAt = time.Now().Add(24 * time.Hour)
+		_, err = apiKeyService.UpdateAPIKey(ctx, user.ID, apiKey.ID, services.APIKeyUpdate{ExpiresAt: &expiresAt}, nil)
+		assert.NoError(t, err)
+
+		_, _, err = apiKeyService.Authenticate(ctx, key)
+		assert.NoError(t, err)
+	})
+
+	t.Run("should update, list and revoke the keys of their user only", func(t *testing.T) {
+		apiKey, key, err := apiKeyService.CreateAPIKey(ctx, user.ID, "payouts", []services.Scope{services.ScopeTransfer}, nil, nil)
+		assert.NoError(t, err)
+
+		_, err = apiKeyService.UpdateAPIKey(ctx, otherUser.ID, apiKey.ID, services.APIKeyUpdate{}, nil)
+		assert.ErrorIs(t, err, services.ErrAPIKeyNotFound)
+
+		name := "payouts-v2"
+		updated, err := apiKeyService.UpdateAPIKey(ctx, user.ID, apiKey.ID, services.APIKeyUpdate{
+			Name:   &name,
+			Scopes: []services.Scope{services.ScopeReadBalances},
+		}, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, name, updated.Name)
+		assert.Equal(t, []string{"balances:read"}, updated.ScopeList())
+
+		apiKeys, err := apiKeyService.ListAPIKeys(ctx, user.ID)
+		assert.NoError(t, err)
+		assert.Len(t, apiKeys, 3)
+
+		apiKeys, err = apiKeyService.ListAPIKeys(ctx, otherUser.ID)
+		assert.NoError(t, err)
+		assert.Empty(t, apiKeys)
+
+		assert.ErrorIs(t, apiKeyService.DeleteAPIKey(ctx, otherUser.ID, apiKey.ID), services.ErrAPIKeyNotFound)
+		assert.NoError(t, apiKeyService.DeleteAPIKey(ctx, user.ID, apiKey.ID))
+
+		_, _, err = apiKeyService.Authenticate(ctx, key)
+		assert.ErrorIs(t, err, services.ErrInvalidAPIKey)
+	})
+
+	t.Run("should require step-up authentication to grant the transfer scope", func(t *testing.T) {
+		var checks int
+		refuse := func(*gorm.DB) error {
+			checks++
+			return services.ErrTwoFactorRequired
+		}
+
+		_, _, err := apiKeyService.CreateAPIKey(ctx, otherUser.ID, "payouts", []services.Scope{services.ScopeTransfer}, nil, refuse)
+		assert.ErrorIs(t, err, services.ErrTwoFactorRequired)
+
+		apiKey, _, err := apiKeyService.CreateAPIKey(ctx, otherUser.ID, "reporting", []services.Scope{services.ScopeReadBalances}, nil, refuse)
+		assert.NoError(t, err)
+
+		_, err = apiKeyService.UpdateAPIKey(ctx, otherUser.ID, apiKey.ID, services.APIKeyUpdate{
+			Scopes: []services.Scope{services.ScopeReadBalances, services.ScopeTransfer},
+		}, refuse)
+		assert.ErrorIs(t, err, services.ErrTwoFactorRequired)
+		assert.Equal(t, 2, checks)
+
+		// Neither the key nor the scope refused was granted
+		apiKeys, err := apiKeyService.ListAPIKeys(ctx, otherUser.ID)
+		assert.NoError(t, err)
+		if assert.Len(t, apiKeys, 1) {
+			assert.Equal(t, []string{"balances:read"}, apiKeys[0].ScopeList())
+		}
+	})
+}
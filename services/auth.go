@@ -0,0 +1,248 @@
+package services
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/wanliqun/go-wallet-app/apperrors"
+	"github.com/wanliqun/go-wallet-app/config"
+	"github.com/wanliqun/go-wallet-app/models"
+	"github.com/wanliqun/go-wallet-app/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type TokenType string
+
+const (
+	AccessToken  TokenType = "access"
+	RefreshToken TokenType = "refresh"
+)
+
+var (
+	ErrInvalidCredentials = apperrors.New(apperrors.InvalidCredentials, "invalid credentials")
+	ErrInvalidToken       = apperrors.New(apperrors.InvalidToken, "invalid token")
+	ErrTokenRevoked       = apperrors.New(apperrors.TokenRevoked, "token revoked")
+
+	_ IAuthService = &AuthService{}
+
+	// dummyPasswordHash is compared against when the user does not exist, so that
+	// login requests take the same time whether or not the user name is known.
+	dummyPasswordHash, _ = utils.HashPassword("dummy-password")
+)
+
+// TokenClaims are the claims carried by both access and refresh tokens
+type TokenClaims struct {
+	jwt.RegisteredClaims
+	Type TokenType `json:"typ"`
+}
+
+// TokenPair is the result of a successful login or refresh
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"` // Lifetime of the access token in seconds
+}
+
+type IAuthService interface {
+	Login(name, password string) (*TokenPair, error)
+	Refresh(refreshToken string) (*TokenPair, error)
+	Revoke(token string) error
+	Authenticate(accessToken string) (*models.User, error)
+}
+
+// AuthService represents the service for issuing and validating signed tokens
+type AuthService struct {
+	DB     *gorm.DB
+	Config config.AuthConfig
+}
+
+func NewAuthService(db *gorm.DB, conf config.AuthConfig) *AuthService {
+	return &AuthService{DB: db, Config: conf}
+}
+
+// Login verifies the user's password and issues a new token pair
+func (s *AuthService) Login(name, password string) (*TokenPair, error) {
+	var user models.User
+	if err := s.DB.Where("name = ?", name).First(&user).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+		utils.CheckPassword(dummyPasswordHash, password)
+		return nil, ErrInvalidCredentials
+	}
+
+	if user.PasswordHash == "" || !utils.CheckPassword(user.PasswordHash, password) {
+		return nil, ErrInvalidCredentials
+	}
+
+	return s.issueTokenPair(user.ID)
+}
+
+// Refresh exchanges a valid refresh token for a new token pair. The refresh token is
+// rotated, so that each one can only be used once.
+func (s *AuthService) Refresh(refreshToken string) (*TokenPair, error) {
+	claims, err := s.parseToken(refreshToken, RefreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	userID, err := claims.userID()
+	if err != nil {
+		return nil, err
+	}
+
+	var pair *TokenPair
+	err = s.DB.Transaction(func(tx *gorm.DB) error {
+		revoked, err := s.revoke(tx, userID, claims)
+		if err != nil {
+			return err
+		}
+		if !revoked { // already used or revoked
+			return ErrTokenRevoked
+		}
+
+		pair, err = s.issueTokenPair(userID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return pair, nil
+}
+
+// Revoke invalidates the access or refresh token before it expires
+func (s *AuthService) Revoke(token string) error {
+	claims, err := s.parseToken(token, "")
+	if err != nil {
+		return err
+	}
+
+	userID, err := claims.userID()
+	if err != nil {
+		return err
+	}
+
+	_, err = s.revoke(s.DB, userID, claims)
+	return err
+}
+
+// Authenticate validates the access token and returns the user it was issued to
+func (s *AuthService) Authenticate(accessToken string) (*models.User, error) {
+	claims, err := s.parseToken(accessToken, AccessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	userID, err := claims.userID()
+	if err != nil {
+		return nil, err
+	}
+
+	var count int64
+	if err := s.DB.Model(&models.RevokedToken{}).Where("id = ?", claims.ID).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, ErrTokenRevoked
+	}
+
+	var user models.User
+	if err := s.DB.First(&user, userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// issueTokenPair signs a new access and refresh token for the user
+func (s *AuthService) issueTokenPair(userID uint) (*TokenPair, error) {
+	accessToken, err := s.signToken(userID, AccessToken, s.Config.AccessTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := s.signToken(userID, RefreshToken, s.Config.RefreshTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(s.Config.AccessTokenTTL.Seconds()),
+	}, nil
+}
+
+// signToken signs a token of the given type with the configured HMAC secret
+func (s *AuthService) signToken(userID uint, tokenType TokenType, ttl time.Duration) (string, error) {
+	now := time.Now()
+	claims := TokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			Subject:   strconv.FormatUint(uint64(userID), 10),
+			Issuer:    s.Config.Issuer,
+			Audience:  jwt.ClaimStrings{s.Config.Audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+		Type: tokenType,
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(s.Config.Secret))
+}
+
+// parseToken verifies the token signature and registered claims. An empty token type
+// accepts both access and refresh tokens.
+func (s *AuthService) parseToken(token string, tokenType TokenType) (*TokenClaims, error) {
+	var claims TokenClaims
+	_, err := jwt.ParseWithClaims(token, &claims, func(*jwt.Token) (interface{}, error) {
+		return []byte(s.Config.Secret), nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(s.Config.Issuer),
+		jwt.WithAudience(s.Config.Audience),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if claims.ID == "" || (tokenType != "" && claims.Type != tokenType) {
+		return nil, ErrInvalidToken
+	}
+
+	return &claims, nil
+}
+
+// revoke records the token as revoked and reports whether it was not revoked before
+func (s *AuthService) revoke(db *gorm.DB, userID uint, claims *TokenClaims) (bool, error) {
+	result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.RevokedToken{
+		ID:        claims.ID,
+		UserID:    userID,
+		ExpiresAt: claims.ExpiresAt.Time,
+	})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// userID returns the ID of the user the token was issued to
+func (claims *TokenClaims) userID() (uint, error) {
+	id, err := strconv.ParseUint(claims.Subject, 10, 64)
+	if err != nil {
+		return 0, ErrInvalidToken
+	}
+	return uint(id), nil
+}
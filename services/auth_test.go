@@ -0,0 +1,115 @@
+package services_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wanliqun/go-wallet-app/config"
+	"github.com/wanliqun/go-wallet-app/services"
+	"github.com/wanliqun/go-wallet-app/utils"
+)
+
+var authConfig = config.AuthConfig{
+	Secret:          "test-secret",
+	Issuer:          "test-issuer",
+	Audience:        "test-audience",
+	AccessTokenTTL:  time.Minute,
+	RefreshTokenTTL: time.Hour,
+}
+
+func TestLogin(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+
+	testuser := userGenerator.Generate()
+	testuser.PasswordHash, _ = utils.HashPassword("password")
+	tx.Create(testuser)
+
+	authService := services.NewAuthService(tx, authConfig)
+
+	t.Run("should issue tokens for valid credentials", func(t *testing.T) {
+		tokens, err := authService.Login(testuser.Name, "password")
+		assert.NoError(t, err)
+		assert.NotEmpty(t, tokens.AccessToken)
+		assert.NotEmpty(t, tokens.RefreshToken)
+
+		user, err := authService.Authenticate(tokens.AccessToken)
+		assert.NoError(t, err)
+		assert.Equal(t, testuser.ID, user.ID)
+	})
+
+	t.Run("should reject a wrong password", func(t *testing.T) {
+		_, err := authService.Login(testuser.Name, "wrong")
+		assert.Equal(t, services.ErrInvalidCredentials, err)
+	})
+
+	t.Run("should reject an unknown user", func(t *testing.T) {
+		_, err := authService.Login("nonexistentuser", "password")
+		assert.Equal(t, services.ErrInvalidCredentials, err)
+	})
+}
+
+func TestAuthenticate(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+
+	testuser := userGenerator.Generate()
+	testuser.PasswordHash, _ = utils.HashPassword("password")
+	tx.Create(testuser)
+
+	authService := services.NewAuthService(tx, authConfig)
+	tokens, _ := authService.Login(testuser.Name, "password")
+
+	t.Run("should reject a refresh token used as access token", func(t *testing.T) {
+		_, err := authService.Authenticate(tokens.RefreshToken)
+		assert.Equal(t, services.ErrInvalidToken, err)
+	})
+
+	t.Run("should reject a token signed with another secret", func(t *testing.T) {
+		otherConfig := authConfig
+		otherConfig.Secret = "other-secret"
+		otherTokens, _ := services.NewAuthService(tx, otherConfig).Login(testuser.Name, "password")
+
+		_, err := authService.Authenticate(otherTokens.AccessToken)
+		assert.Equal(t, services.ErrInvalidToken, err)
+	})
+
+	t.Run("should reject a user name as token", func(t *testing.T) {
+		_, err := authService.Authenticate(testuser.Name)
+		assert.Equal(t, services.ErrInvalidToken, err)
+	})
+
+	t.Run("should reject a revoked token", func(t *testing.T) {
+		assert.NoError(t, authService.Revoke(tokens.AccessToken))
+
+		_, err := authService.Authenticate(tokens.AccessToken)
+		assert.Equal(t, services.ErrTokenRevoked, err)
+	})
+}
+
+func TestRefresh(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+
+	testuser := userGenerator.Generate()
+	testuser.PasswordHash, _ = utils.HashPassword("password")
+	tx.Create(testuser)
+
+	authService := services.NewAuthService(tx, authConfig)
+	tokens, _ := authService.Login(testuser.Name, "password")
+
+	t.Run("should rotate the refresh token", func(t *testing.T) {
+		newTokens, err := authService.Refresh(tokens.RefreshToken)
+		assert.NoError(t, err)
+		assert.NotEqual(t, tokens.RefreshToken, newTokens.RefreshToken)
+
+		_, err = authService.Refresh(tokens.RefreshToken)
+		assert.Equal(t, services.ErrTokenRevoked, err)
+	})
+
+	t.Run("should reject an access token", func(t *testing.T) {
+		_, err := authService.Refresh(tokens.AccessToken)
+		assert.Equal(t, services.ErrInvalidToken, err)
+	})
+}
@@ -0,0 +1,85 @@
+package services
+
+import (
+	"github.com/wanliqun/go-wallet-app/apperrors"
+	"github.com/wanliqun/go-wallet-app/models"
+)
+
+// Permission is an action on the accounts of other users or on the platform, which the role of
+// the acting user must grant. Users act on their own wallet without any permission.
+type Permission string
+
+const (
+	PermViewUsers        Permission = "users:read"             // View the profile of any user
+	PermManageRoles      Permission = "users:roles"            // Change the role of any user
+	PermViewBalances     Permission = "balances:read"          // View the balances of any user
+	PermViewTransactions Permission = "transactions:read"      // View the transaction history of any user
+	PermReverse          Permission = "transactions:reverse"   // Reverse transactions
+	PermViewLimits       Permission = "limits:read"            // View the limits of any user
+	PermManageLimits     Permission = "limits:write"           // Override the limits of any user
+	PermReconcile        Permission = "reconciliation:run"     // Run and list balance reconciliations
+	PermManageCurrencies Permission = "currencies:write"       // Manage the currency registry
+	PermManageServices   Permission = "service_accounts:write" // Create service accounts and manage their API keys
+)
+
+var (
+	ErrPermissionDenied = apperrors.New(apperrors.PermissionDenied, "permission denied")
+
+	_ IAuthorizer = &RoleAuthorizer{}
+)
+
+// IAuthorizer decides whether a user is allowed an action
+type IAuthorizer interface {
+	Authorize(user *models.User, permission Permission) error
+}
+
+// DefaultRolePermissions are the permissions granted to each role
+var DefaultRolePermissions = map[models.Role][]Permission{
+	models.RoleUser: nil,
+	models.RoleSupport: {
+		PermViewUsers, PermViewBalances, PermViewTransactions, PermViewLimits,
+	},
+	models.RoleFinance: {
+		PermViewUsers, PermViewBalances, PermViewTransactions, PermViewLimits,
+		PermReverse, PermManageLimits, PermReconcile,
+	},
+	models.RoleAdmin: {
+		PermViewUsers, PermViewBalances, PermViewTransactions, PermViewLimits,
+		PermReverse, PermManageLimits, PermReconcile,
+		PermManageCurrencies, PermManageRoles, PermManageServices,
+	},
+}
+
+// RoleAuthorizer grants the permissions of the role of the user
+type RoleAuthorizer struct {
+	permissions map[models.Role]map[Permission]bool
+}
+
+func NewRoleAuthorizer(rolePermissions map[models.Role][]Permission) *RoleAuthorizer {
+	permissions := make(map[models.Role]map[Permission]bool, len(rolePermissions))
+	for role, perms := range rolePermissions {
+		permissions[role] = make(map[Permission]bool, len(perms))
+		for _, perm := range perms {
+			permissions[role][perm] = true
+		}
+	}
+	return &RoleAuthorizer{permissions: permissions}
+}
+
+// Authorize returns ErrPermissionDenied unless the role of the user grants the permission
+func (a *RoleAuthorizer) Authorize(user *models.User, permission Permission) error {
+	if user == nil || !a.permissions[user.Role][permission] {
+		return ErrPermissionDenied
+	}
+	return nil
+}
+
+// IsValidRole reports whether the role is one of the known roles
+func IsValidRole(role models.Role) bool {
+	for _, r := range models.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,42 @@
+package services_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wanliqun/go-wallet-app/models"
+	"github.com/wanliqun/go-wallet-app/services"
+)
+
+func TestRoleAuthorizer(t *testing.T) {
+	authorizer := services.NewRoleAuthorizer(services.DefaultRolePermissions)
+
+	tests := []struct {
+		role       models.Role
+		permission services.Permission
+		allowed    bool
+	}{
+		{models.RoleUser, services.PermViewBalances, false},
+		{models.RoleSupport, services.PermViewBalances, true},
+		{models.RoleSupport, services.PermViewTransactions, true},
+		{models.RoleSupport, services.PermReverse, false},
+		{models.RoleFinance, services.PermReverse, true},
+		{models.RoleFinance, services.PermManageRoles, false},
+		{models.RoleAdmin, services.PermManageRoles, true},
+		{models.RoleAdmin, services.PermManageCurrencies, true},
+		{models.RoleFinance, services.PermManageServices, false},
+		{models.RoleAdmin, services.PermManageServices, true},
+		{models.Role("root"), services.PermViewBalances, false},
+	}
+
+	for _, tt := range tests {
+		err := authorizer.Authorize(&models.User{Role: tt.role}, tt.permission)
+		if tt.allowed {
+			assert.NoError(t, err, "%s should be granted %s", tt.role, tt.permission)
+		} else {
+			assert.ErrorIs(t, err, services.ErrPermissionDenied, "%s should not be granted %s", tt.role, tt.permission)
+		}
+	}
+
+	assert.ErrorIs(t, authorizer.Authorize(nil, services.PermViewBalances), services.ErrPermissionDenied)
+}
@@ -0,0 +1,207 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/wanliqun/go-wallet-app/apperrors"
+	"github.com/wanliqun/go-wallet-app/config"
+	"github.com/wanliqun/go-wallet-app/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// currencyCacheTTL is how long the registry cache is served before reloading, so that changes
+// made by other instances are picked up without a restart.
+const currencyCacheTTL = 30 * time.Second
+
+var (
+	ErrCurrencyNotFound = apperrors.New(apperrors.CurrencyNotFound, "currency not found")
+	ErrCurrencyExists   = apperrors.New(apperrors.CurrencyExists, "currency already exists")
+
+	_ ICurrencyService = &CurrencyService{}
+)
+
+// CurrencyUpdate holds the optional currency fields to update, nil fields are left unchanged
+type CurrencyUpdate struct {
+	Name      *string
+	Enabled   *bool
+	MinAmount *decimal.Decimal
+	MaxAmount *decimal.Decimal
+}
+
+// ICurrencyRegistry looks up currencies on hot paths such as request validation
+type ICurrencyRegistry interface {
+	LookupCurrency(code string) (models.Currency, bool)
+}
+
+type ICurrencyService interface {
+	ICurrencyRegistry
+
+	ListCurrencies(includeDisabled bool) ([]models.Currency, error)
+	GetCurrency(code string) (*models.Currency, bool, error)
+	CreateCurrency(currency *models.Currency) error
+	UpdateCurrency(code string, update CurrencyUpdate) (*models.Currency, error)
+}
+
+// CurrencyService represents the service for the currency registry
+type CurrencyService struct {
+	DB *gorm.DB
+
+	mu       sync.RWMutex
+	cache    map[string]models.Currency
+	cachedAt time.Time
+}
+
+func NewCurrencyService(db *gorm.DB) *CurrencyService {
+	return &CurrencyService{DB: db}
+}
+
+// SeedFromConfig registers the currencies of the configuration which are not registered yet
+func (s *CurrencyService) SeedFromConfig(currencies map[string]config.ConcurrencyConfig) error {
+	if len(currencies) == 0 {
+		return nil
+	}
+
+	seeds := make([]models.Currency, 0, len(currencies))
+	for code, conf := range currencies {
+		seeds = append(seeds, models.Currency{
+			Code:      strings.ToUpper(code),
+			Name:      conf.Name,
+			Precision: int32(conf.Precision),
+			Enabled:   true,
+		})
+	}
+
+	err := s.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&seeds).Error
+	s.invalidate()
+	return err
+}
+
+// LookupCurrency returns the registered currency from the cache
+func (s *CurrencyService) LookupCurrency(code string) (models.Currency, bool) {
+	s.mu.RLock()
+	fresh := s.cache != nil && time.Since(s.cachedAt) < currencyCacheTTL
+	currency, ok := s.cache[code]
+	s.mu.RUnlock()
+
+	if fresh {
+		return currency, ok
+	}
+
+	// Serve the stale cache if reloading fails
+	if err := s.reload(); err != nil {
+		log.Printf("failed to reload currency registry: %v", err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	currency, ok = s.cache[code]
+	return currency, ok
+}
+
+func (s *CurrencyService) ListCurrencies(includeDisabled bool) ([]models.Currency, error) {
+	query := s.DB.Order("code")
+	if !includeDisabled {
+		query = query.Where("enabled = ?", true)
+	}
+
+	var currencies []models.Currency
+	if err := query.Find(&currencies).Error; err != nil {
+		return nil, err
+	}
+	return currencies, nil
+}
+
+func (s *CurrencyService) GetCurrency(code string) (*models.Currency, bool, error) {
+	var currency models.Currency
+	if err := s.DB.Where("code = ?", code).First(&currency).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	return &currency, true, nil
+}
+
+// CreateCurrency registers a new currency
+func (s *CurrencyService) CreateCurrency(currency *models.Currency) error {
+	result := s.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(currency)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrCurrencyExists
+	}
+
+	s.invalidate()
+	return nil
+}
+
+// UpdateCurrency updates a registered currency, e.g. to disable it. The precision can not be
+// changed since existing balances are stored in minor units.
+func (s *CurrencyService) UpdateCurrency(code string, update CurrencyUpdate) (*models.Currency, error) {
+	updates := make(map[string]interface{})
+	if update.Name != nil {
+		updates["name"] = *update.Name
+	}
+	if update.Enabled != nil {
+		updates["enabled"] = *update.Enabled
+	}
+	if update.MinAmount != nil {
+		updates["min_amount"] = *update.MinAmount
+	}
+	if update.MaxAmount != nil {
+		updates["max_amount"] = *update.MaxAmount
+	}
+
+	var currency models.Currency
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("code = ?", code).First(&currency).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrCurrencyNotFound
+			}
+			return err
+		}
+
+		if len(updates) == 0 {
+			return nil
+		}
+		return tx.Model(&currency).Updates(updates).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.invalidate()
+	return &currency, nil
+}
+
+// reload loads all registered currencies into the cache
+func (s *CurrencyService) reload() error {
+	var currencies []models.Currency
+	if err := s.DB.Find(&currencies).Error; err != nil {
+		return err
+	}
+
+	cache := make(map[string]models.Currency, len(currencies))
+	for _, currency := range currencies {
+		cache[currency.Code] = currency
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cache, s.cachedAt = cache, time.Now()
+	return nil
+}
+
+// invalidate drops the cache so that the next lookup reloads it
+func (s *CurrencyService) invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cache = nil
+}
@@ -0,0 +1,64 @@
+package services_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wanliqun/go-wallet-app/config"
+	"github.com/wanliqun/go-wallet-app/models"
+	"github.com/wanliqun/go-wallet-app/services"
+)
+
+func TestCurrencyRegistry(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+
+	currencyService := services.NewCurrencyService(tx)
+
+	err := currencyService.SeedFromConfig(map[string]config.ConcurrencyConfig{
+		"btc": {Name: "Bitcoin", Precision: 8},
+	})
+	assert.NoError(t, err)
+
+	t.Run("should seed currencies from config in upper case", func(t *testing.T) {
+		currency, ok := currencyService.LookupCurrency("BTC")
+		assert.True(t, ok)
+		assert.Equal(t, int32(8), currency.Precision)
+		assert.True(t, currency.Enabled)
+	})
+
+	t.Run("should reject a duplicate currency", func(t *testing.T) {
+		err := currencyService.CreateCurrency(&models.Currency{Code: "BTC", Name: "Bitcoin", Precision: 8})
+		assert.Equal(t, services.ErrCurrencyExists, err)
+	})
+
+	t.Run("should add a currency without restart", func(t *testing.T) {
+		err := currencyService.CreateCurrency(&models.Currency{Code: "USDT", Name: "Tether", Precision: 6, Enabled: true})
+		assert.NoError(t, err)
+
+		currency, ok := currencyService.LookupCurrency("USDT")
+		assert.True(t, ok)
+		assert.Equal(t, "Tether", currency.Name)
+	})
+
+	t.Run("should disable a currency", func(t *testing.T) {
+		enabled := false
+		_, err := currencyService.UpdateCurrency("USDT", services.CurrencyUpdate{Enabled: &enabled})
+		assert.NoError(t, err)
+
+		currency, ok := currencyService.LookupCurrency("USDT")
+		assert.True(t, ok)
+		assert.False(t, currency.Enabled)
+
+		currencies, err := currencyService.ListCurrencies(false)
+		assert.NoError(t, err)
+		for _, c := range currencies {
+			assert.NotEqual(t, "USDT", c.Code)
+		}
+	})
+
+	t.Run("should return not found for an unknown currency", func(t *testing.T) {
+		_, err := currencyService.UpdateCurrency("XYZ", services.CurrencyUpdate{})
+		assert.Equal(t, services.ErrCurrencyNotFound, err)
+	})
+}
@@ -0,0 +1,242 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/wanliqun/go-wallet-app/apperrors"
+	"github.com/wanliqun/go-wallet-app/config"
+	"github.com/wanliqun/go-wallet-app/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrSameCurrency  = apperrors.New(apperrors.SameCurrency, "cannot exchange a currency to itself")
+	ErrQuoteNotFound = apperrors.New(apperrors.QuoteNotFound, "exchange quote not found")
+	ErrQuoteExpired  = apperrors.New(apperrors.QuoteExpired, "exchange quote expired")
+	ErrQuoteExecuted = apperrors.New(apperrors.QuoteExecuted, "exchange quote already executed")
+
+	_ IExchangeService = &ExchangeService{}
+)
+
+type IExchangeService interface {
+	Quote(userID uint, from, to string, amount decimal.Decimal) (*models.ExchangeQuote, error)
+	ExecuteQuote(userID, quoteID uint, idempotencyKey string) (*models.Transaction, *models.Transaction, error)
+	Exchange(userID uint, from, to string, amount decimal.Decimal, idempotencyKey string) (*models.Transaction, *models.Transaction, error)
+}
+
+// ExchangeService represents the service converting funds between the vaults of a user
+type ExchangeService struct {
+	DB       *gorm.DB
+	Registry ICurrencyRegistry
+	Rates    IRateProvider
+	Config   config.ExchangeConfig
+}
+
+func NewExchangeService(
+	db *gorm.DB, registry ICurrencyRegistry, rates IRateProvider, conf config.ExchangeConfig) *ExchangeService {
+	return &ExchangeService{DB: db, Registry: registry, Rates: rates, Config: conf}
+}
+
+// Quote fixes the current rate for exchanging the amount until the quote expires
+func (s *ExchangeService) Quote(userID uint, from, to string, amount decimal.Decimal) (*models.ExchangeQuote, error) {
+	quote, err := s.newQuote(userID, from, to, amount)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.DB.Create(quote).Error; err != nil {
+		return nil, err
+	}
+	return quote, nil
+}
+
+// ExecuteQuote exchanges the quoted amount at the quoted rate, and returns the exchange_out and
+// exchange_in transactions.
+func (s *ExchangeService) ExecuteQuote(
+	userID, quoteID uint, idempotencyKey string) (*models.Transaction, *models.Transaction, error) {
+	fingerprint := idempotencyFingerprint("exchange_quote", quoteID)
+	return s.exchange(userID, idempotencyKey, fingerprint, func(tx *gorm.DB) (*models.ExchangeQuote, error) {
+		var quote models.ExchangeQuote
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND user_id = ?", quoteID, userID).
+			First(&quote).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, ErrQuoteNotFound
+			}
+			return nil, err
+		}
+
+		if quote.TransactionID != nil {
+			return nil, ErrQuoteExecuted
+		}
+		if !quote.ExpiresAt.After(time.Now()) {
+			return nil, ErrQuoteExpired
+		}
+		return &quote, nil
+	})
+}
+
+// Exchange exchanges the amount at the current rate, and returns the exchange_out and
+// exchange_in transactions.
+func (s *ExchangeService) Exchange(
+	userID uint, from, to string, amount decimal.Decimal, idempotencyKey string) (*models.Transaction, *models.Transaction, error) {
+	fingerprint := idempotencyFingerprint("exchange", from, to, amount)
+	return s.exchange(userID, idempotencyKey, fingerprint, func(tx *gorm.DB) (*models.ExchangeQuote, error) {
+		quote, err := s.newQuote(userID, from, to, amount)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := tx.Create(quote).Error; err != nil {
+			return nil, err
+		}
+		return quote, nil
+	})
+}
+
+// newQuote converts the amount at the current rate, rounding down to the minor unit of the
+// target currency.
+func (s *ExchangeService) newQuote(userID uint, from, to string, amount decimal.Decimal) (*models.ExchangeQuote, error) {
+	if amount.LessThanOrEqual(decimal.Zero) {
+		return nil, ErrInvalidAmount
+	}
+
+	if from == to {
+		return nil, ErrSameCurrency
+	}
+
+	fromCurrency, ok := s.Registry.LookupCurrency(from)
+	if !ok {
+		return nil, ErrCurrencyNotFound
+	}
+	toCurrency, ok := s.Registry.LookupCurrency(to)
+	if !ok {
+		return nil, ErrCurrencyNotFound
+	}
+
+	rate, err := s.Rates.GetRate(from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	toAmount := amount.Shift(-fromCurrency.Precision).Mul(rate).Shift(toCurrency.Precision).Truncate(0)
+	if !toAmount.IsPositive() {
+		return nil, ErrInvalidAmount
+	}
+
+	return &models.ExchangeQuote{
+		UserID:       userID,
+		FromCurrency: from,
+		ToCurrency:   to,
+		FromAmount:   amount,
+		ToAmount:     toAmount,
+		Rate:         rate,
+		ExpiresAt:    time.Now().Add(s.Config.QuoteTTL),
+	}, nil
+}
+
+// exchange executes the quote guarded by the idempotency key within a database transaction
+func (s *ExchangeService) exchange(
+	userID uint, idempotencyKey, fingerprint string, getQuote func(tx *gorm.DB) (*models.ExchangeQuote, error),
+) (out, in *models.Transaction, err error) {
+	err = s.DB.Transaction(func(tx *gorm.DB) (err error) {
+		out, err = withIdempotency(tx, userID, idempotencyKey, fingerprint, func() (*models.Transaction, error) {
+			quote, err := getQuote(tx)
+			if err != nil {
+				return nil, err
+			}
+
+			out, in, err = executeQuote(tx, quote)
+			return out, err
+		})
+		if err != nil {
+			return err
+		}
+
+		// The request was replayed, return the exchange_in leg executed originally
+		if in == nil {
+			in = &models.Transaction{}
+			return tx.Where("parent_transaction_id = ? AND type = ?", out.ID, models.ExchangeIn).First(in).Error
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return out, in, nil
+}
+
+// executeQuote debits the source vault and credits the target vault of the user
+func executeQuote(tx *gorm.DB, quote *models.ExchangeQuote) (*models.Transaction, *models.Transaction, error) {
+	// Deduct from the source vault atomically, ensuring the balance doesn't go negative
+	result := tx.Model(&models.Vault{}).
+		Where("user_id = ? AND currency = ? AND amount >= ?", quote.UserID, quote.FromCurrency, quote.FromAmount).
+		Update("amount", gorm.Expr("amount - ?", quote.FromAmount))
+	if result.Error != nil {
+		return nil, nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, nil, ErrInsufficientBalance
+	}
+
+	if err := creditVault(tx, quote.UserID, quote.ToCurrency, quote.ToAmount); err != nil {
+		return nil, nil, err
+	}
+
+	// Record both legs with the applied rate, the exchange_in leg is linked to the exchange_out leg
+	rate := decimal.NewNullDecimal(quote.Rate)
+	out := models.Transaction{
+		UserID:   quote.UserID,
+		Type:     models.ExchangeOut,
+		Amount:   quote.FromAmount,
+		Currency: quote.FromCurrency,
+		Rate:     rate,
+	}
+	if err := tx.Create(&out).Error; err != nil {
+		return nil, nil, err
+	}
+
+	in := models.Transaction{
+		UserID:              quote.UserID,
+		Type:                models.ExchangeIn,
+		Amount:              quote.ToAmount,
+		Currency:            quote.ToCurrency,
+		Rate:                rate,
+		ParentTransactionID: &out.ID,
+	}
+	if err := tx.Create(&in).Error; err != nil {
+		return nil, nil, err
+	}
+
+	// Post each leg against the exchange position of the platform, balancing each currency
+	err := newJournal(quote.FromCurrency, "exchange").
+		forTransaction(&out).
+		move(userAccount(quote.UserID), systemAccount(models.ExchangeAccount), quote.FromAmount).
+		post(tx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = newJournal(quote.ToCurrency, "exchange").
+		forTransaction(&in).
+		move(systemAccount(models.ExchangeAccount), userAccount(quote.UserID), quote.ToAmount).
+		post(tx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := tx.Model(quote).Update("transaction_id", out.ID).Error; err != nil {
+		return nil, nil, err
+	}
+
+	if err := enqueueTransactionEvents(tx, &out, &in); err != nil {
+		return nil, nil, err
+	}
+
+	return &out, &in, nil
+}
@@ -0,0 +1,101 @@
+package services_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/wanliqun/go-wallet-app/config"
+	"github.com/wanliqun/go-wallet-app/models"
+	"github.com/wanliqun/go-wallet-app/services"
+)
+
+// staticRegistry is a currency registry backed by a fixed set of currencies
+type staticRegistry map[string]models.Currency
+
+func (r staticRegistry) LookupCurrency(code string) (models.Currency, bool) {
+	currency, ok := r[code]
+	return currency, ok
+}
+
+func TestExchange(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+
+	testuser := userGenerator.Generate()
+	tx.Create(testuser)
+
+	registry := staticRegistry{
+		"BTC":  {Code: "BTC", Precision: 8, Enabled: true},
+		"USDT": {Code: "USDT", Precision: 6, Enabled: true},
+	}
+	rates := services.NewStaticRateProvider(map[string]decimal.Decimal{"BTC/USDT": decimal.NewFromInt(50000)})
+
+	walletService := services.NewWalletService(tx)
+	exchangeService := services.NewExchangeService(tx, registry, rates, config.ExchangeConfig{QuoteTTL: time.Minute})
+	ledgerService := services.NewLedgerService(tx)
+
+	assertBalance := func(t *testing.T, currency string, expected int64) {
+		var vault models.Vault
+		tx.First(&vault, "user_id = ? AND currency = ?", testuser.ID, currency)
+		assert.True(t, decimal.NewFromInt(expected).Equal(vault.Amount), "%s vault amount %v", currency, vault.Amount)
+
+		balance, err := ledgerService.GetAccountBalance(testuser.ID, currency)
+		assert.NoError(t, err)
+		assert.True(t, decimal.NewFromInt(expected).Equal(balance), "%s ledger balance %v", currency, balance)
+	}
+
+	// 0.1 BTC
+	walletService.Deposit(ctx, testuser.ID, "BTC", decimal.NewFromInt(10_000_000), "")
+
+	t.Run("should exchange at the current rate", func(t *testing.T) {
+		// 0.01 BTC = 500 USDT
+		out, in, err := exchangeService.Exchange(testuser.ID, "BTC", "USDT", decimal.NewFromInt(1_000_000), "exchange-key")
+		assert.NoError(t, err)
+		assert.Equal(t, models.ExchangeOut, out.Type)
+		assert.Equal(t, models.ExchangeIn, in.Type)
+		assert.Equal(t, out.ID, *in.ParentTransactionID)
+		assert.True(t, decimal.NewFromInt(500_000_000).Equal(in.Amount))
+		assert.True(t, decimal.NewFromInt(50000).Equal(in.Rate.Decimal))
+		assertBalance(t, "BTC", 9_000_000)
+		assertBalance(t, "USDT", 500_000_000)
+
+		replayedOut, replayedIn, err := exchangeService.Exchange(testuser.ID, "BTC", "USDT", decimal.NewFromInt(1_000_000), "exchange-key")
+		assert.NoError(t, err)
+		assert.Equal(t, out.ID, replayedOut.ID)
+		assert.Equal(t, in.ID, replayedIn.ID)
+		assertBalance(t, "BTC", 9_000_000)
+	})
+
+	t.Run("should execute quote once", func(t *testing.T) {
+		// 100 USDT = 0.002 BTC
+		quote, err := exchangeService.Quote(testuser.ID, "USDT", "BTC", decimal.NewFromInt(100_000_000))
+		assert.NoError(t, err)
+		assert.True(t, decimal.NewFromInt(200_000).Equal(quote.ToAmount))
+
+		_, in, err := exchangeService.ExecuteQuote(testuser.ID, quote.ID, "")
+		assert.NoError(t, err)
+		assert.True(t, quote.ToAmount.Equal(in.Amount))
+		assertBalance(t, "BTC", 9_200_000)
+		assertBalance(t, "USDT", 400_000_000)
+
+		_, _, err = exchangeService.ExecuteQuote(testuser.ID, quote.ID, "")
+		assert.ErrorIs(t, err, services.ErrQuoteExecuted)
+	})
+
+	t.Run("should refuse expired quote", func(t *testing.T) {
+		quote, err := exchangeService.Quote(testuser.ID, "USDT", "BTC", decimal.NewFromInt(100_000_000))
+		assert.NoError(t, err)
+
+		tx.Model(quote).Update("expires_at", time.Now().Add(-time.Second))
+
+		_, _, err = exchangeService.ExecuteQuote(testuser.ID, quote.ID, "")
+		assert.ErrorIs(t, err, services.ErrQuoteExpired)
+	})
+
+	t.Run("should refuse insufficient balance", func(t *testing.T) {
+		_, _, err := exchangeService.Exchange(testuser.ID, "BTC", "USDT", decimal.NewFromInt(100_000_000), "")
+		assert.ErrorIs(t, err, services.ErrInsufficientBalance)
+	})
+}
@@ -0,0 +1,222 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/shopspring/decimal"
+	"github.com/wanliqun/go-wallet-app/apperrors"
+	"github.com/wanliqun/go-wallet-app/config"
+	"github.com/wanliqun/go-wallet-app/models"
+	"gorm.io/gorm"
+)
+
+type FeeType string
+
+const (
+	FlatFee       FeeType = "flat"
+	PercentageFee FeeType = "percentage"
+	TieredFee     FeeType = "tiered"
+)
+
+var (
+	ErrAmountBelowFee = apperrors.New(apperrors.AmountBelowFee, "amount does not cover the fee")
+
+	_ IFeeService = &FeeService{}
+
+	// FeeOperations maps the operation names of fee schedules to the charged transaction types
+	FeeOperations = map[string]models.TransactionType{
+		"withdrawal": models.Withdrawal,
+		"transfer":   models.TransferOut,
+	}
+)
+
+// FeeTier is the flat and percentage fee of amounts below the upper bound of the tier
+type FeeTier struct {
+	UpTo   decimal.Decimal // Exclusive upper bound, zero for the last tier
+	Amount decimal.Decimal
+	Rate   decimal.Decimal
+}
+
+// FeeSchedule defines how the fee of an operation is computed, amounts are in human units
+type FeeSchedule struct {
+	Type   FeeType
+	Amount decimal.Decimal // Flat fee
+	Rate   decimal.Decimal // Percentage fee as a fraction of the amount
+	Min    decimal.Decimal // Minimum fee, zero for no minimum
+	Max    decimal.Decimal // Maximum fee, zero for no maximum
+	Tiers  []FeeTier
+}
+
+// Fee computes the fee of the amount in minor units, rounded up to the minor unit
+func (s FeeSchedule) Fee(amount decimal.Decimal, precision int32) decimal.Decimal {
+	var fee decimal.Decimal
+	switch s.Type {
+	case FlatFee:
+		fee = s.Amount.Shift(precision)
+	case PercentageFee:
+		fee = amount.Mul(s.Rate)
+	case TieredFee:
+		for _, tier := range s.Tiers {
+			if tier.UpTo.IsZero() || amount.LessThan(tier.UpTo.Shift(precision)) {
+				fee = tier.Amount.Shift(precision).Add(amount.Mul(tier.Rate))
+				break
+			}
+		}
+	}
+
+	if s.Min.IsPositive() && fee.LessThan(s.Min.Shift(precision)) {
+		fee = s.Min.Shift(precision)
+	}
+	if s.Max.IsPositive() && fee.GreaterThan(s.Max.Shift(precision)) {
+		fee = s.Max.Shift(precision)
+	}
+	return fee.Ceil()
+}
+
+type IFeeService interface {
+	// CalculateFee returns the fee of the operation and the net amount left once the fee is deducted
+	CalculateFee(currency string, operation models.TransactionType, amount decimal.Decimal) (fee, net decimal.Decimal, err error)
+}
+
+// FeeService represents the service computing the fees of withdrawals and transfers from the
+// fee schedules per currency and operation
+type FeeService struct {
+	Registry  ICurrencyRegistry
+	Schedules map[string]map[models.TransactionType]FeeSchedule
+}
+
+func NewFeeService(registry ICurrencyRegistry, schedules map[string]map[models.TransactionType]FeeSchedule) *FeeService {
+	return &FeeService{Registry: registry, Schedules: schedules}
+}
+
+// NewFeeServiceFromConfig parses the configured fee schedules keyed by currency and operation
+func NewFeeServiceFromConfig(registry ICurrencyRegistry, fees map[string]map[string]config.FeeConfig) (*FeeService, error) {
+	schedules := make(map[string]map[models.TransactionType]FeeSchedule, len(fees))
+	for currency, operations := range fees {
+		currency = strings.ToUpper(currency)
+		schedules[currency] = make(map[models.TransactionType]FeeSchedule, len(operations))
+
+		for operation, conf := range operations {
+			txnType, ok := FeeOperations[operation]
+			if !ok {
+				return nil, fmt.Errorf("invalid fee operation %q of currency %s", operation, currency)
+			}
+
+			schedule, err := parseFeeSchedule(conf)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s fee schedule of currency %s: %w", operation, currency, err)
+			}
+			schedules[currency][txnType] = schedule
+		}
+	}
+	return NewFeeService(registry, schedules), nil
+}
+
+func (s *FeeService) CalculateFee(
+	currency string, operation models.TransactionType, amount decimal.Decimal) (decimal.Decimal, decimal.Decimal, error) {
+	schedule, ok := s.Schedules[currency][operation]
+	if !ok {
+		return decimal.Zero, amount, nil
+	}
+
+	conf, ok := s.Registry.LookupCurrency(currency)
+	if !ok {
+		return decimal.Zero, decimal.Zero, ErrCurrencyNotFound
+	}
+
+	fee := schedule.Fee(amount, conf.Precision)
+	net := amount.Sub(fee)
+	if !net.IsPositive() {
+		return fee, net, ErrAmountBelowFee
+	}
+	return fee, net, nil
+}
+
+// parseFeeSchedule parses and validates the configured fee schedule
+func parseFeeSchedule(conf config.FeeConfig) (schedule FeeSchedule, err error) {
+	schedule.Type = FeeType(conf.Type)
+	if schedule.Amount, err = parseOptionalDecimal(conf.Amount); err != nil {
+		return schedule, err
+	}
+	if schedule.Rate, err = parseOptionalDecimal(conf.Rate); err != nil {
+		return schedule, err
+	}
+	if schedule.Min, err = parseOptionalDecimal(conf.Min); err != nil {
+		return schedule, err
+	}
+	if schedule.Max, err = parseOptionalDecimal(conf.Max); err != nil {
+		return schedule, err
+	}
+
+	for i, tierConf := range conf.Tiers {
+		var tier FeeTier
+		if tier.UpTo, err = parseOptionalDecimal(tierConf.UpTo); err != nil {
+			return schedule, err
+		}
+		if tier.Amount, err = parseOptionalDecimal(tierConf.Amount); err != nil {
+			return schedule, err
+		}
+		if tier.Rate, err = parseOptionalDecimal(tierConf.Rate); err != nil {
+			return schedule, err
+		}
+
+		// Tiers must be in ascending order, only the last one may be unbounded
+		last := i == len(conf.Tiers)-1
+		if (tier.UpTo.IsZero() && !last) || (i > 0 && !tier.UpTo.IsZero() && tier.UpTo.LessThanOrEqual(schedule.Tiers[i-1].UpTo)) {
+			return schedule, errors.New("tiers must be in ascending order of upper bounds")
+		}
+		schedule.Tiers = append(schedule.Tiers, tier)
+	}
+
+	switch schedule.Type {
+	case FlatFee, PercentageFee:
+	case TieredFee:
+		if len(schedule.Tiers) == 0 {
+			return schedule, errors.New("no fee tiers")
+		}
+	default:
+		return schedule, fmt.Errorf("unknown fee type %q", conf.Type)
+	}
+
+	return schedule, nil
+}
+
+// parseOptionalDecimal parses a non-negative decimal, an empty string is parsed as zero
+func parseOptionalDecimal(value string) (decimal.Decimal, error) {
+	if value == "" {
+		return decimal.Zero, nil
+	}
+
+	d, err := decimal.NewFromString(value)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	if d.IsNegative() {
+		return decimal.Zero, fmt.Errorf("negative value %s", value)
+	}
+	return d, nil
+}
+
+// chargeFee records the fee of the parent transaction and credits it to the platform fee account
+// in the journal entry of the parent transaction, it returns the fee transaction if any
+func chargeFee(tx *gorm.DB, parent *models.Transaction, fee decimal.Decimal, journal *journal) (*models.Transaction, error) {
+	if !fee.IsPositive() {
+		return nil, nil
+	}
+
+	transaction := models.Transaction{
+		UserID:              parent.UserID,
+		Type:                models.Fee,
+		Amount:              fee,
+		Currency:            parent.Currency,
+		ParentTransactionID: &parent.ID,
+	}
+	if err := tx.Create(&transaction).Error; err != nil {
+		return nil, err
+	}
+
+	journal.credit(systemAccount(models.PlatformFeesAccount), fee)
+	return &transaction, nil
+}
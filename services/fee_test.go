@@ -0,0 +1,229 @@
+package services_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/wanliqun/go-wallet-app/config"
+	"github.com/wanliqun/go-wallet-app/models"
+	"github.com/wanliqun/go-wallet-app/services"
+)
+
+func TestFeeSchedule(t *testing.T) {
+	tiered := services.FeeSchedule{
+		Type: services.TieredFee,
+		Tiers: []services.FeeTier{
+			{UpTo: decimal.NewFromInt(100), Amount: decimal.NewFromInt(1)},
+			{UpTo: decimal.NewFromInt(1000), Rate: decimal.RequireFromString("0.005")},
+			{Amount: decimal.NewFromInt(2), Rate: decimal.RequireFromString("0.001")},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		schedule services.FeeSchedule
+		amount   int64
+		expected int64
+	}{
+		{
+			name:     "flat",
+			schedule: services.FeeSchedule{Type: services.FlatFee, Amount: decimal.RequireFromString("1.5")},
+			amount:   10_000,
+			expected: 150,
+		},
+		{
+			name:     "percentage rounded up",
+			schedule: services.FeeSchedule{Type: services.PercentageFee, Rate: decimal.RequireFromString("0.001")},
+			amount:   12_345,
+			expected: 13,
+		},
+		{
+			name: "percentage with minimum",
+			schedule: services.FeeSchedule{
+				Type: services.PercentageFee, Rate: decimal.RequireFromString("0.001"), Min: decimal.NewFromInt(1),
+			},
+			amount:   10_000,
+			expected: 100,
+		},
+		{
+			name: "percentage with maximum",
+			schedule: services.FeeSchedule{
+				Type: services.PercentageFee, Rate: decimal.RequireFromString("0.01"), Max: decimal.NewFromInt(5),
+			},
+			amount:   1_000_000,
+			expected: 500,
+		},
+		{name: "first tier", schedule: tiered, amount: 5_000, expected: 100},
+		{name: "second tier", schedule: tiered, amount: 10_000, expected: 50},
+		{name: "last tier", schedule: tiered, amount: 200_000, expected: 400},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fee := tt.schedule.Fee(decimal.NewFromInt(tt.amount), 2)
+			assert.True(t, decimal.NewFromInt(tt.expected).Equal(fee), "fee %v", fee)
+		})
+	}
+}
+
+func TestNewFeeServiceFromConfig(t *testing.T) {
+	registry := staticRegistry{"USDT": {Code: "USDT", Precision: 6, Enabled: true}}
+
+	t.Run("should parse fee schedules", func(t *testing.T) {
+		feeService, err := services.NewFeeServiceFromConfig(registry, map[string]map[string]config.FeeConfig{
+			"usdt": {
+				"withdrawal": {Type: "flat", Amount: "1"},
+				"transfer": {Type: "tiered", Tiers: []config.FeeTierConfig{
+					{UpTo: "100", Amount: "0.1"},
+					{Rate: "0.001"},
+				}},
+			},
+		})
+		assert.NoError(t, err)
+
+		fee, net, err := feeService.CalculateFee("USDT", models.Withdrawal, decimal.NewFromInt(10_000_000))
+		assert.NoError(t, err)
+		assert.True(t, decimal.NewFromInt(1_000_000).Equal(fee))
+		assert.True(t, decimal.NewFromInt(9_000_000).Equal(net))
+
+		fee, _, err = feeService.CalculateFee("USDT", models.TransferOut, decimal.NewFromInt(10_000_000))
+		assert.NoError(t, err)
+		assert.True(t, decimal.NewFromInt(100_000).Equal(fee))
+	})
+
+	t.Run("should charge no fee without schedule", func(t *testing.T) {
+		feeService := services.NewFeeService(registry, nil)
+
+		fee, net, err := feeService.CalculateFee("USDT", models.Withdrawal, decimal.NewFromInt(100))
+		assert.NoError(t, err)
+		assert.True(t, fee.IsZero())
+		assert.True(t, decimal.NewFromInt(100).Equal(net))
+	})
+
+	t.Run("should reject amounts not covering the fee", func(t *testing.T) {
+		feeService, err := services.NewFeeServiceFromConfig(registry, map[string]map[string]config.FeeConfig{
+			"USDT": {"withdrawal": {Type: "flat", Amount: "1"}},
+		})
+		assert.NoError(t, err)
+
+		_, _, err = feeService.CalculateFee("USDT", models.Withdrawal, decimal.NewFromInt(1_000_000))
+		assert.Equal(t, services.ErrAmountBelowFee, err)
+	})
+
+	t.Run("should reject invalid schedules", func(t *testing.T) {
+		invalid := []map[string]config.FeeConfig{
+			{"deposit": {Type: "flat", Amount: "1"}},
+			{"withdrawal": {Type: "unknown"}},
+			{"withdrawal": {Type: "flat", Amount: "-1"}},
+			{"withdrawal": {Type: "tiered"}},
+			{"withdrawal": {Type: "tiered", Tiers: []config.FeeTierConfig{{Amount: "1"}, {UpTo: "100"}}}},
+			{"withdrawal": {Type: "tiered", Tiers: []config.FeeTierConfig{{UpTo: "100"}, {UpTo: "10"}}}},
+		}
+		for _, operations := range invalid {
+			_, err := services.NewFeeServiceFromConfig(registry, map[string]map[string]config.FeeConfig{"USDT": operations})
+			assert.Error(t, err, "%+v", operations)
+		}
+	})
+}
+
+func TestChargeFees(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+
+	registry := staticRegistry{"USDT": {Code: "USDT", Precision: 6, Enabled: true}}
+	feeService, err := services.NewFeeServiceFromConfig(registry, map[string]map[string]config.FeeConfig{
+		"USDT": {
+			"withdrawal": {Type: "flat", Amount: "1"},
+			"transfer":   {Type: "percentage", Rate: "0.01"},
+		},
+	})
+	assert.NoError(t, err)
+
+	walletService := services.NewWalletService(tx)
+	walletService.Fees = feeService
+	ledgerService := services.NewLedgerService(tx)
+
+	sender := userGenerator.Generate()
+	tx.Create(sender)
+	recipient := userGenerator.Generate()
+	tx.Create(recipient)
+
+	currency := "USDT"
+	walletService.Deposit(ctx, sender.ID, currency, decimal.NewFromInt(100_000_000), "")
+
+	assertBalance := func(t *testing.T, userID uint, expected int64) {
+		var vault models.Vault
+		tx.First(&vault, "user_id = ? AND currency = ?", userID, currency)
+		assert.True(t, decimal.NewFromInt(expected).Equal(vault.Amount), "vault amount %v", vault.Amount)
+
+		balance, err := ledgerService.GetAccountBalance(userID, currency)
+		assert.NoError(t, err)
+		assert.True(t, decimal.NewFromInt(expected).Equal(balance), "ledger balance %v", balance)
+	}
+
+	assertFee := func(t *testing.T, parent *models.Transaction, expected int64) {
+		var fee models.Transaction
+		err := tx.Where("parent_transaction_id = ? AND type = ?", parent.ID, models.Fee).First(&fee).Error
+		assert.NoError(t, err)
+		assert.Equal(t, parent.UserID, fee.UserID)
+		assert.True(t, decimal.NewFromInt(expected).Equal(fee.Amount), "fee amount %v", fee.Amount)
+	}
+
+	t.Run("should deduct the withdrawal fee from the amount", func(t *testing.T) {
+		txn, err := walletService.Withdraw(ctx, sender.ID, currency, decimal.NewFromInt(10_000_000), "", nil)
+		assert.NoError(t, err)
+		assert.True(t, decimal.NewFromInt(9_000_000).Equal(txn.Amount))
+
+		assertFee(t, txn, 1_000_000)
+		assertBalance(t, sender.ID, 90_000_000)
+	})
+
+	t.Run("should credit the recipient net of the transfer fee", func(t *testing.T) {
+		txn, err := walletService.Transfer(ctx, sender.ID, recipient.ID, currency, decimal.NewFromInt(10_000_000), "", "", nil)
+		assert.NoError(t, err)
+		assert.True(t, decimal.NewFromInt(9_900_000).Equal(txn.Amount))
+
+		assertFee(t, txn, 100_000)
+		assertBalance(t, sender.ID, 80_000_000)
+		assertBalance(t, recipient.ID, 9_900_000)
+	})
+
+	t.Run("should reject amounts not covering the fee", func(t *testing.T) {
+		_, err := walletService.Withdraw(ctx, sender.ID, currency, decimal.NewFromInt(1_000_000), "", nil)
+		assert.Equal(t, services.ErrAmountBelowFee, err)
+		assertBalance(t, sender.ID, 80_000_000)
+	})
+
+	t.Run("should capture holds net of the transfer fee", func(t *testing.T) {
+		holdService := services.NewHoldService(tx, config.HoldsConfig{TTL: time.Hour})
+		holdService.Fees = feeService
+
+		hold, err := holdService.PlaceHold(sender.ID, recipient.ID, currency, decimal.NewFromInt(10_000_000), "", "", nil)
+		assert.NoError(t, err)
+		_, err = holdService.CaptureHold(sender.ID, hold.ID)
+		assert.NoError(t, err)
+
+		var capture models.Transaction
+		tx.Where("user_id = ? AND type = ?", sender.ID, models.HoldCaptured).First(&capture)
+		assert.True(t, decimal.NewFromInt(9_900_000).Equal(capture.Amount))
+
+		assertFee(t, &capture, 100_000)
+		assertBalance(t, sender.ID, 70_000_000)
+		assertBalance(t, recipient.ID, 19_800_000)
+	})
+
+	t.Run("should not refund the fee on reversal", func(t *testing.T) {
+		txn, err := walletService.Withdraw(ctx, sender.ID, currency, decimal.NewFromInt(10_000_000), "", nil)
+		assert.NoError(t, err)
+		assertBalance(t, sender.ID, 60_000_000)
+
+		reversal, err := walletService.Reverse(ctx, txn.ID, "", false)
+		assert.NoError(t, err)
+		assert.True(t, decimal.NewFromInt(9_000_000).Equal(reversal.Amount))
+
+		assertFee(t, txn, 1_000_000)
+		assertBalance(t, sender.ID, 69_000_000)
+	})
+}
@@ -0,0 +1,346 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/wanliqun/go-wallet-app/apperrors"
+	"github.com/wanliqun/go-wallet-app/config"
+	"github.com/wanliqun/go-wallet-app/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// expiryBatchSize is the maximum number of holds expired within a database transaction
+const expiryBatchSize = 100
+
+var (
+	ErrHoldNotFound   = apperrors.New(apperrors.HoldNotFound, "hold not found")
+	ErrHoldNotPending = apperrors.New(apperrors.HoldNotPending, "hold is no longer pending")
+	ErrHoldExpired    = apperrors.New(apperrors.HoldExpired, "hold expired")
+
+	_ IHoldService = &HoldService{}
+
+	// settledTransactionTypes are the transaction types recording the settlement of a hold
+	settledTransactionTypes = map[models.HoldStatus]models.TransactionType{
+		models.HoldStatusCaptured: models.HoldCaptured,
+		models.HoldStatusReleased: models.HoldReleased,
+		models.HoldStatusExpired:  models.HoldExpired,
+	}
+)
+
+type IHoldService interface {
+	PlaceHold(senderID, recipientID uint, currency string, amount decimal.Decimal, memo, idempotencyKey string, stepUp StepUpCheck) (*models.Hold, error)
+	CaptureHold(userID, holdID uint) (*models.Hold, error)
+	ReleaseHold(userID, holdID uint) (*models.Hold, error)
+	GetHold(userID, holdID uint) (*models.Hold, bool, error)
+	ExpireHolds() (int, error)
+}
+
+// HoldService represents the service for two-phase transfers, which reserve the funds of the
+// sender first and settle them later.
+type HoldService struct {
+	DB     *gorm.DB
+	Config config.HoldsConfig
+	Fees   IFeeService   // Transfer fee schedules charged on capture, no fees are charged if nil
+	Limits ILimitService // Limits of the held funds, no limits are enforced if nil
+}
+
+func NewHoldService(db *gorm.DB, conf config.HoldsConfig) *HoldService {
+	return &HoldService{DB: db, Config: conf}
+}
+
+// PlaceHold moves the amount from the available to the held balance of the sender, until the
+// hold is captured to the recipient, released or expired.
+func (s *HoldService) PlaceHold(
+	senderID, recipientID uint, currency string, amount decimal.Decimal, memo, idempotencyKey string, stepUp StepUpCheck,
+) (*models.Hold, error) {
+	if amount.LessThanOrEqual(decimal.Zero) {
+		return nil, ErrInvalidAmount
+	}
+
+	if recipientID == senderID {
+		return nil, ErrSelfTransfer
+	}
+
+	// Reject holds which could never be captured, the fee is charged on capture though
+	if _, _, err := s.calculateFee(currency, amount); err != nil {
+		return nil, err
+	}
+
+	var hold *models.Hold
+	fingerprint := idempotencyFingerprint("hold", recipientID, currency, amount, memo)
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		transaction, err := withIdempotency(tx, senderID, idempotencyKey, fingerprint, func() (*models.Transaction, error) {
+			if err := checkStepUp(stepUp, tx); err != nil {
+				return nil, err
+			}
+
+			// Reserve the funds atomically, ensuring the available balance doesn't go negative
+			result := tx.Model(&models.Vault{}).
+				Where("user_id = ? AND currency = ? AND amount >= ?", senderID, currency, amount).
+				Updates(map[string]interface{}{
+					"amount": gorm.Expr("amount - ?", amount),
+					"held":   gorm.Expr("held + ?", amount),
+				})
+			if result.Error != nil {
+				return nil, result.Error
+			}
+			if result.RowsAffected == 0 {
+				return nil, ErrInsufficientBalance
+			}
+
+			// Check the limits now that the vault is locked against concurrent holds
+			if err := checkLimits(s.Limits, tx, senderID, currency, amount); err != nil {
+				return nil, err
+			}
+
+			// Record the hold in transaction history
+			transaction := models.Transaction{
+				UserID:         senderID,
+				CounterpartyID: &recipientID,
+				Type:           models.HoldPlaced,
+				Amount:         amount,
+				Currency:       currency,
+				Memo:           memo,
+			}
+			if err := tx.Create(&transaction).Error; err != nil {
+				return nil, err
+			}
+
+			hold = &models.Hold{
+				UserID:        senderID,
+				RecipientID:   recipientID,
+				Amount:        amount,
+				Currency:      currency,
+				Memo:          memo,
+				Status:        models.HoldStatusPending,
+				ExpiresAt:     time.Now().Add(s.Config.TTL),
+				TransactionID: transaction.ID,
+			}
+			if err := tx.Create(hold).Error; err != nil {
+				return nil, err
+			}
+
+			if err := enqueueTransactionEvents(tx, &transaction); err != nil {
+				return nil, err
+			}
+
+			return &transaction, nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// The request was replayed, return the hold placed originally
+		if hold == nil {
+			hold = &models.Hold{}
+			return tx.Where("transaction_id = ?", transaction.ID).First(hold).Error
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return hold, nil
+}
+
+// CaptureHold completes the transfer of the held funds to the recipient, charging the transfer
+// fee of the currency at the time of the capture on the held amount.
+func (s *HoldService) CaptureHold(userID, holdID uint) (*models.Hold, error) {
+	return s.settleHold(userID, holdID, models.HoldStatusCaptured)
+}
+
+// ReleaseHold returns the held funds to the available balance of the sender
+func (s *HoldService) ReleaseHold(userID, holdID uint) (*models.Hold, error) {
+	return s.settleHold(userID, holdID, models.HoldStatusReleased)
+}
+
+// GetHold returns the hold placed by or for the user
+func (s *HoldService) GetHold(userID, holdID uint) (*models.Hold, bool, error) {
+	var hold models.Hold
+	err := s.DB.Where("id = ? AND (user_id = ? OR recipient_id = ?)", holdID, userID, userID).First(&hold).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	return &hold, true, nil
+}
+
+// ExpireHolds releases the pending holds past their expiry, and returns the number of holds expired
+func (s *HoldService) ExpireHolds() (int, error) {
+	var expired int
+	for {
+		var holds []models.Hold
+		err := s.DB.Transaction(func(tx *gorm.DB) error {
+			// Skip the holds being settled concurrently
+			err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+				Where("status = ? AND expires_at <= ?", models.HoldStatusPending, time.Now()).
+				Order("expires_at").
+				Limit(expiryBatchSize).
+				Find(&holds).Error
+			if err != nil {
+				return err
+			}
+
+			for i := range holds {
+				if err := settle(tx, &holds[i], models.HoldStatusExpired, decimal.Zero); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return expired, err
+		}
+
+		expired += len(holds)
+		if len(holds) < expiryBatchSize {
+			return expired, nil
+		}
+	}
+}
+
+// Schedule expires holds periodically until the context is canceled
+func (s *HoldService) Schedule(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.ExpireHolds(); err != nil {
+				log.Printf("failed to expire holds: %v", err)
+			}
+		}
+	}
+}
+
+// settleHold settles the pending hold placed by the user
+func (s *HoldService) settleHold(userID, holdID uint, status models.HoldStatus) (*models.Hold, error) {
+	var hold models.Hold
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND user_id = ?", holdID, userID).
+			First(&hold).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrHoldNotFound
+			}
+			return err
+		}
+
+		if hold.Status != models.HoldStatusPending {
+			return ErrHoldNotPending
+		}
+		if status != models.HoldStatusCaptured {
+			return settle(tx, &hold, status, decimal.Zero)
+		}
+
+		if !hold.ExpiresAt.After(time.Now()) {
+			return ErrHoldExpired
+		}
+
+		fee, _, err := s.calculateFee(hold.Currency, hold.Amount)
+		if err != nil {
+			return err
+		}
+		return settle(tx, &hold, status, fee)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &hold, nil
+}
+
+// calculateFee returns the transfer fee of the held amount and the net amount left for the recipient
+func (s *HoldService) calculateFee(currency string, amount decimal.Decimal) (decimal.Decimal, decimal.Decimal, error) {
+	if s.Fees == nil {
+		return decimal.Zero, amount, nil
+	}
+	return s.Fees.CalculateFee(currency, models.TransferOut, amount)
+}
+
+// settle takes the funds off the held balance of the sender, crediting the recipient net of the
+// fee if the hold is captured, or returning them to the available balance of the sender otherwise.
+func settle(tx *gorm.DB, hold *models.Hold, status models.HoldStatus, fee decimal.Decimal) error {
+	updates := map[string]interface{}{"held": gorm.Expr("held - ?", hold.Amount)}
+	if status != models.HoldStatusCaptured {
+		updates["amount"] = gorm.Expr("amount + ?", hold.Amount)
+	}
+	result := tx.Model(&models.Vault{}).
+		Where("user_id = ? AND currency = ? AND held >= ?", hold.UserID, hold.Currency, hold.Amount).
+		Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("failed to update sender's vault")
+	}
+
+	// Record the settlement in transaction history, a capture net of the fee
+	net := hold.Amount.Sub(fee)
+	batchTxns := []*models.Transaction{{
+		UserID:         hold.UserID,
+		CounterpartyID: &hold.RecipientID,
+		Type:           settledTransactionTypes[status],
+		Amount:         net,
+		Currency:       hold.Currency,
+		Memo:           hold.Memo,
+	}}
+
+	if status == models.HoldStatusCaptured {
+		if err := creditVault(tx, hold.RecipientID, hold.Currency, net); err != nil {
+			return err
+		}
+
+		// The capture is a transfer, both legs sharing a reference
+		batchTxns[0].Reference = uuid.NewString()
+		batchTxns = append(batchTxns, &models.Transaction{
+			UserID:         hold.RecipientID,
+			CounterpartyID: &hold.UserID,
+			Type:           models.TransferIn,
+			Amount:         net,
+			Currency:       hold.Currency,
+			Memo:           hold.Memo,
+			Reference:      batchTxns[0].Reference,
+		})
+	}
+
+	if err := tx.Create(batchTxns).Error; err != nil {
+		return err
+	}
+
+	// Held funds stay on the sender's ledger account until they are captured
+	var feeTxn *models.Transaction
+	if status == models.HoldStatusCaptured {
+		journal := newJournal(hold.Currency, "hold capture").
+			forTransaction(batchTxns[0]).
+			debit(userAccount(hold.UserID), hold.Amount).
+			credit(userAccount(hold.RecipientID), net)
+		var err error
+		if feeTxn, err = chargeFee(tx, batchTxns[0], fee, journal); err != nil {
+			return err
+		}
+		if err := journal.post(tx); err != nil {
+			return err
+		}
+	}
+
+	if err := enqueueTransactionEvents(tx, withFee(feeTxn, batchTxns...)...); err != nil {
+		return err
+	}
+
+	hold.Status = status
+	return tx.Model(hold).Update("status", status).Error
+}
@@ -0,0 +1,106 @@
+package services_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/wanliqun/go-wallet-app/config"
+	"github.com/wanliqun/go-wallet-app/models"
+	"github.com/wanliqun/go-wallet-app/services"
+)
+
+func TestHolds(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+
+	senderUser := userGenerator.Generate()
+	recipientUser := userGenerator.Generate()
+	tx.CreateInBatches([]*models.User{senderUser, recipientUser}, 2)
+
+	walletService := services.NewWalletService(tx)
+	holdService := services.NewHoldService(tx, config.HoldsConfig{TTL: time.Hour})
+
+	currency := "USDT"
+
+	assertVault := func(t *testing.T, userID uint, amount, held int64) {
+		var vault models.Vault
+		tx.First(&vault, "user_id = ? AND currency = ?", userID, currency)
+		assert.True(t, decimal.NewFromInt(amount).Equal(vault.Amount), "vault amount %v", vault.Amount)
+		assert.True(t, decimal.NewFromInt(held).Equal(vault.Held), "vault held %v", vault.Held)
+	}
+
+	walletService.Deposit(ctx, senderUser.ID, currency, decimal.NewFromInt(100), "")
+
+	t.Run("should refuse to hold more than available", func(t *testing.T) {
+		_, err := holdService.PlaceHold(senderUser.ID, recipientUser.ID, currency, decimal.NewFromInt(200), "", "", nil)
+		assert.ErrorIs(t, err, services.ErrInsufficientBalance)
+	})
+
+	t.Run("should capture hold", func(t *testing.T) {
+		hold, err := holdService.PlaceHold(senderUser.ID, recipientUser.ID, currency, decimal.NewFromInt(60), "order", "hold-key", nil)
+		assert.NoError(t, err)
+		assert.Equal(t, models.HoldStatusPending, hold.Status)
+		assertVault(t, senderUser.ID, 40, 60)
+
+		replayed, err := holdService.PlaceHold(senderUser.ID, recipientUser.ID, currency, decimal.NewFromInt(60), "order", "hold-key", nil)
+		assert.NoError(t, err)
+		assert.Equal(t, hold.ID, replayed.ID)
+		assertVault(t, senderUser.ID, 40, 60)
+
+		_, err = holdService.CaptureHold(recipientUser.ID, hold.ID)
+		assert.ErrorIs(t, err, services.ErrHoldNotFound)
+
+		captured, err := holdService.CaptureHold(senderUser.ID, hold.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, models.HoldStatusCaptured, captured.Status)
+		assertVault(t, senderUser.ID, 40, 0)
+		assertVault(t, recipientUser.ID, 60, 0)
+
+		_, err = holdService.ReleaseHold(senderUser.ID, hold.ID)
+		assert.ErrorIs(t, err, services.ErrHoldNotPending)
+	})
+
+	t.Run("should release hold", func(t *testing.T) {
+		hold, err := holdService.PlaceHold(senderUser.ID, recipientUser.ID, currency, decimal.NewFromInt(30), "", "", nil)
+		assert.NoError(t, err)
+		assertVault(t, senderUser.ID, 10, 30)
+
+		released, err := holdService.ReleaseHold(senderUser.ID, hold.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, models.HoldStatusReleased, released.Status)
+		assertVault(t, senderUser.ID, 40, 0)
+	})
+
+	t.Run("should expire hold", func(t *testing.T) {
+		hold, err := holdService.PlaceHold(senderUser.ID, recipientUser.ID, currency, decimal.NewFromInt(20), "", "", nil)
+		assert.NoError(t, err)
+
+		tx.Model(hold).Update("expires_at", time.Now().Add(-time.Minute))
+
+		_, err = holdService.CaptureHold(senderUser.ID, hold.ID)
+		assert.ErrorIs(t, err, services.ErrHoldExpired)
+
+		expired, err := holdService.ExpireHolds()
+		assert.NoError(t, err)
+		assert.GreaterOrEqual(t, expired, 1)
+		assertVault(t, senderUser.ID, 40, 0)
+
+		hold, ok, err := holdService.GetHold(recipientUser.ID, hold.ID)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, models.HoldStatusExpired, hold.Status)
+	})
+
+	t.Run("should record each step in history", func(t *testing.T) {
+		var types []models.TransactionType
+		tx.Model(&models.Transaction{}).Where("user_id = ?", senderUser.ID).Order("id").Pluck("type", &types)
+		assert.Equal(t, []models.TransactionType{
+			models.Deposit,
+			models.HoldPlaced, models.HoldCaptured,
+			models.HoldPlaced, models.HoldReleased,
+			models.HoldPlaced, models.HoldExpired,
+		}, types)
+	})
+}
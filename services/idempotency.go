@@ -0,0 +1,78 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/wanliqun/go-wallet-app/apperrors"
+	"github.com/wanliqun/go-wallet-app/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrIdempotencyKeyConflict = apperrors.New(apperrors.IdempotencyKeyConflict, "idempotency key already used with a different request")
+)
+
+// idempotencyFingerprint computes a stable fingerprint of an operation and its parameters
+func idempotencyFingerprint(operation string, params ...interface{}) string {
+	h := sha256.New()
+	h.Write([]byte(operation))
+	for _, p := range params {
+		fmt.Fprintf(h, "|%v", p)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// withIdempotency runs the operation within the database transaction guarded by the idempotency key.
+// If the key has already been used by the same user for the same request, the original transaction
+// is replayed without running the operation again. An empty key disables the guard.
+func withIdempotency(
+	tx *gorm.DB, userID uint, key, fingerprint string, operation func() (*models.Transaction, error),
+) (*models.Transaction, error) {
+	if key == "" {
+		return operation()
+	}
+
+	// Claim the key, concurrent requests with the same key are blocked by the unique index
+	// until the first one commits or rolls back.
+	record := models.IdempotencyKey{
+		UserID:      userID,
+		Key:         key,
+		Fingerprint: fingerprint,
+	}
+	result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&record)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	// The key was already claimed, replay the stored result
+	if result.RowsAffected == 0 {
+		var existing models.IdempotencyKey
+		if err := tx.Where("user_id = ? AND key = ?", userID, key).First(&existing).Error; err != nil {
+			return nil, err
+		}
+		if existing.Fingerprint != fingerprint {
+			return nil, ErrIdempotencyKeyConflict
+		}
+
+		var transaction models.Transaction
+		if err := tx.First(&transaction, existing.TransactionID).Error; err != nil {
+			return nil, err
+		}
+		return &transaction, nil
+	}
+
+	transaction, err := operation()
+	if err != nil {
+		return nil, err
+	}
+
+	// Store the result so that retries can replay it
+	if err := tx.Model(&record).Update("transaction_id", transaction.ID).Error; err != nil {
+		return nil, err
+	}
+
+	return transaction, nil
+}
@@ -0,0 +1,75 @@
+package services_test
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/wanliqun/go-wallet-app/models"
+	"github.com/wanliqun/go-wallet-app/services"
+)
+
+func TestIdempotentDeposit(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+
+	walletService := services.NewWalletService(tx)
+
+	testuser := userGenerator.Generate()
+	tx.Create(testuser)
+
+	currency := "USDT"
+	amount := decimal.NewFromFloat(100.0)
+
+	t.Run("should replay the original transaction for a duplicate key", func(t *testing.T) {
+		first, err := walletService.Deposit(ctx, testuser.ID, currency, amount, "deposit-key")
+		assert.NoError(t, err)
+
+		second, err := walletService.Deposit(ctx, testuser.ID, currency, amount, "deposit-key")
+		assert.NoError(t, err)
+		assert.Equal(t, first.ID, second.ID)
+
+		var count int64
+		tx.Model(&models.Transaction{}).Where("user_id = ?", testuser.ID).Count(&count)
+		assert.EqualValues(t, 1, count)
+	})
+
+	t.Run("should return conflict when the key is reused with a different request", func(t *testing.T) {
+		_, err := walletService.Deposit(ctx, testuser.ID, currency, decimal.NewFromFloat(50.0), "deposit-key")
+		assert.Equal(t, services.ErrIdempotencyKeyConflict, err)
+	})
+}
+
+func TestIdempotentTransfer(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+
+	senderUser := userGenerator.Generate()
+	recipientUser := userGenerator.Generate()
+	tx.CreateInBatches([]*models.User{senderUser, recipientUser}, 2)
+
+	walletService := services.NewWalletService(tx)
+
+	currency := "USDT"
+	amount := decimal.NewFromFloat(30.0)
+
+	walletService.Deposit(ctx, senderUser.ID, currency, decimal.NewFromFloat(100.0), "")
+
+	t.Run("should move funds only once for a duplicate key", func(t *testing.T) {
+		first, err := walletService.Transfer(ctx, senderUser.ID, recipientUser.ID, currency, amount, "memo", "transfer-key", nil)
+		assert.NoError(t, err)
+
+		second, err := walletService.Transfer(ctx, senderUser.ID, recipientUser.ID, currency, amount, "memo", "transfer-key", nil)
+		assert.NoError(t, err)
+		assert.Equal(t, first.ID, second.ID)
+
+		var senderVault models.Vault
+		tx.First(&senderVault, "user_id = ? AND currency = ?", senderUser.ID, currency)
+		assert.True(t, decimal.NewFromFloat(70.0).Equal(senderVault.Amount))
+	})
+
+	t.Run("should return conflict for a different memo", func(t *testing.T) {
+		_, err := walletService.Transfer(ctx, senderUser.ID, recipientUser.ID, currency, amount, "other memo", "transfer-key", nil)
+		assert.Equal(t, services.ErrIdempotencyKeyConflict, err)
+	})
+}
@@ -0,0 +1,241 @@
+package services
+
+import (
+	"errors"
+
+	"github.com/shopspring/decimal"
+	"github.com/wanliqun/go-wallet-app/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrUnbalancedEntry = errors.New("unbalanced journal entry")
+
+	_ ILedgerService = &LedgerService{}
+)
+
+// accountRef identifies a ledger account regardless of its currency
+type accountRef struct {
+	code   string
+	userID *uint
+}
+
+// userAccount refers to the ledger account of the user
+func userAccount(userID uint) accountRef {
+	return accountRef{code: models.UserLedgerAccountCode(userID), userID: &userID}
+}
+
+// systemAccount refers to a system ledger account of the platform
+func systemAccount(code string) accountRef {
+	return accountRef{code: code}
+}
+
+type journalLine struct {
+	account accountRef
+	side    models.PostingSide
+	amount  decimal.Decimal
+}
+
+// journal assembles the postings of a journal entry before it is posted
+type journal struct {
+	currency      string
+	description   string
+	transactionID *uint
+	lines         []journalLine
+}
+
+func newJournal(currency, description string) *journal {
+	return &journal{currency: currency, description: description}
+}
+
+// forTransaction links the journal entry to the transaction row recording the operation
+func (j *journal) forTransaction(transaction *models.Transaction) *journal {
+	j.transactionID = &transaction.ID
+	return j
+}
+
+func (j *journal) debit(account accountRef, amount decimal.Decimal) *journal {
+	j.lines = append(j.lines, journalLine{account: account, side: models.Debit, amount: amount})
+	return j
+}
+
+func (j *journal) credit(account accountRef, amount decimal.Decimal) *journal {
+	j.lines = append(j.lines, journalLine{account: account, side: models.Credit, amount: amount})
+	return j
+}
+
+// move debits the source account and credits the destination account by the same amount
+func (j *journal) move(from, to accountRef, amount decimal.Decimal) *journal {
+	return j.debit(from, amount).credit(to, amount)
+}
+
+// post validates that the journal entry is balanced and writes it within the database transaction
+func (j *journal) post(tx *gorm.DB) error {
+	debits, credits := decimal.Zero, decimal.Zero
+	for _, line := range j.lines {
+		if !line.amount.IsPositive() {
+			return ErrUnbalancedEntry
+		}
+		if line.side == models.Debit {
+			debits = debits.Add(line.amount)
+		} else {
+			credits = credits.Add(line.amount)
+		}
+	}
+	if len(j.lines) == 0 || !debits.Equal(credits) {
+		return ErrUnbalancedEntry
+	}
+
+	entry := models.JournalEntry{
+		TransactionID: j.transactionID,
+		Currency:      j.currency,
+		Description:   j.description,
+	}
+	for _, line := range j.lines {
+		account, err := ensureLedgerAccount(tx, line.account, j.currency)
+		if err != nil {
+			return err
+		}
+
+		entry.Postings = append(entry.Postings, models.Posting{
+			AccountID: account.ID,
+			Side:      line.side,
+			Amount:    line.amount,
+		})
+	}
+
+	return tx.Create(&entry).Error
+}
+
+// ensureLedgerAccount returns the ledger account in the currency, creating it if absent
+func ensureLedgerAccount(tx *gorm.DB, ref accountRef, currency string) (*models.LedgerAccount, error) {
+	account := models.LedgerAccount{
+		Code:     ref.code,
+		Currency: currency,
+		Type:     models.SystemLedgerAccount,
+		UserID:   ref.userID,
+	}
+	if ref.userID != nil {
+		account.Type = models.UserLedgerAccount
+	}
+
+	result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&account)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected > 0 {
+		return &account, nil
+	}
+
+	// The account already exists
+	account = models.LedgerAccount{}
+	if err := tx.Where("code = ? AND currency = ?", ref.code, currency).First(&account).Error; err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
+
+// signedPostingAmount is the SQL expression of a posting amount signed by its side,
+// credits increase and debits decrease the balance of user accounts.
+const signedPostingAmount = "CASE WHEN postings.side = 'credit' THEN postings.amount ELSE -postings.amount END"
+
+type ILedgerService interface {
+	GetAccountBalance(userID uint, currency string) (decimal.Decimal, error)
+	RebuildVaults() (int64, error)
+	BackfillOpeningBalances() (int, error)
+}
+
+// LedgerService represents the service for the double-entry ledger underneath vault balances.
+// Vault amounts are a cache of the balances of the user ledger accounts, which can always be
+// derived from the postings.
+type LedgerService struct {
+	DB *gorm.DB
+}
+
+func NewLedgerService(db *gorm.DB) *LedgerService {
+	return &LedgerService{DB: db}
+}
+
+// GetAccountBalance derives the balance of the user's ledger account from its postings
+func (s *LedgerService) GetAccountBalance(userID uint, currency string) (decimal.Decimal, error) {
+	var balance decimal.NullDecimal
+	err := s.DB.Model(&models.Posting{}).
+		Select("SUM("+signedPostingAmount+")").
+		Joins("JOIN ledger_accounts ON ledger_accounts.id = postings.account_id").
+		Where("ledger_accounts.code = ? AND ledger_accounts.currency = ?", models.UserLedgerAccountCode(userID), currency).
+		Scan(&balance).Error
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	return balance.Decimal, nil
+}
+
+// RebuildVaults overwrites cached vault amounts which differ from the ledger balances,
+// and returns the number of vaults updated. Held amounts are kept, since holds do not
+// move funds out of the user's ledger account until captured.
+func (s *LedgerService) RebuildVaults() (int64, error) {
+	result := s.DB.Exec(`
+		UPDATE vaults SET amount = balances.balance - vaults.held, updated_at = NOW()
+		FROM (
+			SELECT ledger_accounts.user_id, ledger_accounts.currency, SUM(`+signedPostingAmount+`) AS balance
+			FROM postings JOIN ledger_accounts ON ledger_accounts.id = postings.account_id
+			WHERE ledger_accounts.type = ? AND ledger_accounts.deleted_at IS NULL
+			GROUP BY ledger_accounts.user_id, ledger_accounts.currency
+		) AS balances
+		WHERE vaults.user_id = balances.user_id AND vaults.currency = balances.currency
+			AND vaults.amount + vaults.held <> balances.balance`, models.UserLedgerAccount)
+	return result.RowsAffected, result.Error
+}
+
+// BackfillOpeningBalances posts the balances of vaults which predate the ledger as opening
+// balances, and returns the number of vaults backfilled. It is safe to run repeatedly, and by
+// instances starting concurrently: each vault is locked and checked again for a ledger account
+// before its balance is posted, so that it is only posted once.
+func (s *LedgerService) BackfillOpeningBalances() (int, error) {
+	var vaults []models.Vault
+	err := s.DB.Model(&models.Vault{}).
+		Joins("LEFT JOIN ledger_accounts ON ledger_accounts.code = 'user:' || vaults.user_id AND ledger_accounts.currency = vaults.currency").
+		Where("ledger_accounts.id IS NULL").
+		Find(&vaults).Error
+	if err != nil {
+		return 0, err
+	}
+
+	backfilled := 0
+	for _, vault := range vaults {
+		err := s.DB.Transaction(func(tx *gorm.DB) error {
+			// Read the vault again under lock, another instance may have backfilled it meanwhile
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&vault, vault.ID).Error; err != nil {
+				return err
+			}
+
+			var accounts int64
+			err := tx.Model(&models.LedgerAccount{}).
+				Where("code = ? AND currency = ?", models.UserLedgerAccountCode(vault.UserID), vault.Currency).
+				Count(&accounts).Error
+			if err != nil || accounts > 0 {
+				return err
+			}
+
+			backfilled++
+			journal := newJournal(vault.Currency, "opening balance")
+			switch {
+			case vault.Amount.IsPositive():
+				journal.move(systemAccount(models.OpeningBalancesAccount), userAccount(vault.UserID), vault.Amount)
+			case vault.Amount.IsNegative():
+				journal.move(userAccount(vault.UserID), systemAccount(models.OpeningBalancesAccount), vault.Amount.Neg())
+			default: // nothing to post, only open the account
+				_, err := ensureLedgerAccount(tx, userAccount(vault.UserID), vault.Currency)
+				return err
+			}
+			return journal.post(tx)
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return backfilled, nil
+}
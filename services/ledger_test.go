@@ -0,0 +1,98 @@
+package services_test
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/wanliqun/go-wallet-app/models"
+	"github.com/wanliqun/go-wallet-app/services"
+)
+
+func TestLedgerPostings(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+
+	senderUser := userGenerator.Generate()
+	recipientUser := userGenerator.Generate()
+	tx.CreateInBatches([]*models.User{senderUser, recipientUser}, 2)
+
+	walletService := services.NewWalletService(tx)
+	ledgerService := services.NewLedgerService(tx)
+
+	currency := "USDT"
+
+	walletService.Deposit(ctx, senderUser.ID, currency, decimal.NewFromInt(100), "")
+	walletService.Deposit(ctx, senderUser.ID, currency, decimal.NewFromInt(50), "")
+	walletService.Withdraw(ctx, senderUser.ID, currency, decimal.NewFromInt(30), "", nil)
+	walletService.Transfer(ctx, senderUser.ID, recipientUser.ID, currency, decimal.NewFromInt(20), "memo", "", nil)
+
+	t.Run("should derive the same balances as the vaults", func(t *testing.T) {
+		for userID, expected := range map[uint]int64{senderUser.ID: 100, recipientUser.ID: 20} {
+			balance, err := ledgerService.GetAccountBalance(userID, currency)
+			assert.NoError(t, err)
+			assert.True(t, decimal.NewFromInt(expected).Equal(balance))
+
+			var vault models.Vault
+			tx.First(&vault, "user_id = ? AND currency = ?", userID, currency)
+			assert.True(t, balance.Equal(vault.Amount))
+		}
+	})
+
+	t.Run("should post balanced journal entries", func(t *testing.T) {
+		var unbalanced int64
+		tx.Raw(`SELECT COUNT(*) FROM (
+			SELECT journal_entry_id FROM postings GROUP BY journal_entry_id
+			HAVING SUM(CASE WHEN side = 'debit' THEN amount ELSE -amount END) <> 0
+		) AS unbalanced`).Scan(&unbalanced)
+		assert.Zero(t, unbalanced)
+
+		var entries int64
+		tx.Model(&models.JournalEntry{}).
+			Joins("JOIN transactions ON transactions.id = journal_entries.transaction_id").
+			Where("transactions.user_id = ?", senderUser.ID).
+			Count(&entries)
+		assert.EqualValues(t, 4, entries)
+	})
+
+	t.Run("should rebuild vaults from the ledger", func(t *testing.T) {
+		tx.Model(&models.Vault{}).
+			Where("user_id = ? AND currency = ?", recipientUser.ID, currency).
+			Update("amount", decimal.NewFromInt(999))
+
+		updated, err := ledgerService.RebuildVaults()
+		assert.NoError(t, err)
+		assert.EqualValues(t, 1, updated)
+
+		var vault models.Vault
+		tx.First(&vault, "user_id = ? AND currency = ?", recipientUser.ID, currency)
+		assert.True(t, decimal.NewFromInt(20).Equal(vault.Amount))
+	})
+}
+
+func TestBackfillOpeningBalances(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+
+	testuser := userGenerator.Generate()
+	tx.Create(testuser)
+
+	// Vault created before the ledger was introduced
+	tx.Create(&models.Vault{UserID: testuser.ID, Currency: "BTC", Amount: decimal.NewFromInt(42)})
+
+	ledgerService := services.NewLedgerService(tx)
+
+	t.Run("should post the vault balance as opening balance once", func(t *testing.T) {
+		backfilled, err := ledgerService.BackfillOpeningBalances()
+		assert.NoError(t, err)
+		assert.GreaterOrEqual(t, backfilled, 1)
+
+		balance, err := ledgerService.GetAccountBalance(testuser.ID, "BTC")
+		assert.NoError(t, err)
+		assert.True(t, decimal.NewFromInt(42).Equal(balance))
+
+		backfilled, err = ledgerService.BackfillOpeningBalances()
+		assert.NoError(t, err)
+		assert.Zero(t, backfilled)
+	})
+}
@@ -0,0 +1,255 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/wanliqun/go-wallet-app/apperrors"
+	"github.com/wanliqun/go-wallet-app/config"
+	"github.com/wanliqun/go-wallet-app/models"
+	"github.com/wanliqun/go-wallet-app/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type LimitPeriod string
+
+const (
+	PerTransactionLimit LimitPeriod = "per_transaction"
+	DailyLimit          LimitPeriod = "daily"
+	MonthlyLimit        LimitPeriod = "monthly"
+)
+
+var (
+	ErrLimitExceeded = apperrors.New(apperrors.LimitExceeded, "transaction limit exceeded")
+
+	_ ILimitService = &LimitService{}
+
+	// limitedTransactionTypes are the outgoing transaction types counted towards the limits. Holds
+	// are counted once placed, fees along with the withdrawal or transfer they are charged for.
+	limitedTransactionTypes = []models.TransactionType{
+		models.Withdrawal, models.TransferOut, models.HoldPlaced, models.Fee,
+	}
+)
+
+// LimitExceededError reports the limit exceeded by a transaction and the remaining allowance
+type LimitExceededError struct {
+	Period    LimitPeriod
+	Currency  string
+	Precision int32
+	Limit     decimal.Decimal // Limit in minor units
+	Remaining decimal.Decimal // Amount that can still be sent out within the period in minor units
+}
+
+func (e *LimitExceededError) Error() string {
+	return fmt.Sprintf("%s limit of %s %s exceeded, remaining allowance is %s %s",
+		strings.ReplaceAll(string(e.Period), "_", "-"),
+		utils.FormatMinorUnits(e.Limit, e.Precision), e.Currency,
+		utils.FormatMinorUnits(e.Remaining, e.Precision), e.Currency)
+}
+
+func (e *LimitExceededError) Unwrap() error {
+	return ErrLimitExceeded
+}
+
+// Limits are the limits of the funds a user can send out in a currency, a zero limit means no limit
+type Limits struct {
+	PerTransaction decimal.Decimal
+	Daily          decimal.Decimal
+	Monthly        decimal.Decimal
+}
+
+// Of returns the limit of the period
+func (l Limits) Of(period LimitPeriod) decimal.Decimal {
+	switch period {
+	case DailyLimit:
+		return l.Daily
+	case MonthlyLimit:
+		return l.Monthly
+	default:
+		return l.PerTransaction
+	}
+}
+
+// LimitUsage represents the effective limits of a user in a currency in minor units, along with
+// the amounts already sent out within the current day and month.
+type LimitUsage struct {
+	Limits
+	Currency    string
+	Precision   int32
+	DailyUsed   decimal.Decimal
+	MonthlyUsed decimal.Decimal
+}
+
+// Remaining returns the amount that can still be sent out within the period, and false if unlimited
+func (u *LimitUsage) Remaining(period LimitPeriod) (decimal.Decimal, bool) {
+	var used decimal.Decimal
+	switch period {
+	case DailyLimit:
+		used = u.DailyUsed
+	case MonthlyLimit:
+		used = u.MonthlyUsed
+	}
+
+	limit := u.Of(period)
+	if !limit.IsPositive() {
+		return decimal.Zero, false
+	}
+	return decimal.Max(limit.Sub(used), decimal.Zero), true
+}
+
+type ILimitService interface {
+	GetLimits(userID uint, currency string) (*LimitUsage, error)
+	SetUserLimit(limit *models.UserLimit) error
+	DeleteUserLimit(userID uint, currency string) error
+	// CheckLimits checks the amount about to be sent out by the user within the database transaction
+	CheckLimits(tx *gorm.DB, userID uint, currency string, amount decimal.Decimal) error
+}
+
+// LimitService represents the service enforcing the limits of the funds users can send out
+type LimitService struct {
+	DB       *gorm.DB
+	Registry ICurrencyRegistry
+	Defaults map[string]Limits // Configured limits keyed by currency in human units
+}
+
+func NewLimitService(db *gorm.DB, registry ICurrencyRegistry, defaults map[string]Limits) *LimitService {
+	return &LimitService{DB: db, Registry: registry, Defaults: defaults}
+}
+
+// NewLimitServiceFromConfig parses the configured limits keyed by currency
+func NewLimitServiceFromConfig(
+	db *gorm.DB, registry ICurrencyRegistry, limits map[string]config.LimitConfig) (*LimitService, error) {
+	defaults := make(map[string]Limits, len(limits))
+	for currency, conf := range limits {
+		currency = strings.ToUpper(currency)
+
+		var l Limits
+		var err error
+		if l.PerTransaction, err = parseOptionalDecimal(conf.PerTransaction); err != nil {
+			return nil, fmt.Errorf("invalid per transaction limit of currency %s: %w", currency, err)
+		}
+		if l.Daily, err = parseOptionalDecimal(conf.Daily); err != nil {
+			return nil, fmt.Errorf("invalid daily limit of currency %s: %w", currency, err)
+		}
+		if l.Monthly, err = parseOptionalDecimal(conf.Monthly); err != nil {
+			return nil, fmt.Errorf("invalid monthly limit of currency %s: %w", currency, err)
+		}
+		defaults[currency] = l
+	}
+	return NewLimitService(db, registry, defaults), nil
+}
+
+// GetLimits returns the effective limits of the user in the currency and their usage
+func (s *LimitService) GetLimits(userID uint, currency string) (*LimitUsage, error) {
+	return s.getUsage(s.DB, userID, currency)
+}
+
+// SetUserLimit overrides the configured limits of the currency for the user
+func (s *LimitService) SetUserLimit(limit *models.UserLimit) error {
+	return s.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "currency"}},
+		DoUpdates: clause.AssignmentColumns([]string{"per_transaction", "daily", "monthly", "updated_at"}),
+	}).Create(limit).Error
+}
+
+// DeleteUserLimit restores the configured limits of the currency for the user
+func (s *LimitService) DeleteUserLimit(userID uint, currency string) error {
+	return s.DB.Unscoped().
+		Where("user_id = ? AND currency = ?", userID, currency).
+		Delete(&models.UserLimit{}).Error
+}
+
+// CheckLimits returns a LimitExceededError if sending out the amount exceeds any limit. It should
+// be called once the sender's vault is locked, so that concurrent transactions are serialized.
+func (s *LimitService) CheckLimits(tx *gorm.DB, userID uint, currency string, amount decimal.Decimal) error {
+	usage, err := s.getUsage(tx, userID, currency)
+	if err != nil {
+		return err
+	}
+
+	for _, period := range []LimitPeriod{PerTransactionLimit, DailyLimit, MonthlyLimit} {
+		remaining, ok := usage.Remaining(period)
+		if ok && amount.GreaterThan(remaining) {
+			return &LimitExceededError{
+				Period:    period,
+				Currency:  currency,
+				Precision: usage.Precision,
+				Limit:     usage.Of(period),
+				Remaining: remaining,
+			}
+		}
+	}
+	return nil
+}
+
+// getUsage merges the configured limits with the overrides of the user, and sums the amounts
+// sent out since the start of the current month (UTC).
+func (s *LimitService) getUsage(tx *gorm.DB, userID uint, currency string) (*LimitUsage, error) {
+	conf, ok := s.Registry.LookupCurrency(currency)
+	if !ok {
+		return nil, ErrCurrencyNotFound
+	}
+
+	defaults := s.Defaults[currency]
+	usage := LimitUsage{
+		Limits: Limits{
+			PerTransaction: defaults.PerTransaction.Shift(conf.Precision).Truncate(0),
+			Daily:          defaults.Daily.Shift(conf.Precision).Truncate(0),
+			Monthly:        defaults.Monthly.Shift(conf.Precision).Truncate(0),
+		},
+		Currency:  currency,
+		Precision: conf.Precision,
+	}
+
+	var override models.UserLimit
+	err := tx.Where("user_id = ? AND currency = ?", userID, currency).Take(&override).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	if override.PerTransaction.Valid {
+		usage.PerTransaction = override.PerTransaction.Decimal
+	}
+	if override.Daily.Valid {
+		usage.Daily = override.Daily.Decimal
+	}
+	if override.Monthly.Valid {
+		usage.Monthly = override.Monthly.Decimal
+	}
+
+	if !usage.Daily.IsPositive() && !usage.Monthly.IsPositive() {
+		return &usage, nil
+	}
+
+	// The range scan on (user_id, type, timestamp) is served by the idx_user_type_timestamp_id index
+	now := time.Now().UTC()
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	var used struct {
+		Daily   decimal.NullDecimal
+		Monthly decimal.NullDecimal
+	}
+	err = tx.Model(&models.Transaction{}).
+		Select("SUM(CASE WHEN timestamp >= ? THEN amount ELSE 0 END) AS daily, SUM(amount) AS monthly", dayStart).
+		Where("user_id = ? AND type IN ? AND timestamp >= ?", userID, limitedTransactionTypes, monthStart).
+		Where("currency = ?", currency).
+		Scan(&used).Error
+	if err != nil {
+		return nil, err
+	}
+
+	usage.DailyUsed, usage.MonthlyUsed = used.Daily.Decimal, used.Monthly.Decimal
+	return &usage, nil
+}
+
+// checkLimits checks the limits of the funds sent out by the user, unless no limits are enforced
+func checkLimits(limits ILimitService, tx *gorm.DB, userID uint, currency string, amount decimal.Decimal) error {
+	if limits == nil {
+		return nil
+	}
+	return limits.CheckLimits(tx, userID, currency, amount)
+}
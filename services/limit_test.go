@@ -0,0 +1,110 @@
+package services_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/wanliqun/go-wallet-app/config"
+	"github.com/wanliqun/go-wallet-app/models"
+	"github.com/wanliqun/go-wallet-app/services"
+)
+
+func TestLimits(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+
+	registry := staticRegistry{"USDT": {Code: "USDT", Precision: 2, Enabled: true}}
+	limitService, err := services.NewLimitServiceFromConfig(tx, registry, map[string]config.LimitConfig{
+		"usdt": {PerTransaction: "50", Daily: "100"},
+	})
+	assert.NoError(t, err)
+
+	walletService := services.NewWalletService(tx)
+	walletService.Limits = limitService
+
+	sender := userGenerator.Generate()
+	tx.Create(sender)
+	recipient := userGenerator.Generate()
+	tx.Create(recipient)
+
+	currency := "USDT"
+	walletService.Deposit(ctx, sender.ID, currency, decimal.NewFromInt(100_000), "")
+
+	assertLimitExceeded := func(t *testing.T, err error, period services.LimitPeriod, remaining int64) {
+		assert.ErrorIs(t, err, services.ErrLimitExceeded)
+
+		var limitErr *services.LimitExceededError
+		if assert.True(t, errors.As(err, &limitErr)) {
+			assert.Equal(t, period, limitErr.Period)
+			assert.True(t, decimal.NewFromInt(remaining).Equal(limitErr.Remaining), "remaining %v", limitErr.Remaining)
+		}
+	}
+
+	t.Run("should reject amounts above the per transaction limit", func(t *testing.T) {
+		_, err := walletService.Withdraw(ctx, sender.ID, currency, decimal.NewFromInt(5_001), "", nil)
+		assertLimitExceeded(t, err, services.PerTransactionLimit, 5_000)
+	})
+
+	t.Run("should count withdrawals and transfers towards the daily limit", func(t *testing.T) {
+		_, err := walletService.Withdraw(ctx, sender.ID, currency, decimal.NewFromInt(4_000), "", nil)
+		assert.NoError(t, err)
+		_, err = walletService.Transfer(ctx, sender.ID, recipient.ID, currency, decimal.NewFromInt(4_000), "", "", nil)
+		assert.NoError(t, err)
+
+		_, err = walletService.Transfer(ctx, sender.ID, recipient.ID, currency, decimal.NewFromInt(2_500), "", "", nil)
+		assertLimitExceeded(t, err, services.DailyLimit, 2_000)
+
+		// The rejected transfer is rolled back
+		var vault models.Vault
+		tx.First(&vault, "user_id = ? AND currency = ?", sender.ID, currency)
+		assert.True(t, decimal.NewFromInt(92_000).Equal(vault.Amount))
+
+		usage, err := limitService.GetLimits(sender.ID, currency)
+		assert.NoError(t, err)
+		assert.True(t, decimal.NewFromInt(8_000).Equal(usage.DailyUsed))
+		assert.True(t, decimal.NewFromInt(8_000).Equal(usage.MonthlyUsed))
+	})
+
+	t.Run("should apply the overrides of the user", func(t *testing.T) {
+		err := limitService.SetUserLimit(&models.UserLimit{
+			UserID:   sender.ID,
+			Currency: currency,
+			Daily:    decimal.NewNullDecimal(decimal.Zero),
+			Monthly:  decimal.NewNullDecimal(decimal.NewFromInt(9_000)),
+		})
+		assert.NoError(t, err)
+
+		usage, err := limitService.GetLimits(sender.ID, currency)
+		assert.NoError(t, err)
+		assert.True(t, decimal.NewFromInt(5_000).Equal(usage.PerTransaction))
+		assert.True(t, usage.Daily.IsZero())
+
+		_, err = walletService.Withdraw(ctx, sender.ID, currency, decimal.NewFromInt(1_500), "", nil)
+		assertLimitExceeded(t, err, services.MonthlyLimit, 1_000)
+
+		_, err = walletService.Withdraw(ctx, sender.ID, currency, decimal.NewFromInt(1_000), "", nil)
+		assert.NoError(t, err)
+	})
+
+	t.Run("should restore the configured limits", func(t *testing.T) {
+		err := limitService.DeleteUserLimit(sender.ID, currency)
+		assert.NoError(t, err)
+
+		usage, err := limitService.GetLimits(sender.ID, currency)
+		assert.NoError(t, err)
+		assert.True(t, decimal.NewFromInt(10_000).Equal(usage.Daily))
+
+		remaining, ok := usage.Remaining(services.DailyLimit)
+		assert.True(t, ok)
+		assert.True(t, decimal.NewFromInt(1_000).Equal(remaining))
+	})
+
+	t.Run("should reject invalid configuration", func(t *testing.T) {
+		_, err := services.NewLimitServiceFromConfig(tx, registry, map[string]config.LimitConfig{
+			"usdt": {Daily: "-100"},
+		})
+		assert.Error(t, err)
+	})
+}
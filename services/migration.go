@@ -0,0 +1,108 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/wanliqun/go-wallet-app/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// MinorUnitsMigration is the name of the migration scaling the amounts stored in whole units,
+// before amounts were stored in minor units, by the precision of their currency.
+const MinorUnitsMigration = "minor_units"
+
+// ErrMinorUnitsPending reports a database holding amounts which may still be in whole units
+var ErrMinorUnitsPending = errors.New("amounts may predate minor units, " +
+	"run the migrate-minor-units subcommand, or migrate-minor-units -mark-applied if they are already in minor units")
+
+// CheckMinorUnits returns ErrMinorUnitsPending unless the amounts are known to be in minor units,
+// either since the migration was applied or since no amount was stored yet. An empty database is
+// recorded as migrated, so that amounts stored from then on are never scaled.
+func CheckMinorUnits(db *gorm.DB) error {
+	applied, err := isMigrationApplied(db, MinorUnitsMigration)
+	if err != nil || applied {
+		return err
+	}
+
+	var count int64
+	if err := db.Unscoped().Model(&models.Vault{}).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		if err := db.Unscoped().Model(&models.Transaction{}).Count(&count).Error; err != nil {
+			return err
+		}
+	}
+	if count > 0 {
+		return ErrMinorUnitsPending
+	}
+
+	_, err = MarkMigrationApplied(db, MinorUnitsMigration)
+	return err
+}
+
+// MigrateToMinorUnits multiplies the vault, transaction, hold and ledger posting amounts by
+// 10^precision of their currency. It returns false without changing anything if the migration
+// was already applied, and fails if any currency is missing from the registry.
+func MigrateToMinorUnits(db *gorm.DB, registry ICurrencyRegistry) (bool, error) {
+	var applied bool
+	err := db.Transaction(func(tx *gorm.DB) (err error) {
+		// Recording the migration first guards against concurrent runs too
+		if applied, err = MarkMigrationApplied(tx, MinorUnitsMigration); err != nil || !applied {
+			return err
+		}
+
+		var currencies []string
+		err = tx.Raw(`SELECT currency FROM vaults UNION SELECT currency FROM transactions
+			UNION SELECT currency FROM holds UNION SELECT currency FROM journal_entries`).Scan(&currencies).Error
+		if err != nil {
+			return err
+		}
+
+		for _, code := range currencies {
+			currency, ok := registry.LookupCurrency(code)
+			if !ok {
+				return fmt.Errorf("%w: %s", ErrCurrencyNotFound, code)
+			}
+			factor := decimal.New(1, currency.Precision)
+
+			updates := []struct {
+				query string
+				args  []interface{}
+			}{
+				{"UPDATE vaults SET amount = amount * ?, held = held * ? WHERE currency = ?", []interface{}{factor, factor, code}},
+				{"UPDATE transactions SET amount = amount * ? WHERE currency = ?", []interface{}{factor, code}},
+				{"UPDATE holds SET amount = amount * ? WHERE currency = ?", []interface{}{factor, code}},
+				{`UPDATE postings SET amount = amount * ?
+					WHERE journal_entry_id IN (SELECT id FROM journal_entries WHERE currency = ?)`, []interface{}{factor, code}},
+			}
+			for _, update := range updates {
+				if err := tx.Exec(update.query, update.args...).Error; err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return applied, nil
+}
+
+// MarkMigrationApplied records the migration as applied, returning false if it already was
+func MarkMigrationApplied(db *gorm.DB, name string) (bool, error) {
+	result := db.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&models.SchemaMigration{Name: name, AppliedAt: time.Now()})
+	return result.RowsAffected > 0, result.Error
+}
+
+func isMigrationApplied(db *gorm.DB, name string) (bool, error) {
+	var count int64
+	err := db.Model(&models.SchemaMigration{}).Where("name = ?", name).Count(&count).Error
+	return count > 0, err
+}
@@ -0,0 +1,65 @@
+package services_test
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/wanliqun/go-wallet-app/models"
+	"github.com/wanliqun/go-wallet-app/services"
+)
+
+func TestMigrateToMinorUnits(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+
+	user := userGenerator.Generate()
+	tx.Create(user)
+
+	// Amounts stored in whole units, before amounts were stored in minor units
+	tx.Create(&models.Vault{UserID: user.ID, Currency: "BTC", Amount: decimal.NewFromInt(1100), Held: decimal.NewFromInt(5)})
+	tx.Create(&models.Transaction{UserID: user.ID, Type: models.Deposit, Currency: "BTC", Amount: decimal.NewFromInt(1105)})
+
+	assert.ErrorIs(t, services.CheckMinorUnits(tx), services.ErrMinorUnitsPending)
+
+	registry := staticRegistry{"BTC": {Code: "BTC", Precision: 8}}
+	applied, err := services.MigrateToMinorUnits(tx, registry)
+	assert.NoError(t, err)
+	assert.True(t, applied)
+	assert.NoError(t, services.CheckMinorUnits(tx))
+
+	assertAmounts := func(t *testing.T) {
+		var vault models.Vault
+		tx.First(&vault, "user_id = ? AND currency = ?", user.ID, "BTC")
+		assert.True(t, decimal.RequireFromString("110000000000").Equal(vault.Amount), "vault amount %v", vault.Amount)
+		assert.True(t, decimal.RequireFromString("500000000").Equal(vault.Held), "vault held %v", vault.Held)
+
+		var transaction models.Transaction
+		tx.First(&transaction, "user_id = ?", user.ID)
+		assert.True(t, decimal.RequireFromString("110500000000").Equal(transaction.Amount), "transaction amount %v", transaction.Amount)
+	}
+	assertAmounts(t)
+
+	// The migration only ever runs once
+	applied, err = services.MigrateToMinorUnits(tx, registry)
+	assert.NoError(t, err)
+	assert.False(t, applied)
+	assertAmounts(t)
+}
+
+func TestMigrateToMinorUnits_UnknownCurrency(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+
+	user := userGenerator.Generate()
+	tx.Create(user)
+	tx.Create(&models.Vault{UserID: user.ID, Currency: "XYZ", Amount: decimal.NewFromInt(10)})
+
+	applied, err := services.MigrateToMinorUnits(tx, staticRegistry{})
+	assert.ErrorIs(t, err, services.ErrCurrencyNotFound)
+	assert.False(t, applied)
+
+	var vault models.Vault
+	tx.First(&vault, "user_id = ?", user.ID)
+	assert.True(t, decimal.NewFromInt(10).Equal(vault.Amount))
+}
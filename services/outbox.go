@@ -0,0 +1,173 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/wanliqun/go-wallet-app/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// relayBatchSize is the maximum number of events relayed within a database transaction
+const relayBatchSize = 100
+
+// EventEnvelope is the published form of an outbox event, as posted to the webhook endpoints
+// and to the event publisher
+type EventEnvelope struct {
+	ID        uint                `json:"id"` // ID of the event, to deduplicate redelivered events
+	Type      string              `json:"type"`
+	UserID    uint                `json:"user_id"` // User the event is about
+	CreatedAt time.Time           `json:"created_at"`
+	Data      models.EventPayload `json:"data"`
+}
+
+func newEventEnvelope(event *models.OutboxEvent) EventEnvelope {
+	return EventEnvelope{
+		ID:        event.ID,
+		Type:      event.Type,
+		UserID:    event.UserID,
+		CreatedAt: event.CreatedAt,
+		Data:      event.Payload,
+	}
+}
+
+// enqueueEvent records the event in the outbox within the database transaction of the change
+// it describes, the event is dispatched once the transaction is committed.
+func enqueueEvent(tx *gorm.DB, userID uint, eventType string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	event := models.OutboxEvent{
+		UserID:  userID,
+		Type:    eventType,
+		Payload: data,
+	}
+	return tx.Create(&event).Error
+}
+
+// enqueueTransactionEvents records the creation of the transactions in the outbox, followed by
+// the resulting balance of every vault they changed. It must be called once the vaults are updated.
+func enqueueTransactionEvents(tx *gorm.DB, transactions ...*models.Transaction) error {
+	type vaultKey struct {
+		userID   uint
+		currency string
+	}
+
+	var changed []vaultKey
+	changedBy := make(map[vaultKey]uint)
+	for _, txn := range transactions {
+		if err := enqueueEvent(tx, txn.UserID, models.EventTransactionCreated, txn); err != nil {
+			return err
+		}
+
+		key := vaultKey{txn.UserID, txn.Currency}
+		if _, ok := changedBy[key]; !ok {
+			changed = append(changed, key)
+			changedBy[key] = txn.ID
+		}
+	}
+
+	for _, key := range changed {
+		var vault models.Vault
+		if err := tx.Where("user_id = ? AND currency = ?", key.userID, key.currency).Take(&vault).Error; err != nil {
+			return err
+		}
+
+		change := models.BalanceChange{
+			UserID:        vault.UserID,
+			Currency:      vault.Currency,
+			Amount:        vault.Amount,
+			Held:          vault.Held,
+			TransactionID: changedBy[key],
+		}
+		if err := enqueueEvent(tx, key.userID, models.EventBalanceChanged, change); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OutboxRelay relays the committed outbox events to the event publisher at least once, redelivering
+// the events of a failed batch on the next run. Events are only published in order within a batch:
+// IDs are assigned on insert rather than on commit, so an event committed late by a slow transaction
+// may be published in a later batch than events following it.
+type OutboxRelay struct {
+	DB        *gorm.DB
+	Publisher IPublisher
+}
+
+func NewOutboxRelay(db *gorm.DB, publisher IPublisher) *OutboxRelay {
+	return &OutboxRelay{DB: db, Publisher: publisher}
+}
+
+// Relay publishes the unpublished events, and returns the number of events published
+func (r *OutboxRelay) Relay(ctx context.Context) (int, error) {
+	var published int
+	for {
+		var events []models.OutboxEvent
+		var relayed int
+		var publishErr error
+		err := r.DB.Transaction(func(tx *gorm.DB) error {
+			// Concurrent relays wait for each other rather than skipping the locked events, so
+			// that the events of a batch are not published by another relay in between
+			err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("published_at IS NULL").
+				Order("id").
+				Limit(relayBatchSize).
+				Find(&events).Error
+			if err != nil || len(events) == 0 {
+				return err
+			}
+
+			// Mark the events published up to the first failure
+			eventIDs := make([]uint, 0, len(events))
+			for i := range events {
+				if publishErr = r.Publisher.Publish(ctx, &events[i]); publishErr != nil {
+					break
+				}
+				eventIDs = append(eventIDs, events[i].ID)
+			}
+			if len(eventIDs) == 0 {
+				return nil
+			}
+
+			relayed = len(eventIDs)
+			return tx.Model(&models.OutboxEvent{}).
+				Where("id IN ?", eventIDs).
+				Update("published_at", time.Now()).Error
+		})
+		if err != nil {
+			return published, err
+		}
+
+		published += relayed
+		if publishErr != nil {
+			return published, publishErr
+		}
+		if len(events) < relayBatchSize {
+			return published, nil
+		}
+	}
+}
+
+// Schedule relays events periodically until the context is canceled
+func (r *OutboxRelay) Schedule(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := r.Relay(ctx); err != nil {
+				log.Printf("failed to relay outbox events: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package services_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/wanliqun/go-wallet-app/models"
+	"github.com/wanliqun/go-wallet-app/services"
+)
+
+// recordingBroker records the messages published to it, failing once failAfter messages are published
+type recordingBroker struct {
+	subjects  []string
+	keys      []string
+	messages  [][]byte
+	failAfter int
+}
+
+func (b *recordingBroker) Publish(ctx context.Context, subject, key string, data []byte, headers map[string]string) error {
+	if b.failAfter > 0 && len(b.messages) >= b.failAfter {
+		return errors.New("broker unavailable")
+	}
+	b.subjects = append(b.subjects, subject)
+	b.keys = append(b.keys, key)
+	b.messages = append(b.messages, data)
+	return nil
+}
+
+func TestOutboxRelay(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+
+	senderUser := userGenerator.Generate()
+	recipientUser := userGenerator.Generate()
+	tx.CreateInBatches([]*models.User{senderUser, recipientUser}, 2)
+
+	walletService := services.NewWalletService(tx)
+	publisher := services.NewMemoryPublisher(0)
+	relay := services.NewOutboxRelay(tx, publisher)
+
+	currency := "USDT"
+
+	type event struct {
+		Type   string
+		UserID uint
+	}
+	publishedEvents := func() []event {
+		var events []event
+		for _, e := range publisher.Events() {
+			if e.UserID == senderUser.ID || e.UserID == recipientUser.ID {
+				events = append(events, event{e.Type, e.UserID})
+			}
+		}
+		return events
+	}
+
+	t.Run("should publish events of committed operations in order", func(t *testing.T) {
+		_, err := walletService.Deposit(ctx, senderUser.ID, currency, decimal.NewFromInt(100), "")
+		assert.NoError(t, err)
+		transfer, err := walletService.Transfer(ctx, senderUser.ID, recipientUser.ID, currency, decimal.NewFromInt(30), "", "", nil)
+		assert.NoError(t, err)
+
+		_, err = relay.Relay(context.Background())
+		assert.NoError(t, err)
+
+		assert.Equal(t, []event{
+			{models.EventTransactionCreated, senderUser.ID},
+			{models.EventBalanceChanged, senderUser.ID},
+			{models.EventTransactionCreated, senderUser.ID},
+			{models.EventTransactionCreated, recipientUser.ID},
+			{models.EventBalanceChanged, senderUser.ID},
+			{models.EventBalanceChanged, recipientUser.ID},
+		}, publishedEvents())
+
+		events := publisher.Events()
+		var change models.BalanceChange
+		assert.NoError(t, json.Unmarshal(events[len(events)-2].Payload, &change))
+		assert.Equal(t, transfer.ID, change.TransactionID)
+		assert.True(t, decimal.NewFromInt(70).Equal(change.Amount), "balance %v", change.Amount)
+
+		// Published events are not published again
+		published, err := relay.Relay(context.Background())
+		assert.NoError(t, err)
+		assert.Zero(t, published)
+	})
+
+	t.Run("should not publish events of failed operations", func(t *testing.T) {
+		publisher.Reset()
+
+		_, err := walletService.Withdraw(ctx, senderUser.ID, currency, decimal.NewFromInt(1000), "", nil)
+		assert.ErrorIs(t, err, services.ErrInsufficientBalance)
+
+		_, err = relay.Relay(context.Background())
+		assert.NoError(t, err)
+		assert.Empty(t, publishedEvents())
+	})
+
+	t.Run("should resume publishing after broker failure", func(t *testing.T) {
+		broker := &recordingBroker{failAfter: 1}
+		brokerRelay := services.NewOutboxRelay(tx, services.NewBrokerPublisher(broker, "wallet."))
+
+		withdrawal, err := walletService.Withdraw(ctx, senderUser.ID, currency, decimal.NewFromInt(10), "", nil)
+		assert.NoError(t, err)
+
+		published, err := brokerRelay.Relay(context.Background())
+		assert.Error(t, err)
+		assert.Equal(t, 1, published)
+
+		broker.failAfter = 0
+		published, err = brokerRelay.Relay(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, 1, published)
+
+		assert.Equal(t, []string{"wallet.transaction.created", "wallet.balance.changed"}, broker.subjects)
+
+		var envelope services.EventEnvelope
+		assert.NoError(t, json.Unmarshal(broker.messages[0], &envelope))
+		assert.Equal(t, senderUser.ID, envelope.UserID)
+
+		var txn models.Transaction
+		assert.NoError(t, json.Unmarshal(envelope.Data, &txn))
+		assert.Equal(t, withdrawal.ID, txn.ID)
+	})
+}
+
+func TestMemoryPublisher(t *testing.T) {
+	publisher := services.NewMemoryPublisher(2)
+	for id := uint(1); id <= 3; id++ {
+		assert.NoError(t, publisher.Publish(context.Background(), &models.OutboxEvent{ID: id}))
+	}
+
+	events := publisher.Events()
+	assert.Len(t, events, 2)
+	assert.Equal(t, uint(2), events[0].ID)
+	assert.Equal(t, uint(3), events[1].ID)
+}
+
+func TestMemoryPublisherSubscribe(t *testing.T) {
+	publisher := services.NewMemoryPublisher(0)
+
+	events, cancel := publisher.Subscribe(1, 1)
+	slowEvents, _ := publisher.Subscribe(1, 0)
+	otherEvents, cancelOther := publisher.Subscribe(2, 1)
+	defer cancelOther()
+
+	assert.NoError(t, publisher.Publish(context.Background(), &models.OutboxEvent{ID: 1, UserID: 1}))
+	assert.Equal(t, uint(1), (<-events).ID)
+	assert.Empty(t, otherEvents)
+
+	// Subscribers falling behind are dropped
+	_, ok := <-slowEvents
+	assert.False(t, ok)
+
+	cancel()
+	_, ok = <-events
+	assert.False(t, ok)
+	cancel()
+}
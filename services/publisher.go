@@ -0,0 +1,145 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync"
+
+	"github.com/wanliqun/go-wallet-app/models"
+)
+
+var (
+	_ IPublisher    = &MemoryPublisher{}
+	_ ISubscribable = &MemoryPublisher{}
+	_ IPublisher    = &BrokerPublisher{}
+)
+
+// IPublisher publishes the committed domain events, such as transaction.created and balance.changed
+type IPublisher interface {
+	Publish(ctx context.Context, event *models.OutboxEvent) error
+}
+
+// ISubscribable delivers the events published about a user to the subscribers of the user
+type ISubscribable interface {
+	// Subscribe returns the channel of the events published from now on, which is closed once
+	// canceled or if the subscriber falls more than buffer events behind.
+	Subscribe(userID uint, buffer int) (events <-chan models.OutboxEvent, cancel func())
+}
+
+// MemoryPublisher keeps the published events in memory, so that they can be inspected without
+// an external broker, and passes them on to the subscribers within the process
+type MemoryPublisher struct {
+	Capacity int // Maximum number of events kept, the oldest events are dropped first, zero for no limit
+
+	mu          sync.Mutex
+	events      []models.OutboxEvent
+	subscribers map[uint]map[chan models.OutboxEvent]struct{} // Subscribers keyed by user ID
+}
+
+func NewMemoryPublisher(capacity int) *MemoryPublisher {
+	return &MemoryPublisher{Capacity: capacity}
+}
+
+func (p *MemoryPublisher) Publish(ctx context.Context, event *models.OutboxEvent) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.events = append(p.events, *event)
+	if p.Capacity > 0 && len(p.events) > p.Capacity {
+		p.events = append(p.events[:0], p.events[len(p.events)-p.Capacity:]...)
+	}
+
+	for ch := range p.subscribers[event.UserID] {
+		select {
+		case ch <- *event:
+		default:
+			// Drop the subscriber rather than blocking the relay, it is expected to resume
+			// from the last event received
+			p.unsubscribe(event.UserID, ch)
+		}
+	}
+	return nil
+}
+
+func (p *MemoryPublisher) Subscribe(userID uint, buffer int) (<-chan models.OutboxEvent, func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.subscribers == nil {
+		p.subscribers = make(map[uint]map[chan models.OutboxEvent]struct{})
+	}
+	if p.subscribers[userID] == nil {
+		p.subscribers[userID] = make(map[chan models.OutboxEvent]struct{})
+	}
+
+	ch := make(chan models.OutboxEvent, buffer)
+	p.subscribers[userID][ch] = struct{}{}
+
+	cancel := func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.unsubscribe(userID, ch)
+	}
+	return ch, cancel
+}
+
+// unsubscribe closes the channel of the subscriber unless already closed, the lock must be held
+func (p *MemoryPublisher) unsubscribe(userID uint, ch chan models.OutboxEvent) {
+	if _, ok := p.subscribers[userID][ch]; !ok {
+		return
+	}
+
+	delete(p.subscribers[userID], ch)
+	if len(p.subscribers[userID]) == 0 {
+		delete(p.subscribers, userID)
+	}
+	close(ch)
+}
+
+// Events returns the kept events in the order they were published
+func (p *MemoryPublisher) Events() []models.OutboxEvent {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]models.OutboxEvent(nil), p.events...)
+}
+
+// Reset drops the kept events
+func (p *MemoryPublisher) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.events = nil
+}
+
+// IBroker is the client of a NATS or Kafka style message broker, which messages are published to
+type IBroker interface {
+	// Publish sends the message to the subject (or topic), messages sharing a key are delivered in order
+	Publish(ctx context.Context, subject, key string, data []byte, headers map[string]string) error
+}
+
+// BrokerPublisher publishes the events to a message broker, each event type on its own subject
+// keyed by the user, so that the events of a user are consumed in order.
+type BrokerPublisher struct {
+	Broker        IBroker
+	SubjectPrefix string // Prefix of the subjects, e.g. "wallet." publishes to "wallet.balance.changed"
+}
+
+func NewBrokerPublisher(broker IBroker, subjectPrefix string) *BrokerPublisher {
+	return &BrokerPublisher{Broker: broker, SubjectPrefix: subjectPrefix}
+}
+
+func (p *BrokerPublisher) Publish(ctx context.Context, event *models.OutboxEvent) error {
+	data, err := json.Marshal(newEventEnvelope(event))
+	if err != nil {
+		return err
+	}
+
+	headers := map[string]string{
+		"event-id":   strconv.FormatUint(uint64(event.ID), 10),
+		"event-type": event.Type,
+	}
+	key := strconv.FormatUint(uint64(event.UserID), 10)
+	return p.Broker.Publish(ctx, p.SubjectPrefix+event.Type, key, data, headers)
+}
@@ -0,0 +1,67 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/shopspring/decimal"
+	"github.com/wanliqun/go-wallet-app/apperrors"
+)
+
+// inverseRatePrecision is the number of decimal places of rates derived from the inverse pair
+const inverseRatePrecision = 18
+
+var (
+	ErrRateUnavailable = apperrors.New(apperrors.RateUnavailable, "exchange rate unavailable")
+
+	_ IRateProvider = &StaticRateProvider{}
+)
+
+// IRateProvider supplies the exchange rates between currencies
+type IRateProvider interface {
+	// GetRate returns the amount of the quote currency one unit of the base currency is worth,
+	// both in human units.
+	GetRate(base, quote string) (decimal.Decimal, error)
+}
+
+// StaticRateProvider supplies fixed exchange rates keyed by currency pair, e.g. "BTC/USDT".
+// Rates of the inverse pair are derived if absent.
+type StaticRateProvider struct {
+	Rates map[string]decimal.Decimal
+}
+
+func NewStaticRateProvider(rates map[string]decimal.Decimal) *StaticRateProvider {
+	provider := &StaticRateProvider{Rates: make(map[string]decimal.Decimal, len(rates))}
+	for pair, rate := range rates {
+		provider.Rates[strings.ToUpper(pair)] = rate
+	}
+	return provider
+}
+
+// NewStaticRateProviderFromConfig parses the configured rates keyed by currency pair
+func NewStaticRateProviderFromConfig(rates map[string]string) (*StaticRateProvider, error) {
+	parsed := make(map[string]decimal.Decimal, len(rates))
+	for pair, value := range rates {
+		if strings.Count(pair, "/") != 1 {
+			return nil, fmt.Errorf("invalid currency pair %q", pair)
+		}
+
+		rate, err := decimal.NewFromString(value)
+		if err != nil || !rate.IsPositive() {
+			return nil, fmt.Errorf("invalid exchange rate %q of currency pair %q", value, pair)
+		}
+		parsed[pair] = rate
+	}
+	return NewStaticRateProvider(parsed), nil
+}
+
+func (p *StaticRateProvider) GetRate(base, quote string) (decimal.Decimal, error) {
+	base, quote = strings.ToUpper(base), strings.ToUpper(quote)
+	if rate, ok := p.Rates[base+"/"+quote]; ok {
+		return rate, nil
+	}
+	if rate, ok := p.Rates[quote+"/"+base]; ok {
+		return decimal.NewFromInt(1).DivRound(rate, inverseRatePrecision), nil
+	}
+	return decimal.Zero, ErrRateUnavailable
+}
@@ -0,0 +1,33 @@
+package services_test
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/wanliqun/go-wallet-app/services"
+)
+
+func TestStaticRateProvider(t *testing.T) {
+	provider, err := services.NewStaticRateProviderFromConfig(map[string]string{"btc/usdt": "50000"})
+	assert.NoError(t, err)
+
+	tests := []struct {
+		base, quote string
+		expected    string
+		err         error
+	}{
+		{"BTC", "USDT", "50000", nil},
+		{"USDT", "BTC", "0.00002", nil},
+		{"BTC", "ETH", "0", services.ErrRateUnavailable},
+	}
+
+	for _, tt := range tests {
+		rate, err := provider.GetRate(tt.base, tt.quote)
+		assert.ErrorIs(t, err, tt.err)
+		assert.True(t, decimal.RequireFromString(tt.expected).Equal(rate), "%s/%s rate %v", tt.base, tt.quote, rate)
+	}
+
+	_, err = services.NewStaticRateProviderFromConfig(map[string]string{"BTC/USDT": "-1"})
+	assert.Error(t, err)
+}
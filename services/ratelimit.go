@@ -0,0 +1,260 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wanliqun/go-wallet-app/apperrors"
+)
+
+const (
+	// memoryStoreSweepInterval is how often the in-memory store drops the buckets refilled to their limit
+	memoryStoreSweepInterval = time.Minute
+
+	// sharedStoreMaxAttempts is how many times a bucket of the shared store is retried on contention
+	sharedStoreMaxAttempts = 8
+)
+
+var (
+	ErrRateLimited = apperrors.New(apperrors.TooManyRequests, "rate limit exceeded")
+
+	errRateLimitContention = errors.New("rate limit bucket contended")
+
+	_ IRateLimitStore = &MemoryRateLimitStore{}
+	_ IRateLimitStore = &SharedRateLimitStore{}
+)
+
+// RateBudget is a token bucket holding up to Limit requests, refilled from empty over the Period
+type RateBudget struct {
+	Limit  int
+	Period time.Duration
+}
+
+// Rate returns the number of tokens refilled per second
+func (b RateBudget) Rate() float64 {
+	return float64(b.Limit) / b.Period.Seconds()
+}
+
+// RateLimitResult is the state of a bucket once a token was taken from it, or refused
+type RateLimitResult struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int           // Whole tokens left in the bucket
+	Reset      time.Duration // Time until the bucket is refilled to its limit
+	RetryAfter time.Duration // Time until the next token, if refused
+}
+
+// IRateLimitStore keeps the token buckets of the clients
+type IRateLimitStore interface {
+	// Take takes a token from the bucket of the key if any is left, once the bucket is refilled
+	// according to the budget for the time elapsed since it was last updated.
+	Take(ctx context.Context, key string, budget RateBudget, now time.Time) (RateLimitResult, error)
+	// Refund gives back a token taken from the bucket of the key, e.g. for a request refused by
+	// another bucket, without exceeding the limit.
+	Refund(ctx context.Context, key string, budget RateBudget, now time.Time) error
+}
+
+// bucket is the state of a token bucket
+type bucket struct {
+	Tokens    float64
+	UpdatedAt time.Time
+}
+
+// take refills the bucket and takes a token from it, the bucket of an unknown key being full
+func (b *bucket) take(budget RateBudget, now time.Time, found bool) RateLimitResult {
+	rate := budget.Rate()
+	limit := float64(budget.Limit)
+
+	// Clocks of other instances may lag behind, the bucket is only refilled once they catch up
+	if !found {
+		b.Tokens, b.UpdatedAt = limit, now
+	} else if elapsed := now.Sub(b.UpdatedAt).Seconds(); elapsed > 0 {
+		b.Tokens, b.UpdatedAt = math.Min(limit, b.Tokens+elapsed*rate), now
+	}
+
+	result := RateLimitResult{Limit: budget.Limit}
+	if b.Tokens >= 1 {
+		b.Tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = secondsDuration((1 - b.Tokens) / rate)
+	}
+	result.Remaining = int(b.Tokens)
+	result.Reset = secondsDuration((limit - b.Tokens) / rate)
+	return result
+}
+
+// refund refills the bucket and gives a token back to it, returning the time until it is full
+func (b *bucket) refund(budget RateBudget, now time.Time) time.Duration {
+	limit := float64(budget.Limit)
+	if elapsed := now.Sub(b.UpdatedAt).Seconds(); elapsed > 0 {
+		b.Tokens, b.UpdatedAt = b.Tokens+elapsed*budget.Rate(), now
+	}
+	b.Tokens = math.Min(limit, b.Tokens+1)
+	return secondsDuration((limit - b.Tokens) / budget.Rate())
+}
+
+func secondsDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// MemoryRateLimitStore keeps the buckets in memory, limiting each instance of the app separately
+type MemoryRateLimitStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*memoryBucket
+	lastSweep time.Time
+}
+
+type memoryBucket struct {
+	bucket
+	fullAt time.Time // When the bucket is refilled to its limit, and can be dropped
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{buckets: make(map[string]*memoryBucket)}
+}
+
+func (s *MemoryRateLimitStore) Take(ctx context.Context, key string, budget RateBudget, now time.Time) (RateLimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep(now)
+
+	b, found := s.buckets[key]
+	if !found {
+		b = &memoryBucket{}
+		s.buckets[key] = b
+	}
+
+	result := b.take(budget, now, found)
+	b.fullAt = now.Add(result.Reset)
+	return result, nil
+}
+
+// Refund gives the token back to the bucket, buckets already dropped being full anyway
+func (s *MemoryRateLimitStore) Refund(ctx context.Context, key string, budget RateBudget, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if b, found := s.buckets[key]; found {
+		b.fullAt = now.Add(b.refund(budget, now))
+	}
+	return nil
+}
+
+// sweep drops the buckets refilled to their limit, which are the same as absent ones. The lock must be held.
+func (s *MemoryRateLimitStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < memoryStoreSweepInterval {
+		return
+	}
+	s.lastSweep = now
+
+	for key, b := range s.buckets {
+		if !now.Before(b.fullAt) {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+// ISharedStore is a key-value store shared by the instances of the app, e.g. Redis or Memcached
+type ISharedStore interface {
+	// Get returns the value of the key, or an empty string if absent
+	Get(ctx context.Context, key string) (string, error)
+	// CompareAndSwap sets the value of the key expiring after the TTL, only if the value is still
+	// the old one (an empty old value meaning absent), and returns whether the value was set.
+	CompareAndSwap(ctx context.Context, key, old, value string, ttl time.Duration) (bool, error)
+}
+
+// SharedRateLimitStore keeps the buckets in a store shared by the instances of the app, so that the
+// limits apply across instances. Buckets are updated optimistically, and expire once refilled.
+type SharedRateLimitStore struct {
+	Store     ISharedStore
+	KeyPrefix string // Prefix of the keys in the store, e.g. "ratelimit:"
+}
+
+func NewSharedRateLimitStore(store ISharedStore, keyPrefix string) *SharedRateLimitStore {
+	return &SharedRateLimitStore{Store: store, KeyPrefix: keyPrefix}
+}
+
+func (s *SharedRateLimitStore) Take(ctx context.Context, key string, budget RateBudget, now time.Time) (RateLimitResult, error) {
+	key = s.KeyPrefix + key
+
+	for attempt := 0; attempt < sharedStoreMaxAttempts; attempt++ {
+		old, err := s.Store.Get(ctx, key)
+		if err != nil {
+			return RateLimitResult{}, err
+		}
+
+		var b bucket
+		found := old != ""
+		if found {
+			if b, err = decodeBucket(old); err != nil {
+				return RateLimitResult{}, err
+			}
+		}
+
+		result := b.take(budget, now, found)
+
+		// Keep the bucket a little longer than it takes to refill, absent buckets being full anyway
+		ok, err := s.Store.CompareAndSwap(ctx, key, old, encodeBucket(b), result.Reset+time.Second)
+		if err != nil {
+			return RateLimitResult{}, err
+		}
+		if ok {
+			return result, nil
+		}
+	}
+	return RateLimitResult{}, errRateLimitContention
+}
+
+// Refund gives the token back to the bucket, buckets already expired being full anyway
+func (s *SharedRateLimitStore) Refund(ctx context.Context, key string, budget RateBudget, now time.Time) error {
+	key = s.KeyPrefix + key
+
+	for attempt := 0; attempt < sharedStoreMaxAttempts; attempt++ {
+		old, err := s.Store.Get(ctx, key)
+		if err != nil || old == "" {
+			return err
+		}
+
+		b, err := decodeBucket(old)
+		if err != nil {
+			return err
+		}
+		reset := b.refund(budget, now)
+
+		ok, err := s.Store.CompareAndSwap(ctx, key, old, encodeBucket(b), reset+time.Second)
+		if err != nil || ok {
+			return err
+		}
+	}
+	return errRateLimitContention
+}
+
+// encodeBucket encodes the bucket as "<tokens>:<unix nanoseconds>"
+func encodeBucket(b bucket) string {
+	return strconv.FormatFloat(b.Tokens, 'g', -1, 64) + ":" + strconv.FormatInt(b.UpdatedAt.UnixNano(), 10)
+}
+
+func decodeBucket(value string) (bucket, error) {
+	tokens, updatedAt, ok := strings.Cut(value, ":")
+	if !ok {
+		return bucket{}, fmt.Errorf("malformed rate limit bucket %q", value)
+	}
+
+	t, err := strconv.ParseFloat(tokens, 64)
+	if err != nil {
+		return bucket{}, fmt.Errorf("malformed rate limit bucket %q: %w", value, err)
+	}
+	nanos, err := strconv.ParseInt(updatedAt, 10, 64)
+	if err != nil {
+		return bucket{}, fmt.Errorf("malformed rate limit bucket %q: %w", value, err)
+	}
+	return bucket{Tokens: t, UpdatedAt: time.Unix(0, nanos)}, nil
+}
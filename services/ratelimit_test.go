@@ -0,0 +1,95 @@
+package services_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wanliqun/go-wallet-app/services"
+)
+
+// sharedStore is an in-process ISharedStore, standing in for Redis
+type sharedStore struct {
+	mu     sync.Mutex
+	values map[string]string
+	swaps  int // Swaps to fail, as if another instance updated the key in between
+}
+
+func (s *sharedStore) Get(ctx context.Context, key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.values[key], nil
+}
+
+func (s *sharedStore) CompareAndSwap(ctx context.Context, key, old, value string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.swaps > 0 {
+		s.swaps--
+		return false, nil
+	}
+	if s.values[key] != old {
+		return false, nil
+	}
+	s.values[key] = value
+	return true, nil
+}
+
+func TestRateLimitStores(t *testing.T) {
+	budget := services.RateBudget{Limit: 3, Period: 3 * time.Second} // One token per second
+	stores := map[string]services.IRateLimitStore{
+		"memory": services.NewMemoryRateLimitStore(),
+		"shared": services.NewSharedRateLimitStore(&sharedStore{values: map[string]string{}, swaps: 2}, "ratelimit:"),
+	}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			now := time.Now()
+
+			for i := 2; i >= 0; i-- {
+				result, err := store.Take(ctx, "user:1", budget, now)
+				assert.NoError(t, err)
+				assert.True(t, result.Allowed)
+				assert.Equal(t, 3, result.Limit)
+				assert.Equal(t, i, result.Remaining)
+			}
+
+			// The bucket is empty until a token is refilled
+			result, err := store.Take(ctx, "user:1", budget, now.Add(500*time.Millisecond))
+			assert.NoError(t, err)
+			assert.False(t, result.Allowed)
+			assert.Equal(t, 500*time.Millisecond, result.RetryAfter)
+			assert.Equal(t, 2500*time.Millisecond, result.Reset)
+
+			// Other clients have their own bucket
+			result, err = store.Take(ctx, "user:2", budget, now)
+			assert.NoError(t, err)
+			assert.True(t, result.Allowed)
+
+			result, err = store.Take(ctx, "user:1", budget, now.Add(time.Second))
+			assert.NoError(t, err)
+			assert.True(t, result.Allowed)
+			assert.Equal(t, 0, result.Remaining)
+
+			// Refilling never exceeds the limit
+			result, err = store.Take(ctx, "user:1", budget, now.Add(time.Hour))
+			assert.NoError(t, err)
+			assert.True(t, result.Allowed)
+			assert.Equal(t, 2, result.Remaining)
+			assert.Equal(t, time.Second, result.Reset)
+
+			// Tokens given back can be taken again, without exceeding the limit
+			assert.NoError(t, store.Refund(ctx, "user:1", budget, now.Add(time.Hour)))
+			assert.NoError(t, store.Refund(ctx, "user:1", budget, now.Add(time.Hour)))
+			result, err = store.Take(ctx, "user:1", budget, now.Add(time.Hour))
+			assert.NoError(t, err)
+			assert.True(t, result.Allowed)
+			assert.Equal(t, 2, result.Remaining)
+
+			assert.NoError(t, store.Refund(ctx, "user:3", budget, now))
+		})
+	}
+}
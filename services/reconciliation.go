@@ -0,0 +1,167 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/wanliqun/go-wallet-app/models"
+	"gorm.io/gorm"
+)
+
+var (
+	_ IReconciliationService = &ReconciliationService{}
+)
+
+// signedTransactionAmount is the SQL expression of a transaction amount signed by its effect
+// on the vault balance, it takes the credit and debit transaction types as arguments.
+const signedTransactionAmount = "CASE WHEN type IN ? THEN amount WHEN type IN ? THEN -amount ELSE 0 END"
+
+type IReconciliationService interface {
+	Reconcile() (*models.ReconciliationRun, error)
+	ListRuns(limit int) ([]models.ReconciliationRun, error)
+}
+
+// ReconciliationService represents the service checking that every vault balance, including the
+// held amount, matches the signed sum of the user's transactions in the currency.
+type ReconciliationService struct {
+	DB *gorm.DB
+}
+
+func NewReconciliationService(db *gorm.DB) *ReconciliationService {
+	return &ReconciliationService{DB: db}
+}
+
+// vaultReconciliation is a row of the reconciliation query
+type vaultReconciliation struct {
+	UserID           uint
+	Currency         string
+	Actual           decimal.Decimal
+	Expected         decimal.Decimal
+	FirstID          *uint
+	LastID           *uint
+	FirstTimestamp   *time.Time
+	LastTimestamp    *time.Time
+	TransactionCount int64
+}
+
+// Reconcile scans all vaults, and records and returns the mismatches found
+func (s *ReconciliationService) Reconcile() (*models.ReconciliationRun, error) {
+	run := models.ReconciliationRun{StartedAt: time.Now()}
+
+	// Transactions before the last clean run have already been reconciled
+	since, err := s.lastCleanRunTime()
+	if err != nil {
+		return nil, err
+	}
+
+	totals := s.DB.Model(&models.Transaction{}).
+		Select(`user_id, currency,
+			SUM(`+signedTransactionAmount+`) AS expected,
+			MIN(id) FILTER (WHERE timestamp >= ?) AS first_id,
+			MAX(id) FILTER (WHERE timestamp >= ?) AS last_id,
+			MIN(timestamp) FILTER (WHERE timestamp >= ?) AS first_timestamp,
+			MAX(timestamp) FILTER (WHERE timestamp >= ?) AS last_timestamp,
+			COUNT(*) FILTER (WHERE timestamp >= ?) AS transaction_count`,
+			models.CreditTransactionTypes, models.DebitTransactionTypes, since, since, since, since, since).
+		Group("user_id, currency")
+
+	rows, err := s.DB.Model(&models.Vault{}).
+		Select(`vaults.user_id, vaults.currency, vaults.amount + vaults.held AS actual,
+			COALESCE(totals.expected, 0) AS expected, totals.first_id, totals.last_id,
+			totals.first_timestamp, totals.last_timestamp, COALESCE(totals.transaction_count, 0) AS transaction_count`).
+		Joins("LEFT JOIN (?) AS totals ON totals.user_id = vaults.user_id AND totals.currency = vaults.currency", totals).
+		Order("vaults.user_id, vaults.currency").
+		Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Stream the vaults so that only the mismatches are kept in memory
+	for rows.Next() {
+		var row vaultReconciliation
+		if err := s.DB.ScanRows(rows, &row); err != nil {
+			return nil, err
+		}
+
+		run.VaultsScanned++
+		if row.Actual.Equal(row.Expected) {
+			continue
+		}
+
+		run.Mismatches = append(run.Mismatches, models.VaultMismatch{
+			UserID:             row.UserID,
+			Currency:           row.Currency,
+			Expected:           row.Expected,
+			Actual:             row.Actual,
+			Difference:         row.Actual.Sub(row.Expected),
+			FirstTransactionID: row.FirstID,
+			LastTransactionID:  row.LastID,
+			FirstTimestamp:     row.FirstTimestamp,
+			LastTimestamp:      row.LastTimestamp,
+			TransactionCount:   row.TransactionCount,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	run.MismatchCount = len(run.Mismatches)
+	run.FinishedAt = time.Now()
+	if err := s.DB.Create(&run).Error; err != nil {
+		return nil, err
+	}
+
+	return &run, nil
+}
+
+// ListRuns returns the most recent reconciliation runs
+func (s *ReconciliationService) ListRuns(limit int) ([]models.ReconciliationRun, error) {
+	if limit == 0 {
+		limit = 10 // Default limit
+	}
+
+	var runs []models.ReconciliationRun
+	if err := s.DB.Order("started_at desc, id desc").Limit(limit).Find(&runs).Error; err != nil {
+		return nil, err
+	}
+	return runs, nil
+}
+
+// Schedule runs the reconciliation periodically until the context is canceled
+func (s *ReconciliationService) Schedule(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			run, err := s.Reconcile()
+			if err != nil {
+				log.Printf("failed to reconcile balances: %v", err)
+				continue
+			}
+			if run.MismatchCount > 0 {
+				log.Printf("Reconciliation run %d found %d mismatched vaults", run.ID, run.MismatchCount)
+			}
+		}
+	}
+}
+
+// lastCleanRunTime returns the start time of the last run without mismatches
+func (s *ReconciliationService) lastCleanRunTime() (time.Time, error) {
+	var run models.ReconciliationRun
+	err := s.DB.Where("mismatch_count = 0").Order("started_at desc").First(&run).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return time.Time{}, nil
+		}
+		return time.Time{}, err
+	}
+	return run.StartedAt, nil
+}
@@ -0,0 +1,69 @@
+package services_test
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/wanliqun/go-wallet-app/models"
+	"github.com/wanliqun/go-wallet-app/services"
+)
+
+func TestReconcile(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+
+	senderUser := userGenerator.Generate()
+	recipientUser := userGenerator.Generate()
+	tx.CreateInBatches([]*models.User{senderUser, recipientUser}, 2)
+
+	walletService := services.NewWalletService(tx)
+	reconciliationService := services.NewReconciliationService(tx)
+
+	currency := "USDT"
+
+	walletService.Deposit(ctx, senderUser.ID, currency, decimal.NewFromInt(100), "")
+	walletService.Withdraw(ctx, senderUser.ID, currency, decimal.NewFromInt(30), "", nil)
+	walletService.Transfer(ctx, senderUser.ID, recipientUser.ID, currency, decimal.NewFromInt(20), "memo", "", nil)
+
+	findMismatch := func(run *models.ReconciliationRun, userID uint) *models.VaultMismatch {
+		for i := range run.Mismatches {
+			if run.Mismatches[i].UserID == userID && run.Mismatches[i].Currency == currency {
+				return &run.Mismatches[i]
+			}
+		}
+		return nil
+	}
+
+	t.Run("should find no mismatch for consistent vaults", func(t *testing.T) {
+		run, err := reconciliationService.Reconcile()
+		assert.NoError(t, err)
+		assert.NotZero(t, run.ID)
+		assert.Nil(t, findMismatch(run, senderUser.ID))
+		assert.Nil(t, findMismatch(run, recipientUser.ID))
+	})
+
+	t.Run("should report tampered vaults", func(t *testing.T) {
+		tx.Model(&models.Vault{}).
+			Where("user_id = ? AND currency = ?", recipientUser.ID, currency).
+			Update("amount", 25)
+
+		run, err := reconciliationService.Reconcile()
+		assert.NoError(t, err)
+
+		mismatch := findMismatch(run, recipientUser.ID)
+		if assert.NotNil(t, mismatch) {
+			assert.True(t, decimal.NewFromInt(20).Equal(mismatch.Expected))
+			assert.True(t, decimal.NewFromInt(25).Equal(mismatch.Actual))
+			assert.True(t, decimal.NewFromInt(5).Equal(mismatch.Difference))
+		}
+		assert.Nil(t, findMismatch(run, senderUser.ID))
+
+		runs, err := reconciliationService.ListRuns(1)
+		assert.NoError(t, err)
+		if assert.Len(t, runs, 1) {
+			assert.Equal(t, run.ID, runs[0].ID)
+			assert.Equal(t, run.MismatchCount, runs[0].MismatchCount)
+		}
+	})
+}
@@ -0,0 +1,191 @@
+package services
+
+import (
+	"context"
+	"errors"
+
+	"github.com/wanliqun/go-wallet-app/apperrors"
+	"github.com/wanliqun/go-wallet-app/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrTransactionNotFound = apperrors.New(apperrors.TransactionNotFound, "transaction not found")
+	ErrAlreadyReversed     = apperrors.New(apperrors.AlreadyReversed, "transaction already reversed")
+	ErrNotReversible       = apperrors.New(apperrors.NotReversible, "transaction not reversible")
+	ErrFundsAlreadySpent   = apperrors.New(apperrors.FundsAlreadySpent, "insufficient balance to reverse, funds already spent")
+)
+
+// Reverse undoes a deposit, withdrawal or transfer by recording compensating transactions linked
+// to the original ones, and returns the reversal of the given transaction. Either leg identifies
+// a transfer, which is reversed as a whole. Unless forced, the reversal fails if the user credited
+// by the original transaction no longer holds the funds, forcing lets the vault go negative.
+// Fees are not refunded, only the amount net of the fee is compensated and the fee transaction
+// is left in place.
+func (s *WalletService) Reverse(ctx context.Context, transactionID uint, memo string, force bool) (*models.Transaction, error) {
+	db, cancel := session(ctx, s.DB, s.Timeouts, OpReverse)
+	defer cancel()
+
+	var reversal *models.Transaction
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// Lock the original transactions so that concurrent reversals are serialized
+		originals, err := lockReversibleTransactions(tx, transactionID)
+		if err != nil {
+			return err
+		}
+
+		var originalIDs []uint
+		for _, original := range originals {
+			originalIDs = append(originalIDs, original.ID)
+		}
+
+		var reversed int64
+		err = tx.Model(&models.Transaction{}).Where("original_transaction_id IN ?", originalIDs).Count(&reversed).Error
+		if err != nil {
+			return err
+		}
+		if reversed > 0 {
+			return ErrAlreadyReversed
+		}
+
+		// Compensate each original transaction, taking the funds back before returning them
+		reversals := make([]*models.Transaction, len(originals))
+		for i, original := range originals {
+			reversals[i] = &models.Transaction{
+				UserID:                original.UserID,
+				CounterpartyID:        original.CounterpartyID,
+				Type:                  models.ReversalIn,
+				Amount:                original.Amount,
+				Currency:              original.Currency,
+				Memo:                  memo,
+				OriginalTransactionID: &originals[i].ID,
+			}
+			if original.Type == models.Deposit || original.Type == models.TransferIn {
+				reversals[i].Type = models.ReversalOut
+			}
+		}
+		for _, txn := range reversals {
+			if txn.Type == models.ReversalOut {
+				if err := debitReversedVault(tx, txn, force); err != nil {
+					return err
+				}
+			}
+		}
+		for _, txn := range reversals {
+			if txn.Type == models.ReversalIn {
+				if err := creditReversedVault(tx, txn); err != nil {
+					return err
+				}
+			}
+		}
+
+		if err := tx.Create(reversals).Error; err != nil {
+			return err
+		}
+
+		// Post the opposite of the original journal entry
+		original := originals[0]
+		journal := newJournal(original.Currency, "reversal").forTransaction(reversals[0])
+		for _, txn := range reversals {
+			if txn.Type == models.ReversalOut {
+				journal.debit(userAccount(txn.UserID), txn.Amount)
+			} else {
+				journal.credit(userAccount(txn.UserID), txn.Amount)
+			}
+		}
+		switch original.Type {
+		case models.Deposit:
+			journal.credit(systemAccount(models.ExternalDepositsAccount), original.Amount)
+		case models.Withdrawal:
+			journal.debit(systemAccount(models.WithdrawalsPayableAccount), original.Amount)
+		}
+		if err := journal.post(tx); err != nil {
+			return err
+		}
+
+		if err := enqueueTransactionEvents(tx, reversals...); err != nil {
+			return err
+		}
+
+		for i := range originals {
+			if originals[i].ID == transactionID {
+				reversal = reversals[i]
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return reversal, nil
+}
+
+// lockReversibleTransactions locks the transaction and, for a transfer, both of its legs
+func lockReversibleTransactions(tx *gorm.DB, transactionID uint) ([]models.Transaction, error) {
+	var transaction models.Transaction
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&transaction, transactionID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrTransactionNotFound
+		}
+		return nil, err
+	}
+
+	switch transaction.Type {
+	case models.Deposit, models.Withdrawal:
+		return []models.Transaction{transaction}, nil
+	case models.TransferOut, models.TransferIn:
+	default:
+		return nil, ErrNotReversible
+	}
+
+	if transaction.CounterpartyID == nil {
+		return nil, ErrNotReversible
+	}
+
+	counterpart, ok, err := findTransferCounterpart(tx.Clauses(clause.Locking{Strength: "UPDATE"}), &transaction)
+	if err != nil {
+		return nil, err
+	}
+	// Transfers of captured holds are not reversible
+	if !ok || (counterpart.Type != models.TransferOut && counterpart.Type != models.TransferIn) {
+		return nil, ErrNotReversible
+	}
+
+	if transaction.Type == models.TransferOut {
+		return []models.Transaction{transaction, *counterpart}, nil
+	}
+	return []models.Transaction{*counterpart, transaction}, nil
+}
+
+// debitReversedVault takes the reversed funds back from the vault
+func debitReversedVault(tx *gorm.DB, reversal *models.Transaction, force bool) error {
+	query := tx.Model(&models.Vault{}).Where("user_id = ? AND currency = ?", reversal.UserID, reversal.Currency)
+	if !force {
+		query = query.Where("amount >= ?", reversal.Amount)
+	}
+
+	result := query.Update("amount", gorm.Expr("amount - ?", reversal.Amount))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrFundsAlreadySpent
+	}
+	return nil
+}
+
+// creditReversedVault returns the reversed funds to the vault
+func creditReversedVault(tx *gorm.DB, reversal *models.Transaction) error {
+	result := tx.Model(&models.Vault{}).
+		Where("user_id = ? AND currency = ?", reversal.UserID, reversal.Currency).
+		Update("amount", gorm.Expr("amount + ?", reversal.Amount))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("failed to update reversed vault")
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package services_test
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/wanliqun/go-wallet-app/models"
+	"github.com/wanliqun/go-wallet-app/services"
+)
+
+func TestReverse(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+
+	senderUser := userGenerator.Generate()
+	recipientUser := userGenerator.Generate()
+	tx.CreateInBatches([]*models.User{senderUser, recipientUser}, 2)
+
+	walletService := services.NewWalletService(tx)
+	ledgerService := services.NewLedgerService(tx)
+
+	currency := "USDT"
+
+	assertBalance := func(t *testing.T, userID uint, expected int64) {
+		var vault models.Vault
+		tx.First(&vault, "user_id = ? AND currency = ?", userID, currency)
+		assert.True(t, decimal.NewFromInt(expected).Equal(vault.Amount), "vault amount %v", vault.Amount)
+
+		balance, err := ledgerService.GetAccountBalance(userID, currency)
+		assert.NoError(t, err)
+		assert.True(t, decimal.NewFromInt(expected).Equal(balance), "ledger balance %v", balance)
+	}
+
+	deposit, _ := walletService.Deposit(ctx, senderUser.ID, currency, decimal.NewFromInt(100), "")
+
+	t.Run("should reverse deposit", func(t *testing.T) {
+		reversal, err := walletService.Reverse(ctx, deposit.ID, "chargeback", false)
+		assert.NoError(t, err)
+		assert.Equal(t, models.ReversalOut, reversal.Type)
+		assert.Equal(t, deposit.ID, *reversal.OriginalTransactionID)
+		assertBalance(t, senderUser.ID, 0)
+	})
+
+	t.Run("should refuse to reverse twice", func(t *testing.T) {
+		_, err := walletService.Reverse(ctx, deposit.ID, "", false)
+		assert.ErrorIs(t, err, services.ErrAlreadyReversed)
+	})
+
+	walletService.Deposit(ctx, senderUser.ID, currency, decimal.NewFromInt(100), "")
+	transfer, _ := walletService.Transfer(ctx, senderUser.ID, recipientUser.ID, currency, decimal.NewFromInt(60), "memo", "", nil)
+	walletService.Withdraw(ctx, recipientUser.ID, currency, decimal.NewFromInt(30), "", nil)
+
+	t.Run("should refuse to reverse spent funds", func(t *testing.T) {
+		_, err := walletService.Reverse(ctx, transfer.ID, "", false)
+		assert.ErrorIs(t, err, services.ErrFundsAlreadySpent)
+		assertBalance(t, senderUser.ID, 40)
+		assertBalance(t, recipientUser.ID, 30)
+	})
+
+	t.Run("should force reversal into negative", func(t *testing.T) {
+		reversal, err := walletService.Reverse(ctx, transfer.ID, "fraud", true)
+		assert.NoError(t, err)
+		assert.Equal(t, models.ReversalIn, reversal.Type)
+		assert.Equal(t, senderUser.ID, reversal.UserID)
+		assertBalance(t, senderUser.ID, 100)
+		assertBalance(t, recipientUser.ID, -30)
+
+		var counterpart models.Transaction
+		tx.First(&counterpart, "user_id = ? AND type = ?", recipientUser.ID, models.ReversalOut)
+		assert.NotNil(t, counterpart.OriginalTransactionID)
+	})
+
+	t.Run("should refuse to reverse a reversal", func(t *testing.T) {
+		var reversal models.Transaction
+		tx.First(&reversal, "user_id = ? AND type = ?", senderUser.ID, models.ReversalIn)
+
+		_, err := walletService.Reverse(ctx, reversal.ID, "", false)
+		assert.ErrorIs(t, err, services.ErrNotReversible)
+	})
+}
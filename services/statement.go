@@ -0,0 +1,123 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/wanliqun/go-wallet-app/models"
+	"gorm.io/gorm"
+)
+
+var _ IStatementService = &StatementService{}
+
+// Statement is the account statement of a user in a currency over a period, balances being
+// totals including the held amount in minor units
+type Statement struct {
+	UserID         uint
+	Currency       string
+	From           time.Time // Inclusive start of the period
+	To             time.Time // Exclusive end of the period
+	OpeningBalance decimal.Decimal
+	ClosingBalance decimal.Decimal
+	TotalCredits   decimal.Decimal
+	TotalDebits    decimal.Decimal
+	Discrepancy    decimal.Decimal // Vault balance not accounted for by the transactions, e.g. of vaults predating them
+	Lines          []StatementLine
+	GeneratedAt    time.Time
+}
+
+// StatementLine is a transaction of the statement along with the running balance following it
+type StatementLine struct {
+	Transaction models.Transaction
+	Balance     decimal.Decimal
+}
+
+type IStatementService interface {
+	GetStatement(userID uint, currency string, from, to time.Time) (*Statement, error)
+}
+
+// StatementService represents the service generating account statements from the transactions
+type StatementService struct {
+	DB *gorm.DB
+}
+
+func NewStatementService(db *gorm.DB) *StatementService {
+	return &StatementService{DB: db}
+}
+
+// GetStatement computes the opening balance from the transactions preceding the period and the
+// running balance of every transaction within it. The closing balance, carried forward by the
+// transactions following the period, is cross-checked against the vault balance, any difference
+// being reported as the discrepancy of the statement rather than refusing it.
+func (s *StatementService) GetStatement(userID uint, currency string, from, to time.Time) (*Statement, error) {
+	statement := Statement{
+		UserID:      userID,
+		Currency:    currency,
+		From:        from,
+		To:          to,
+		GeneratedAt: time.Now(),
+	}
+
+	var totals struct {
+		Opening decimal.Decimal
+		Later   decimal.Decimal // Signed sum of the transactions following the period
+	}
+	var transactions []models.Transaction
+	var vault models.Vault
+
+	// Read a consistent snapshot, so that concurrent transactions cannot unbalance the statement
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&models.Transaction{}).
+			Select(`COALESCE(SUM(`+signedTransactionAmount+`) FILTER (WHERE timestamp < ?), 0) AS opening,
+				COALESCE(SUM(`+signedTransactionAmount+`) FILTER (WHERE timestamp >= ?), 0) AS later`,
+				models.CreditTransactionTypes, models.DebitTransactionTypes, from,
+				models.CreditTransactionTypes, models.DebitTransactionTypes, to).
+			Where("user_id = ? AND currency = ?", userID, currency).
+			Scan(&totals).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Where("user_id = ? AND currency = ? AND timestamp >= ? AND timestamp < ?", userID, currency, from, to).
+			Order("timestamp, id").
+			Find(&transactions).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Where("user_id = ? AND currency = ?", userID, currency).Take(&vault).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+
+	statement.OpeningBalance = totals.Opening
+	balance := totals.Opening
+	statement.Lines = make([]StatementLine, 0, len(transactions))
+	for _, txn := range transactions {
+		switch txn.Type.Sign() {
+		case 1:
+			balance = balance.Add(txn.Amount)
+			statement.TotalCredits = statement.TotalCredits.Add(txn.Amount)
+		case -1:
+			balance = balance.Sub(txn.Amount)
+			statement.TotalDebits = statement.TotalDebits.Add(txn.Amount)
+		}
+		statement.Lines = append(statement.Lines, StatementLine{Transaction: txn, Balance: balance})
+	}
+	statement.ClosingBalance = balance
+
+	statement.Discrepancy = vault.Amount.Add(vault.Held).Sub(balance.Add(totals.Later))
+	if !statement.Discrepancy.IsZero() {
+		log.Printf("statement of user %d in %s does not reconcile: closing balance %s carried forward by %s, vault balance %s",
+			userID, currency, balance, totals.Later, vault.Amount.Add(vault.Held))
+	}
+	return &statement, nil
+}
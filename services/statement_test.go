@@ -0,0 +1,83 @@
+package services_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/wanliqun/go-wallet-app/models"
+	"github.com/wanliqun/go-wallet-app/services"
+)
+
+func TestGetStatement(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+
+	senderUser := userGenerator.Generate()
+	recipientUser := userGenerator.Generate()
+	tx.CreateInBatches([]*models.User{senderUser, recipientUser}, 2)
+
+	walletService := services.NewWalletService(tx)
+	statementService := services.NewStatementService(tx)
+
+	currency := "USDT"
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 1, 0)
+
+	// Move the transactions into place around the period
+	backdate := func(txn *models.Transaction, timestamp time.Time) {
+		tx.Model(&models.Transaction{}).Where("id = ?", txn.ID).Update("timestamp", timestamp)
+	}
+
+	deposit, _ := walletService.Deposit(ctx, senderUser.ID, currency, decimal.NewFromInt(100), "")
+	backdate(deposit, from.Add(-time.Hour))
+	withdrawal, _ := walletService.Withdraw(ctx, senderUser.ID, currency, decimal.NewFromInt(30), "", nil)
+	backdate(withdrawal, from)
+	transferOut, _ := walletService.Transfer(ctx, senderUser.ID, recipientUser.ID, currency, decimal.NewFromInt(20), "rent", "", nil)
+	backdate(transferOut, from.AddDate(0, 0, 20))
+	later, _ := walletService.Deposit(ctx, senderUser.ID, currency, decimal.NewFromInt(5), "")
+	backdate(later, to)
+
+	t.Run("should compute the opening, running and closing balances", func(t *testing.T) {
+		statement, err := statementService.GetStatement(senderUser.ID, currency, from, to)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		assert.True(t, decimal.NewFromInt(100).Equal(statement.OpeningBalance))
+		assert.True(t, decimal.NewFromInt(50).Equal(statement.ClosingBalance))
+		assert.True(t, statement.TotalCredits.IsZero())
+		assert.True(t, decimal.NewFromInt(50).Equal(statement.TotalDebits))
+
+		if assert.Len(t, statement.Lines, 2) {
+			assert.Equal(t, withdrawal.ID, statement.Lines[0].Transaction.ID)
+			assert.True(t, decimal.NewFromInt(70).Equal(statement.Lines[0].Balance))
+			assert.Equal(t, transferOut.ID, statement.Lines[1].Transaction.ID)
+			assert.True(t, decimal.NewFromInt(50).Equal(statement.Lines[1].Balance))
+		}
+	})
+
+	t.Run("should be empty without transactions", func(t *testing.T) {
+		statement, err := statementService.GetStatement(senderUser.ID, "BTC", from, to)
+		assert.NoError(t, err)
+		assert.Empty(t, statement.Lines)
+		assert.True(t, statement.OpeningBalance.IsZero())
+		assert.True(t, statement.ClosingBalance.IsZero())
+	})
+
+	t.Run("should report the discrepancy of statements not reconciling with the vault", func(t *testing.T) {
+		statement, err := statementService.GetStatement(senderUser.ID, currency, from, to)
+		assert.NoError(t, err)
+		assert.True(t, statement.Discrepancy.IsZero())
+
+		var vault models.Vault
+		tx.Where("user_id = ? AND currency = ?", senderUser.ID, currency).Take(&vault)
+		tx.Model(&vault).Update("amount", vault.Amount.Add(decimal.NewFromInt(25)))
+
+		statement, err = statementService.GetStatement(senderUser.ID, currency, from, to)
+		assert.NoError(t, err)
+		assert.True(t, decimal.NewFromInt(25).Equal(statement.Discrepancy), statement.Discrepancy.String())
+		assert.Len(t, statement.Lines, 2)
+	})
+}
@@ -0,0 +1,35 @@
+package services
+
+import (
+	"context"
+
+	"github.com/wanliqun/go-wallet-app/config"
+	"gorm.io/gorm"
+)
+
+// Names of the operations which database timeouts can be configured for
+const (
+	OpDeposit        = "deposit"
+	OpWithdraw       = "withdraw"
+	OpTransfer       = "transfer"
+	OpReverse        = "reverse"
+	OpGetBalances    = "balances"
+	OpGetHistory     = "history"
+	OpGetTransaction = "transaction"
+	OpGetUser        = "user"
+	OpCreateUser     = "create_user"
+	OpUpdateUser     = "update_user"
+	OpDeleteUser     = "delete_user"
+)
+
+// session returns the database bound to the context, canceled once the timeout of the operation
+// elapses. Canceling the context aborts the running query and rolls back its transaction, releasing
+// the row locks held, e.g. when the client disconnects.
+func session(
+	ctx context.Context, db *gorm.DB, timeouts config.TimeoutsConfig, operation string) (*gorm.DB, context.CancelFunc) {
+	if timeout := timeouts.For(operation); timeout > 0 {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		return db.WithContext(ctx), cancel
+	}
+	return db.WithContext(ctx), func() {}
+}
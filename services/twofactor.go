@@ -0,0 +1,395 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/wanliqun/go-wallet-app/apperrors"
+	"github.com/wanliqun/go-wallet-app/config"
+	"github.com/wanliqun/go-wallet-app/models"
+	"github.com/wanliqun/go-wallet-app/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Reasons why an operation requires step-up authentication
+const (
+	StepUpWithdrawal     = "withdrawal"
+	StepUpAboveThreshold = "amount_above_threshold"
+	StepUpNewRecipient   = "new_recipient"
+	StepUpTransferKey    = "transfer_key" // Grant of the transfer scope to an API key, whose transfers are exempt
+)
+
+// recoveryCodeGroupSize is the number of characters between the dashes of a recovery code
+const recoveryCodeGroupSize = 4
+
+var (
+	ErrTwoFactorRequired       = apperrors.New(apperrors.TwoFactorRequired, "two-factor code required")
+	ErrInvalidTwoFactorCode    = apperrors.New(apperrors.InvalidTwoFactorCode, "invalid two-factor code")
+	ErrTwoFactorNotEnrolled    = apperrors.New(apperrors.TwoFactorNotEnrolled, "two-factor authentication must be enabled")
+	ErrTwoFactorAlreadyEnabled = apperrors.New(apperrors.TwoFactorAlreadyEnabled, "two-factor authentication already enabled")
+	ErrTwoFactorNotEnabled     = apperrors.New(apperrors.TwoFactorNotEnabled, "two-factor authentication not enabled")
+
+	_ ITwoFactorService = &TwoFactorService{}
+
+	// recoveryCodeEncoding encodes recovery codes in lower case letters and digits
+	recoveryCodeEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+)
+
+// StepUpCheck verifies the step-up authentication of a withdrawal, transfer or API key grant within
+// the database transaction of the operation, so that a code is only consumed if the operation commits. It is not
+// run for replays of an idempotency key, which were verified by the original request.
+type StepUpCheck func(tx *gorm.DB) error
+
+// NewStepUpCheck returns the check of the action with the code supplied by the user, or nil if
+// there is no two-factor service to check it
+func NewStepUpCheck(twoFactor ITwoFactorService, user *models.User, action StepUpAction, code string) StepUpCheck {
+	if twoFactor == nil {
+		return nil
+	}
+	return func(tx *gorm.DB) error {
+		return twoFactor.StepUp(tx, user, action, code)
+	}
+}
+
+// StepUpAction is a withdrawal or transfer about to be made, amounts are in minor units, or the
+// grant of a scope to an API key
+type StepUpAction struct {
+	Operation   models.TransactionType // Withdrawal or TransferOut
+	Currency    string
+	Amount      decimal.Decimal
+	RecipientID uint  // Recipient of transfers
+	Scope       Scope // Scope granted to an API key
+}
+
+// TwoFactorChallenge is responded to actions requiring a code, which must be supplied on retry
+type TwoFactorChallenge struct {
+	Type    string   `json:"type"`    // Type of the code, always "totp" (or a recovery code)
+	Reasons []string `json:"reasons"` // Why the action requires a code
+}
+
+// StepUpRequiredError reports that the action requires a two-factor code, with the challenge to answer
+type StepUpRequiredError struct {
+	Challenge TwoFactorChallenge
+}
+
+func (e *StepUpRequiredError) Error() string {
+	return fmt.Sprintf("two-factor code required for %s", strings.Join(e.Challenge.Reasons, ", "))
+}
+
+func (e *StepUpRequiredError) Unwrap() error {
+	return ErrTwoFactorRequired
+}
+
+func (e *StepUpRequiredError) ErrorDetails() interface{} {
+	return e.Challenge
+}
+
+// TOTPEnrollment is the pending secret of a user, to be added to an authenticator app and confirmed
+type TOTPEnrollment struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"` // otpauth URI to render as a QR code
+}
+
+type ITwoFactorService interface {
+	// Enroll generates a new pending secret, replacing any previous pending one
+	Enroll(ctx context.Context, user *models.User) (*TOTPEnrollment, error)
+	// Confirm enables two-factor authentication with a code of the pending secret and returns the recovery codes
+	Confirm(ctx context.Context, userID uint, code string) ([]string, error)
+	Disable(ctx context.Context, userID uint, code string) error
+	RegenerateRecoveryCodes(ctx context.Context, userID uint, code string) ([]string, error)
+	// StepUp verifies the code within the transaction of the action, if it requires step-up authentication
+	StepUp(tx *gorm.DB, user *models.User, action StepUpAction, code string) error
+}
+
+// TwoFactorService represents the service for TOTP enrollment and step-up authentication
+type TwoFactorService struct {
+	DB         *gorm.DB
+	Registry   ICurrencyRegistry
+	Config     config.TwoFactorConfig
+	Thresholds map[string]decimal.Decimal // Transfer thresholds keyed by currency in human units
+}
+
+func NewTwoFactorService(
+	db *gorm.DB, registry ICurrencyRegistry, conf config.TwoFactorConfig, thresholds map[string]decimal.Decimal) *TwoFactorService {
+	return &TwoFactorService{DB: db, Registry: registry, Config: conf, Thresholds: thresholds}
+}
+
+// NewTwoFactorServiceFromConfig parses the configured transfer thresholds keyed by currency
+func NewTwoFactorServiceFromConfig(
+	db *gorm.DB, registry ICurrencyRegistry, conf config.TwoFactorConfig) (*TwoFactorService, error) {
+	thresholds := make(map[string]decimal.Decimal, len(conf.TransferThresholds))
+	for currency, value := range conf.TransferThresholds {
+		currency = strings.ToUpper(currency)
+
+		threshold, err := parseOptionalDecimal(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid transfer threshold of currency %s: %w", currency, err)
+		}
+		thresholds[currency] = threshold
+	}
+	return NewTwoFactorService(db, registry, conf, thresholds), nil
+}
+
+func (s *TwoFactorService) Enroll(ctx context.Context, user *models.User) (*TOTPEnrollment, error) {
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	result := s.DB.WithContext(ctx).Model(&models.User{}).
+		Where("id = ? AND NOT totp_enabled", user.ID).
+		Update("totp_secret", secret)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+
+	return &TOTPEnrollment{
+		Secret:          secret,
+		ProvisioningURI: utils.TOTPProvisioningURI(s.Config.Issuer, user.Name, secret),
+	}, nil
+}
+
+func (s *TwoFactorService) Confirm(ctx context.Context, userID uint, code string) ([]string, error) {
+	var recoveryCodes []string
+	err := s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		user, err := lockUser(tx, userID)
+		if err != nil {
+			return err
+		}
+		if user.TOTPEnabled {
+			return ErrTwoFactorAlreadyEnabled
+		}
+		if user.TOTPSecret == "" {
+			return ErrTwoFactorNotEnabled
+		}
+
+		step, ok := utils.ValidateTOTP(user.TOTPSecret, code, time.Now(), s.Config.Skew)
+		if !ok {
+			return ErrInvalidTwoFactorCode
+		}
+
+		err = tx.Model(user).Updates(map[string]interface{}{"totp_enabled": true, "totp_last_step": step}).Error
+		if err != nil {
+			return err
+		}
+
+		recoveryCodes, err = s.replaceRecoveryCodes(tx, userID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return recoveryCodes, nil
+}
+
+func (s *TwoFactorService) Disable(ctx context.Context, userID uint, code string) error {
+	return s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := s.verify(tx, userID, code); err != nil {
+			return err
+		}
+
+		err := tx.Model(&models.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+			"totp_enabled": false, "totp_secret": "", "totp_last_step": 0,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error
+	})
+}
+
+func (s *TwoFactorService) RegenerateRecoveryCodes(ctx context.Context, userID uint, code string) ([]string, error) {
+	var recoveryCodes []string
+	err := s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) (err error) {
+		if err := s.verify(tx, userID, code); err != nil {
+			return err
+		}
+
+		recoveryCodes, err = s.replaceRecoveryCodes(tx, userID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return recoveryCodes, nil
+}
+
+// StepUp returns a StepUpRequiredError if the action requires a code and none is supplied, and
+// ErrInvalidTwoFactorCode if the code is wrong. Users without two-factor authentication are let
+// through, unless it is required by the configuration. The code is consumed within the transaction
+// tx of the action, and is given back if the action rolls back.
+func (s *TwoFactorService) StepUp(tx *gorm.DB, user *models.User, action StepUpAction, code string) error {
+	reasons, err := s.stepUpReasons(tx, user.ID, action)
+	if err != nil {
+		return err
+	}
+	if len(reasons) == 0 {
+		return nil
+	}
+
+	if !user.TOTPEnabled {
+		if s.Config.Required {
+			return ErrTwoFactorNotEnrolled
+		}
+		return nil
+	}
+
+	if code == "" {
+		return &StepUpRequiredError{Challenge: TwoFactorChallenge{Type: "totp", Reasons: reasons}}
+	}
+
+	return s.verify(tx, user.ID, code)
+}
+
+// checkStepUp runs the step-up check of the operation within its transaction, if any
+func checkStepUp(stepUp StepUpCheck, tx *gorm.DB) error {
+	if stepUp == nil {
+		return nil
+	}
+	return stepUp(tx)
+}
+
+// stepUpReasons returns why the action requires step-up authentication, none if it does not
+func (s *TwoFactorService) stepUpReasons(db *gorm.DB, userID uint, action StepUpAction) ([]string, error) {
+	if action.Scope != "" {
+		if action.Scope == ScopeTransfer {
+			return []string{StepUpTransferKey}, nil
+		}
+		return nil, nil
+	}
+	if action.Operation == models.Withdrawal {
+		return []string{StepUpWithdrawal}, nil
+	}
+
+	var reasons []string
+	if threshold, ok := s.Thresholds[action.Currency]; ok && threshold.IsPositive() {
+		if conf, ok := s.Registry.LookupCurrency(action.Currency); ok &&
+			action.Amount.GreaterThan(threshold.Shift(conf.Precision).Truncate(0)) {
+			reasons = append(reasons, StepUpAboveThreshold)
+		}
+	}
+
+	// A recipient is new unless the sender has transferred funds to them before, directly or by a
+	// captured hold, a lookup served by the (user_id, counterparty_id, timestamp, id) index
+	var ids []uint
+	err := db.Model(&models.Transaction{}).
+		Where("user_id = ? AND counterparty_id = ? AND type IN ?",
+			userID, action.RecipientID, []models.TransactionType{models.TransferOut, models.HoldCaptured}).
+		Limit(1).
+		Pluck("id", &ids).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		reasons = append(reasons, StepUpNewRecipient)
+	}
+
+	return reasons, nil
+}
+
+// verify checks the TOTP code or consumes the recovery code of the user within the transaction.
+// A TOTP code is only accepted once, so that an intercepted code can not be replayed.
+func (s *TwoFactorService) verify(tx *gorm.DB, userID uint, code string) error {
+	user, err := lockUser(tx, userID)
+	if err != nil {
+		return err
+	}
+	if !user.TOTPEnabled {
+		return ErrTwoFactorNotEnabled
+	}
+
+	code = strings.TrimSpace(code)
+	if len(code) == utils.TOTPDigits {
+		step, ok := utils.ValidateTOTP(user.TOTPSecret, code, time.Now(), s.Config.Skew)
+		if !ok || step <= user.TOTPLastStep {
+			return ErrInvalidTwoFactorCode
+		}
+		return tx.Model(user).Update("totp_last_step", step).Error
+	}
+
+	result := tx.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hashRecoveryCode(code)).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvalidTwoFactorCode
+	}
+	return nil
+}
+
+// replaceRecoveryCodes generates new recovery codes of the user, invalidating the previous ones
+func (s *TwoFactorService) replaceRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, s.Config.RecoveryCodes)
+	rows := make([]models.RecoveryCode, 0, s.Config.RecoveryCodes)
+	for i := 0; i < s.Config.RecoveryCodes; i++ {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+		rows = append(rows, models.RecoveryCode{UserID: userID, CodeHash: hashRecoveryCode(code)})
+	}
+
+	if len(rows) > 0 {
+		if err := tx.Create(&rows).Error; err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
+
+// lockUser reads the user for update
+func lockUser(tx *gorm.DB, userID uint) (*models.User, error) {
+	var user models.User
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+// generateRecoveryCode generates a random 80-bit code in groups of four characters, e.g. "abcd-efgh-ijkl-mnop"
+func generateRecoveryCode() (string, error) {
+	buf := make([]byte, 10)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	encoded := recoveryCodeEncoding.EncodeToString(buf)
+	groups := make([]string, 0, len(encoded)/recoveryCodeGroupSize)
+	for i := 0; i < len(encoded); i += recoveryCodeGroupSize {
+		groups = append(groups, encoded[i:i+recoveryCodeGroupSize])
+	}
+	return strings.Join(groups, "-"), nil
+}
+
+// hashRecoveryCode hashes the recovery code ignoring case and separators. Recovery codes are random
+// enough for a plain SHA-256 hash, unlike passwords.
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,152 @@
+package services_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/wanliqun/go-wallet-app/config"
+	"github.com/wanliqun/go-wallet-app/models"
+	"github.com/wanliqun/go-wallet-app/services"
+	"github.com/wanliqun/go-wallet-app/utils"
+)
+
+func TestTwoFactor(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+
+	registry := staticRegistry{"USDT": {Code: "USDT", Precision: 2, Enabled: true}}
+	twoFactorService, err := services.NewTwoFactorServiceFromConfig(tx, registry, config.TwoFactorConfig{
+		Issuer:             "go-wallet-app",
+		Skew:               1,
+		RecoveryCodes:      3,
+		TransferThresholds: map[string]string{"usdt": "100"},
+	})
+	assert.NoError(t, err)
+
+	user := userGenerator.Generate()
+	tx.Create(user)
+	recipient := userGenerator.Generate()
+	tx.Create(recipient)
+
+	reload := func() *models.User {
+		var reloaded models.User
+		tx.First(&reloaded, user.ID)
+		return &reloaded
+	}
+
+	withdrawal := services.StepUpAction{Operation: models.Withdrawal, Currency: "USDT", Amount: decimal.NewFromInt(100)}
+
+	t.Run("should let users without two-factor authentication through", func(t *testing.T) {
+		assert.NoError(t, twoFactorService.StepUp(tx, reload(), withdrawal, ""))
+	})
+
+	var secret string
+	var recoveryCodes []string
+
+	t.Run("should enable two-factor authentication with a code of the pending secret", func(t *testing.T) {
+		enrollment, err := twoFactorService.Enroll(ctx, user)
+		assert.NoError(t, err)
+		assert.Contains(t, enrollment.ProvisioningURI, "secret="+enrollment.Secret)
+		secret = enrollment.Secret
+
+		_, err = twoFactorService.Confirm(ctx, user.ID, "000000")
+		assert.ErrorIs(t, err, services.ErrInvalidTwoFactorCode)
+
+		code, _ := utils.TOTPCode(secret, utils.TOTPStep(time.Now()))
+		recoveryCodes, err = twoFactorService.Confirm(ctx, user.ID, code)
+		assert.NoError(t, err)
+		assert.Len(t, recoveryCodes, 3)
+		assert.True(t, reload().TOTPEnabled)
+
+		_, err = twoFactorService.Enroll(ctx, user)
+		assert.ErrorIs(t, err, services.ErrTwoFactorAlreadyEnabled)
+	})
+
+	t.Run("should challenge withdrawals without a code", func(t *testing.T) {
+		err := twoFactorService.StepUp(tx, reload(), withdrawal, "")
+		assert.ErrorIs(t, err, services.ErrTwoFactorRequired)
+
+		var stepUpErr *services.StepUpRequiredError
+		if assert.True(t, errors.As(err, &stepUpErr)) {
+			assert.Equal(t, []string{services.StepUpWithdrawal}, stepUpErr.Challenge.Reasons)
+		}
+	})
+
+	t.Run("should challenge grants of the transfer scope to API keys", func(t *testing.T) {
+		var stepUpErr *services.StepUpRequiredError
+		err := twoFactorService.StepUp(tx, reload(), services.StepUpAction{Scope: services.ScopeTransfer}, "")
+		if assert.True(t, errors.As(err, &stepUpErr)) {
+			assert.Equal(t, []string{services.StepUpTransferKey}, stepUpErr.Challenge.Reasons)
+		}
+
+		assert.NoError(t, twoFactorService.StepUp(tx, reload(), services.StepUpAction{Scope: services.ScopeReadBalances}, ""))
+	})
+
+	t.Run("should accept a TOTP code only once", func(t *testing.T) {
+		code, _ := utils.TOTPCode(secret, utils.TOTPStep(time.Now())+1)
+		assert.NoError(t, twoFactorService.StepUp(tx, reload(), withdrawal, code))
+
+		err := twoFactorService.StepUp(tx, reload(), withdrawal, code)
+		assert.ErrorIs(t, err, services.ErrInvalidTwoFactorCode)
+	})
+
+	t.Run("should accept a recovery code only once", func(t *testing.T) {
+		assert.NoError(t, twoFactorService.StepUp(tx, reload(), withdrawal, recoveryCodes[0]))
+
+		err := twoFactorService.StepUp(tx, reload(), withdrawal, recoveryCodes[0])
+		assert.ErrorIs(t, err, services.ErrInvalidTwoFactorCode)
+	})
+
+	t.Run("should challenge transfers to new recipients and above the threshold", func(t *testing.T) {
+		transfer := services.StepUpAction{
+			Operation: models.TransferOut, Currency: "USDT", Amount: decimal.NewFromInt(10_001), RecipientID: recipient.ID,
+		}
+
+		var stepUpErr *services.StepUpRequiredError
+		err := twoFactorService.StepUp(tx, reload(), transfer, "")
+		if assert.True(t, errors.As(err, &stepUpErr)) {
+			assert.Equal(t, []string{services.StepUpAboveThreshold, services.StepUpNewRecipient}, stepUpErr.Challenge.Reasons)
+		}
+
+		// Transfers to known recipients up to the threshold need no code
+		tx.Create(&models.Transaction{
+			UserID: user.ID, CounterpartyID: &recipient.ID, Type: models.TransferOut,
+			Currency: "USDT", Amount: decimal.NewFromInt(1),
+		})
+		transfer.Amount = decimal.NewFromInt(10_000)
+		assert.NoError(t, twoFactorService.StepUp(tx, reload(), transfer, ""))
+	})
+
+	t.Run("should only consume the code if the withdrawal commits", func(t *testing.T) {
+		walletService := services.NewWalletService(tx)
+		stepUp := services.NewStepUpCheck(twoFactorService, reload(), withdrawal, recoveryCodes[2])
+
+		_, err := walletService.Withdraw(ctx, user.ID, "USDT", withdrawal.Amount, "withdraw-2fa", stepUp)
+		assert.ErrorIs(t, err, services.ErrInsufficientBalance)
+
+		_, err = walletService.Deposit(ctx, user.ID, "USDT", decimal.NewFromInt(1000), "")
+		assert.NoError(t, err)
+		txn, err := walletService.Withdraw(ctx, user.ID, "USDT", withdrawal.Amount, "withdraw-2fa", stepUp)
+		assert.NoError(t, err)
+
+		// Replays of the idempotency key skip the step-up, the code being consumed already
+		replayed, err := walletService.Withdraw(ctx, user.ID, "USDT", withdrawal.Amount, "withdraw-2fa", stepUp)
+		assert.NoError(t, err)
+		assert.Equal(t, txn.ID, replayed.ID)
+
+		_, err = walletService.Withdraw(ctx, user.ID, "USDT", withdrawal.Amount, "", stepUp)
+		assert.ErrorIs(t, err, services.ErrInvalidTwoFactorCode)
+	})
+
+	t.Run("should disable two-factor authentication with a valid code", func(t *testing.T) {
+		assert.NoError(t, twoFactorService.Disable(ctx, user.ID, recoveryCodes[1]))
+		assert.False(t, reload().TOTPEnabled)
+
+		var count int64
+		tx.Model(&models.RecoveryCode{}).Where("user_id = ?", user.ID).Count(&count)
+		assert.Zero(t, count)
+	})
+}
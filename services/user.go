@@ -1,35 +1,84 @@
 package services
 
 import (
+	"context"
 	"errors"
+	"strings"
 
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/wanliqun/go-wallet-app/apperrors"
+	"github.com/wanliqun/go-wallet-app/config"
 	"github.com/wanliqun/go-wallet-app/models"
+	"github.com/wanliqun/go-wallet-app/utils"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// pgUniqueViolation is the PostgreSQL error code for unique constraint violations
+const pgUniqueViolation = "23505"
+
+// serviceAccountEmailDomain is the domain of the placeholder emails of the service accounts
+const serviceAccountEmailDomain = "@service-accounts.invalid"
+
 var (
-	ErrUnauthorized = errors.New("Unauthorized")
-	ErrUserNotFound = errors.New("user not found")
+	ErrUnauthorized      = apperrors.New(apperrors.Forbidden, "Unauthorized")
+	ErrUserNotFound      = apperrors.New(apperrors.UserNotFound, "user not found")
+	ErrUserNameTaken     = apperrors.New(apperrors.UserNameTaken, "user name already taken")
+	ErrEmailTaken        = apperrors.New(apperrors.EmailTaken, "email already taken")
+	ErrAccountHasBalance = apperrors.New(apperrors.AccountHasBalance, "account still holds non-zero balances")
+	ErrInvalidRole       = apperrors.New(apperrors.InvalidRole, "invalid role")
+	ErrLastAdmin         = apperrors.New(apperrors.LastAdmin, "the last admin can not be demoted")
 
 	_ IUserService = &UserService{}
 )
 
+// UserUpdate holds the optional profile fields to update, nil fields are left unchanged
+type UserUpdate struct {
+	Name     *string
+	Email    *string
+	Password *string
+}
+
 type IUserService interface {
-	GetUserByName(name string) (*models.User, bool, error)
+	GetUserByName(ctx context.Context, name string) (*models.User, bool, error)
+	GetUserByID(ctx context.Context, id uint) (*models.User, bool, error)
+	CreateUser(ctx context.Context, name, email, password string) (*models.User, error)
+	UpdateUser(ctx context.Context, id uint, update UserUpdate) (*models.User, error)
+	DeleteUser(ctx context.Context, id uint) error
+	SetUserRole(ctx context.Context, id uint, role models.Role) (*models.User, error)
+	CreateServiceAccount(ctx context.Context, name string, role models.Role) (*models.User, error)
 }
 
 // UserService represents the service for user-related operations
 type UserService struct {
-	DB *gorm.DB
+	DB       *gorm.DB
+	Timeouts config.TimeoutsConfig // Database timeouts of the operations, none are applied if zero
 }
 
 func NewUserService(db *gorm.DB) *UserService {
 	return &UserService{DB: db}
 }
 
-func (svc *UserService) GetUserByName(name string) (*models.User, bool, error) {
+func (svc *UserService) GetUserByName(ctx context.Context, name string) (*models.User, bool, error) {
+	db, cancel := session(ctx, svc.DB, svc.Timeouts, OpGetUser)
+	defer cancel()
+
+	var user models.User
+	if err := db.Where("name = ?", name).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	return &user, true, nil
+}
+
+func (svc *UserService) GetUserByID(ctx context.Context, id uint) (*models.User, bool, error) {
+	db, cancel := session(ctx, svc.DB, svc.Timeouts, OpGetUser)
+	defer cancel()
+
 	var user models.User
-	if err := svc.DB.Where("name = ?", name).First(&user).Error; err != nil {
+	if err := db.First(&user, id).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, false, nil
 		}
@@ -37,3 +86,241 @@ func (svc *UserService) GetUserByName(name string) (*models.User, bool, error) {
 	}
 	return &user, true, nil
 }
+
+// CreateUser signs up a new user with a unique name and email
+func (svc *UserService) CreateUser(ctx context.Context, name, email, password string) (*models.User, error) {
+	db, cancel := session(ctx, svc.DB, svc.Timeouts, OpCreateUser)
+	defer cancel()
+
+	passwordHash, err := utils.HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	user := models.User{
+		Name:         name,
+		Email:        email,
+		Role:         models.RoleUser,
+		PasswordHash: passwordHash,
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := svc.checkUniqueness(tx, 0, &name, &email); err != nil {
+			return err
+		}
+		return translateUserError(tx.Create(&user).Error)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// UpdateUser updates the profile of the user
+func (svc *UserService) UpdateUser(ctx context.Context, id uint, update UserUpdate) (*models.User, error) {
+	db, cancel := session(ctx, svc.DB, svc.Timeouts, OpUpdateUser)
+	defer cancel()
+
+	updates := make(map[string]interface{})
+	if update.Name != nil {
+		updates["name"] = *update.Name
+	}
+	if update.Email != nil {
+		updates["email"] = *update.Email
+	}
+	if update.Password != nil {
+		passwordHash, err := utils.HashPassword(*update.Password)
+		if err != nil {
+			return nil, err
+		}
+		updates["password_hash"] = passwordHash
+	}
+
+	var user models.User
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrUserNotFound
+			}
+			return err
+		}
+
+		if len(updates) == 0 {
+			return nil
+		}
+
+		if err := svc.checkUniqueness(tx, id, update.Name, update.Email); err != nil {
+			return err
+		}
+		return translateUserError(tx.Model(&user).Updates(updates).Error)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// DeleteUser soft deletes the user, which is refused while any vault still holds a non-zero balance
+func (svc *UserService) DeleteUser(ctx context.Context, id uint) error {
+	db, cancel := session(ctx, svc.DB, svc.Timeouts, OpDeleteUser)
+	defer cancel()
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrUserNotFound
+			}
+			return err
+		}
+
+		// Lock the vaults so that no funds can arrive while closing the account
+		var vaults []models.Vault
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("user_id = ?", id).
+			Find(&vaults).Error
+		if err != nil {
+			return err
+		}
+		for _, vault := range vaults {
+			if !vault.Amount.IsZero() || !vault.Held.IsZero() {
+				return ErrAccountHasBalance
+			}
+		}
+
+		return tx.Delete(&user).Error
+	})
+}
+
+// SetUserRole changes the role of the user, which takes effect on the next request of the user.
+// The last admin can not be demoted, so that the roles can always be managed.
+func (svc *UserService) SetUserRole(ctx context.Context, id uint, role models.Role) (*models.User, error) {
+	db, cancel := session(ctx, svc.DB, svc.Timeouts, OpUpdateUser)
+	defer cancel()
+
+	if !IsValidRole(role) {
+		return nil, ErrInvalidRole
+	}
+
+	var user models.User
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&user, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrUserNotFound
+			}
+			return err
+		}
+
+		if user.Role == models.RoleAdmin && role != models.RoleAdmin {
+			// Lock the admins, so that concurrent demotions can not remove the last two together
+			var adminIDs []uint
+			err := tx.Model(&models.User{}).
+				Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("role = ?", models.RoleAdmin).
+				Pluck("id", &adminIDs).Error
+			if err != nil {
+				return err
+			}
+			if len(adminIDs) <= 1 {
+				return ErrLastAdmin
+			}
+		}
+
+		return tx.Model(&user).Update("role", role).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// CreateServiceAccount creates a user for a service, which has no password and only authenticates
+// with API keys. Service accounts have no email, a placeholder under the reserved .invalid domain
+// keeps the column unique.
+func (svc *UserService) CreateServiceAccount(ctx context.Context, name string, role models.Role) (*models.User, error) {
+	db, cancel := session(ctx, svc.DB, svc.Timeouts, OpCreateUser)
+	defer cancel()
+
+	if !IsValidRole(role) {
+		return nil, ErrInvalidRole
+	}
+
+	email := name + serviceAccountEmailDomain
+	user := models.User{
+		Name:           name,
+		Email:          email,
+		Role:           role,
+		ServiceAccount: true,
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := svc.checkUniqueness(tx, 0, &name, &email); err != nil {
+			return err
+		}
+		return translateUserError(tx.Create(&user).Error)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// SeedAdmins grants the admin role to the named users, so that the configured administrators keep
+// their access. Users not signed up yet are skipped. It runs on every startup, so the configuration
+// overrides a demotion of the named users through SetUserRole, which lasts until the next restart
+// unless they are removed from the configuration.
+func (svc *UserService) SeedAdmins(names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+
+	return svc.DB.Model(&models.User{}).
+		Where("name IN ? AND role <> ?", names, models.RoleAdmin).
+		Update("role", models.RoleAdmin).Error
+}
+
+// checkUniqueness checks that the name and email are not used by any other user, including
+// soft deleted ones since they still hold the unique columns.
+func (svc *UserService) checkUniqueness(tx *gorm.DB, id uint, name, email *string) error {
+	if name != nil {
+		var count int64
+		err := tx.Unscoped().Model(&models.User{}).
+			Where("name = ? AND id <> ?", *name, id).
+			Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrUserNameTaken
+		}
+	}
+
+	if email != nil {
+		var count int64
+		err := tx.Unscoped().Model(&models.User{}).
+			Where("email = ? AND id <> ?", *email, id).
+			Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrEmailTaken
+		}
+	}
+
+	return nil
+}
+
+// translateUserError translates unique constraint violations raised by concurrent sign-ups
+func translateUserError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgUniqueViolation {
+		if strings.Contains(pgErr.ConstraintName, "email") {
+			return ErrEmailTaken
+		}
+		return ErrUserNameTaken
+	}
+	return err
+}
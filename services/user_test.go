@@ -8,6 +8,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/assert"
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/wait"
@@ -19,14 +20,13 @@ import (
 )
 
 var (
+	ctx           = context.Background()
 	db            *gorm.DB
 	userGenerator models.FakeUserGenerator
 )
 
 // Setup PostgreSQL container for tests
 func TestMain(m *testing.M) {
-	ctx := context.Background()
-
 	// Request to start a PostgreSQL container
 	req := testcontainers.ContainerRequest{
 		Image:        "postgres:13", // Specify PostgreSQL version
@@ -63,7 +63,27 @@ func TestMain(m *testing.M) {
 	}
 
 	// Run auto-migrations
-	db.AutoMigrate(&models.User{}, &models.Vault{}, &models.Transaction{})
+	db.AutoMigrate(
+		&models.User{},
+		&models.Vault{},
+		&models.Transaction{},
+		&models.IdempotencyKey{},
+		&models.RevokedToken{},
+		&models.Currency{},
+		&models.LedgerAccount{},
+		&models.JournalEntry{},
+		&models.Posting{},
+		&models.ReconciliationRun{},
+		&models.Hold{},
+		&models.ExchangeQuote{},
+		&models.UserLimit{},
+		&models.OutboxEvent{},
+		&models.WebhookEndpoint{},
+		&models.WebhookDelivery{},
+		&models.RecoveryCode{},
+		&models.APIKey{},
+		&models.SchemaMigration{},
+	)
 
 	// Run the tests
 	code := m.Run()
@@ -83,7 +103,7 @@ func TestGetUserByName(t *testing.T) {
 	userService := services.NewUserService(tx)
 
 	t.Run("should return user when user exists", func(t *testing.T) {
-		user, found, err := userService.GetUserByName(testuser.Name)
+		user, found, err := userService.GetUserByName(ctx, testuser.Name)
 		assert.NoError(t, err)
 		assert.True(t, found)
 		assert.Equal(t, testuser.Name, user.Name)
@@ -91,9 +111,166 @@ func TestGetUserByName(t *testing.T) {
 	})
 
 	t.Run("should return not found when user does not exist", func(t *testing.T) {
-		user, found, err := userService.GetUserByName("nonexistentuser")
+		user, found, err := userService.GetUserByName(ctx, "nonexistentuser")
 		assert.NoError(t, err)
 		assert.False(t, found)
 		assert.Nil(t, user)
 	})
 }
+
+func TestCreateUser(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+
+	testuser := userGenerator.Generate()
+	tx.Create(testuser)
+
+	userService := services.NewUserService(tx)
+
+	t.Run("should create user with hashed password", func(t *testing.T) {
+		user, err := userService.CreateUser(ctx, "new_user", "new_user@example.com", "password123")
+		assert.NoError(t, err)
+		assert.NotZero(t, user.ID)
+		assert.NotEqual(t, "password123", user.PasswordHash)
+	})
+
+	t.Run("should reject a taken name", func(t *testing.T) {
+		_, err := userService.CreateUser(ctx, testuser.Name, "other@example.com", "password123")
+		assert.Equal(t, services.ErrUserNameTaken, err)
+	})
+
+	t.Run("should reject a taken email", func(t *testing.T) {
+		_, err := userService.CreateUser(ctx, "other_user", testuser.Email, "password123")
+		assert.Equal(t, services.ErrEmailTaken, err)
+	})
+}
+
+func TestUpdateUser(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+
+	testuser := userGenerator.Generate()
+	otheruser := userGenerator.Generate()
+	tx.CreateInBatches([]*models.User{testuser, otheruser}, 2)
+
+	userService := services.NewUserService(tx)
+
+	t.Run("should update the email", func(t *testing.T) {
+		email := "updated@example.com"
+		user, err := userService.UpdateUser(ctx, testuser.ID, services.UserUpdate{Email: &email})
+		assert.NoError(t, err)
+		assert.Equal(t, email, user.Email)
+		assert.Equal(t, testuser.Name, user.Name)
+	})
+
+	t.Run("should reject a name taken by another user", func(t *testing.T) {
+		_, err := userService.UpdateUser(ctx, testuser.ID, services.UserUpdate{Name: &otheruser.Name})
+		assert.Equal(t, services.ErrUserNameTaken, err)
+	})
+}
+
+func TestDeleteUser(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+
+	richuser := userGenerator.Generate()
+	pooruser := userGenerator.Generate()
+	tx.CreateInBatches([]*models.User{richuser, pooruser}, 2)
+
+	tx.Create(&models.Vault{UserID: richuser.ID, Currency: "USDT", Amount: decimal.NewFromInt(10)})
+	tx.Create(&models.Vault{UserID: pooruser.ID, Currency: "USDT", Amount: decimal.Zero})
+
+	userService := services.NewUserService(tx)
+
+	t.Run("should refuse to delete a user holding balances", func(t *testing.T) {
+		err := userService.DeleteUser(ctx, richuser.ID)
+		assert.Equal(t, services.ErrAccountHasBalance, err)
+	})
+
+	t.Run("should soft delete a user with zero balances", func(t *testing.T) {
+		err := userService.DeleteUser(ctx, pooruser.ID)
+		assert.NoError(t, err)
+
+		_, found, err := userService.GetUserByID(ctx, pooruser.ID)
+		assert.NoError(t, err)
+		assert.False(t, found)
+
+		var deleted models.User
+		tx.Unscoped().First(&deleted, pooruser.ID)
+		assert.True(t, deleted.DeletedAt.Valid)
+	})
+}
+
+func TestSetUserRole(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+
+	testuser := userGenerator.Generate()
+	adminuser := userGenerator.Generate()
+	tx.CreateInBatches([]*models.User{testuser, adminuser}, 2)
+
+	userService := services.NewUserService(tx)
+
+	t.Run("should change the role", func(t *testing.T) {
+		user, err := userService.SetUserRole(ctx, testuser.ID, models.RoleSupport)
+		assert.NoError(t, err)
+		assert.Equal(t, models.RoleSupport, user.Role)
+
+		user, _, err = userService.GetUserByID(ctx, testuser.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, models.RoleSupport, user.Role)
+	})
+
+	t.Run("should reject an unknown role", func(t *testing.T) {
+		_, err := userService.SetUserRole(ctx, testuser.ID, models.Role("root"))
+		assert.Equal(t, services.ErrInvalidRole, err)
+	})
+
+	t.Run("should grant the admin role to the configured administrators", func(t *testing.T) {
+		err := userService.SeedAdmins([]string{adminuser.Name, "unknown_user"})
+		assert.NoError(t, err)
+
+		user, _, err := userService.GetUserByID(ctx, adminuser.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, models.RoleAdmin, user.Role)
+	})
+
+	t.Run("should refuse to demote the last admin", func(t *testing.T) {
+		_, err := userService.SetUserRole(ctx, adminuser.ID, models.RoleUser)
+		assert.Equal(t, services.ErrLastAdmin, err)
+
+		user, _, err := userService.GetUserByID(ctx, adminuser.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, models.RoleAdmin, user.Role)
+
+		// Another admin can be demoted
+		_, err = userService.SetUserRole(ctx, testuser.ID, models.RoleAdmin)
+		assert.NoError(t, err)
+		user, err = userService.SetUserRole(ctx, adminuser.ID, models.RoleUser)
+		assert.NoError(t, err)
+		assert.Equal(t, models.RoleUser, user.Role)
+	})
+}
+
+func TestCreateServiceAccount(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+
+	userService := services.NewUserService(tx)
+	authService := services.NewAuthService(tx, authConfig)
+
+	account, err := userService.CreateServiceAccount(ctx, "reporting_job", models.RoleSupport)
+	assert.NoError(t, err)
+	assert.True(t, account.ServiceAccount)
+	assert.Equal(t, models.RoleSupport, account.Role)
+
+	// Service accounts can not log in
+	_, err = authService.Login("reporting_job", "")
+	assert.Equal(t, services.ErrInvalidCredentials, err)
+
+	_, err = userService.CreateServiceAccount(ctx, "reporting_job", models.RoleSupport)
+	assert.Equal(t, services.ErrUserNameTaken, err)
+
+	_, err = userService.CreateServiceAccount(ctx, "other_job", models.Role("root"))
+	assert.Equal(t, services.ErrInvalidRole, err)
+}
@@ -1,9 +1,15 @@
 package services
 
 import (
+	"context"
 	"errors"
+	"strings"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/shopspring/decimal"
+	"github.com/wanliqun/go-wallet-app/apperrors"
+	"github.com/wanliqun/go-wallet-app/config"
 	"github.com/wanliqun/go-wallet-app/models"
 	"github.com/wanliqun/go-wallet-app/utils"
 	"gorm.io/gorm"
@@ -18,173 +24,347 @@ const (
 )
 
 var (
-	ErrInvalidAmount       = errors.New("invalid amount")
-	ErrInsufficientBalance = errors.New("insufficient balance")
+	ErrInvalidAmount       = apperrors.New(apperrors.InvalidAmount, "invalid amount")
+	ErrInsufficientBalance = apperrors.New(apperrors.InsufficientBalance, "insufficient balance")
+	ErrSelfTransfer        = apperrors.New(apperrors.SelfTransfer, "cannot transfer to self")
+	ErrInvalidCursor       = apperrors.New(apperrors.InvalidRequest, "invalid cursor")
 
 	_ IWalletService = &WalletService{}
+
+	// likeEscaper escapes the wildcards of LIKE patterns with backslashes, the default escape character
+	likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
 )
 
 type IWalletService interface {
-	Deposit(userID uint, currency string, amount decimal.Decimal) error
-	Withdraw(userID uint, currency string, amount decimal.Decimal) error
-	Transfer(senderID, recipientID uint, currency string, amount decimal.Decimal, memo string) error
-	GetBalances(userID uint, currencies []string) ([]models.Vault, error)
-	GetTransactionHistory(userID uint, txnType models.TransactionType, cursor string, order SortOrder, limit int) ([]models.Transaction, string, error)
+	Deposit(ctx context.Context, userID uint, currency string, amount decimal.Decimal, idempotencyKey string) (*models.Transaction, error)
+	Withdraw(ctx context.Context, userID uint, currency string, amount decimal.Decimal, idempotencyKey string, stepUp StepUpCheck) (*models.Transaction, error)
+	Transfer(ctx context.Context, senderID, recipientID uint, currency string, amount decimal.Decimal, memo, idempotencyKey string, stepUp StepUpCheck) (*models.Transaction, error)
+	Reverse(ctx context.Context, transactionID uint, memo string, force bool) (*models.Transaction, error)
+	GetBalances(ctx context.Context, userID uint, currencies []string) ([]models.Vault, error)
+	GetTransactionHistory(ctx context.Context, userID uint, filter TransactionFilter, cursor string, order SortOrder, limit int) ([]models.Transaction, string, error)
+	GetTransaction(ctx context.Context, userID, transactionID uint) (*models.Transaction, bool, error)
+	GetTransferCounterpart(ctx context.Context, txn *models.Transaction) (*models.Transaction, bool, error)
+}
+
+// TransactionFilter narrows down the transaction history, zero fields do not filter
+type TransactionFilter struct {
+	Types          []models.TransactionType // Any of the types
+	From           time.Time                // Inclusive lower bound of the timestamp
+	To             time.Time                // Exclusive upper bound of the timestamp
+	Currency       string
+	CounterpartyID uint
+	MinAmount      decimal.NullDecimal // Inclusive lower bound of the amount in minor units
+	MaxAmount      decimal.NullDecimal // Inclusive upper bound of the amount in minor units
+	Memo           string              // Case-insensitive text the memo contains
+}
+
+// apply adds the conditions of the filter to the query of the transactions of a user. The type,
+// currency and counterparty conditions are served by the (user_id, <column>, timestamp, id)
+// indexes, which keep the rows ordered for keyset pagination.
+func (f TransactionFilter) apply(query *gorm.DB) *gorm.DB {
+	if len(f.Types) == 1 {
+		query = query.Where("type = ?", f.Types[0])
+	} else if len(f.Types) > 1 {
+		query = query.Where("type IN ?", f.Types)
+	}
+	if !f.From.IsZero() {
+		query = query.Where("timestamp >= ?", f.From)
+	}
+	if !f.To.IsZero() {
+		query = query.Where("timestamp < ?", f.To)
+	}
+	if f.Currency != "" {
+		query = query.Where("currency = ?", f.Currency)
+	}
+	if f.CounterpartyID != 0 {
+		query = query.Where("counterparty_id = ?", f.CounterpartyID)
+	}
+	if f.MinAmount.Valid {
+		query = query.Where("amount >= ?", f.MinAmount.Decimal)
+	}
+	if f.MaxAmount.Valid {
+		query = query.Where("amount <= ?", f.MaxAmount.Decimal)
+	}
+	if f.Memo != "" {
+		query = query.Where("memo ILIKE ?", "%"+likeEscaper.Replace(f.Memo)+"%")
+	}
+	return query
 }
 
 // WalletService represents the service for wallet-related operations
 type WalletService struct {
-	DB *gorm.DB
+	DB       *gorm.DB
+	Fees     IFeeService           // Fee schedules of withdrawals and transfers, no fees are charged if nil
+	Limits   ILimitService         // Limits of withdrawals and transfers, no limits are enforced if nil
+	Timeouts config.TimeoutsConfig // Database timeouts of the operations, none are applied if zero
 }
 
 func NewWalletService(db *gorm.DB) *WalletService {
 	return &WalletService{DB: db}
 }
 
-func (s *WalletService) Deposit(userID uint, currency string, amount decimal.Decimal) error {
+func (s *WalletService) Deposit(
+	ctx context.Context, userID uint, currency string, amount decimal.Decimal, idempotencyKey string) (*models.Transaction, error) {
+	db, cancel := session(ctx, s.DB, s.Timeouts, OpDeposit)
+	defer cancel()
+
 	if amount.LessThanOrEqual(decimal.Zero) {
-		return ErrInvalidAmount
+		return nil, ErrInvalidAmount
 	}
 
-	return s.DB.Transaction(func(tx *gorm.DB) error {
-		// Upsert the Vault record using ON CONFLICT clause
-		vault := models.Vault{
-			UserID:   userID,
-			Currency: currency,
-			Amount:   amount,
-		}
-		err := tx.Clauses(clause.OnConflict{
-			Columns: []clause.Column{{Name: "user_id"}, {Name: "currency"}},
-			DoUpdates: clause.Assignments(map[string]interface{}{
-				"amount": gorm.Expr("EXCLUDED.amount + ?", amount),
-			}),
-		}).Create(&vault).Error
-		if err != nil {
-			return err
-		}
+	var transaction *models.Transaction
+	fingerprint := idempotencyFingerprint("deposit", currency, amount)
+	err := db.Transaction(func(tx *gorm.DB) (err error) {
+		transaction, err = withIdempotency(tx, userID, idempotencyKey, fingerprint, func() (*models.Transaction, error) {
+			if err := creditVault(tx, userID, currency, amount); err != nil {
+				return nil, err
+			}
 
-		// Record the deposit in transaction history
-		transaction := models.Transaction{
-			UserID:   userID,
-			Type:     models.Deposit,
-			Amount:   amount,
-			Currency: currency,
-		}
-		if err := tx.Create(&transaction).Error; err != nil {
-			return err
-		}
+			// Record the deposit in transaction history
+			transaction := models.Transaction{
+				UserID:   userID,
+				Type:     models.Deposit,
+				Amount:   amount,
+				Currency: currency,
+			}
+			if err := tx.Create(&transaction).Error; err != nil {
+				return nil, err
+			}
 
-		return nil
+			// Post the funds received from outside to the user's ledger account
+			err := newJournal(currency, "deposit").
+				forTransaction(&transaction).
+				move(systemAccount(models.ExternalDepositsAccount), userAccount(userID), amount).
+				post(tx)
+			if err != nil {
+				return nil, err
+			}
+
+			if err := enqueueTransactionEvents(tx, &transaction); err != nil {
+				return nil, err
+			}
+
+			return &transaction, nil
+		})
+		return err
 	})
+	if err != nil {
+		return nil, err
+	}
+
+	return transaction, nil
 }
 
-func (s *WalletService) Withdraw(userID uint, currency string, amount decimal.Decimal) error {
+func (s *WalletService) Withdraw(
+	ctx context.Context, userID uint, currency string, amount decimal.Decimal, idempotencyKey string, stepUp StepUpCheck,
+) (*models.Transaction, error) {
+	db, cancel := session(ctx, s.DB, s.Timeouts, OpWithdraw)
+	defer cancel()
+
 	if amount.LessThanOrEqual(decimal.Zero) {
-		return ErrInvalidAmount
+		return nil, ErrInvalidAmount
 	}
 
-	return s.DB.Transaction(func(tx *gorm.DB) error {
-		// Attempt to decrement the amount atomically, ensuring the balance doesn't go negative
-		result := tx.Model(&models.Vault{}).
-			Where("user_id = ? AND currency = ? AND amount >= ?", userID, currency, amount).
-			Update("amount", gorm.Expr("amount - ?", amount))
-		if result.Error != nil {
-			return result.Error
-		}
-		if result.RowsAffected == 0 {
-			return ErrInsufficientBalance
-		}
+	fee, net, err := s.calculateFee(currency, models.Withdrawal, amount)
+	if err != nil {
+		return nil, err
+	}
 
-		// Record the withdrawal in transaction history
-		transaction := models.Transaction{
-			UserID:   userID,
-			Type:     models.Withdrawal,
-			Amount:   amount,
-			Currency: currency,
-		}
-		if err := tx.Create(&transaction).Error; err != nil {
-			return err
-		}
+	var transaction *models.Transaction
+	fingerprint := idempotencyFingerprint("withdraw", currency, amount)
+	err = db.Transaction(func(tx *gorm.DB) (err error) {
+		transaction, err = withIdempotency(tx, userID, idempotencyKey, fingerprint, func() (*models.Transaction, error) {
+			if err := checkStepUp(stepUp, tx); err != nil {
+				return nil, err
+			}
+
+			// Attempt to decrement the amount atomically, ensuring the balance doesn't go negative
+			result := tx.Model(&models.Vault{}).
+				Where("user_id = ? AND currency = ? AND amount >= ?", userID, currency, amount).
+				Update("amount", gorm.Expr("amount - ?", amount))
+			if result.Error != nil {
+				return nil, result.Error
+			}
+			if result.RowsAffected == 0 {
+				return nil, ErrInsufficientBalance
+			}
+
+			// Check the limits now that the vault is locked against concurrent withdrawals
+			if err := checkLimits(s.Limits, tx, userID, currency, amount); err != nil {
+				return nil, err
+			}
+
+			// Record the withdrawal net of the fee in transaction history
+			transaction := models.Transaction{
+				UserID:   userID,
+				Type:     models.Withdrawal,
+				Amount:   net,
+				Currency: currency,
+			}
+			if err := tx.Create(&transaction).Error; err != nil {
+				return nil, err
+			}
+
+			// Post the funds owed to the withdrawal destination from the user's ledger account
+			journal := newJournal(currency, "withdrawal").
+				forTransaction(&transaction).
+				debit(userAccount(userID), amount).
+				credit(systemAccount(models.WithdrawalsPayableAccount), net)
+			feeTxn, err := chargeFee(tx, &transaction, fee, journal)
+			if err != nil {
+				return nil, err
+			}
+			if err := journal.post(tx); err != nil {
+				return nil, err
+			}
+
+			if err := enqueueTransactionEvents(tx, withFee(feeTxn, &transaction)...); err != nil {
+				return nil, err
+			}
 
-		return nil
+			return &transaction, nil
+		})
+		return err
 	})
+	if err != nil {
+		return nil, err
+	}
+
+	return transaction, nil
 }
 
-func (s *WalletService) Transfer(senderID, recipientID uint, currency string, amount decimal.Decimal, memo string) error {
+func (s *WalletService) Transfer(
+	ctx context.Context, senderID, recipientID uint, currency string, amount decimal.Decimal, memo, idempotencyKey string,
+	stepUp StepUpCheck,
+) (*models.Transaction, error) {
+	db, cancel := session(ctx, s.DB, s.Timeouts, OpTransfer)
+	defer cancel()
+
 	if amount.LessThanOrEqual(decimal.Zero) {
-		return ErrInvalidAmount
+		return nil, ErrInvalidAmount
 	}
 
 	// Validate recipient (cannot be the sender)
 	if recipientID == senderID {
-		return errors.New("cannot transfer to self")
+		return nil, ErrSelfTransfer
+	}
+
+	fee, net, err := s.calculateFee(currency, models.TransferOut, amount)
+	if err != nil {
+		return nil, err
 	}
 
 	// Start a database transaction
-	return s.DB.Transaction(func(tx *gorm.DB) error {
-		// Deduct from sender's vault atomically
-		result := tx.Model(&models.Vault{}).
-			Where("user_id = ? AND currency = ? AND amount >= ?", senderID, currency, amount).
-			Updates(map[string]interface{}{
-				"amount": gorm.Expr("amount - ?", amount),
-			})
-		if result.Error != nil {
-			return result.Error
-		}
-		if result.RowsAffected == 0 {
-			return ErrInsufficientBalance
-		}
+	var transaction *models.Transaction
+	fingerprint := idempotencyFingerprint("transfer", recipientID, currency, amount, memo)
+	err = db.Transaction(func(tx *gorm.DB) (err error) {
+		transaction, err = withIdempotency(tx, senderID, idempotencyKey, fingerprint, func() (*models.Transaction, error) {
+			if err := checkStepUp(stepUp, tx); err != nil {
+				return nil, err
+			}
 
-		// Find or create recipient's vault
-		var recipientVault models.Vault
-		if err := tx.FirstOrCreate(&recipientVault, models.Vault{
-			UserID:   recipientID,
-			Currency: currency,
-		}).Error; err != nil {
-			// If there's any error other than duplicate key, return it
-			if !errors.Is(err, gorm.ErrDuplicatedKey) {
-				return err
+			// Deduct from sender's vault atomically
+			result := tx.Model(&models.Vault{}).
+				Where("user_id = ? AND currency = ? AND amount >= ?", senderID, currency, amount).
+				Updates(map[string]interface{}{
+					"amount": gorm.Expr("amount - ?", amount),
+				})
+			if result.Error != nil {
+				return nil, result.Error
+			}
+			if result.RowsAffected == 0 {
+				return nil, ErrInsufficientBalance
 			}
-		}
 
-		// Add to recipient's vault
-		result = tx.Model(&models.Vault{}).
-			Where("user_id = ? AND currency = ?", recipientID, currency).
-			Update("amount", gorm.Expr("amount + ?", amount))
-		if result.Error != nil {
-			return result.Error
-		}
-		if result.RowsAffected == 0 {
-			return errors.New("failed to update recipient's vault")
-		}
+			// Check the limits now that the vault is locked against concurrent transfers
+			if err := checkLimits(s.Limits, tx, senderID, currency, amount); err != nil {
+				return nil, err
+			}
 
-		// Create transaction records for sender and recipient as a batch
-		batchTxns := []*models.Transaction{
-			{ // transfer out
-				UserID:         senderID,
-				Type:           models.TransferOut,
-				Amount:         amount,
-				Currency:       currency,
-				Memo:           memo,
-				CounterpartyID: &recipientID,
-			},
-			{ // transfer in
-				UserID:         recipientID,
-				Type:           models.TransferIn,
-				Amount:         amount,
-				Currency:       currency,
-				Memo:           memo,
-				CounterpartyID: &senderID,
-			},
-		}
-		// Batch insert the transactions
-		return tx.Create(batchTxns).Error
+			// Find or create recipient's vault
+			var recipientVault models.Vault
+			if err := tx.FirstOrCreate(&recipientVault, models.Vault{
+				UserID:   recipientID,
+				Currency: currency,
+			}).Error; err != nil {
+				// If there's any error other than duplicate key, return it
+				if !errors.Is(err, gorm.ErrDuplicatedKey) {
+					return nil, err
+				}
+			}
+
+			// Add the amount net of the fee to recipient's vault
+			result = tx.Model(&models.Vault{}).
+				Where("user_id = ? AND currency = ?", recipientID, currency).
+				Update("amount", gorm.Expr("amount + ?", net))
+			if result.Error != nil {
+				return nil, result.Error
+			}
+			if result.RowsAffected == 0 {
+				return nil, errors.New("failed to update recipient's vault")
+			}
+
+			// Create transaction records for sender and recipient as a batch, sharing a reference
+			reference := uuid.NewString()
+			batchTxns := []*models.Transaction{
+				{ // transfer out
+					UserID:         senderID,
+					Type:           models.TransferOut,
+					Amount:         net,
+					Currency:       currency,
+					Memo:           memo,
+					Reference:      reference,
+					CounterpartyID: &recipientID,
+				},
+				{ // transfer in
+					UserID:         recipientID,
+					Type:           models.TransferIn,
+					Amount:         net,
+					Currency:       currency,
+					Memo:           memo,
+					Reference:      reference,
+					CounterpartyID: &senderID,
+				},
+			}
+			// Batch insert the transactions
+			if err := tx.Create(batchTxns).Error; err != nil {
+				return nil, err
+			}
+
+			// Post the transfer between the ledger accounts of sender and recipient
+			journal := newJournal(currency, "transfer").
+				forTransaction(batchTxns[0]).
+				debit(userAccount(senderID), amount).
+				credit(userAccount(recipientID), net)
+			feeTxn, err := chargeFee(tx, batchTxns[0], fee, journal)
+			if err != nil {
+				return nil, err
+			}
+			if err := journal.post(tx); err != nil {
+				return nil, err
+			}
+
+			if err := enqueueTransactionEvents(tx, withFee(feeTxn, batchTxns...)...); err != nil {
+				return nil, err
+			}
+
+			return batchTxns[0], nil
+		})
+		return err
 	})
+	if err != nil {
+		return nil, err
+	}
+
+	return transaction, nil
 }
 
-func (s *WalletService) GetBalances(userID uint, currencies []string) ([]models.Vault, error) {
+func (s *WalletService) GetBalances(ctx context.Context, userID uint, currencies []string) ([]models.Vault, error) {
+	db, cancel := session(ctx, s.DB, s.Timeouts, OpGetBalances)
+	defer cancel()
+
 	var vaults []models.Vault
-	err := s.DB.Model(&models.Vault{}).
+	err := db.Model(&models.Vault{}).
 		Where("user_id = ? AND currency IN ?", userID, currencies).
 		Find(&vaults).Error
 	if err != nil {
@@ -196,21 +376,19 @@ func (s *WalletService) GetBalances(userID uint, currencies []string) ([]models.
 
 // GetTransactionHistory retrieves paginated transaction history using a unique cursor with filters
 func (s *WalletService) GetTransactionHistory(
-	userID uint, txnType models.TransactionType, cursor string, order SortOrder, limit int) ([]models.Transaction, string, error) {
+	ctx context.Context, userID uint, filter TransactionFilter, cursor string, order SortOrder, limit int) ([]models.Transaction, string, error) {
+	db, cancel := session(ctx, s.DB, s.Timeouts, OpGetHistory)
+	defer cancel()
+
 	var transactions []models.Transaction
 
-	query := s.DB.Where("user_id = ?", userID)
-
-	// Apply transaction type filter if provided
-	if txnType != "" {
-		query = query.Where("type = ?", txnType)
-	}
+	query := filter.apply(db.Where("user_id = ?", userID))
 
 	// Decode the cursor if provided for pagination
 	if cursor != "" {
 		timestamp, txnID, err := utils.DecodeCursor(cursor)
 		if err != nil {
-			return nil, "", err
+			return nil, "", ErrInvalidCursor
 		}
 
 		// Apply keyset pagination using cursor values
@@ -248,3 +426,96 @@ func (s *WalletService) GetTransactionHistory(
 
 	return transactions, nextCursor, nil
 }
+
+// GetTransaction returns the transaction of the user
+func (s *WalletService) GetTransaction(ctx context.Context, userID, transactionID uint) (*models.Transaction, bool, error) {
+	db, cancel := session(ctx, s.DB, s.Timeouts, OpGetTransaction)
+	defer cancel()
+
+	var transaction models.Transaction
+	err := db.Where("id = ? AND user_id = ?", transactionID, userID).First(&transaction).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	return &transaction, true, nil
+}
+
+// GetTransferCounterpart returns the other leg of a transfer, which belongs to the counterparty
+func (s *WalletService) GetTransferCounterpart(ctx context.Context, txn *models.Transaction) (*models.Transaction, bool, error) {
+	db, cancel := session(ctx, s.DB, s.Timeouts, OpGetTransaction)
+	defer cancel()
+
+	return findTransferCounterpart(db, txn)
+}
+
+// transferCounterpartTypes are the types of the other leg of a transfer by the type of either leg
+var transferCounterpartTypes = map[models.TransactionType][]models.TransactionType{
+	models.TransferOut:  {models.TransferIn},
+	models.HoldCaptured: {models.TransferIn},
+	models.TransferIn:   {models.TransferOut, models.HoldCaptured},
+}
+
+// findTransferCounterpart finds the other leg of a transfer, or of a captured hold, by their shared
+// reference. Legs recorded before references were introduced are matched by their counterparty,
+// amount and timestamp, since both legs are inserted in a batch.
+func findTransferCounterpart(query *gorm.DB, txn *models.Transaction) (*models.Transaction, bool, error) {
+	types, ok := transferCounterpartTypes[txn.Type]
+	if !ok || txn.CounterpartyID == nil {
+		return nil, false, nil
+	}
+
+	query = query.Where("user_id = ? AND counterparty_id = ? AND type IN ?", *txn.CounterpartyID, txn.UserID, types)
+	if txn.Reference != "" {
+		query = query.Where("reference = ?", txn.Reference)
+	} else {
+		query = query.
+			Where("currency = ? AND amount = ? AND timestamp = ?", txn.Currency, txn.Amount, txn.Timestamp).
+			Order(clause.OrderBy{Expression: clause.Expr{
+				SQL: "ABS(id - ?)", Vars: []interface{}{txn.ID}, WithoutParentheses: true,
+			}})
+	}
+
+	var counterpart models.Transaction
+	if err := query.Take(&counterpart).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	return &counterpart, true, nil
+}
+
+// calculateFee returns the fee of the operation and the net amount left once the fee is deducted
+func (s *WalletService) calculateFee(
+	currency string, operation models.TransactionType, amount decimal.Decimal) (decimal.Decimal, decimal.Decimal, error) {
+	if s.Fees == nil {
+		return decimal.Zero, amount, nil
+	}
+	return s.Fees.CalculateFee(currency, operation, amount)
+}
+
+// withFee appends the fee transaction, if any, to the transactions it was charged along with
+func withFee(fee *models.Transaction, transactions ...*models.Transaction) []*models.Transaction {
+	if fee == nil {
+		return transactions
+	}
+	return append(transactions, fee)
+}
+
+// creditVault adds the amount to the user's vault, upserting the Vault record using ON CONFLICT clause
+func creditVault(tx *gorm.DB, userID uint, currency string, amount decimal.Decimal) error {
+	vault := models.Vault{
+		UserID:   userID,
+		Currency: currency,
+		Amount:   amount,
+	}
+	return tx.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "user_id"}, {Name: "currency"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"amount": gorm.Expr("vaults.amount + EXCLUDED.amount"),
+		}),
+	}).Create(&vault).Error
+}
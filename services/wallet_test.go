@@ -1,10 +1,13 @@
 package services_test
 
 import (
+	"context"
 	"testing"
+	"time"
 
 	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/assert"
+	"github.com/wanliqun/go-wallet-app/config"
 	"github.com/wanliqun/go-wallet-app/models"
 	"github.com/wanliqun/go-wallet-app/services"
 )
@@ -23,7 +26,7 @@ func TestDeposit(t *testing.T) {
 	t.Run("should deposit successfully", func(t *testing.T) {
 		amount := decimal.NewFromFloat(100.0)
 
-		err := walletService.Deposit(testuser.ID, currency, amount)
+		_, err := walletService.Deposit(ctx, testuser.ID, currency, amount, "")
 		assert.NoError(t, err)
 
 		var vault models.Vault
@@ -34,7 +37,7 @@ func TestDeposit(t *testing.T) {
 	t.Run("should return error for invalid amount", func(t *testing.T) {
 		amount := decimal.NewFromFloat(-50.0)
 
-		err := walletService.Deposit(testuser.ID, currency, amount)
+		_, err := walletService.Deposit(ctx, testuser.ID, currency, amount, "")
 		assert.Error(t, err)
 		assert.Equal(t, services.ErrInvalidAmount, err)
 	})
@@ -51,12 +54,12 @@ func TestWithdraw(t *testing.T) {
 
 	currency := "USDT"
 	initialAmount := decimal.NewFromFloat(100.0)
-	walletService.Deposit(testuser.ID, currency, initialAmount)
+	walletService.Deposit(ctx, testuser.ID, currency, initialAmount, "")
 
 	t.Run("should withdraw successfully", func(t *testing.T) {
 		withdrawAmount := decimal.NewFromFloat(50.0)
 
-		err := walletService.Withdraw(testuser.ID, currency, withdrawAmount)
+		_, err := walletService.Withdraw(ctx, testuser.ID, currency, withdrawAmount, "", nil)
 		assert.NoError(t, err)
 
 		var vault models.Vault
@@ -67,7 +70,7 @@ func TestWithdraw(t *testing.T) {
 	t.Run("should return error for insufficient balance", func(t *testing.T) {
 		withdrawAmount := decimal.NewFromFloat(200.0)
 
-		err := walletService.Withdraw(testuser.ID, currency, withdrawAmount)
+		_, err := walletService.Withdraw(ctx, testuser.ID, currency, withdrawAmount, "", nil)
 		assert.Error(t, err)
 		assert.Equal(t, services.ErrInsufficientBalance, err)
 	})
@@ -86,10 +89,10 @@ func TestTransfer(t *testing.T) {
 	currency := "USDT"
 	amount := decimal.NewFromFloat(50.0)
 
-	walletService.Deposit(senderUser.ID, currency, decimal.NewFromFloat(100.0))
+	walletService.Deposit(ctx, senderUser.ID, currency, decimal.NewFromFloat(100.0), "")
 
 	t.Run("should transfer successfully", func(t *testing.T) {
-		err := walletService.Transfer(senderUser.ID, recipientUser.ID, currency, amount, "test transfer")
+		_, err := walletService.Transfer(ctx, senderUser.ID, recipientUser.ID, currency, amount, "test transfer", "", nil)
 		assert.NoError(t, err)
 
 		var senderVault, recipientVault models.Vault
@@ -101,13 +104,13 @@ func TestTransfer(t *testing.T) {
 	})
 
 	t.Run("should return error for insufficient balance", func(t *testing.T) {
-		err := walletService.Transfer(senderUser.ID, recipientUser.ID, currency, decimal.NewFromFloat(200.0), "test insufficient balance")
+		_, err := walletService.Transfer(ctx, senderUser.ID, recipientUser.ID, currency, decimal.NewFromFloat(200.0), "test insufficient balance", "", nil)
 		assert.Error(t, err)
 		assert.Equal(t, services.ErrInsufficientBalance, err)
 	})
 
 	t.Run("should return error when transferring to self", func(t *testing.T) {
-		err := walletService.Transfer(senderUser.ID, senderUser.ID, currency, amount, "self transfer")
+		_, err := walletService.Transfer(ctx, senderUser.ID, senderUser.ID, currency, amount, "self transfer", "", nil)
 		assert.Error(t, err)
 		assert.Equal(t, "cannot transfer to self", err.Error())
 	})
@@ -125,11 +128,11 @@ func TestGetBalances(t *testing.T) {
 	currency1 := "BTC"
 	currency2 := "USDT"
 
-	walletService.Deposit(testuser.ID, currency1, decimal.NewFromFloat(100.0))
-	walletService.Deposit(testuser.ID, currency2, decimal.NewFromFloat(50.0))
+	walletService.Deposit(ctx, testuser.ID, currency1, decimal.NewFromFloat(100.0), "")
+	walletService.Deposit(ctx, testuser.ID, currency2, decimal.NewFromFloat(50.0), "")
 
 	t.Run("should return all balances for the user", func(t *testing.T) {
-		balances, err := walletService.GetBalances(testuser.ID, []string{currency1, currency2})
+		balances, err := walletService.GetBalances(ctx, testuser.ID, []string{currency1, currency2})
 		assert.NoError(t, err)
 		assert.Len(t, balances, 2)
 
@@ -155,12 +158,12 @@ func TestGetTransactionHistory(t *testing.T) {
 
 	currency := "USDT"
 
-	walletService.Deposit(testuser.ID, currency, decimal.NewFromFloat(100.0))
-	walletService.Withdraw(testuser.ID, currency, decimal.NewFromFloat(20.0))
-	walletService.Deposit(testuser.ID, currency, decimal.NewFromFloat(50.0))
+	walletService.Deposit(ctx, testuser.ID, currency, decimal.NewFromFloat(100.0), "")
+	walletService.Withdraw(ctx, testuser.ID, currency, decimal.NewFromFloat(20.0), "", nil)
+	walletService.Deposit(ctx, testuser.ID, currency, decimal.NewFromFloat(50.0), "")
 
 	t.Run("should return transaction history for the user", func(t *testing.T) {
-		transactions, cursor, err := walletService.GetTransactionHistory(testuser.ID, "", "", services.SortOrderDesc, 10)
+		transactions, cursor, err := walletService.GetTransactionHistory(ctx, testuser.ID, services.TransactionFilter{}, "", services.SortOrderDesc, 10)
 		assert.NoError(t, err)
 		assert.Len(t, transactions, 3)
 		assert.NotEmpty(t, cursor)
@@ -176,13 +179,196 @@ func TestGetTransactionHistory(t *testing.T) {
 	})
 
 	t.Run("should return paginated transaction history", func(t *testing.T) {
-		transactions, cursor, err := walletService.GetTransactionHistory(testuser.ID, "", "", services.SortOrderDesc, 2)
+		transactions, cursor, err := walletService.GetTransactionHistory(ctx, testuser.ID, services.TransactionFilter{}, "", services.SortOrderDesc, 2)
 		assert.NoError(t, err)
 		assert.Len(t, transactions, 2)
 		assert.NotEmpty(t, cursor)
 
-		nextTransactions, _, err := walletService.GetTransactionHistory(testuser.ID, "", cursor, services.SortOrderDesc, 2)
+		nextTransactions, _, err := walletService.GetTransactionHistory(ctx, testuser.ID, services.TransactionFilter{}, cursor, services.SortOrderDesc, 2)
 		assert.NoError(t, err)
 		assert.Len(t, nextTransactions, 1)
 	})
+	t.Run("should refuse malformed cursors", func(t *testing.T) {
+		_, _, err := walletService.GetTransactionHistory(ctx, testuser.ID, services.TransactionFilter{}, "not-a-cursor", services.SortOrderDesc, 2)
+		assert.ErrorIs(t, err, services.ErrInvalidCursor)
+	})
+}
+
+func TestGetTransactionHistoryFilters(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+
+	testuser := userGenerator.Generate()
+	counterparty := userGenerator.Generate()
+	tx.CreateInBatches([]*models.User{testuser, counterparty}, 2)
+
+	walletService := services.NewWalletService(tx)
+
+	walletService.Deposit(ctx, testuser.ID, "USDT", decimal.NewFromInt(100), "")
+	walletService.Deposit(ctx, testuser.ID, "BTC", decimal.NewFromInt(5), "")
+	walletService.Transfer(ctx, testuser.ID, counterparty.ID, "USDT", decimal.NewFromInt(30), "Rent 100%", "", nil)
+	walletService.Withdraw(ctx, testuser.ID, "USDT", decimal.NewFromInt(20), "", nil)
+	walletService.Transfer(ctx, counterparty.ID, testuser.ID, "USDT", decimal.NewFromInt(10), "refund", "", nil)
+
+	history := func(t *testing.T, filter services.TransactionFilter) []models.TransactionType {
+		var types []models.TransactionType
+		for cursor := ""; ; {
+			// Page one by one to check the filters compose with the keyset cursor
+			transactions, nextCursor, err := walletService.GetTransactionHistory(ctx, testuser.ID, filter, cursor, services.SortOrderAsc, 1)
+			assert.NoError(t, err)
+			if len(transactions) == 0 {
+				return types
+			}
+			types = append(types, transactions[0].Type)
+			cursor = nextCursor
+		}
+	}
+
+	t.Run("should filter by multiple types", func(t *testing.T) {
+		types := history(t, services.TransactionFilter{Types: []models.TransactionType{models.Withdrawal, models.TransferIn}})
+		assert.Equal(t, []models.TransactionType{models.Withdrawal, models.TransferIn}, types)
+	})
+
+	t.Run("should filter by currency and amount range", func(t *testing.T) {
+		types := history(t, services.TransactionFilter{
+			Currency:  "USDT",
+			MinAmount: decimal.NewNullDecimal(decimal.NewFromInt(20)),
+			MaxAmount: decimal.NewNullDecimal(decimal.NewFromInt(30)),
+		})
+		assert.Equal(t, []models.TransactionType{models.TransferOut, models.Withdrawal}, types)
+	})
+
+	t.Run("should filter by counterparty", func(t *testing.T) {
+		types := history(t, services.TransactionFilter{CounterpartyID: counterparty.ID})
+		assert.Equal(t, []models.TransactionType{models.TransferOut, models.TransferIn}, types)
+	})
+
+	t.Run("should search memos literally", func(t *testing.T) {
+		assert.Equal(t, []models.TransactionType{models.TransferOut}, history(t, services.TransactionFilter{Memo: "rent 100%"}))
+		assert.Empty(t, history(t, services.TransactionFilter{Memo: "r_nt"}))
+	})
+
+	t.Run("should filter by time range", func(t *testing.T) {
+		assert.Len(t, history(t, services.TransactionFilter{From: time.Now().Add(-time.Hour)}), 5)
+		assert.Empty(t, history(t, services.TransactionFilter{To: time.Now().Add(-time.Hour)}))
+	})
+}
+
+func TestGetTransaction(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+
+	senderUser := userGenerator.Generate()
+	recipientUser := userGenerator.Generate()
+	tx.CreateInBatches([]*models.User{senderUser, recipientUser}, 2)
+
+	walletService := services.NewWalletService(tx)
+	holdService := services.NewHoldService(tx, config.HoldsConfig{TTL: time.Hour})
+
+	currency := "USDT"
+	walletService.Deposit(ctx, senderUser.ID, currency, decimal.NewFromInt(100), "")
+
+	t.Run("should only return transactions of the user", func(t *testing.T) {
+		transferOut, err := walletService.Transfer(ctx, senderUser.ID, recipientUser.ID, currency, decimal.NewFromInt(10), "", "", nil)
+		assert.NoError(t, err)
+
+		transaction, ok, err := walletService.GetTransaction(ctx, senderUser.ID, transferOut.ID)
+		assert.NoError(t, err)
+		if assert.True(t, ok) {
+			assert.Equal(t, transferOut.ID, transaction.ID)
+		}
+
+		_, ok, err = walletService.GetTransaction(ctx, recipientUser.ID, transferOut.ID)
+		assert.NoError(t, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("should link the legs of a transfer by reference", func(t *testing.T) {
+		transferOut, err := walletService.Transfer(ctx, senderUser.ID, recipientUser.ID, currency, decimal.NewFromInt(20), "rent", "", nil)
+		assert.NoError(t, err)
+		assert.NotEmpty(t, transferOut.Reference)
+
+		transferIn, ok, err := walletService.GetTransferCounterpart(ctx, transferOut)
+		assert.NoError(t, err)
+		if assert.True(t, ok) {
+			assert.Equal(t, models.TransferIn, transferIn.Type)
+			assert.Equal(t, recipientUser.ID, transferIn.UserID)
+			assert.Equal(t, transferOut.Reference, transferIn.Reference)
+		}
+
+		found, ok, err := walletService.GetTransferCounterpart(ctx, transferIn)
+		assert.NoError(t, err)
+		if assert.True(t, ok) {
+			assert.Equal(t, transferOut.ID, found.ID)
+		}
+	})
+
+	t.Run("should link the legs of legacy transfers", func(t *testing.T) {
+		transferOut, err := walletService.Transfer(ctx, senderUser.ID, recipientUser.ID, currency, decimal.NewFromInt(5), "", "", nil)
+		assert.NoError(t, err)
+		tx.Model(&models.Transaction{}).Where("reference = ?", transferOut.Reference).Update("reference", "")
+		transferOut.Reference = ""
+
+		transferIn, ok, err := walletService.GetTransferCounterpart(ctx, transferOut)
+		assert.NoError(t, err)
+		if assert.True(t, ok) {
+			assert.Equal(t, models.TransferIn, transferIn.Type)
+			assert.Equal(t, recipientUser.ID, transferIn.UserID)
+		}
+	})
+
+	t.Run("should link the legs of a captured hold", func(t *testing.T) {
+		hold, err := holdService.PlaceHold(senderUser.ID, recipientUser.ID, currency, decimal.NewFromInt(15), "", "", nil)
+		assert.NoError(t, err)
+		_, err = holdService.CaptureHold(senderUser.ID, hold.ID)
+		assert.NoError(t, err)
+
+		var capture models.Transaction
+		tx.Where("user_id = ? AND type = ?", senderUser.ID, models.HoldCaptured).Take(&capture)
+		assert.NotEmpty(t, capture.Reference)
+
+		transferIn, ok, err := walletService.GetTransferCounterpart(ctx, &capture)
+		assert.NoError(t, err)
+		if assert.True(t, ok) {
+			assert.Equal(t, models.TransferIn, transferIn.Type)
+			assert.Equal(t, capture.Reference, transferIn.Reference)
+		}
+	})
+
+	t.Run("should find no counterpart of deposits", func(t *testing.T) {
+		deposit, err := walletService.Deposit(ctx, senderUser.ID, currency, decimal.NewFromInt(1), "")
+		assert.NoError(t, err)
+
+		_, ok, err := walletService.GetTransferCounterpart(ctx, deposit)
+		assert.NoError(t, err)
+		assert.False(t, ok)
+	})
+}
+
+func TestWalletTimeouts(t *testing.T) {
+	testuser := userGenerator.Generate()
+	walletService := services.NewWalletService(db)
+
+	t.Run("should abort once the operation times out", func(t *testing.T) {
+		walletService.Timeouts = config.TimeoutsConfig{
+			Default:    time.Minute,
+			Operations: map[string]time.Duration{services.OpDeposit: time.Nanosecond},
+		}
+
+		_, err := walletService.Deposit(ctx, testuser.ID, "USDT", decimal.NewFromInt(100), "")
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+		_, err = walletService.GetBalances(ctx, testuser.ID, []string{"USDT"})
+		assert.NoError(t, err)
+	})
+
+	t.Run("should abort once the context is canceled", func(t *testing.T) {
+		walletService.Timeouts = config.TimeoutsConfig{}
+
+		canceled, cancel := context.WithCancel(ctx)
+		cancel()
+
+		_, err := walletService.Transfer(canceled, testuser.ID, testuser.ID+1, "USDT", decimal.NewFromInt(1), "", "", nil)
+		assert.ErrorIs(t, err, context.Canceled)
+	})
 }
@@ -0,0 +1,499 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/wanliqun/go-wallet-app/apperrors"
+	"github.com/wanliqun/go-wallet-app/config"
+	"github.com/wanliqun/go-wallet-app/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	dispatchBatchSize = 100             // Maximum number of events fanned out, or deliveries attempted, at once
+	resolveTimeout    = 5 * time.Second // Timeout of resolving the host of an endpoint on registration
+)
+
+// Headers of the webhook deliveries
+const (
+	WebhookIDHeader        = "X-Webhook-ID"        // ID of the event, identical across the retries
+	WebhookEventHeader     = "X-Webhook-Event"     // Type of the event
+	WebhookTimestampHeader = "X-Webhook-Timestamp" // Unix time of the attempt in seconds
+	WebhookSignatureHeader = "X-Webhook-Signature" // HMAC-SHA256 of the timestamp and the body
+)
+
+var (
+	ErrWebhookEndpointNotFound = apperrors.New(apperrors.WebhookEndpointNotFound, "webhook endpoint not found")
+	ErrInvalidWebhookURL       = apperrors.New(apperrors.InvalidWebhookURL, "invalid webhook URL")
+
+	// nonPublicNetworks are the address ranges webhooks are never delivered to, besides the
+	// loopback, private, link-local, multicast and unspecified addresses
+	nonPublicNetworks = []*net.IPNet{
+		mustParseCIDR("100.64.0.0/10"), // Carrier-grade NAT
+		mustParseCIDR("198.18.0.0/15"), // Benchmarking
+	}
+
+	_ IWebhookService = &WebhookService{}
+)
+
+type IWebhookService interface {
+	CreateEndpoint(userID uint, url string, events []string) (*models.WebhookEndpoint, error)
+	ListEndpoints(userID uint) ([]models.WebhookEndpoint, error)
+	DeleteEndpoint(userID, endpointID uint) error
+	ListDeliveries(userID, endpointID uint, limit int) ([]models.WebhookDelivery, error)
+	Dispatch() (int, error)
+}
+
+// WebhookService represents the service delivering the outbox events to the webhook endpoints
+// registered by the users, retrying failed deliveries with exponential backoff.
+type WebhookService struct {
+	DB     *gorm.DB
+	Client *http.Client
+	Config config.WebhooksConfig
+}
+
+func NewWebhookService(db *gorm.DB, conf config.WebhooksConfig) *WebhookService {
+	return &WebhookService{
+		DB:     db,
+		Client: newWebhookClient(conf),
+		Config: conf,
+	}
+}
+
+// newWebhookClient returns the client of the deliveries, which never follows redirects and, unless
+// insecure endpoints are allowed, refuses to connect to non-public addresses. The check is made
+// when dialing, so that a hostname resolving to a public address on registration can not be
+// rebound to an internal one later.
+func newWebhookClient(conf config.WebhooksConfig) *http.Client {
+	dialer := &net.Dialer{Timeout: conf.Timeout}
+	if !conf.AllowInsecure {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+				return fmt.Errorf("%w: address %s is not public", ErrInvalidWebhookURL, host)
+			}
+			return nil
+		}
+	}
+
+	return &http.Client{
+		Timeout: conf.Timeout,
+		// Connect directly, as a proxy would be the address checked when dialing
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: conf.Timeout,
+			MaxIdleConns:        100,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// SignWebhookPayload returns the signature of a delivery, which receivers recompute with the secret
+// of the endpoint to verify that the delivery is authentic.
+func SignWebhookPayload(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// CreateEndpoint registers the URL to be notified of the events of the types, or of all types
+// if none is given. The secret of the endpoint is generated randomly.
+func (s *WebhookService) CreateEndpoint(userID uint, url string, events []string) (*models.WebhookEndpoint, error) {
+	if err := s.validateURL(url); err != nil {
+		return nil, err
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+
+	endpoint := models.WebhookEndpoint{
+		UserID: userID,
+		URL:    url,
+		Secret: hex.EncodeToString(secret),
+		Events: strings.Join(events, ","),
+	}
+	if err := s.DB.Create(&endpoint).Error; err != nil {
+		return nil, err
+	}
+	return &endpoint, nil
+}
+
+// ListEndpoints returns the webhook endpoints registered by the user
+func (s *WebhookService) ListEndpoints(userID uint) ([]models.WebhookEndpoint, error) {
+	var endpoints []models.WebhookEndpoint
+	err := s.DB.Where("user_id = ?", userID).Order("id").Find(&endpoints).Error
+	return endpoints, err
+}
+
+// DeleteEndpoint unregisters the webhook endpoint, its pending deliveries are given up
+func (s *WebhookService) DeleteEndpoint(userID, endpointID uint) error {
+	result := s.DB.Where("id = ? AND user_id = ?", endpointID, userID).Delete(&models.WebhookEndpoint{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrWebhookEndpointNotFound
+	}
+	return nil
+}
+
+// ListDeliveries returns the latest deliveries to the webhook endpoint of the user
+func (s *WebhookService) ListDeliveries(userID, endpointID uint, limit int) ([]models.WebhookDelivery, error) {
+	var endpoint models.WebhookEndpoint
+	err := s.DB.Where("id = ? AND user_id = ?", endpointID, userID).First(&endpoint).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrWebhookEndpointNotFound
+		}
+		return nil, err
+	}
+
+	if limit == 0 {
+		limit = 20 // Default limit
+	}
+
+	var deliveries []models.WebhookDelivery
+	err = s.DB.Where("endpoint_id = ?", endpointID).Order("id DESC").Limit(limit).Find(&deliveries).Error
+	return deliveries, err
+}
+
+// Dispatch fans the new outbox events out to the subscribed endpoints, and attempts the due
+// deliveries. It returns the number of delivery attempts made.
+func (s *WebhookService) Dispatch() (int, error) {
+	if err := s.fanOut(); err != nil {
+		return 0, err
+	}
+
+	var attempted int
+	for {
+		deliveries, err := s.claimDeliveries()
+		if err != nil {
+			return attempted, err
+		}
+
+		if err := s.deliverAll(deliveries); err != nil {
+			return attempted, err
+		}
+
+		attempted += len(deliveries)
+		if len(deliveries) < dispatchBatchSize {
+			return attempted, nil
+		}
+	}
+}
+
+// Schedule dispatches events periodically until the context is canceled
+func (s *WebhookService) Schedule(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.Dispatch(); err != nil {
+				log.Printf("failed to dispatch webhook events: %v", err)
+			}
+		}
+	}
+}
+
+// fanOut creates a pending delivery of every undispatched event to each endpoint of the user
+// subscribed to the event type, and marks the events dispatched.
+func (s *WebhookService) fanOut() error {
+	for {
+		var events []models.OutboxEvent
+		err := s.DB.Transaction(func(tx *gorm.DB) error {
+			// Skip the events being fanned out concurrently
+			err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+				Where("dispatched_at IS NULL").
+				Order("id").
+				Limit(dispatchBatchSize).
+				Find(&events).Error
+			if err != nil || len(events) == 0 {
+				return err
+			}
+
+			eventIDs := make([]uint, 0, len(events))
+			userIDs := make([]uint, 0, len(events))
+			for _, event := range events {
+				eventIDs = append(eventIDs, event.ID)
+				userIDs = append(userIDs, event.UserID)
+			}
+
+			var endpoints []models.WebhookEndpoint
+			if err := tx.Where("user_id IN ?", userIDs).Find(&endpoints).Error; err != nil {
+				return err
+			}
+
+			now := time.Now()
+			var deliveries []models.WebhookDelivery
+			for _, event := range events {
+				for i := range endpoints {
+					if endpoints[i].UserID != event.UserID || !endpoints[i].Subscribes(event.Type) {
+						continue
+					}
+					deliveries = append(deliveries, models.WebhookDelivery{
+						EndpointID:    endpoints[i].ID,
+						EventID:       event.ID,
+						EventType:     event.Type,
+						Status:        models.WebhookDeliveryPending,
+						NextAttemptAt: now,
+					})
+				}
+			}
+
+			if len(deliveries) > 0 {
+				err := tx.Clauses(clause.OnConflict{DoNothing: true}).Omit("Event").Create(&deliveries).Error
+				if err != nil {
+					return err
+				}
+			}
+
+			return tx.Model(&models.OutboxEvent{}).
+				Where("id IN ?", eventIDs).
+				Update("dispatched_at", now).Error
+		})
+		if err != nil {
+			return err
+		}
+
+		if len(events) < dispatchBatchSize {
+			return nil
+		}
+	}
+}
+
+// claimDeliveries leases a batch of due pending deliveries, so that they are not attempted
+// concurrently by another dispatcher until the attempt times out.
+func (s *WebhookService) claimDeliveries() ([]models.WebhookDelivery, error) {
+	var deliveryIDs []uint
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		err := tx.Model(&models.WebhookDelivery{}).
+			Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND next_attempt_at <= ?", models.WebhookDeliveryPending, now).
+			Order("next_attempt_at").
+			Limit(dispatchBatchSize).
+			Pluck("id", &deliveryIDs).Error
+		if err != nil || len(deliveryIDs) == 0 {
+			return err
+		}
+
+		return tx.Model(&models.WebhookDelivery{}).
+			Where("id IN ?", deliveryIDs).
+			Update("next_attempt_at", now.Add(2*s.Config.Timeout)).Error
+	})
+	if err != nil || len(deliveryIDs) == 0 {
+		return nil, err
+	}
+
+	var deliveries []models.WebhookDelivery
+	err = s.DB.Preload("Event").Where("id IN ?", deliveryIDs).Order("id").Find(&deliveries).Error
+	return deliveries, err
+}
+
+// deliverAll attempts the claimed deliveries and records the outcomes
+func (s *WebhookService) deliverAll(deliveries []models.WebhookDelivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+
+	endpointIDs := make([]uint, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		endpointIDs = append(endpointIDs, delivery.EndpointID)
+	}
+
+	var endpoints []models.WebhookEndpoint
+	if err := s.DB.Where("id IN ?", endpointIDs).Find(&endpoints).Error; err != nil {
+		return err
+	}
+	endpointsByID := make(map[uint]*models.WebhookEndpoint, len(endpoints))
+	for i := range endpoints {
+		endpointsByID[endpoints[i].ID] = &endpoints[i]
+	}
+
+	for i := range deliveries {
+		delivery := &deliveries[i]
+
+		endpoint, ok := endpointsByID[delivery.EndpointID]
+		if !ok {
+			// The endpoint was deleted since the delivery was created
+			delivery.Status = models.WebhookDeliveryFailed
+			delivery.LastError = "webhook endpoint deleted"
+		} else {
+			s.attempt(endpoint, delivery)
+		}
+
+		err := s.DB.Model(&models.WebhookDelivery{}).Where("id = ?", delivery.ID).Updates(map[string]interface{}{
+			"status":          delivery.Status,
+			"attempts":        delivery.Attempts,
+			"next_attempt_at": delivery.NextAttemptAt,
+			"response_status": delivery.ResponseStatus,
+			"last_error":      delivery.LastError,
+			"delivered_at":    delivery.DeliveredAt,
+		}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// attempt posts the event to the endpoint, and schedules a retry unless it is acknowledged by a
+// 2xx response or the attempts are exhausted.
+func (s *WebhookService) attempt(endpoint *models.WebhookEndpoint, delivery *models.WebhookDelivery) {
+	delivery.Attempts++
+
+	status, err := s.post(endpoint, &delivery.Event)
+	delivery.ResponseStatus = status
+	if err == nil {
+		now := time.Now()
+		delivery.Status = models.WebhookDeliverySucceeded
+		delivery.DeliveredAt = &now
+		delivery.LastError = ""
+		return
+	}
+
+	delivery.LastError = err.Error()
+	if len(delivery.LastError) > 512 {
+		delivery.LastError = delivery.LastError[:512]
+	}
+
+	if delivery.Attempts >= s.Config.MaxAttempts {
+		delivery.Status = models.WebhookDeliveryFailed
+		return
+	}
+	delivery.NextAttemptAt = time.Now().Add(s.retryBackoff(delivery.Attempts))
+}
+
+// post sends the signed event to the endpoint, and returns the HTTP status of the response
+func (s *WebhookService) post(endpoint *models.WebhookEndpoint, event *models.OutboxEvent) (int, error) {
+	body, err := json.Marshal(newEventEnvelope(event))
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, endpoint.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookIDHeader, strconv.FormatUint(uint64(event.ID), 10))
+	req.Header.Set(WebhookEventHeader, event.Type)
+	req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(WebhookSignatureHeader, SignWebhookPayload(endpoint.Secret, timestamp, body))
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected response status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// validateURL requires the URL of an endpoint to be https, and its host to resolve to public
+// addresses only, unless insecure endpoints are allowed
+func (s *WebhookService) validateURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Hostname() == "" {
+		return ErrInvalidWebhookURL
+	}
+
+	switch {
+	case u.Scheme == "https":
+	case u.Scheme == "http" && s.Config.AllowInsecure:
+	default:
+		return fmt.Errorf("%w: scheme must be https", ErrInvalidWebhookURL)
+	}
+
+	if s.Config.AllowInsecure {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip", u.Hostname())
+	if err != nil {
+		return fmt.Errorf("%w: host %s can not be resolved", ErrInvalidWebhookURL, u.Hostname())
+	}
+	for _, ip := range ips {
+		if !isPublicIP(ip) {
+			return fmt.Errorf("%w: host %s resolves to non-public address %s", ErrInvalidWebhookURL, u.Hostname(), ip)
+		}
+	}
+	return nil
+}
+
+// isPublicIP reports whether the address is routable on the internet, rather than reaching the
+// host itself or its internal networks
+func isPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, network := range nonPublicNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+func mustParseCIDR(cidr string) *net.IPNet {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	return network
+}
+
+// retryBackoff returns the delay before the retry following the attempts, doubled for every
+// attempt and capped by the maximum backoff
+func (s *WebhookService) retryBackoff(attempts int) time.Duration {
+	backoff := s.Config.RetryBackoff
+	for i := 1; i < attempts; i++ {
+		backoff *= 2
+		if s.Config.MaxBackoff > 0 && backoff >= s.Config.MaxBackoff {
+			return s.Config.MaxBackoff
+		}
+	}
+	return backoff
+}
@@ -0,0 +1,258 @@
+package services_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/wanliqun/go-wallet-app/config"
+	"github.com/wanliqun/go-wallet-app/models"
+	"github.com/wanliqun/go-wallet-app/services"
+)
+
+func TestSignWebhookPayload(t *testing.T) {
+	signature := services.SignWebhookPayload("secret", 1700000000, []byte(`{"id":1}`))
+	assert.Equal(t, "sha256=", signature[:7])
+	assert.Len(t, signature, 7+64)
+
+	assert.Equal(t, signature, services.SignWebhookPayload("secret", 1700000000, []byte(`{"id":1}`)))
+	assert.NotEqual(t, signature, services.SignWebhookPayload("other", 1700000000, []byte(`{"id":1}`)))
+	assert.NotEqual(t, signature, services.SignWebhookPayload("secret", 1700000001, []byte(`{"id":1}`)))
+}
+
+func TestWebhooks(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+
+	senderUser := userGenerator.Generate()
+	recipientUser := userGenerator.Generate()
+	tx.CreateInBatches([]*models.User{senderUser, recipientUser}, 2)
+
+	// The receiver fails the first request, and acknowledges the later ones
+	var requests atomic.Int32
+	received := make(chan *http.Request, 10)
+	bodies := make(chan []byte, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- r
+		bodies <- body
+
+		if requests.Add(1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	walletService := services.NewWalletService(tx)
+	// The receivers listen on the loopback interface over plain http
+	webhookService := services.NewWebhookService(tx, config.WebhooksConfig{
+		Timeout:       time.Second,
+		MaxAttempts:   2,
+		RetryBackoff:  time.Minute,
+		MaxBackoff:    time.Hour,
+		AllowInsecure: true,
+	})
+
+	endpoint, err := webhookService.CreateEndpoint(senderUser.ID, server.URL, []string{models.EventTransactionCreated})
+	assert.NoError(t, err)
+	assert.Len(t, endpoint.Secret, 64)
+
+	currency := "USDT"
+
+	t.Run("should not deliver events of other users", func(t *testing.T) {
+		_, err := walletService.Deposit(ctx, recipientUser.ID, currency, decimal.NewFromInt(100), "")
+		assert.NoError(t, err)
+
+		attempted, err := webhookService.Dispatch()
+		assert.NoError(t, err)
+		assert.Zero(t, attempted)
+	})
+
+	t.Run("should retry failed delivery with backoff", func(t *testing.T) {
+		deposit, err := walletService.Deposit(ctx, senderUser.ID, currency, decimal.NewFromInt(100), "")
+		assert.NoError(t, err)
+
+		attempted, err := webhookService.Dispatch()
+		assert.NoError(t, err)
+		assert.Equal(t, 1, attempted)
+
+		req, body := <-received, <-bodies
+		timestamp, _ := strconv.ParseInt(req.Header.Get(services.WebhookTimestampHeader), 10, 64)
+		assert.Equal(t, services.SignWebhookPayload(endpoint.Secret, timestamp, body),
+			req.Header.Get(services.WebhookSignatureHeader))
+		assert.Equal(t, models.EventTransactionCreated, req.Header.Get(services.WebhookEventHeader))
+
+		var envelope services.EventEnvelope
+		assert.NoError(t, json.Unmarshal(body, &envelope))
+		assert.Equal(t, models.EventTransactionCreated, envelope.Type)
+
+		var txn models.Transaction
+		assert.NoError(t, json.Unmarshal(envelope.Data, &txn))
+		assert.Equal(t, deposit.ID, txn.ID)
+
+		deliveries, err := webhookService.ListDeliveries(senderUser.ID, endpoint.ID, 0)
+		assert.NoError(t, err)
+		assert.Len(t, deliveries, 1)
+		assert.Equal(t, models.WebhookDeliveryPending, deliveries[0].Status)
+		assert.Equal(t, 1, deliveries[0].Attempts)
+		assert.Equal(t, http.StatusInternalServerError, deliveries[0].ResponseStatus)
+		assert.WithinDuration(t, time.Now().Add(time.Minute), deliveries[0].NextAttemptAt, 10*time.Second)
+
+		// The retry isn't due yet
+		attempted, err = webhookService.Dispatch()
+		assert.NoError(t, err)
+		assert.Zero(t, attempted)
+
+		tx.Model(&models.WebhookDelivery{}).Where("id = ?", deliveries[0].ID).Update("next_attempt_at", time.Now())
+
+		attempted, err = webhookService.Dispatch()
+		assert.NoError(t, err)
+		assert.Equal(t, 1, attempted)
+
+		retried := <-received
+		<-bodies
+		assert.Equal(t, req.Header.Get(services.WebhookIDHeader), retried.Header.Get(services.WebhookIDHeader))
+
+		deliveries, err = webhookService.ListDeliveries(senderUser.ID, endpoint.ID, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, models.WebhookDeliverySucceeded, deliveries[0].Status)
+		assert.Equal(t, 2, deliveries[0].Attempts)
+		assert.NotNil(t, deliveries[0].DeliveredAt)
+	})
+
+	t.Run("should give up delivery once attempts are exhausted", func(t *testing.T) {
+		failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+		}))
+		defer failing.Close()
+
+		failingEndpoint, err := webhookService.CreateEndpoint(senderUser.ID, failing.URL, []string{models.EventBalanceChanged})
+		assert.NoError(t, err)
+
+		_, err = walletService.Withdraw(ctx, senderUser.ID, currency, decimal.NewFromInt(10), "", nil)
+		assert.NoError(t, err)
+
+		for i := 0; i < 2; i++ {
+			tx.Model(&models.WebhookDelivery{}).
+				Where("endpoint_id = ?", failingEndpoint.ID).
+				Update("next_attempt_at", time.Now())
+			_, err := webhookService.Dispatch()
+			assert.NoError(t, err)
+		}
+		<-received
+		<-bodies
+
+		deliveries, err := webhookService.ListDeliveries(senderUser.ID, failingEndpoint.ID, 0)
+		assert.NoError(t, err)
+		assert.Len(t, deliveries, 1)
+		assert.Equal(t, models.EventBalanceChanged, deliveries[0].EventType)
+		assert.Equal(t, models.WebhookDeliveryFailed, deliveries[0].Status)
+		assert.Equal(t, 2, deliveries[0].Attempts)
+		assert.Equal(t, http.StatusBadGateway, deliveries[0].ResponseStatus)
+	})
+
+	t.Run("should not follow redirects", func(t *testing.T) {
+		var redirected atomic.Int32
+		target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			redirected.Add(1)
+		}))
+		defer target.Close()
+		redirecting := httptest.NewServer(http.RedirectHandler(target.URL, http.StatusFound))
+		defer redirecting.Close()
+
+		redirectingEndpoint, err := webhookService.CreateEndpoint(senderUser.ID, redirecting.URL, []string{models.EventBalanceChanged})
+		assert.NoError(t, err)
+
+		_, err = walletService.Withdraw(ctx, senderUser.ID, currency, decimal.NewFromInt(10), "", nil)
+		assert.NoError(t, err)
+
+		_, err = webhookService.Dispatch()
+		assert.NoError(t, err)
+		<-received
+		<-bodies
+
+		deliveries, err := webhookService.ListDeliveries(senderUser.ID, redirectingEndpoint.ID, 0)
+		assert.NoError(t, err)
+		assert.Len(t, deliveries, 1)
+		assert.Equal(t, models.WebhookDeliveryPending, deliveries[0].Status)
+		assert.Equal(t, http.StatusFound, deliveries[0].ResponseStatus)
+		assert.Zero(t, redirected.Load())
+	})
+
+	t.Run("should delete endpoint", func(t *testing.T) {
+		err := webhookService.DeleteEndpoint(recipientUser.ID, endpoint.ID)
+		assert.ErrorIs(t, err, services.ErrWebhookEndpointNotFound)
+
+		assert.NoError(t, webhookService.DeleteEndpoint(senderUser.ID, endpoint.ID))
+
+		_, err = webhookService.ListDeliveries(senderUser.ID, endpoint.ID, 0)
+		assert.ErrorIs(t, err, services.ErrWebhookEndpointNotFound)
+	})
+}
+
+func TestWebhookEndpointRestrictions(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+
+	user := userGenerator.Generate()
+	tx.Create(user)
+
+	webhookService := services.NewWebhookService(tx, config.WebhooksConfig{
+		Timeout:      time.Second,
+		MaxAttempts:  2,
+		RetryBackoff: time.Minute,
+	})
+
+	t.Run("should refuse insecure or internal endpoints", func(t *testing.T) {
+		urls := []string{
+			"http://example.com/hooks",
+			"https://127.0.0.1/hooks",
+			"https://[::1]/hooks",
+			"https://10.0.0.1/hooks",
+			"https://192.168.1.1/hooks",
+			"https://169.254.169.254/latest/meta-data",
+			"https://0.0.0.0/hooks",
+			"https://localhost/hooks",
+		}
+		for _, url := range urls {
+			_, err := webhookService.CreateEndpoint(user.ID, url, nil)
+			assert.ErrorIs(t, err, services.ErrInvalidWebhookURL, url)
+		}
+	})
+
+	t.Run("should refuse to connect to internal addresses", func(t *testing.T) {
+		var requests atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests.Add(1)
+		}))
+		defer server.Close()
+
+		// e.g. the host of the endpoint was rebound to an internal address since its registration
+		endpoint := models.WebhookEndpoint{UserID: user.ID, URL: server.URL, Secret: "secret"}
+		assert.NoError(t, tx.Create(&endpoint).Error)
+
+		_, err := services.NewWalletService(tx).Deposit(ctx, user.ID, "USDT", decimal.NewFromInt(100), "")
+		assert.NoError(t, err)
+
+		_, err = webhookService.Dispatch()
+		assert.NoError(t, err)
+
+		deliveries, err := webhookService.ListDeliveries(user.ID, endpoint.ID, 0)
+		assert.NoError(t, err)
+		assert.NotEmpty(t, deliveries)
+		for _, delivery := range deliveries {
+			assert.Zero(t, delivery.ResponseStatus)
+			assert.Contains(t, delivery.LastError, "is not public")
+		}
+		assert.Zero(t, requests.Load())
+	})
+}
@@ -0,0 +1,29 @@
+package utils
+
+import (
+	"errors"
+
+	"github.com/shopspring/decimal"
+)
+
+var ErrTooManyDecimals = errors.New("amount has more decimal places than the currency precision")
+
+// ToMinorUnits converts an amount in human units (e.g. 1.5 USDT) to integer minor units
+// (e.g. 1500000 with precision 6). It fails if the amount is more precise than the currency.
+func ToMinorUnits(amount decimal.Decimal, precision int32) (decimal.Decimal, error) {
+	minor := amount.Shift(precision)
+	if !minor.IsInteger() {
+		return decimal.Zero, ErrTooManyDecimals
+	}
+	return minor.Truncate(0), nil
+}
+
+// FromMinorUnits converts integer minor units back to an amount in human units
+func FromMinorUnits(minor decimal.Decimal, precision int32) decimal.Decimal {
+	return minor.Shift(-precision)
+}
+
+// FormatMinorUnits renders integer minor units as a decimal string with exactly precision decimal places
+func FormatMinorUnits(minor decimal.Decimal, precision int32) string {
+	return FromMinorUnits(minor, precision).StringFixed(precision)
+}
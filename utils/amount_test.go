@@ -0,0 +1,47 @@
+package utils_test
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/wanliqun/go-wallet-app/utils"
+)
+
+func TestToMinorUnits(t *testing.T) {
+	tests := []struct {
+		name          string
+		amount        string
+		precision     int32
+		expectedMinor string
+		expectedError error
+	}{
+		{name: "Whole Amount", amount: "2", precision: 6, expectedMinor: "2000000"},
+		{name: "Fractional Amount", amount: "1.5", precision: 6, expectedMinor: "1500000"},
+		{name: "Exact Precision", amount: "0.00000001", precision: 8, expectedMinor: "1"},
+		{name: "Trailing Zeros", amount: "1.500000000", precision: 6, expectedMinor: "1500000"},
+		{name: "Zero Precision", amount: "42", precision: 0, expectedMinor: "42"},
+		{name: "Too Many Decimals", amount: "1.0000001", precision: 6, expectedError: utils.ErrTooManyDecimals},
+		{name: "Fraction With Zero Precision", amount: "1.5", precision: 0, expectedError: utils.ErrTooManyDecimals},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			minor, err := utils.ToMinorUnits(decimal.RequireFromString(test.amount), test.precision)
+			if test.expectedError != nil {
+				assert.Equal(t, test.expectedError, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, test.expectedMinor, minor.String())
+		})
+	}
+}
+
+func TestFormatMinorUnits(t *testing.T) {
+	assert.Equal(t, "1.500000", utils.FormatMinorUnits(decimal.NewFromInt(1500000), 6))
+	assert.Equal(t, "0.00000001", utils.FormatMinorUnits(decimal.NewFromInt(1), 8))
+	assert.Equal(t, "42", utils.FormatMinorUnits(decimal.NewFromInt(42), 0))
+	assert.True(t, decimal.RequireFromString("1.5").Equal(utils.FromMinorUnits(decimal.NewFromInt(1500000), 6)))
+}
@@ -1,13 +1,13 @@
 package utils
 
 import (
-	"errors"
 	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/wanliqun/go-wallet-app/apperrors"
 )
 
-var ErrInvalidAuthorizationHeader = errors.New("invalid authorization header")
+var ErrInvalidAuthorizationHeader = apperrors.New(apperrors.Unauthorized, "invalid authorization header")
 
 func ExtractBearerToken(c *gin.Context) (string, error) {
 	token := strings.TrimSpace(c.GetHeader("Authorization")) // Trim any extra spaces
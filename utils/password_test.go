@@ -0,0 +1,18 @@
+package utils_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wanliqun/go-wallet-app/utils"
+)
+
+func TestHashAndCheckPassword(t *testing.T) {
+	hash, err := utils.HashPassword("s3cret")
+	assert.NoError(t, err, "hashing a password should not return an error")
+	assert.NotEqual(t, "s3cret", hash, "hash should not equal the plain text password")
+
+	assert.True(t, utils.CheckPassword(hash, "s3cret"), "matching password should be accepted")
+	assert.False(t, utils.CheckPassword(hash, "wrong"), "mismatching password should be rejected")
+	assert.False(t, utils.CheckPassword("", "s3cret"), "empty hash should never match")
+}
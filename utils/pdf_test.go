@@ -0,0 +1,62 @@
+package utils_test
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wanliqun/go-wallet-app/utils"
+)
+
+func TestWriteTextPDF(t *testing.T) {
+	t.Run("should escape the text", func(t *testing.T) {
+		var buf bytes.Buffer
+		err := utils.WriteTextPDF(&buf, "Statement", []string{`Memo (rent) \ café`})
+		assert.NoError(t, err)
+
+		doc := buf.String()
+		assert.True(t, strings.HasPrefix(doc, "%PDF-1.4\n"))
+		assert.True(t, strings.HasSuffix(doc, "%%EOF\n"))
+		assert.Contains(t, doc, `(Memo \(rent\) \\ caf?) '`)
+		assert.Contains(t, doc, "/Count 1")
+	})
+
+	t.Run("should break lines into pages", func(t *testing.T) {
+		lines := make([]string, 200)
+		for i := range lines {
+			lines[i] = fmt.Sprintf("line %d", i)
+		}
+
+		var buf bytes.Buffer
+		assert.NoError(t, utils.WriteTextPDF(&buf, "Statement", lines))
+
+		doc := buf.String()
+		assert.Contains(t, doc, "/Count 3")
+		assert.Contains(t, doc, "(Statement - page 3 of 3) Tj")
+		assert.Contains(t, doc, "(line 199) '")
+	})
+
+	t.Run("should point the cross-reference table at the objects", func(t *testing.T) {
+		var buf bytes.Buffer
+		assert.NoError(t, utils.WriteTextPDF(&buf, "Statement", []string{"line"}))
+		doc := buf.Bytes()
+
+		match := regexp.MustCompile(`startxref\n(\d+)\n`).FindSubmatch(doc)
+		if !assert.NotNil(t, match) {
+			return
+		}
+		xref, _ := strconv.Atoi(string(match[1]))
+		assert.True(t, bytes.HasPrefix(doc[xref:], []byte("xref\n")))
+
+		offsets := regexp.MustCompile(`(\d{10}) 00000 n`).FindAllSubmatch(doc, -1)
+		assert.Len(t, offsets, 6)
+		for i, offset := range offsets {
+			at, _ := strconv.Atoi(string(offset[1]))
+			assert.True(t, bytes.HasPrefix(doc[at:], []byte(fmt.Sprintf("%d 0 obj", i+1))))
+		}
+	})
+}
@@ -1,6 +1,23 @@
 package utils
 
-import "github.com/gin-gonic/gin"
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/wanliqun/go-wallet-app/apperrors"
+)
+
+// RequestIDHeader is the header carrying the ID of a request, echoed in responses
+const RequestIDHeader = "X-Request-ID"
+
+// requestIDKey is the context key of the request ID
+const requestIDKey = "request_id"
 
 func SuccessResponse(c *gin.Context, data interface{}) {
 	c.JSON(200, gin.H{
@@ -10,9 +27,67 @@ func SuccessResponse(c *gin.Context, data interface{}) {
 	})
 }
 
+// ErrorResponse writes the error and aborts the remaining handlers in the chain. The response
+// carries the stable code of the error, validation failures are detailed per field as well as
+// errors implementing apperrors.Detailed, and server errors are logged under the request ID while
+// a generic message is responded.
 func ErrorResponse(c *gin.Context, statusCode int, err error) {
-	c.JSON(statusCode, gin.H{
-		"code":    -1,
-		"message": err.Error(),
-	})
+	code := apperrors.CodeOf(err)
+	message := err.Error()
+	requestID := RequestID(c)
+
+	body := gin.H{"request_id": requestID}
+
+	var validationErrs validator.ValidationErrors
+	var detailed apperrors.Detailed
+	switch {
+	case statusCode >= http.StatusInternalServerError:
+		log.Printf("request %s failed: %v", requestID, err)
+		code, message = apperrors.Internal, "internal server error"
+	case errors.As(err, &validationErrs):
+		details := apperrors.FieldErrors(validationErrs)
+		messages := make([]string, 0, len(details))
+		for _, detail := range details {
+			messages = append(messages, detail.Message)
+		}
+
+		code, message = apperrors.ValidationFailed, strings.Join(messages, "; ")
+		body["details"] = details
+	case errors.As(err, &detailed):
+		body["details"] = detailed.ErrorDetails()
+	case code == apperrors.Internal:
+		// Errors without a code, e.g. malformed request bodies
+		code = apperrors.ForStatus(statusCode)
+	}
+
+	body["code"] = code.Number()
+	body["error"] = code
+	body["message"] = message
+	c.AbortWithStatusJSON(statusCode, body)
+}
+
+// RequestID returns the ID of the request, generating one if none was assigned yet
+func RequestID(c *gin.Context) string {
+	if requestID := c.GetString(requestIDKey); requestID != "" {
+		return requestID
+	}
+
+	requestID := NewRequestID()
+	SetRequestID(c, requestID)
+	return requestID
+}
+
+// SetRequestID assigns the ID of the request and echoes it in the response header
+func SetRequestID(c *gin.Context, requestID string) {
+	c.Set(requestIDKey, requestID)
+	c.Header(RequestIDHeader, requestID)
+}
+
+// NewRequestID generates a random request ID
+func NewRequestID() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		log.Printf("failed to generate request ID: %v", err)
+	}
+	return hex.EncodeToString(buf)
 }
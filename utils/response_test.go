@@ -0,0 +1,80 @@
+package utils_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/wanliqun/go-wallet-app/apperrors"
+	"github.com/wanliqun/go-wallet-app/utils"
+)
+
+// challengeError is an error carrying details for the client
+type challengeError struct{}
+
+func (challengeError) Error() string { return "two-factor code required" }
+
+func (challengeError) Unwrap() error {
+	return apperrors.New(apperrors.TwoFactorRequired, "two-factor code required")
+}
+
+func (challengeError) ErrorDetails() interface{} {
+	return map[string]string{"type": "totp"}
+}
+
+func TestErrorResponse(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	respond := func(statusCode int, err error, requestID string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		if requestID != "" {
+			utils.SetRequestID(c, requestID)
+		}
+		utils.ErrorResponse(c, statusCode, err)
+
+		var resp map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w, resp
+	}
+
+	t.Run("should respond with the code of the error", func(t *testing.T) {
+		err := apperrors.New(apperrors.InsufficientBalance, "insufficient balance")
+		w, resp := respond(http.StatusUnprocessableEntity, err, "req-1")
+
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.Equal(t, float64(4002), resp["code"])
+		assert.Equal(t, "INSUFFICIENT_BALANCE", resp["error"])
+		assert.Equal(t, "insufficient balance", resp["message"])
+		assert.Equal(t, "req-1", resp["request_id"])
+		assert.Equal(t, "req-1", w.Header().Get(utils.RequestIDHeader))
+	})
+
+	t.Run("should hide internal errors behind the request ID", func(t *testing.T) {
+		w, resp := respond(http.StatusInternalServerError, errors.New("pq: connection refused"), "")
+
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		assert.Equal(t, "INTERNAL_ERROR", resp["error"])
+		assert.Equal(t, "internal server error", resp["message"])
+		assert.NotEmpty(t, resp["request_id"])
+		assert.Equal(t, resp["request_id"], w.Header().Get(utils.RequestIDHeader))
+	})
+
+	t.Run("should derive the code of errors without a code from the status", func(t *testing.T) {
+		_, resp := respond(http.StatusBadRequest, errors.New("unexpected EOF"), "")
+
+		assert.Equal(t, "INVALID_REQUEST", resp["error"])
+		assert.Equal(t, "unexpected EOF", resp["message"])
+	})
+	t.Run("should respond with the details of detailed errors", func(t *testing.T) {
+		w, resp := respond(http.StatusUnauthorized, challengeError{}, "")
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Equal(t, "TWO_FACTOR_REQUIRED", resp["error"])
+		assert.Equal(t, map[string]interface{}{"type": "totp"}, resp["details"])
+	})
+}
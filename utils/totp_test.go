@@ -0,0 +1,68 @@
+package utils_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wanliqun/go-wallet-app/utils"
+)
+
+// rfcSecret is the base32 encoded SHA1 secret of the RFC 6238 test vectors, "12345678901234567890"
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	// Last six digits of the RFC 6238 test vectors
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, tt := range tests {
+		code, err := utils.TOTPCode(rfcSecret, utils.TOTPStep(time.Unix(tt.unix, 0)))
+		assert.NoError(t, err)
+		assert.Equal(t, tt.code, code, "code at %d", tt.unix)
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+
+	step, ok := utils.ValidateTOTP(rfcSecret, "005924", now, 1)
+	assert.True(t, ok)
+	assert.Equal(t, utils.TOTPStep(now), step)
+
+	// Codes of the previous step are accepted within the skew
+	step, ok = utils.ValidateTOTP(rfcSecret, "005924", now.Add(utils.TOTPPeriod*time.Second), 1)
+	assert.True(t, ok)
+	assert.Equal(t, utils.TOTPStep(now), step)
+
+	_, ok = utils.ValidateTOTP(rfcSecret, "005924", now.Add(2*utils.TOTPPeriod*time.Second), 1)
+	assert.False(t, ok, "code outside of the skew should be rejected")
+
+	_, ok = utils.ValidateTOTP(rfcSecret, "000000", now, 1)
+	assert.False(t, ok, "wrong code should be rejected")
+
+	_, ok = utils.ValidateTOTP(rfcSecret, "5924", now, 1)
+	assert.False(t, ok, "short code should be rejected")
+}
+
+func TestGenerateTOTPSecret(t *testing.T) {
+	secret, err := utils.GenerateTOTPSecret()
+	assert.NoError(t, err)
+	assert.Len(t, secret, 32)
+
+	_, err = utils.TOTPCode(secret, 1)
+	assert.NoError(t, err)
+
+	uri := utils.TOTPProvisioningURI("Wallet App", "alice", secret)
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/Wallet%20App:alice?"))
+	assert.Contains(t, uri, "secret="+secret)
+	assert.Contains(t, uri, "issuer=Wallet%20App")
+}